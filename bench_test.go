@@ -0,0 +1,42 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+// benchmark instantiates the module of a fixture once and invokes one of
+// its functions in the benchmark loop.
+func benchmark(b *testing.B, fixture, name string, args ...war.Value) {
+	src, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := war.NewRuntime()
+	if _, err := r.Instantiate(src); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if _, err := r.Invoke(name, args...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoop(b *testing.B) {
+	benchmark(b, "loop.wat", "sum", war.I32(1000))
+}
+
+func BenchmarkFib(b *testing.B) {
+	benchmark(b, "fib.wat", "fib", war.I32(15))
+}
+
+func BenchmarkMemoryCopy(b *testing.B) {
+	benchmark(b, "copy.wat", "copy", war.I32(4096))
+}
@@ -0,0 +1,285 @@
+package main_test
+
+import (
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+const branches = `(module
+  (func (export "br") (result i32)
+    (i32.const 1)
+    (block (result i32)
+      (i32.const 2)
+      (i32.const 3)
+      (drop (i32.const 4))
+      (i32.const 5)
+      (br 0 (i32.const 6)))
+    (i32.add))
+  (func (export "nested") (result i32)
+    (i32.const 10)
+    (block $outer (result i32)
+      (i32.const 20)
+      (block (result i32)
+        (i32.const 30)
+        (br_if $outer (i32.const 40) (i32.const 1))
+        (drop))
+      (drop))
+    (i32.add))
+  (func (export "table") (param i32) (result i32)
+    (block $a (result i32)
+      (i32.const 1)
+      (block $b (result i32)
+        (i32.const 2)
+        (br_table $a $b (i32.const 3) (local.get 0)))
+      (i32.add)))
+  (func (export "loop") (result i32)
+    (local $i i32)
+    (i32.const 100)
+    (loop $top
+      (i32.const 7)
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br_if $top (i32.lt_u (local.get $i) (i32.const 3)))
+      (drop))
+    (i32.add (local.get $i)))
+  (func (export "return") (result i32)
+    (i32.const 1)
+    (block (result i32)
+      (i32.const 2)
+      (return (i32.const 3)))
+    (i32.add)))`
+
+func TestBranchResults(t *testing.T) {
+	r := war.NewRuntime()
+	if _, err := r.Instantiate([]byte(branches)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []war.Value
+		expected int32
+	}{
+		// the branch keeps its operand and drops everything else the block
+		// pushed
+		{"br", nil, 1 + 6},
+		{"nested", nil, 10 + 40},
+		{"table", []war.Value{war.I32(0)}, 3},
+		{"table", []war.Value{war.I32(1)}, 1 + 3},
+		// branching to a loop drops the values pushed by the iteration
+		{"loop", nil, 100 + 3},
+		{"return", nil, 3},
+	}
+	for _, tt := range tests {
+		got, err := r.Invoke(tt.name, tt.args...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(got) != 1 || got[0] != war.I32(tt.expected) {
+			t.Errorf("%s%v: got %v, expected [i32:%d]", tt.name, tt.args, got, tt.expected)
+		}
+	}
+}
+
+func TestBlockParams(t *testing.T) {
+	r := war.NewRuntime()
+	_, err := r.Instantiate([]byte(`(module
+  (func (export "inc_if") (param i32 i32) (result i32)
+    (local.get 0)
+    (if (param i32) (result i32) (local.get 1)
+      (then (i32.add (i32.const 1)))))
+  (func (export "sum") (param $n i32) (result i32)
+    (i32.const 0)
+    (loop $top (param i32) (result i32)
+      (i32.add (local.get $n))
+      (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+      (br_if $top (local.get $n)))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []war.Value
+		expected int32
+	}{
+		// a missing else passes the params through
+		{"inc_if", []war.Value{war.I32(5), war.I32(0)}, 5},
+		{"inc_if", []war.Value{war.I32(5), war.I32(1)}, 6},
+		// branching to the loop carries the running sum
+		{"sum", []war.Value{war.I32(4)}, 4 + 3 + 2 + 1},
+	}
+	for _, tt := range tests {
+		got, err := r.Invoke(tt.name, tt.args...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(got) != 1 || got[0] != war.I32(tt.expected) {
+			t.Errorf("%s%v: got %v, expected [i32:%d]", tt.name, tt.args, got, tt.expected)
+		}
+	}
+}
+
+func TestBrIfFallThrough(t *testing.T) {
+	src := `(module
+  (func (export "one") (param i32) (result i32)
+    (i32.const 1000)
+    (block (result i32)
+      (br_if 0 (i32.const 7) (local.get 0))
+      (i32.add (i32.const 1)))
+    (i32.add))
+  (func (export "two") (param i32) (result i32)
+    (block (result i32 i32)
+      (br_if 0 (i32.const 10) (i32.const 3) (local.get 0))
+      (i32.add (i32.const 100)))
+    (i32.sub)))`
+
+	tests := []struct {
+		name     string
+		cond     int32
+		expected int32
+	}{
+		// not taken, the operands stay for the rest of the block
+		{"one", 0, 1000 + 7 + 1},
+		{"two", 0, 10 - (3 + 100)},
+		// taken, they are the results of the block
+		{"one", 1, 1000 + 7},
+		{"two", -1, 10 - 3},
+	}
+	for _, opts := range [][]war.RuntimeOption{nil, {war.WithTreeWalker()}} {
+		r := war.NewRuntime(opts...)
+		if _, err := r.Instantiate([]byte(src)); err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			got, err := r.Invoke(tt.name, war.I32(tt.cond))
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if len(got) != 1 || got[0] != war.I32(tt.expected) {
+				t.Errorf("%s(%d): got %v, expected [i32:%d]", tt.name, tt.cond, got, tt.expected)
+			}
+		}
+	}
+}
+
+func TestShadowedLabels(t *testing.T) {
+	// the inner block adds to its result when the branch leaves it only
+	src := `(module
+  (func (export "folded") (result i32)
+    (block $a (result i32)
+      (i32.add (i32.const 100)
+        (block $a (result i32)
+          (br $a (i32.const 1))))))
+  (func (export "plain") (result i32)
+    block $a (result i32)
+      block $a (result i32)
+        i32.const 2
+        br $a
+      end $a
+      i32.const 200
+      i32.add
+    end $a)
+  (func (export "table") (param i32) (result i32)
+    (block $a (result i32)
+      (i32.add (i32.const 300)
+        (block $a (result i32)
+          (br_table $a 1 (i32.const 3) (local.get 0))))))
+  (func (export "depth") (result i32)
+    (block $a (result i32)
+      (i32.add (i32.const 400)
+        (block $a (result i32)
+          (br 1 (i32.const 4)))))))`
+
+	tests := []struct {
+		name     string
+		arg      []war.Value
+		expected int32
+	}{
+		{"folded", nil, 101},
+		{"plain", nil, 202},
+		{"table", []war.Value{war.I32(0)}, 303},
+		{"table", []war.Value{war.I32(1)}, 3},
+		{"depth", nil, 4},
+	}
+	for _, opts := range [][]war.RuntimeOption{nil, {war.WithTreeWalker()}} {
+		r := war.NewRuntime(opts...)
+		if _, err := r.Instantiate([]byte(src)); err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			got, err := r.Invoke(tt.name, tt.arg...)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if len(got) != 1 || got[0] != war.I32(tt.expected) {
+				t.Errorf("%s%v: got %v, expected [i32:%d]", tt.name, tt.arg, got, tt.expected)
+			}
+		}
+	}
+}
+
+func TestBlockTypeUse(t *testing.T) {
+	src := `(module
+  (type $swap (func (param i32 i32) (result i32 i32)))
+  (type $pick (func (param i32) (result i32)))
+  (func (export "swap") (param i32 i32) (result i32)
+    (local.get 0)
+    (local.get 1)
+    (block (type $swap)
+      (local.set 0)
+      (local.set 1)
+      (local.get 0)
+      (local.get 1))
+    (i32.sub))
+  (func (export "pick") (param i32 i32) (result i32)
+    (local.get 0)
+    (if (type $pick) (local.get 1)
+      (then (i32.const 10) (i32.add))
+      (else (i32.const 20) (i32.add)))))`
+
+	wasm, err := war.Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// through the binary format the block types are type indices
+	disasm, err := war.Disassemble(wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []string{src, string(disasm)} {
+		r := war.NewRuntime()
+		if _, err := r.Instantiate([]byte(src)); err != nil {
+			t.Fatalf("%v\n%s", err, src)
+		}
+		tests := []struct {
+			name     string
+			args     []war.Value
+			expected int32
+		}{
+			{"swap", []war.Value{war.I32(7), war.I32(2)}, 2 - 7},
+			{"pick", []war.Value{war.I32(1), war.I32(1)}, 11},
+			{"pick", []war.Value{war.I32(1), war.I32(0)}, 21},
+		}
+		for _, tt := range tests {
+			got, err := r.Invoke(tt.name, tt.args...)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if len(got) != 1 || got[0] != war.I32(tt.expected) {
+				t.Errorf("%s%v: got %v, expected [i32:%d]", tt.name, tt.args, got, tt.expected)
+			}
+		}
+	}
+
+	for _, src := range []string{
+		`(module (type (func (result i32))) (func (block (type 0) (result i64) (i64.const 0)) drop))`,
+		`(module (func (block (type 1))))`,
+		`(module (type (func (param i32))) (func (block (type 0))))`,
+	} {
+		if _, err := war.NewRuntime().Instantiate([]byte(src)); err == nil {
+			t.Errorf("expected an error instantiating %s", src)
+		}
+	}
+}
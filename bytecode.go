@@ -0,0 +1,189 @@
+package main
+
+import "github.com/bluescreen10/war/text"
+
+// opEnd marks the end of a block in bytecode. It isn't an op of the syntax
+// tree, which has no end.
+const opEnd text.Op = -1
+
+// flatInstr is an instruction of the bytecode of a function, the flat form
+// of its instruction tree: folded operands come before their instruction
+// and blocks run up to an end marker, with the then branch of an if ending
+// at an else marker. Jumps are resolved when flattening.
+type flatInstr struct {
+	op text.Op
+	in *instr // nil for the markers
+	// pc of the else marker of an if, or of its end marker if it has no
+	// else, and pc of the end marker of an else marker
+	jump int
+	// of a br, br_if or return, or of each label of a br_table
+	targets []target
+}
+
+// target is a branch resolved to where it continues.
+type target struct {
+	pc    int // where execution continues
+	keep  int // number of values kept on the stack
+	depth int // relative depth of the label
+	pop   int // number of labels popped, which leaves the label of a loop
+}
+
+// flatten compiles the instruction tree of a function with the given number
+// of results to bytecode.
+func flatten(code []*instr, results int) []flatInstr {
+	var out []flatInstr
+	// scope is a label in scope while flattening. A branch to a loop
+	// continues past the loop instruction; the branches to other blocks
+	// wait for their end marker.
+	type scope struct {
+		loop    int // pc of the loop instruction, or -1
+		keep    int
+		pending [][2]int // pc and label index of the branches out of it
+	}
+	// the function is the outermost
+	scopes := []*scope{{loop: -1, keep: results}}
+
+	branch := func(pc int, depths ...int) {
+		for i, depth := range depths {
+			s := scopes[len(scopes)-1-depth]
+			t := target{keep: s.keep, depth: depth, pop: depth + 1}
+			if s.loop >= 0 {
+				t.pc, t.pop = s.loop+1, depth
+			} else {
+				s.pending = append(s.pending, [2]int{pc, i})
+			}
+			out[pc].targets = append(out[pc].targets, t)
+		}
+	}
+	// end closes the innermost scope with its end marker
+	end := func() {
+		s := scopes[len(scopes)-1]
+		scopes = scopes[:len(scopes)-1]
+		out = append(out, flatInstr{op: opEnd})
+		for _, p := range s.pending {
+			out[p[0]].targets[p[1]].pc = len(out)
+		}
+	}
+
+	var emit func(code []*instr)
+	emit = func(code []*instr) {
+		for _, in := range code {
+			emit(in.args)
+			pc := len(out)
+			out = append(out, flatInstr{op: in.op, in: in})
+			switch in.op {
+			case text.OpBlock:
+				scopes = append(scopes, &scope{loop: -1, keep: len(in.results)})
+				emit(in.body)
+				end()
+			case text.OpLoop:
+				scopes = append(scopes, &scope{loop: pc, keep: len(in.params)})
+				emit(in.body)
+				end()
+			case text.OpIf:
+				scopes = append(scopes, &scope{loop: -1, keep: len(in.results)})
+				emit(in.body)
+				out[pc].jump = len(out)
+				if len(in.els) > 0 {
+					els := len(out)
+					out = append(out, flatInstr{op: text.OpElse})
+					emit(in.els)
+					out[els].jump = len(out)
+				}
+				end()
+			case text.OpBr, text.OpBrIf:
+				branch(pc, int(in.imm))
+			case text.OpBrTable:
+				depths := make([]int, len(in.labels))
+				for i, l := range in.labels {
+					depths[i] = int(l)
+				}
+				branch(pc, depths...)
+			case text.OpReturn:
+				branch(pc, len(scopes)-1)
+			}
+		}
+	}
+	emit(code)
+
+	// branches out of the function continue past its end
+	for _, p := range scopes[0].pending {
+		out[p[0]].targets[p[1]].pc = len(out)
+	}
+	return out
+}
+
+// run interprets the bytecode of a function, stopping at its end or when
+// it returns. The labels of the blocks it's in are the heights of the stack
+// below their params, the function itself being the outermost.
+func (m *machine) run(f *frame, code []flatInstr) {
+	base := len(m.labels)
+	m.labels = append(m.labels, len(m.stack))
+
+	for pc := 0; pc < len(code); {
+		fi := &code[pc]
+		pc++
+		switch fi.op {
+		case opEnd:
+			m.labels = m.labels[:len(m.labels)-1]
+			continue
+		case text.OpElse:
+			// the then branch is over
+			pc = fi.jump
+			continue
+		}
+
+		in := fi.in
+		f.in = in
+		if m.profile != nil {
+			m.profile.count(f, in.op)
+		}
+		if m.tracer != nil {
+			m.tracer(in.pc, in.op, m.stack)
+		}
+
+		switch in.op {
+		case text.OpBlock, text.OpLoop:
+			m.enter(len(in.params))
+		case text.OpIf:
+			cond := m.popI32()
+			m.enter(len(in.params))
+			if cond == 0 {
+				// past the else marker, or at the end marker
+				pc = fi.jump
+				if code[pc].op == text.OpElse {
+					pc++
+				}
+			}
+		case text.OpBr, text.OpReturn:
+			pc = m.branch(&fi.targets[0])
+		case text.OpBrIf:
+			if m.popI32() != 0 {
+				pc = m.branch(&fi.targets[0])
+			}
+		case text.OpBrTable:
+			i := int(m.popI32())
+			if i >= len(fi.targets)-1 || i < 0 {
+				i = len(fi.targets) - 1
+			}
+			pc = m.branch(&fi.targets[i])
+		default:
+			m.step(f, in)
+		}
+	}
+	m.labels = m.labels[:base]
+}
+
+// enter pushes the label of a block taking params values off the stack.
+func (m *machine) enter(params int) {
+	m.labels = append(m.labels, len(m.stack)-params)
+}
+
+// branch unwinds the stack to the label of a target and returns where
+// execution continues.
+func (m *machine) branch(t *target) int {
+	i := len(m.labels) - 1 - t.depth
+	m.unwind(m.labels[i], t.keep)
+	m.labels = m.labels[:len(m.labels)-t.pop]
+	return t.pc
+}
@@ -0,0 +1,139 @@
+package main_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+const differential = `(module
+  (memory 1)
+  (func $fib (export "fib") (param i32) (result i32)
+    (if (result i32) (i32.lt_u (local.get 0) (i32.const 2))
+      (then (local.get 0))
+      (else (i32.add
+        (call $fib (i32.sub (local.get 0) (i32.const 1)))
+        (call $fib (i32.sub (local.get 0) (i32.const 2)))))))
+  (func (export "switch") (param i32) (result i32)
+    (block $c (block $b (block $a
+      (br_table $a $b $c (local.get 0)))
+      (return (i32.const 10)))
+      (return (i32.const 20)))
+    (i32.const 30))
+  (func (export "if") (param i32) (result i32)
+    (local $r i32)
+    (if (local.get 0) (then (local.set $r (i32.const 1))))
+    (if (i32.eqz (local.get 0)) (then) (else (local.set $r (i32.add (local.get $r) (i32.const 2)))))
+    (local.get $r))
+  (func (export "params") (param i32) (result i32)
+    (i32.const 1)
+    (local.get 0)
+    (block (param i32 i32) (result i32)
+      (br_if 0 (i32.add) (i32.const 1))
+      (unreachable)))
+  (func (export "nested_loops") (param i32) (result i32)
+    (local $i i32) (local $j i32) (local $n i32)
+    (loop $outer
+      (local.set $j (i32.const 0))
+      (loop $inner
+        (local.set $n (i32.add (local.get $n) (i32.const 1)))
+        (local.set $j (i32.add (local.get $j) (i32.const 1)))
+        (br_if $inner (i32.lt_u (local.get $j) (local.get $i))))
+      (local.set $i (i32.add (local.get $i) (i32.const 1)))
+      (br_if $outer (i32.lt_u (local.get $i) (local.get 0))))
+    (local.get $n))
+  (func (export "early") (param i32) (result i32)
+    (loop $l (result i32)
+      (if (i32.gt_u (local.get 0) (i32.const 100)) (then (return (local.get 0))))
+      (local.set 0 (i32.mul (local.get 0) (i32.const 3)))
+      (br $l)))
+  (func (export "memory") (param i32) (result i32)
+    (i32.store (local.get 0) (i32.const 0x01020304))
+    (i32.load8_u offset=1 (local.get 0)))
+  (func (export "div") (param i32) (result i32)
+    (i32.div_s (i32.const 100) (local.get 0))))`
+
+func TestBytecodeMatchesTreeWalker(t *testing.T) {
+	tests := []struct {
+		name string
+		args []int32
+	}{
+		{"fib", []int32{0, 1, 10, 15}},
+		{"switch", []int32{0, 1, 2, 3, -1}},
+		{"if", []int32{0, 1}},
+		{"params", []int32{0, 41}},
+		{"nested_loops", []int32{1, 5, 10}},
+		{"early", []int32{1, 2, 200}},
+		{"memory", []int32{0, 100, 65534}},
+		{"div", []int32{7, 0, -1}},
+	}
+
+	run := func(r *war.Runtime, name string, arg int32) string {
+		got, err := r.Invoke(name, war.I32(arg))
+		var trap *war.Trap
+		if errors.As(err, &trap) {
+			return "trap: " + trap.Reason
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(got)
+	}
+
+	tree := war.NewRuntime(war.WithTreeWalker())
+	bytecode := war.NewRuntime()
+	for _, r := range []*war.Runtime{tree, bytecode} {
+		if _, err := r.Instantiate([]byte(differential)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range tests {
+		for _, arg := range tt.args {
+			want := run(tree, tt.name, arg)
+			if got := run(bytecode, tt.name, arg); got != want {
+				t.Errorf("%s(%d): got %s, expected %s", tt.name, arg, got, want)
+			}
+		}
+	}
+}
+
+func TestDeepBranch(t *testing.T) {
+	// eight nested blocks, each adding its bit at its end to the value of
+	// the blocks inside it, and a branch from the innermost to the label at
+	// the depth in its param, leaving a stray value behind
+	const depth = 8
+	var b strings.Builder
+	b.WriteString(`(module (func (export "deep") (param i32) (result i32)`)
+	for range depth {
+		b.WriteString(" (block (result i32)")
+	}
+	b.WriteString(" (i32.const -1) (i32.const 1000) (br_table 0 1 2 3 4 5 6 7 8 (local.get 0))")
+	for i := range depth {
+		fmt.Fprintf(&b, " (i32.add (i32.const %d)))", 1<<i)
+	}
+	b.WriteString("))")
+
+	for _, opts := range [][]war.RuntimeOption{nil, {war.WithTreeWalker()}} {
+		r := war.NewRuntime(opts...)
+		if _, err := r.Instantiate([]byte(b.String())); err != nil {
+			t.Fatal(err)
+		}
+		for d := range depth + 2 {
+			got, err := r.Invoke("deep", war.I32(int32(d)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the blocks around the target add their bits, and branching
+			// to the function, or past it, returns right away
+			want := int32(1000)
+			for i := d + 1; i < depth; i++ {
+				want += 1 << i
+			}
+			if got[0].I32() != want {
+				t.Errorf("depth %d: got %d, expected %d", d, got[0].I32(), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/bluescreen10/war/text"
+)
+
+// instr is an instruction lowered from the syntax tree with its immediates
+// decoded and its references resolved. Folded operands are kept in args and
+// evaluated before the instruction itself.
+type instr struct {
+	op  text.Op
+	pc  int    // offset of the instruction within its function
+	imm uint64 // constant bits, index, label depth, lane or memory offset
+	// second index: the source table of table.copy, the source memory of
+	// memory.copy, the segment of table.init and the table of
+	// call_indirect, the high bits of v128.const or the log2 of the
+	// alignment of a memory access
+	imm2 uint64
+	mem  uint32 // memory of the memory instructions
+
+	labels  []uint32    // br_table depths, the last one being the default
+	params  []ValueType // param types of blocks and call_indirect
+	results []ValueType // result types of blocks, typed select and call_indirect
+	args    []*instr
+	body    []*instr
+	els     []*instr
+	node    *text.Node
+}
+
+// funcCompiler lowers the body of a single function.
+type funcCompiler struct {
+	*compiler
+	locals map[string]uint32
+	labels []string // innermost last, the first one is the function itself
+	pc     int
+}
+
+func (c *funcCompiler) defineLocal(id string, idx int) error {
+	if id == "" {
+		return nil
+	}
+	if _, ok := c.locals[id]; ok {
+		return fmt.Errorf("duplicate local %s", id)
+	}
+	c.locals[id] = uint32(idx)
+	return nil
+}
+
+func (c *funcCompiler) local(ref string) (uint32, error) {
+	if strings.HasPrefix(ref, "$") {
+		idx, ok := c.locals[ref]
+		if !ok {
+			return 0, fmt.Errorf("unknown local %s", ref)
+		}
+		return idx, nil
+	}
+	idx, err := text.ParseUint(ref, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid local index %q", ref)
+	}
+	return uint32(idx), nil
+}
+
+// label resolves a label reference to its relative depth.
+func (c *funcCompiler) label(ref string) (uint32, error) {
+	if strings.HasPrefix(ref, "$") {
+		for i := len(c.labels) - 1; i >= 0; i-- {
+			if c.labels[i] == ref {
+				return uint32(len(c.labels) - 1 - i), nil
+			}
+		}
+		return 0, fmt.Errorf("unknown label %s", ref)
+	}
+	depth, err := text.ParseUint(ref, 32)
+	if err != nil || depth >= uint64(len(c.labels)) {
+		return 0, fmt.Errorf("unknown label %s", ref)
+	}
+	return uint32(depth), nil
+}
+
+func (c *funcCompiler) lowerAll(nodes []*text.Node) ([]*instr, error) {
+	code := make([]*instr, 0, len(nodes))
+	for _, n := range nodes {
+		in, err := c.lower(n)
+		if err != nil {
+			return nil, err
+		}
+		code = append(code, in)
+	}
+	return code, nil
+}
+
+func (c *funcCompiler) lower(n *text.Node) (*instr, error) {
+	if !n.Op.IsInstr() {
+		return nil, fmt.Errorf("unexpected %s", n.Op)
+	}
+
+	in := &instr{op: n.Op, node: n}
+	switch n.Op {
+	case text.OpBlock, text.OpLoop, text.OpIf:
+		return c.lowerBlock(in)
+	}
+
+	args := n.Args
+	if n.Op == text.OpCallIndirect {
+		var err error
+		if args, err = c.callIndirectType(in, args); err != nil {
+			return nil, fmt.Errorf("%s: %w", n.Op, err)
+		}
+	}
+	if n.Op == text.OpSelect && len(args) > 0 && args[0].Op == text.OpResult {
+		for _, s := range text.Fields(args[0].Meta) {
+			t, err := parseValueType(s)
+			if err != nil {
+				return nil, err
+			}
+			in.results = append(in.results, t)
+		}
+		args = args[1:]
+	}
+
+	var err error
+	if in.args, err = c.lowerAll(args); err != nil {
+		return nil, err
+	}
+	in.pc = c.pc
+	c.pc++
+
+	if err := c.immediates(in); err != nil {
+		return nil, fmt.Errorf("%s: %w", n.Op, err)
+	}
+	return in, nil
+}
+
+func (c *funcCompiler) lowerBlock(in *instr) (*instr, error) {
+	n := in.node
+	var body, els, operands []*text.Node
+	var typeUse *text.Node
+	for _, a := range n.Args {
+		switch a.Op {
+		case text.OpTypeUse:
+			typeUse = a
+		case text.OpParam, text.OpResult:
+			for _, s := range text.Fields(a.Meta) {
+				t, err := parseValueType(s)
+				if err != nil {
+					return nil, err
+				}
+				if a.Op == text.OpParam {
+					in.params = append(in.params, t)
+				} else {
+					in.results = append(in.results, t)
+				}
+			}
+		case text.OpThen:
+			body = a.Args
+		case text.OpElse:
+			els = a.Args
+		default:
+			if n.Op == text.OpIf {
+				operands = append(operands, a)
+			} else {
+				body = append(body, a)
+			}
+		}
+	}
+
+	if typeUse != nil {
+		if err := c.blockTypeUse(in, typeUse.Meta); err != nil {
+			return nil, fmt.Errorf("%s: %w", n.Op, err)
+		}
+	}
+
+	var err error
+	if in.args, err = c.lowerAll(operands); err != nil {
+		return nil, err
+	}
+	in.pc = c.pc
+	c.pc++
+
+	c.labels = append(c.labels, n.Meta)
+	defer func() { c.labels = c.labels[:len(c.labels)-1] }()
+	if in.body, err = c.lowerAll(body); err != nil {
+		return nil, err
+	}
+	if in.els, err = c.lowerAll(els); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// callIndirectType resolves the type use leading the args of call_indirect,
+// keeping the type index in imm and the type in params and results, and
+// returns its operands.
+func (c *funcCompiler) callIndirectType(in *instr, args []*text.Node) ([]*text.Node, error) {
+	i := 0
+	for i < len(args) && !args[i].Op.IsInstr() {
+		i++
+	}
+	idx, t, names, err := c.typeUse(args[:i])
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if name != "" {
+			return nil, fmt.Errorf("unexpected param id %s", name)
+		}
+	}
+	in.imm = uint64(idx)
+	in.params, in.results = t.params, t.results
+	return args[i:], nil
+}
+
+// blockTypeUse gives a block the params and results of the type ref, which
+// must match the ones written inline, if any.
+func (c *funcCompiler) blockTypeUse(in *instr, ref string) error {
+	idx, err := c.resolve(spaceType, ref)
+	if err != nil {
+		return err
+	}
+	if int(idx) >= len(c.m.types) {
+		return fmt.Errorf("unknown type %s", ref)
+	}
+	t := c.m.types[idx]
+	inline := funcType{params: in.params, results: in.results}
+	if (len(in.params) > 0 || len(in.results) > 0) && !t.equal(inline) {
+		return fmt.Errorf("inline block type %v does not match type %s", inline, ref)
+	}
+	in.params, in.results = t.params, t.results
+	return nil
+}
+
+func (c *funcCompiler) immediates(in *instr) error {
+	meta := in.node.Meta
+	var err error
+	var idx uint32
+	switch in.op {
+	case text.OpI32Const:
+		in.imm, err = text.ParseInt(meta, 32)
+	case text.OpI64Const:
+		in.imm, err = text.ParseInt(meta, 64)
+	case text.OpF32Const:
+		in.imm, err = text.ParseFloat(meta, 32)
+	case text.OpF64Const:
+		in.imm, err = text.ParseFloat(meta, 64)
+	case text.OpV128Const:
+		in.imm, in.imm2, err = parseV128(meta)
+	case text.OpLocalGet, text.OpLocalSet, text.OpLocalTee:
+		idx, err = c.local(meta)
+		in.imm = uint64(idx)
+	case text.OpGlobalGet, text.OpGlobalSet:
+		idx, err = c.resolve(spaceGlobal, meta)
+		in.imm = uint64(idx)
+	case text.OpCall, text.OpRefFunc:
+		idx, err = c.resolve(spaceFunc, meta)
+		in.imm = uint64(idx)
+	case text.OpRefNull:
+		switch meta {
+		case "func":
+			in.imm = uint64(ValueTypeFuncRef)
+		case "extern":
+			in.imm = uint64(ValueTypeExternRef)
+		default:
+			err = fmt.Errorf("unknown heap type %q", meta)
+		}
+	case text.OpElemDrop:
+		idx, err = c.resolve(spaceElem, meta)
+		in.imm = uint64(idx)
+	case text.OpDataDrop:
+		idx, err = c.resolve(spaceData, meta)
+		in.imm = uint64(idx)
+	case text.OpMemorySize, text.OpMemoryGrow, text.OpMemoryFill,
+		text.OpMemoryCopy, text.OpMemoryInit:
+		err = c.memoryImmediates(in)
+	case text.OpTableGet, text.OpTableSet, text.OpTableSize, text.OpTableGrow,
+		text.OpTableFill, text.OpTableCopy, text.OpTableInit:
+		err = c.tableImmediates(in)
+	case text.OpCallIndirect:
+		if meta == "" {
+			meta = "0"
+		}
+		idx, err = c.resolve(spaceTable, meta)
+		in.imm2 = uint64(idx)
+	case text.OpBr, text.OpBrIf:
+		idx, err = c.label(meta)
+		in.imm = uint64(idx)
+	case text.OpBrTable:
+		for _, ref := range text.Fields(meta) {
+			if idx, err = c.label(ref); err != nil {
+				break
+			}
+			in.labels = append(in.labels, idx)
+		}
+	default:
+		if isLaneAccess(in.op) {
+			in.imm, err = parseLane(in.op, meta)
+		}
+		if isMemoryAccess(in.op) {
+			err = c.memoryImmediates(in)
+		}
+	}
+	return err
+}
+
+// tableImmediates decodes the indices of table instructions. The table
+// defaults to 0 when omitted, both for the destination and the source of
+// table.copy.
+func (c *funcCompiler) tableImmediates(in *instr) error {
+	refs := text.Fields(in.node.Meta)
+	want := 1
+	switch in.op {
+	case text.OpTableCopy:
+		want = 2
+	case text.OpTableInit:
+		// the segment is required
+		if len(refs) == 1 {
+			refs = append([]string{"0"}, refs...)
+		}
+		want = 2
+	}
+
+	switch len(refs) {
+	case 0:
+		if in.op == text.OpTableInit {
+			return fmt.Errorf("missing elem segment")
+		}
+		return nil
+	case want:
+	default:
+		return fmt.Errorf("invalid immediates %q", in.node.Meta)
+	}
+
+	idx, err := c.resolve(spaceTable, refs[0])
+	if err != nil {
+		return err
+	}
+	in.imm = uint64(idx)
+
+	switch in.op {
+	case text.OpTableCopy:
+		idx, err = c.resolve(spaceTable, refs[1])
+	case text.OpTableInit:
+		idx, err = c.resolve(spaceElem, refs[1])
+	default:
+		return nil
+	}
+	in.imm2 = uint64(idx)
+	return err
+}
+
+// memoryImmediates resolves the memory of a memory instruction, memory 0
+// when omitted, along with the source memory of memory.copy, the segment
+// of memory.init and the memarg of loads and stores.
+func (c *funcCompiler) memoryImmediates(in *instr) error {
+	refs := text.Fields(in.node.Meta)
+	want := 1
+	switch {
+	case isMemoryAccess(in.op):
+		// the memarg follows the memory
+		args := refs
+		refs = nil
+		if len(args) > 0 && !strings.Contains(args[0], "=") {
+			refs, args = args[:1], args[1:]
+		}
+		var err error
+		if in.imm, in.imm2, err = memarg(in.op, strings.Join(args, " ")); err != nil {
+			return err
+		}
+	case in.op == text.OpMemoryCopy:
+		want = 2
+	case in.op == text.OpMemoryInit:
+		// the segment is required and comes last
+		if len(refs) == 0 {
+			return fmt.Errorf("missing data segment")
+		}
+		idx, err := c.resolve(spaceData, refs[len(refs)-1])
+		if err != nil {
+			return err
+		}
+		in.imm = uint64(idx)
+		refs = refs[:len(refs)-1]
+	}
+
+	switch len(refs) {
+	case 0:
+		return nil
+	case want:
+	default:
+		return fmt.Errorf("invalid immediates %q", in.node.Meta)
+	}
+	idx, err := c.resolve(spaceMemory, refs[0])
+	if err != nil {
+		return err
+	}
+	in.mem = idx
+	if in.op == text.OpMemoryCopy {
+		idx, err = c.resolve(spaceMemory, refs[1])
+		in.imm2 = uint64(idx)
+	}
+	return err
+}
+
+// memarg decodes the offset and the log2 of the alignment of a memory
+// access, which defaults to its natural alignment and can't exceed it.
+func memarg(op text.Op, meta string) (offset, align uint64, err error) {
+	align = uint64(naturalAlign(op))
+	for _, arg := range text.Fields(meta) {
+		if s, ok := strings.CutPrefix(arg, "offset="); ok {
+			if offset, err = text.ParseUint(s, 32); err != nil {
+				return 0, 0, err
+			}
+		}
+		if s, ok := strings.CutPrefix(arg, "align="); ok {
+			n, err := text.ParseUint(s, 32)
+			if err != nil || n == 0 || n&(n-1) != 0 {
+				return 0, 0, fmt.Errorf("alignment must be a power of two: %s", s)
+			}
+			if uint64(bits.TrailingZeros64(n)) > align {
+				return 0, 0, fmt.Errorf("alignment must not be larger than natural: %s", s)
+			}
+			align = uint64(bits.TrailingZeros64(n))
+		}
+	}
+	return offset, align, nil
+}
+
+func isMemoryAccess(op text.Op) bool {
+	return op >= text.OpI32Load && op <= text.OpV128Store64Lane
+}
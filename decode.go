@@ -0,0 +1,772 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bluescreen10/war/text"
+)
+
+// https://webassembly.github.io/spec/core/binary/modules.html
+
+const (
+	sectionCustom = iota
+	sectionType
+	sectionImport
+	sectionFunction
+	sectionTable
+	sectionMemory
+	sectionGlobal
+	sectionExport
+	sectionStart
+	sectionElement
+	sectionCode
+	sectionData
+	sectionDataCount
+)
+
+// maxLocals is the largest number of locals a function can declare. It's an
+// implementation limit to keep malformed modules from exhausting memory.
+const maxLocals = 50000
+
+// fieldOrder is the order in which the fields of each section are written,
+// with functions next to their imports rather than after the exports.
+var fieldOrder = []byte{
+	sectionType, sectionImport, sectionCode, sectionTable, sectionMemory,
+	sectionGlobal, sectionExport, sectionStart, sectionElement, sectionData,
+}
+
+var wasmMagic = []byte{0x00, 'a', 's', 'm'}
+
+// Disassemble decodes a binary module and returns it in the text format.
+// Functions are named after the name section if there is one, and get a
+// $funcN name otherwise.
+func Disassemble(wasm []byte) ([]byte, error) {
+	m, _, err := decodeModule(wasm)
+	if err != nil {
+		return nil, err
+	}
+	return text.Format(m, text.FormatOptions{}), nil
+}
+
+// CustomSection is a custom section of a binary module, which holds data
+// for tools rather than for the runtime, such as the name section or debug
+// information.
+type CustomSection struct {
+	Name string
+	Data []byte
+}
+
+// DecodeModule decodes and validates a binary module without instantiating
+// it. Custom sections are skipped, whatever their name, and listed by
+// CustomSections.
+func DecodeModule(wasm []byte) (*Module, error) {
+	n, customs, err := decodeModule(wasm)
+	if err != nil {
+		return nil, err
+	}
+	m, err := compileModule(n)
+	if err != nil {
+		return nil, err
+	}
+	m.customs = customs
+	return m, nil
+}
+
+// decoder reads a binary module into the same syntax tree the text parser
+// builds, so both formats share the rest of the pipeline.
+type decoder struct {
+	buf []byte
+	pos int
+
+	names     map[uint32]string // function names from the name section
+	numFuncs  uint32
+	funcTypes []uint32 // types of the defined functions
+	types     []*text.Node
+
+	// dataCount is the number of data segments declared ahead of the code,
+	// which memory.init and data.drop need to be validated in one pass.
+	dataCount    uint32
+	hasDataCount bool
+
+	fields  [numSections][]*text.Node
+	customs []CustomSection
+}
+
+const numSections = sectionDataCount + 1
+
+type decodeError struct{ error }
+
+func (d *decoder) errorf(format string, args ...any) {
+	panic(decodeError{fmt.Errorf(format, args...)})
+}
+
+func decodeModule(wasm []byte) (n *text.Node, customs []CustomSection, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			de, ok := e.(decodeError)
+			if !ok {
+				panic(e)
+			}
+			err = de
+		}
+	}()
+
+	if len(wasm) < 8 || !bytes.Equal(wasm[:4], wasmMagic) {
+		return nil, nil, fmt.Errorf("magic header not detected")
+	}
+	if binary.LittleEndian.Uint32(wasm[4:]) != 1 {
+		return nil, nil, fmt.Errorf("unknown binary version")
+	}
+
+	d := &decoder{buf: wasm[8:], names: readNames(wasm[8:])}
+	for d.pos < len(d.buf) {
+		id := d.byte()
+		size := int(d.u32())
+		if d.pos+size > len(d.buf) {
+			d.errorf("section size mismatch")
+		}
+		end := d.pos + size
+		d.section(id, end)
+		if d.pos != end {
+			d.errorf("section size mismatch")
+		}
+	}
+	if d.hasDataCount && int(d.dataCount) != len(d.fields[sectionData]) {
+		d.errorf("data count and data section have inconsistent lengths")
+	}
+
+	m := text.NewNode(text.OpModule, "")
+	for _, id := range fieldOrder {
+		m.Args = append(m.Args, d.fields[id]...)
+	}
+	return m, d.customs, nil
+}
+
+// readNames looks for the function names in the name section, which comes
+// after the code, so references can use them from the start. Names that
+// aren't valid ids or are already taken are left out.
+func readNames(sections []byte) (names map[uint32]string) {
+	defer func() {
+		// a malformed name section is ignored
+		if e := recover(); e != nil {
+			if _, ok := e.(decodeError); !ok {
+				panic(e)
+			}
+		}
+	}()
+
+	names = map[uint32]string{}
+	taken := map[string]bool{}
+	r := &decoder{buf: sections}
+	for r.pos < len(r.buf) {
+		id := r.byte()
+		size := int(r.u32())
+		end := r.pos + size
+		if end > len(r.buf) {
+			return names
+		}
+		if id == sectionCustom && r.name() == "name" {
+			for r.pos < end {
+				sub := r.byte()
+				subEnd := int(r.u32()) + r.pos
+				if sub == 1 {
+					for range r.u32() {
+						idx, name := r.u32(), r.name()
+						if !validID(name) || taken[name] || isSynthesized(name, idx) {
+							continue
+						}
+						names[idx], taken[name] = name, true
+					}
+				}
+				r.pos = subEnd
+			}
+		}
+		r.pos = end
+	}
+	return names
+}
+
+// validID reports whether a name can be used as an id.
+func validID(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range []byte(name) {
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\"(),;[]{}", c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isSynthesized reports whether a name would clash with the $funcN name of
+// another function.
+func isSynthesized(name string, idx uint32) bool {
+	n, ok := strings.CutPrefix(name, "func")
+	if !ok {
+		return false
+	}
+	i, err := strconv.ParseUint(n, 10, 32)
+	return err == nil && uint32(i) != idx && strconv.FormatUint(i, 10) == n
+}
+
+func (d *decoder) section(id byte, end int) {
+	switch id {
+	case sectionCustom:
+		// the contents are left to the tools that know the name
+		name := d.name()
+		if d.pos > end {
+			d.errorf("unexpected end")
+		}
+		d.customs = append(d.customs, CustomSection{Name: name, Data: d.buf[d.pos:end:end]})
+		d.pos = end
+	case sectionType:
+		for range d.u32() {
+			if d.byte() != 0x60 {
+				d.errorf("malformed function type")
+			}
+			f := text.NewNode(text.OpFunc, "", d.signature()...)
+			d.types = append(d.types, f)
+			d.add(id, text.NewNode(text.OpType, "", f))
+		}
+	case sectionImport:
+		for range d.u32() {
+			imp := text.NewNode(text.OpImport, text.Quote([]byte(d.name()))+" "+text.Quote([]byte(d.name())))
+			var desc *text.Node
+			switch kind := d.byte(); kind {
+			case 0x00:
+				desc = d.funcNode(d.u32())
+			case 0x01:
+				desc = text.NewNode(text.OpTable, d.tableType())
+			case 0x02:
+				desc = text.NewNode(text.OpMemory, d.limits(true))
+			case 0x03:
+				desc = d.globalType()
+			default:
+				d.errorf("malformed import kind %d", kind)
+			}
+			imp.Args = append(imp.Args, desc)
+			d.add(id, imp)
+		}
+	case sectionFunction:
+		for range d.u32() {
+			d.funcTypes = append(d.funcTypes, d.u32())
+		}
+	case sectionTable:
+		for range d.u32() {
+			d.add(id, text.NewNode(text.OpTable, d.tableType()))
+		}
+	case sectionMemory:
+		for range d.u32() {
+			d.add(id, text.NewNode(text.OpMemory, d.limits(true)))
+		}
+	case sectionGlobal:
+		for range d.u32() {
+			g := d.globalType()
+			g.Args = append(g.Args, d.expr()...)
+			d.add(id, g)
+		}
+	case sectionExport:
+		for range d.u32() {
+			name := text.Quote([]byte(d.name()))
+			var desc *text.Node
+			switch kind := d.byte(); kind {
+			case 0x00:
+				desc = text.NewNode(text.OpFunc, d.funcRef(d.u32()))
+			case 0x01:
+				desc = text.NewNode(text.OpTable, d.index())
+			case 0x02:
+				desc = text.NewNode(text.OpMemory, d.index())
+			case 0x03:
+				desc = text.NewNode(text.OpGlobal, d.index())
+			default:
+				d.errorf("malformed export kind %d", kind)
+			}
+			d.add(id, text.NewNode(text.OpExport, name, desc))
+		}
+	case sectionStart:
+		d.add(id, text.NewNode(text.OpStart, d.funcRef(d.u32())))
+	case sectionElement:
+		for range d.u32() {
+			d.add(id, d.elem())
+		}
+	case sectionCode:
+		n := d.u32()
+		if int(n) != len(d.funcTypes) {
+			d.errorf("function and code section have inconsistent lengths")
+		}
+		for i := range n {
+			size := int(d.u32())
+			bodyEnd := d.pos + size
+			f := d.funcNode(d.funcTypes[i])
+			for range d.u32() {
+				count := d.u32()
+				typ := d.valtype()
+				if count == 0 {
+					continue
+				}
+				if count > maxLocals {
+					d.errorf("too many locals")
+				}
+				f.Args = append(f.Args, text.NewNode(text.OpLocal, strings.TrimSpace(strings.Repeat(typ+" ", int(count)))))
+			}
+			f.Args = append(f.Args, d.expr()...)
+			if d.pos != bodyEnd {
+				d.errorf("section size mismatch")
+			}
+			d.add(id, f)
+		}
+	case sectionData:
+		for range d.u32() {
+			d.add(id, d.data())
+		}
+	case sectionDataCount:
+		d.dataCount, d.hasDataCount = d.u32(), true
+	default:
+		d.errorf("malformed section id %d", id)
+	}
+}
+
+func (d *decoder) add(section byte, n *text.Node) {
+	d.fields[section] = append(d.fields[section], n)
+}
+
+// funcNode returns the node of the next function in the index space, with
+// its type use.
+func (d *decoder) funcNode(typeIdx uint32) *text.Node {
+	if int(typeIdx) >= len(d.types) {
+		d.errorf("unknown type %d", typeIdx)
+	}
+	f := text.NewNode(text.OpFunc, d.funcRef(d.numFuncs), text.NewNode(text.OpTypeUse, strconv.Itoa(int(typeIdx))))
+	d.numFuncs++
+	f.Args = append(f.Args, d.types[typeIdx].Args...)
+	return f
+}
+
+// funcRef returns the id of a function.
+func (d *decoder) funcRef(idx uint32) string {
+	if name, ok := d.names[idx]; ok {
+		return "$" + name
+	}
+	return "$func" + strconv.Itoa(int(idx))
+}
+
+func (d *decoder) signature() []*text.Node {
+	var nodes []*text.Node
+	if params := d.valtypes(); params != "" {
+		nodes = append(nodes, text.NewNode(text.OpParam, params))
+	}
+	if results := d.valtypes(); results != "" {
+		nodes = append(nodes, text.NewNode(text.OpResult, results))
+	}
+	return nodes
+}
+
+func (d *decoder) valtypes() string {
+	var types []string
+	for range d.u32() {
+		types = append(types, d.valtype())
+	}
+	return strings.Join(types, " ")
+}
+
+func (d *decoder) valtype() string {
+	t := ValueType(d.byte())
+	switch t {
+	case ValueTypeI32, ValueTypeI64, ValueTypeF32, ValueTypeF64, ValueTypeV128,
+		ValueTypeFuncRef, ValueTypeExternRef:
+		return t.String()
+	}
+	d.errorf("malformed value type 0x%02x", byte(t))
+	return ""
+}
+
+// limits reads the limits of a table or, if memory is set, of a memory.
+func (d *decoder) limits(memory bool) string {
+	var l limits
+	switch flag := d.byte(); flag {
+	case 0x00:
+		l.min = d.u32()
+	case 0x01, 0x03:
+		l.min, l.max, l.hasMax = d.u32(), d.u32(), true
+		l.shared = flag == 0x03
+	case 0x02:
+		d.errorf("shared memory must have maximum")
+	default:
+		d.errorf("malformed limits flag %d", flag)
+	}
+	if err := l.validate(memory); err != nil {
+		d.errorf("%v", err)
+	}
+
+	s := strconv.FormatUint(uint64(l.min), 10)
+	if l.hasMax {
+		s += " " + strconv.FormatUint(uint64(l.max), 10)
+	}
+	if l.shared {
+		s += " shared"
+	}
+	return s
+}
+
+func (d *decoder) tableType() string {
+	typ := d.valtype()
+	return d.limits(false) + " " + typ
+}
+
+func (d *decoder) globalType() *text.Node {
+	typ := d.valtype()
+	switch mut := d.byte(); mut {
+	case 0x00:
+		return text.NewNode(text.OpGlobal, typ)
+	case 0x01:
+		return text.NewNode(text.OpGlobal, "", text.NewNode(text.OpMut, typ))
+	default:
+		d.errorf("malformed mutability %d", mut)
+	}
+	return nil
+}
+
+func (d *decoder) elem() *text.Node {
+	e := text.NewNode(text.OpElem, "")
+	flags := d.u32()
+	if flags > 7 {
+		d.errorf("malformed elements segment kind %d", flags)
+	}
+	passive, explicit, exprs := flags&1 != 0, flags&2 != 0, flags&4 != 0
+
+	typ := "funcref"
+	switch {
+	case passive && explicit:
+		typ = "declare " + typ
+	case !passive:
+		if explicit {
+			e.Args = append(e.Args, text.NewNode(text.OpTable, d.index()))
+		}
+		e.Args = append(e.Args, text.NewNode(text.OpOffset, "", d.expr()...))
+	}
+
+	if flags&3 != 0 {
+		// explicit element kind or reference type
+		if exprs {
+			t := d.valtype()
+			typ = strings.Replace(typ, "funcref", t, 1)
+		} else if kind := d.byte(); kind != 0x00 {
+			d.errorf("malformed element kind %d", kind)
+		}
+	}
+	e.Meta = typ
+
+	for range d.u32() {
+		var item []*text.Node
+		if exprs {
+			item = d.expr()
+		} else {
+			item = []*text.Node{text.NewNode(text.OpRefFunc, d.funcRef(d.u32()))}
+		}
+		e.Args = append(e.Args, text.NewNode(text.OpItem, "", item...))
+	}
+	return e
+}
+
+func (d *decoder) data() *text.Node {
+	n := text.NewNode(text.OpData, "")
+	switch flags := d.u32(); flags {
+	case 0:
+		n.Args = append(n.Args, text.NewNode(text.OpOffset, "", d.expr()...))
+	case 1:
+	case 2:
+		n.Args = append(n.Args, text.NewNode(text.OpMemory, d.index()))
+		n.Args = append(n.Args, text.NewNode(text.OpOffset, "", d.expr()...))
+	default:
+		d.errorf("malformed data segment kind %d", flags)
+	}
+	n.Meta = text.Quote(d.bytes())
+	return n
+}
+
+// expr decodes instructions up to the end opcode of an expression.
+func (d *decoder) expr() []*text.Node {
+	body, end := d.instrs()
+	if end != opcodeEnd {
+		d.errorf("else without if")
+	}
+	return body
+}
+
+// instrs decodes instructions up to an end or else opcode, returning which
+// one ended the sequence.
+func (d *decoder) instrs() ([]*text.Node, byte) {
+	var body []*text.Node
+	for {
+		code := uint32(d.byte())
+		switch code {
+		case opcodeEnd, opcodeElse:
+			return body, byte(code)
+		case 0xfc:
+			code = d.prefixed(prefixMisc)
+		case 0xfd:
+			code = d.prefixed(prefixSIMD)
+		}
+		op, ok := opcodes[code]
+		if !ok {
+			d.errorf("illegal opcode %#x", code)
+		}
+		body = append(body, d.instr(op, code))
+	}
+}
+
+// prefixed returns the opcode of an instruction with the given prefix, which
+// must fit in its low byte.
+func (d *decoder) prefixed(prefix uint32) uint32 {
+	code := d.u32()
+	if code > 0xff {
+		d.errorf("illegal opcode %#x %#x", prefix>>8, code)
+	}
+	return prefix | code
+}
+
+func (d *decoder) instr(op text.Op, code uint32) *text.Node {
+	n := text.NewNode(op, "")
+	switch op {
+	case text.OpBlock, text.OpLoop, text.OpIf:
+		if t := d.blockType(); t != nil {
+			n.Args = append(n.Args, t)
+		}
+		body, end := d.instrs()
+		if op != text.OpIf {
+			if end != opcodeEnd {
+				d.errorf("else without if")
+			}
+			n.Args = append(n.Args, body...)
+			break
+		}
+		n.Args = append(n.Args, text.NewNode(text.OpThen, "", body...))
+		if end == opcodeElse {
+			els, _ := d.instrs()
+			n.Args = append(n.Args, text.NewNode(text.OpElse, "", els...))
+		}
+	case text.OpBr, text.OpBrIf, text.OpLocalGet, text.OpLocalSet, text.OpLocalTee,
+		text.OpGlobalGet, text.OpGlobalSet, text.OpTableGet, text.OpTableSet,
+		text.OpTableGrow, text.OpTableSize, text.OpTableFill, text.OpElemDrop:
+		n.Meta = d.index()
+	case text.OpBrTable:
+		var labels []string
+		for range d.u32() + 1 {
+			labels = append(labels, d.index())
+		}
+		n.Meta = strings.Join(labels, " ")
+	case text.OpCall, text.OpRefFunc:
+		n.Meta = d.funcRef(d.u32())
+	case text.OpCallIndirect:
+		typ := d.index()
+		n.Meta = d.index()
+		n.Args = append(n.Args, text.NewNode(text.OpTypeUse, typ))
+	case text.OpSelect:
+		if code == 0x1c {
+			n.Args = append(n.Args, text.NewNode(text.OpResult, d.valtypes()))
+		}
+	case text.OpMemorySize, text.OpMemoryGrow, text.OpMemoryFill:
+		n.Meta = d.memory()
+	case text.OpMemoryCopy:
+		dst, src := d.u32(), d.u32()
+		if dst != 0 || src != 0 {
+			n.Meta = strconv.Itoa(int(dst)) + " " + strconv.Itoa(int(src))
+		}
+	case text.OpMemoryInit:
+		d.requireDataCount()
+		n.Meta = d.index()
+		if mem := d.memory(); mem != "" {
+			n.Meta = mem + " " + n.Meta
+		}
+	case text.OpDataDrop:
+		d.requireDataCount()
+		n.Meta = d.index()
+	case text.OpTableInit:
+		elem := d.index()
+		n.Meta = d.index() + " " + elem
+	case text.OpTableCopy:
+		n.Meta = d.index() + " " + d.index()
+	case text.OpI32Const:
+		n.Meta = strconv.FormatInt(d.signed(32), 10)
+	case text.OpI64Const:
+		n.Meta = strconv.FormatInt(d.signed(64), 10)
+	case text.OpF32Const:
+		n.Meta = text.FormatFloat(uint64(binary.LittleEndian.Uint32(d.read(4))), 32)
+	case text.OpF64Const:
+		n.Meta = text.FormatFloat(binary.LittleEndian.Uint64(d.read(8)), 64)
+	case text.OpV128Const:
+		b := d.read(16)
+		n.Meta = fmt.Sprintf("i64x2 %#x %#x", binary.LittleEndian.Uint64(b), binary.LittleEndian.Uint64(b[8:]))
+	case text.OpI8x16Shuffle:
+		lanes := make([]string, 16)
+		for i := range lanes {
+			lanes[i] = strconv.Itoa(int(d.byte()))
+		}
+		n.Meta = strings.Join(lanes, " ")
+	case text.OpRefNull:
+		switch t := ValueType(d.byte()); t {
+		case ValueTypeFuncRef:
+			n.Meta = "func"
+		case ValueTypeExternRef:
+			n.Meta = "extern"
+		default:
+			d.errorf("malformed reference type 0x%02x", byte(t))
+		}
+	default:
+		switch {
+		case op >= text.OpV128Load8Lane && op <= text.OpV128Store64Lane:
+			// the lane follows the memarg
+			n.Meta = strings.TrimLeft(d.memarg(op)+" "+strconv.Itoa(int(d.byte())), " ")
+		case isMemoryAccess(op):
+			n.Meta = d.memarg(op)
+		case isLaneAccess(op):
+			n.Meta = strconv.Itoa(int(d.byte()))
+		}
+	}
+	return n
+}
+
+// requireDataCount checks that the module has a data count section, which
+// instructions referring to data segments need.
+func (d *decoder) requireDataCount() {
+	if !d.hasDataCount {
+		d.errorf("data count section required")
+	}
+}
+
+func (d *decoder) blockType() *text.Node {
+	if d.peek() == 0x40 {
+		d.byte()
+		return nil
+	}
+	if d.peek()&0x40 != 0 {
+		// a single byte negative number, which is a value type
+		return text.NewNode(text.OpResult, d.valtype())
+	}
+	idx := d.signed(33)
+	if idx < 0 {
+		d.errorf("malformed block type")
+	}
+	return text.NewNode(text.OpTypeUse, strconv.FormatInt(idx, 10))
+}
+
+// memarg returns the offset and alignment of a memory access, leaving out
+// the defaults.
+func (d *decoder) memarg(op text.Op) string {
+	align := d.u32()
+	var memarg []string
+	if align&0x40 != 0 {
+		// the memory index of the multi-memory proposal follows
+		align &^= 0x40
+		memarg = append(memarg, strconv.Itoa(int(d.u32())))
+	}
+	offset := d.u32()
+	if offset != 0 {
+		memarg = append(memarg, "offset="+strconv.Itoa(int(offset)))
+	}
+	if align != naturalAlign(op) {
+		memarg = append(memarg, "align="+strconv.Itoa(1<<align))
+	}
+	return strings.Join(memarg, " ")
+}
+
+// memory returns the memory index of a memory instruction, leaving out
+// memory 0.
+func (d *decoder) memory() string {
+	if idx := d.u32(); idx != 0 {
+		return strconv.Itoa(int(idx))
+	}
+	return ""
+}
+
+func (d *decoder) peek() byte {
+	if d.pos >= len(d.buf) {
+		d.errorf("unexpected end")
+	}
+	return d.buf[d.pos]
+}
+
+func (d *decoder) byte() byte {
+	b := d.peek()
+	d.pos++
+	return b
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.pos+n > len(d.buf) {
+		d.errorf("unexpected end")
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) bytes() []byte {
+	return d.read(int(d.u32()))
+}
+
+// name reads a name, which must be valid UTF-8.
+func (d *decoder) name() string {
+	b := d.bytes()
+	if !utf8.Valid(b) {
+		d.errorf("malformed UTF-8 encoding")
+	}
+	return string(b)
+}
+
+func (d *decoder) index() string {
+	return strconv.FormatUint(uint64(d.u32()), 10)
+}
+
+// u32 reads an unsigned LEB128 integer.
+func (d *decoder) u32() uint32 {
+	var v uint64
+	for shift := 0; ; shift += 7 {
+		if shift >= 35 {
+			d.errorf("integer representation too long")
+		}
+		b := d.byte()
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if v > 1<<32-1 {
+		d.errorf("integer too large")
+	}
+	return uint32(v)
+}
+
+// signed reads a signed LEB128 integer of size bits, which must use at
+// most as many bytes as needed for the size and whose unused bits must be
+// the sign extension.
+func (d *decoder) signed(size int) int64 {
+	var v int64
+	for shift := 0; ; shift += 7 {
+		if shift >= size {
+			d.errorf("integer representation too long")
+		}
+		b := d.byte()
+		v |= int64(b&0x7f) << shift
+		if b&0x80 != 0 {
+			continue
+		}
+		if rest := size - shift; rest < 7 {
+			// the bits past the sign bit must all copy it
+			top := b & 0x7f >> (rest - 1)
+			if top != 0 && top != 0x7f>>(rest-1) {
+				d.errorf("integer too large")
+			}
+		}
+		if shift+7 < 64 && b&0x40 != 0 {
+			v |= -1 << (shift + 7)
+		}
+		return v
+	}
+}
@@ -0,0 +1,266 @@
+package main_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+	"github.com/bluescreen10/war/text"
+)
+
+// double adds a parameter to itself by calling add.
+var double = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// type
+	0x01, 0x0c, 0x02, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f, 0x60, 0x01, 0x7f, 0x01, 0x7f,
+	// function
+	0x03, 0x03, 0x02, 0x00, 0x01,
+	// export
+	0x07, 0x0a, 0x01, 0x06, 'd', 'o', 'u', 'b', 'l', 'e', 0x00, 0x01,
+	// code
+	0x0a, 0x12, 0x02,
+	0x07, 0x00, 0x20, 0x00, 0x20, 0x01, 0x6a, 0x0b,
+	0x08, 0x00, 0x20, 0x00, 0x20, 0x00, 0x10, 0x00, 0x0b,
+}
+
+// names is a name section for double.
+var names = []byte{
+	0x00, 0x15, 0x04, 'n', 'a', 'm', 'e',
+	0x01, 0x0e, 0x02, 0x00, 0x03, 'a', 'd', 'd', 0x01, 0x06, 'd', 'o', 'u', 'b', 'l', 'e',
+}
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		name string
+		wasm []byte
+		want string
+	}{
+		{"synthesized names", double, `(module
+  (type (func (param i32 i32) (result i32)))
+  (type (func (param i32) (result i32)))
+  (func $func0 (type 0) (param i32 i32) (result i32)
+    local.get 0
+    local.get 1
+    i32.add)
+  (func $func1 (type 1) (param i32) (result i32)
+    local.get 0
+    local.get 0
+    call $func0)
+  (export "double" (func $func1)))
+`},
+		{"name section", append(double[:len(double):len(double)], names...), `(module
+  (type (func (param i32 i32) (result i32)))
+  (type (func (param i32) (result i32)))
+  (func $add (type 0) (param i32 i32) (result i32)
+    local.get 0
+    local.get 1
+    i32.add)
+  (func $double (type 1) (param i32) (result i32)
+    local.get 0
+    local.get 0
+    call $add)
+  (export "double" (func $double)))
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := war.Disassemble(tt.wasm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nexpected:\n%s", got, tt.want)
+			}
+
+			r := war.NewRuntime()
+			if _, err := r.Instantiate(got); err != nil {
+				t.Fatal(err)
+			}
+			res, err := r.Invoke("double", war.I32(21))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res[0] != war.I32(42) {
+				t.Errorf("got %v, expected 42", res[0])
+			}
+		})
+	}
+}
+
+func TestDisassembleMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		wasm []byte
+		want string
+	}{
+		{"magic", []byte("\x00wasm\x01\x00\x00\x00"), "magic header not detected"},
+		{"version", []byte("\x00asm\x02\x00\x00\x00"), "unknown binary version"},
+		{"truncated", double[:len(double)-1], "section size mismatch"},
+		// i32.const 0 in 6 bytes, and -1 in 5 bytes with bits past 32
+		// that don't copy the sign
+		{"long i32", module(
+			[]byte{0x01, 0x05, 0x01, 0x60, 0x00, 0x01, 0x7f},
+			[]byte{0x03, 0x02, 0x01, 0x00},
+			[]byte{0x0a, 0x0b, 0x01, 0x09, 0x00, 0x41, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00, 0x0b},
+		), "integer representation too long"},
+		{"large i32", module(
+			[]byte{0x01, 0x05, 0x01, 0x60, 0x00, 0x01, 0x7f},
+			[]byte{0x03, 0x02, 0x01, 0x00},
+			[]byte{0x0a, 0x0a, 0x01, 0x08, 0x00, 0x41, 0xff, 0xff, 0xff, 0xff, 0x4f, 0x0b},
+		), "integer too large"},
+		// 0xfc 0x100, which would wrap into the SIMD opcodes
+		{"prefixed opcode", module(
+			[]byte{0x01, 0x04, 0x01, 0x60, 0x00, 0x00},
+			[]byte{0x03, 0x02, 0x01, 0x00},
+			[]byte{0x0a, 0x07, 0x01, 0x05, 0x00, 0xfc, 0x80, 0x02, 0x0b},
+		), "illegal opcode 0xfc 0x100"},
+		{"import name", module(
+			[]byte{0x01, 0x04, 0x01, 0x60, 0x00, 0x00},
+			[]byte{0x02, 0x07, 0x01, 0x01, 0xff, 0x01, 'f', 0x00, 0x00},
+		), "malformed UTF-8 encoding"},
+		// the name is longer than the section
+		{"custom section name", module([]byte{0x00, 0x02, 0x04, 'a', 'b', 'c', 'd'}), "unexpected end"},
+		{"table limits", module([]byte{0x04, 0x05, 0x01, 0x70, 0x01, 0x02, 0x01}),
+			"size minimum must not be greater than maximum"},
+		{"memory limits", module([]byte{0x05, 0x04, 0x01, 0x01, 0x02, 0x01}),
+			"size minimum must not be greater than maximum"},
+		// 65537 pages
+		{"memory size", module([]byte{0x05, 0x05, 0x01, 0x00, 0x81, 0x80, 0x04}),
+			"memory size must be at most 65536 pages (4GiB)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := war.Disassemble(tt.wasm); err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v, expected %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomSections(t *testing.T) {
+	// an unknown section before the others, and one after the name section
+	wasm := module(
+		[]byte{0x00, 0x07, 0x04, 'a', 'c', 'm', 'e', 0x01, 0x02},
+		double[8:],
+		names,
+		[]byte{0x00, 0x07, 0x06, 's', 'o', 'u', 'r', 'c', 'e'},
+	)
+	m, err := war.DecodeModule(wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []war.CustomSection{
+		{Name: "acme", Data: []byte{0x01, 0x02}},
+		{Name: "name", Data: names[7:]},
+		{Name: "source", Data: []byte{}},
+	}
+	got := m.CustomSections()
+	if len(got) != len(want) {
+		t.Fatalf("got %d custom sections, expected %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Name != want[i].Name || !bytes.Equal(got[i].Data, want[i].Data) {
+			t.Errorf("section %d: got %q of %d bytes, expected %q of %d bytes",
+				i, got[i].Name, len(got[i].Data), want[i].Name, len(want[i].Data))
+		}
+	}
+
+	// the module runs as if the sections weren't there
+	inst, err := war.NewRuntime().InstantiateModule(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := inst.Invoke("double", war.I32(21))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v[0].I32() != 42 {
+		t.Errorf("got %d, expected 42", v[0].I32())
+	}
+}
+
+// module prefixes sections with the header of a binary module.
+func module(sections ...[]byte) []byte {
+	wasm := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	for _, s := range sections {
+		wasm = append(wasm, s...)
+	}
+	return wasm
+}
+
+func TestDataCount(t *testing.T) {
+	var (
+		types    = []byte{0x01, 0x04, 0x01, 0x60, 0x00, 0x00}
+		funcs    = []byte{0x03, 0x02, 0x01, 0x00}
+		mem      = []byte{0x05, 0x03, 0x01, 0x00, 0x01}
+		dataDrop = []byte{0x0a, 0x07, 0x01, 0x05, 0x00, 0xfc, 0x09, 0x00, 0x0b}
+		nop      = []byte{0x0a, 0x04, 0x01, 0x02, 0x00, 0x0b}
+		data     = []byte{0x0b, 0x05, 0x01, 0x01, 0x02, 'h', 'i'}
+	)
+	dataCount := func(n byte) []byte { return []byte{0x0c, 0x01, n} }
+
+	tests := []struct {
+		name string
+		wasm []byte
+		want string
+	}{
+		{"matching count", module(types, funcs, mem, dataCount(1), dataDrop, data), ""},
+		{"without data.drop", module(types, funcs, mem, nop, data), ""},
+		{"count too large", module(types, funcs, mem, dataCount(2), dataDrop, data), "data count and data section have inconsistent lengths"},
+		{"count without data", module(types, funcs, mem, dataCount(1), nop), "data count and data section have inconsistent lengths"},
+		{"missing count", module(types, funcs, mem, dataDrop, data), "data count section required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := war.Disassemble(tt.wasm)
+			if tt.want != "" {
+				if err == nil || err.Error() != tt.want {
+					t.Errorf("got error %v, expected %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := war.NewRuntime().Instantiate(got); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDisassembleData(t *testing.T) {
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	tricky := "\x00\"'\\\t\n\r a\x7f\xff\xc3\xa9"
+	src := fmt.Sprintf(`(module (memory 1) (data (i32.const 0) %s) (data (i32.const 256) %s))`,
+		text.Quote(all), text.Quote([]byte(tricky)))
+	wasm, err := war.Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := war.Disassemble(wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"\00\"'\\\t\n\r a\7f\ff\c3\a9"`; !strings.Contains(string(got), want) {
+		t.Errorf("got:\n%s\nexpected the data %s", got, want)
+	}
+	// the data re-parses to the same bytes
+	again, err := war.Assemble(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, wasm) {
+		t.Errorf("reassembled:\n%x\nexpected:\n%x", again, wasm)
+	}
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"github.com/bluescreen10/war/text"
+)
+
+// Assemble compiles the module in the text format in src and encodes it as a
+// binary module.
+//
+// The output matches the one of wat2wasm without debug names: integers use
+// the shortest LEB128 encoding, sections are written in the order of the
+// spec and left out when empty, and segments use the most compact of their
+// encodings. The intentional differences are that no custom sections are
+// written, including the name section, and that vector instructions can't
+// be encoded yet.
+func Assemble(src []byte) ([]byte, error) {
+	wasm, _, err := AssembleWithSourceMap(src)
+	return wasm, err
+}
+
+// AssembleWithSourceMap is like Assemble but also returns where the
+// instructions of the code section were written in src.
+func AssembleWithSourceMap(src []byte) ([]byte, SourceMap, error) {
+	m, err := CompileModule(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeModule(m)
+}
+
+// SourceMap maps offsets in a binary module to positions in the text it was
+// assembled from, with one mapping per instruction in increasing offsets.
+type SourceMap []SourceMapping
+
+// SourceMapping is the position of the instruction whose opcode starts at
+// Offset in the binary.
+type SourceMapping struct {
+	Offset int
+	Pos    text.Pos
+}
+
+// Lookup returns the position of the instruction the byte at offset belongs
+// to, which is the last one starting at or before it.
+func (sm SourceMap) Lookup(offset int) (text.Pos, bool) {
+	i, _ := slices.BinarySearchFunc(sm, offset, func(m SourceMapping, off int) int {
+		return cmp.Compare(m.Offset, off+1)
+	})
+	if i == 0 {
+		return text.Pos{}, false
+	}
+	return sm[i-1].Pos, true
+}
+
+// sectionOrder is the order of sections mandated by the spec.
+var sectionOrder = []byte{
+	sectionType, sectionImport, sectionFunction, sectionTable, sectionMemory,
+	sectionGlobal, sectionExport, sectionStart, sectionElement, sectionDataCount,
+	sectionCode, sectionData,
+}
+
+// encoder writes a compiled module in the binary format.
+type encoder struct {
+	buf []byte
+
+	m *Module
+	// types of the blocks that aren't in the module, added after its own
+	types []funcType
+	// set when memory.init or data.drop is used, which requires a data
+	// count section
+	needDataCount bool
+
+	// the instructions written in the current function body, when
+	// mapping is set, and those of the code section written so far
+	mapping bool
+	bodyMap SourceMap
+	codeMap SourceMap
+}
+
+type encodeError struct{ error }
+
+func (e *encoder) errorf(format string, args ...any) {
+	panic(encodeError{fmt.Errorf(format, args...)})
+}
+
+func encodeModule(m *Module) (wasm []byte, sm SourceMap, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			ee, ok := e.(encodeError)
+			if !ok {
+				panic(e)
+			}
+			err = ee
+		}
+	}()
+
+	e := &encoder{m: m, types: m.types}
+	var sections [numSections][]byte
+	// the code comes first as it adds the types of blocks and decides
+	// whether the data count is needed
+	if len(m.funcs) > 0 {
+		sections[sectionCode] = e.section(func(e *encoder) { e.code() })
+	}
+	if len(e.types) > 0 {
+		sections[sectionType] = e.section(func(e *encoder) {
+			e.u32(uint32(len(e.types)))
+			for _, t := range e.types {
+				e.byte(0x60)
+				e.valtypes(t.params)
+				e.valtypes(t.results)
+			}
+		})
+	}
+	if len(m.imports) > 0 {
+		sections[sectionImport] = e.section(func(e *encoder) {
+			e.u32(uint32(len(m.imports)))
+			for _, imp := range m.imports {
+				e.name(imp.module)
+				e.name(imp.name)
+				e.byte(byte(imp.kind))
+				switch imp.kind {
+				case ExternFunc:
+					e.u32(imp.typeIdx)
+				case ExternTable:
+					e.byte(byte(imp.elem))
+					e.limits(imp.limits)
+				case ExternMemory:
+					e.limits(imp.limits)
+				case ExternGlobal:
+					e.globalType(imp.global)
+				}
+			}
+		})
+	}
+	if len(m.funcs) > 0 {
+		sections[sectionFunction] = e.section(func(e *encoder) {
+			e.u32(uint32(len(m.funcs)))
+			for _, f := range m.funcs {
+				e.u32(f.typeIdx)
+			}
+		})
+	}
+	if len(m.tables) > 0 {
+		sections[sectionTable] = e.section(func(e *encoder) {
+			e.u32(uint32(len(m.tables)))
+			for _, t := range m.tables {
+				e.byte(byte(t.typ))
+				e.limits(t.limits)
+			}
+		})
+	}
+	if len(m.mems) > 0 {
+		sections[sectionMemory] = e.section(func(e *encoder) {
+			e.u32(uint32(len(m.mems)))
+			for _, mem := range m.mems {
+				e.limits(mem.limits)
+			}
+		})
+	}
+	if len(m.globals) > 0 {
+		sections[sectionGlobal] = e.section(func(e *encoder) {
+			e.u32(uint32(len(m.globals)))
+			for _, g := range m.globals {
+				e.globalType(g.typ)
+				e.expr(g.init)
+			}
+		})
+	}
+	if len(m.exports) > 0 {
+		sections[sectionExport] = e.section(func(e *encoder) {
+			e.u32(uint32(len(m.exports)))
+			for _, exp := range m.exports {
+				e.name(exp.name)
+				e.byte(byte(exp.kind))
+				e.u32(exp.index)
+			}
+		})
+	}
+	if m.hasStart {
+		sections[sectionStart] = e.section(func(e *encoder) { e.u32(m.start) })
+	}
+	if len(m.elems) > 0 {
+		sections[sectionElement] = e.section(func(e *encoder) {
+			e.u32(uint32(len(m.elems)))
+			for _, seg := range m.elems {
+				e.elem(seg)
+			}
+		})
+	}
+	if e.needDataCount {
+		sections[sectionDataCount] = e.section(func(e *encoder) { e.u32(uint32(len(m.datas))) })
+	}
+	if len(m.datas) > 0 {
+		sections[sectionData] = e.section(func(e *encoder) {
+			e.u32(uint32(len(m.datas)))
+			for _, d := range m.datas {
+				e.data(d)
+			}
+		})
+	}
+
+	wasm = append([]byte(nil), wasmMagic...)
+	wasm = append(wasm, 0x01, 0x00, 0x00, 0x00)
+	for _, id := range sectionOrder {
+		if s := sections[id]; s != nil {
+			wasm = append(wasm, id)
+			wasm = binary.AppendUvarint(wasm, uint64(len(s)))
+			if id == sectionCode {
+				sm = e.codeMap.shift(len(wasm))
+			}
+			wasm = append(wasm, s...)
+		}
+	}
+	return wasm, sm, nil
+}
+
+// section returns the contents written by fn, sharing the state of e.
+func (e *encoder) section(fn func(e *encoder)) []byte {
+	buf := e.buf
+	e.buf = nil
+	fn(e)
+	s := e.buf
+	e.buf = buf
+	return s
+}
+
+func (e *encoder) code() {
+	e.u32(uint32(len(e.m.funcs)))
+	for _, f := range e.m.funcs {
+		e.mapping, e.bodyMap = true, nil
+		body := e.section(func(e *encoder) {
+			e.locals(f.locals)
+			e.expr(f.body)
+		})
+		e.mapping = false
+		e.u32(uint32(len(body)))
+		e.codeMap = append(e.codeMap, e.bodyMap.shift(len(e.buf))...)
+		e.buf = append(e.buf, body...)
+	}
+}
+
+// shift returns the mappings with base added to their offsets.
+func (sm SourceMap) shift(base int) SourceMap {
+	shifted := make(SourceMap, len(sm))
+	for i, m := range sm {
+		shifted[i] = SourceMapping{Offset: base + m.Offset, Pos: m.Pos}
+	}
+	return shifted
+}
+
+// locals writes the locals of a function, grouping runs of the same type.
+func (e *encoder) locals(locals []ValueType) {
+	var groups int
+	for i, t := range locals {
+		if i == 0 || t != locals[i-1] {
+			groups++
+		}
+	}
+	e.u32(uint32(groups))
+	for i := 0; i < len(locals); {
+		j := i + 1
+		for j < len(locals) && locals[j] == locals[i] {
+			j++
+		}
+		e.u32(uint32(j - i))
+		e.byte(byte(locals[i]))
+		i = j
+	}
+}
+
+func (e *encoder) elem(seg *elemSegment) {
+	// func indices are used unless an item is another expression
+	exprs := seg.typ != ValueTypeFuncRef
+	for _, item := range seg.init {
+		if len(item) != 1 || item[0].op != text.OpRefFunc || len(item[0].args) > 0 {
+			exprs = true
+		}
+	}
+
+	var flags uint32
+	switch {
+	case seg.declare:
+		flags = 3
+	case seg.offset == nil:
+		flags = 1
+	case seg.table != 0 || seg.typ != ValueTypeFuncRef:
+		// the short form on table 0 implies funcref elements
+		flags = 2
+	}
+	if exprs {
+		flags |= 4
+	}
+	e.u32(flags)
+
+	if seg.offset != nil {
+		if flags&2 != 0 {
+			e.u32(seg.table)
+		}
+		e.expr(seg.offset)
+	}
+	if flags&3 != 0 {
+		if exprs {
+			e.byte(byte(seg.typ))
+		} else {
+			// element kind of functions
+			e.byte(0x00)
+		}
+	}
+
+	e.u32(uint32(len(seg.init)))
+	for _, item := range seg.init {
+		if exprs {
+			e.expr(item)
+		} else {
+			e.u32(uint32(item[0].imm))
+		}
+	}
+}
+
+func (e *encoder) data(d *dataSegment) {
+	switch {
+	case d.offset == nil:
+		e.u32(1)
+	case d.mem != 0:
+		e.u32(2)
+		e.u32(d.mem)
+		e.expr(d.offset)
+	default:
+		e.u32(0)
+		e.expr(d.offset)
+	}
+	e.u32(uint32(len(d.init)))
+	e.buf = append(e.buf, d.init...)
+}
+
+// expr writes instructions followed by the end opcode.
+func (e *encoder) expr(code []*instr) {
+	for _, in := range code {
+		e.instr(in)
+	}
+	e.byte(opcodeEnd)
+}
+
+// instr writes an instruction after its folded operands.
+func (e *encoder) instr(in *instr) {
+	for _, a := range in.args {
+		e.instr(a)
+	}
+
+	code, ok := opcodeOf[in.op]
+	if !ok {
+		e.errorf("cannot encode %s", in.op)
+	}
+	if in.op == text.OpSelect && in.results != nil {
+		code = 0x1c
+	}
+	if e.mapping && in.node != nil && in.node.Span.Start.Line > 0 {
+		e.bodyMap = append(e.bodyMap, SourceMapping{Offset: len(e.buf), Pos: in.node.Span.Start})
+	}
+	switch {
+	case code >= prefixSIMD:
+		e.byte(0xfd)
+		e.u32(code &^ prefixSIMD)
+	case code >= prefixMisc:
+		e.byte(0xfc)
+		e.u32(code &^ prefixMisc)
+	default:
+		e.byte(byte(code))
+	}
+
+	switch in.op {
+	case text.OpBlock, text.OpLoop, text.OpIf:
+		e.blockType(in.params, in.results)
+		for _, b := range in.body {
+			e.instr(b)
+		}
+		if len(in.els) > 0 {
+			e.byte(opcodeElse)
+			for _, b := range in.els {
+				e.instr(b)
+			}
+		}
+		e.byte(opcodeEnd)
+	case text.OpBr, text.OpBrIf, text.OpLocalGet, text.OpLocalSet, text.OpLocalTee,
+		text.OpGlobalGet, text.OpGlobalSet, text.OpTableGet, text.OpTableSet,
+		text.OpTableGrow, text.OpTableSize, text.OpTableFill, text.OpElemDrop,
+		text.OpCall, text.OpRefFunc:
+		e.u32(uint32(in.imm))
+	case text.OpCallIndirect:
+		e.u32(uint32(in.imm))
+		e.u32(uint32(in.imm2))
+	case text.OpBrTable:
+		e.u32(uint32(len(in.labels) - 1))
+		for _, l := range in.labels {
+			e.u32(l)
+		}
+	case text.OpSelect:
+		if in.results != nil {
+			e.valtypes(in.results)
+		}
+	case text.OpMemorySize, text.OpMemoryGrow, text.OpMemoryFill:
+		e.u32(in.mem)
+	case text.OpMemoryCopy:
+		e.u32(in.mem)
+		e.u32(uint32(in.imm2))
+	case text.OpMemoryInit:
+		e.needDataCount = true
+		e.u32(uint32(in.imm))
+		e.u32(in.mem)
+	case text.OpDataDrop:
+		e.needDataCount = true
+		e.u32(uint32(in.imm))
+	case text.OpTableInit:
+		// the segment comes first
+		e.u32(uint32(in.imm2))
+		e.u32(uint32(in.imm))
+	case text.OpTableCopy:
+		e.u32(uint32(in.imm))
+		e.u32(uint32(in.imm2))
+	case text.OpI32Const:
+		e.s64(int64(int32(in.imm)))
+	case text.OpI64Const:
+		e.s64(int64(in.imm))
+	case text.OpF32Const:
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(in.imm))
+	case text.OpF64Const:
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, in.imm)
+	case text.OpV128Const:
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, in.imm)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, in.imm2)
+	case text.OpRefNull:
+		e.byte(byte(in.imm))
+	default:
+		switch {
+		case isMemoryAccess(in.op):
+			e.memarg(in)
+		case isLaneAccess(in.op):
+			e.byte(byte(in.imm))
+		}
+	}
+}
+
+// blockType writes the type of a block inline when it has no params and at
+// most one result, and as a type index otherwise.
+func (e *encoder) blockType(params, results []ValueType) {
+	switch {
+	case len(params) == 0 && len(results) == 0:
+		e.byte(0x40)
+		return
+	case len(params) == 0 && len(results) == 1:
+		e.byte(byte(results[0]))
+		return
+	}
+
+	t := funcType{params: params, results: results}
+	for i, u := range e.types {
+		if u.equal(t) {
+			e.s64(int64(i))
+			return
+		}
+	}
+	e.types = append(e.types[:len(e.types):len(e.types)], t)
+	e.s64(int64(len(e.types) - 1))
+}
+
+// memarg writes the log2 of the alignment and the offset of a memory
+// access, as the decoder reads them back. A memory other than 0 sets bit 6
+// of the alignment and follows it.
+func (e *encoder) memarg(in *instr) {
+	if in.mem == 0 {
+		e.u32(uint32(in.imm2))
+	} else {
+		e.u32(uint32(in.imm2) | 0x40)
+		e.u32(in.mem)
+	}
+	e.u32(uint32(in.imm))
+}
+
+func (e *encoder) globalType(t globalType) {
+	e.byte(byte(t.typ))
+	if t.mut {
+		e.byte(0x01)
+	} else {
+		e.byte(0x00)
+	}
+}
+
+// limits writes limits with their flag: bit 0 set if they have a maximum
+// and bit 1 if they are shared.
+func (e *encoder) limits(l limits) {
+	var flag byte
+	if l.hasMax {
+		flag |= 0x01
+	}
+	if l.shared {
+		flag |= 0x02
+	}
+	e.byte(flag)
+	e.u32(l.min)
+	if l.hasMax {
+		e.u32(l.max)
+	}
+}
+
+func (e *encoder) valtypes(types []ValueType) {
+	e.u32(uint32(len(types)))
+	for _, t := range types {
+		e.byte(byte(t))
+	}
+}
+
+func (e *encoder) name(s string) {
+	e.u32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+// u32 writes an unsigned LEB128 integer in as few bytes as possible.
+func (e *encoder) u32(v uint32) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(v))
+}
+
+// s64 writes a signed LEB128 integer in as few bytes as possible.
+func (e *encoder) s64(v int64) {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		// done once the rest is the sign extension of the last byte
+		if v == 0 && b&0x40 == 0 || v == -1 && b&0x40 != 0 {
+			e.buf = append(e.buf, b)
+			return
+		}
+		e.buf = append(e.buf, b|0x80)
+	}
+}
@@ -0,0 +1,253 @@
+package main_test
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []byte
+	}{
+		{"double", `(module
+  (func $add (param i32 i32) (result i32)
+    (i32.add (local.get 0) (local.get 1)))
+  (func (export "double") (param i32) (result i32)
+    (call $add (local.get 0) (local.get 0))))`, double},
+		// as written by wat2wasm
+		{"leb128", `(module
+  (memory 1 2)
+  (global (mut i64) (i64.const -129))
+  (func (result i32) (local i32 i32 i64)
+    (i32.load offset=624485 align=1 (i32.const 64)))
+  (data (i32.const 16) "hi"))`, module(
+			[]byte{0x01, 0x05, 0x01, 0x60, 0x00, 0x01, 0x7f},
+			[]byte{0x03, 0x02, 0x01, 0x00},
+			[]byte{0x05, 0x04, 0x01, 0x01, 0x01, 0x02},
+			[]byte{0x06, 0x07, 0x01, 0x7e, 0x01, 0x42, 0xff, 0x7e, 0x0b},
+			[]byte{0x0a, 0x10, 0x01, 0x0e, 0x02, 0x02, 0x7f, 0x01, 0x7e,
+				0x41, 0xc0, 0x00, 0x28, 0x00, 0xe5, 0x8e, 0x26, 0x0b},
+			[]byte{0x0b, 0x08, 0x01, 0x00, 0x41, 0x10, 0x0b, 0x02, 'h', 'i'},
+		)},
+		{"externref elem", `(module
+  (table 2 externref)
+  (elem (table 0) (i32.const 0) externref (ref.null extern)))`, module(
+			[]byte{0x04, 0x04, 0x01, 0x6f, 0x00, 0x02},
+			[]byte{0x09, 0x0b, 0x01, 0x06, 0x00, 0x41, 0x00, 0x0b, 0x6f, 0x01, 0xd0, 0x6f, 0x0b},
+		)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := war.Assemble([]byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got\n% x\nexpected\n% x", got, tt.want)
+			}
+			text, err := war.Disassemble(got)
+			if err != nil {
+				t.Fatalf("disassembling: %v", err)
+			}
+			again, err := war.Assemble(text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, got) {
+				t.Errorf("got\n% x\nafter a round trip, expected\n% x", again, got)
+			}
+		})
+	}
+}
+
+func TestMemargRoundTrip(t *testing.T) {
+	tests := []struct {
+		memarg string
+		want   []byte // the load and its memarg
+		text   string // as disassembled
+	}{
+		{"offset=16 align=8", []byte{0x29, 0x03, 0x10}, "i64.load offset=16)"},
+		{"offset=16", []byte{0x29, 0x03, 0x10}, "i64.load offset=16)"},
+		{"align=4", []byte{0x29, 0x02, 0x00}, "i64.load align=4)"},
+		{"offset=200 align=1", []byte{0x29, 0x00, 0xc8, 0x01}, "i64.load offset=200 align=1)"},
+		{"$m offset=16", []byte{0x29, 0x43, 0x01, 0x10}, "i64.load 1 offset=16)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.memarg, func(t *testing.T) {
+			src := `(module (memory 1) (memory $m 1) (func (param i32) (result i64)
+  (i64.load ` + tt.memarg + ` (local.get 0))))`
+			wasm, err := war.Assemble([]byte(src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(wasm, append([]byte{0x20, 0x00}, tt.want...)) {
+				t.Errorf("got\n% x\nexpected it to contain % x", wasm, tt.want)
+			}
+
+			text, err := war.Disassemble(wasm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(text, []byte(tt.text)) {
+				t.Errorf("got\n%s\nexpected it to contain %q", text, tt.text)
+			}
+			again, err := war.Assemble(text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, wasm) {
+				t.Errorf("got\n% x\nafter a round trip, expected\n% x", again, wasm)
+			}
+		})
+	}
+
+	for _, memarg := range []string{"align=16", "align=3", "offset=0x1_0000_0000"} {
+		src := `(module (memory 1) (func (param i32) (result i64) (i64.load ` + memarg + ` (local.get 0))))`
+		if _, err := war.Assemble([]byte(src)); err == nil {
+			t.Errorf("%s: expected an error", memarg)
+		}
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	src := `(module
+  (func (param i32 i32) (result i32)
+    (i32.add
+      (local.get 0)
+      (local.get 1))))`
+	wasm, sm, err := war.AssembleWithSourceMap([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := bytes.Index(wasm, []byte{0x20, 0x00, 0x20, 0x01, 0x6a, 0x0b})
+	if body < 0 {
+		t.Fatalf("body not found in\n% x", wasm)
+	}
+	for _, tt := range []struct {
+		offset    int
+		line, col int
+	}{
+		{body, 4, 7},     // local.get 0
+		{body + 1, 4, 7}, // its immediate
+		{body + 2, 5, 7}, // local.get 1
+		{body + 4, 3, 5}, // i32.add
+	} {
+		pos, ok := sm.Lookup(tt.offset)
+		if !ok || pos.Line != tt.line || pos.Col != tt.col {
+			t.Errorf("offset %d: got %v, expected %d:%d", tt.offset, pos, tt.line, tt.col)
+		}
+	}
+	if _, ok := sm.Lookup(body - 1); ok {
+		t.Errorf("offset %d before the code is mapped", body-1)
+	}
+}
+
+func TestConstLEB128(t *testing.T) {
+	tests := []struct {
+		typ  string
+		val  int64
+		want []byte // the const instruction
+	}{
+		{"i32", -1, []byte{0x41, 0x7f}},
+		{"i32", 63, []byte{0x41, 0x3f}},
+		{"i32", 64, []byte{0x41, 0xc0, 0x00}},
+		{"i32", -64, []byte{0x41, 0x40}},
+		{"i32", -65, []byte{0x41, 0xbf, 0x7f}},
+		{"i32", math.MinInt32, []byte{0x41, 0x80, 0x80, 0x80, 0x80, 0x78}},
+		{"i32", math.MaxInt32, []byte{0x41, 0xff, 0xff, 0xff, 0xff, 0x07}},
+		{"i64", -1, []byte{0x42, 0x7f}},
+		{"i64", math.MinInt64, []byte{0x42, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7f}},
+		{"i64", math.MaxInt64, []byte{0x42, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}},
+		{"i64", 1<<62 - 1, []byte{0x42, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x3f}},
+		{"i64", 1 << 62, []byte{0x42, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0xc0, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d", tt.typ, tt.val), func(t *testing.T) {
+			src := fmt.Sprintf(`(module (func (export "f") (result %s) (%s.const %d)))`, tt.typ, tt.typ, tt.val)
+			wasm, err := war.Assemble([]byte(src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(wasm, append(tt.want, 0x0b)) {
+				t.Errorf("got\n% x\nexpected it to contain\n% x", wasm, tt.want)
+			}
+
+			text, err := war.Disassemble(wasm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := war.NewRuntime()
+			if _, err := r.Instantiate(text); err != nil {
+				t.Fatal(err)
+			}
+			got, err := r.Invoke("f")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := war.I64(tt.val)
+			if tt.typ == "i32" {
+				want = war.I32(int32(tt.val))
+			}
+			if got[0] != want {
+				t.Errorf("got %v, expected %v", got[0], want)
+			}
+		})
+	}
+}
+
+func TestSIMDRoundTrip(t *testing.T) {
+	src := `(module
+  (global $g v128 (v128.const i32x4 1 2 3 -1))
+  (func (export "sum") (param v128) (result i64)
+    (i64x2.extract_lane 1 (i64x2.add (local.get 0) (global.get $g)))))`
+	wasm, err := war.Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// v128.const, i64x2.extract_lane 1 and i64x2.add
+	for _, want := range [][]byte{
+		{0xfd, 0x0c, 1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 0xff, 0xff, 0xff, 0xff},
+		{0xfd, 0xce, 0x01, 0xfd, 0x1d, 0x01},
+	} {
+		if !bytes.Contains(wasm, want) {
+			t.Errorf("got\n% x\nexpected it to contain % x", wasm, want)
+		}
+	}
+
+	m, err := war.DecodeModule(wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := war.NewRuntime().InstantiateModule(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := inst.Invoke("sum", war.V128(0, 1))
+	if want := war.I64(-0xfffffffc); err != nil || got[0] != want {
+		t.Errorf("got %v, %v, expected %v", got, err, want)
+	}
+
+	// the instructions the interpreter doesn't run still disassemble
+	text, err := war.Disassemble(module(
+		[]byte{0x01, 0x05, 0x01, 0x60, 0x01, 0x7b, 0x00},
+		[]byte{0x03, 0x02, 0x01, 0x00},
+		[]byte{0x0a, 0x1e, 0x01, 0x1c, 0x00, 0x20, 0x00, 0x20, 0x00, 0xfd, 0x0d,
+			0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 0xfd, 0x15, 0x03, 0x1a, 0x0b},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"(param v128)", "i8x16.shuffle 0 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15", "i8x16.extract_lane_s 3"} {
+		if !bytes.Contains(text, []byte(want)) {
+			t.Errorf("got\n%s\nexpected it to contain %q", text, want)
+		}
+	}
+}
@@ -1,5 +1,113 @@
 package main
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bluescreen10/war/text"
+)
 
 var ErrNotImplemented = errors.New("not implemented")
+
+// ErrOutOfBounds is the error of host accesses outside of a memory.
+var ErrOutOfBounds = errors.New(trapMemoryBounds)
+
+// ErrMemoryGrown is the error of accesses through a memory view taken
+// before the memory grew.
+var ErrMemoryGrown = errors.New("memory grew since the view was taken")
+
+// InternalError is the error of a panic while running a module, either a
+// bug of the runtime, such as an instruction the interpreter doesn't
+// handle, or of a host function. The panic is recovered from so that it
+// doesn't take the embedder down.
+type InternalError struct {
+	Op    text.Op  // executing instruction, if any
+	Pos   text.Pos // source position of Op, zero when not parsed from text
+	Value any      // the value of the panic
+}
+
+func (e *InternalError) Error() string {
+	if e.Op == text.OpUnkown {
+		return fmt.Sprintf("internal error: %v", e.Value)
+	}
+	return fmt.Sprintf("internal error running %s at %s: %v", e.Op, e.Pos, e.Value)
+}
+
+// Frame is an entry of the call stack captured when a trap occurs.
+type Frame struct {
+	Func   uint32 // index of the function in the module's function index space
+	Name   string // name of the function, if known
+	Offset int    // offset of the executing instruction within the function
+}
+
+func (f Frame) String() string {
+	if f.Name != "" {
+		return fmt.Sprintf("%s+%d", f.Name, f.Offset)
+	}
+	return fmt.Sprintf("func[%d]+%d", f.Func, f.Offset)
+}
+
+// LinkError is the error returned when an import of a module can't be
+// resolved.
+type LinkError struct {
+	Module string
+	Name   string
+	Reason string
+}
+
+func (e *LinkError) Error() string {
+	return fmt.Sprintf("%s %q %q", e.Reason, e.Module, e.Name)
+}
+
+// Trap reasons, worded as in the spec testsuite so assert_trap matches them.
+const (
+	trapUnreachable       = "unreachable"
+	trapDivideByZero      = "integer divide by zero"
+	trapIntegerOverflow   = "integer overflow"
+	trapInvalidConversion = "invalid conversion to integer"
+	trapMemoryBounds      = "out of bounds memory access"
+	trapTableBounds       = "out of bounds table access"
+	trapStackExhausted    = "call stack exhausted"
+	trapUndefinedElement  = "undefined element"
+	trapUninitialized     = "uninitialized element"
+	trapIndirectCallType  = "indirect call type mismatch"
+
+	// only with WithTrapOnGrowFailure
+	trapMemoryGrow = "memory.grow failed"
+	trapTableGrow  = "table.grow failed"
+)
+
+// Trap is the error returned when execution traps.
+type Trap struct {
+	Reason string
+	// Pos is the source position of the trapping instruction, zero when the
+	// module wasn't parsed from text.
+	Pos    text.Pos
+	frames []Frame
+}
+
+func (t *Trap) Error() string {
+	return t.Reason
+}
+
+// Frames returns the call stack at the time of the trap, innermost frame
+// first.
+func (t *Trap) Frames() []Frame {
+	return t.frames
+}
+
+// Trace returns the reason of the trap followed by its call stack.
+func (t *Trap) Trace() string {
+	var s strings.Builder
+	s.WriteString(t.Reason)
+	if t.Pos.Line > 0 {
+		s.WriteString(" at ")
+		s.WriteString(t.Pos.String())
+	}
+	for _, f := range t.frames {
+		s.WriteString("\n\tat ")
+		s.WriteString(f.String())
+	}
+	return s.String()
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bluescreen10/war/text"
+)
+
+// Feature is a proposal standardized after the first version of
+// WebAssembly, which a runtime can be restricted not to support in order to
+// behave like an older engine.
+type Feature uint32
+
+const (
+	FeatureSIMD Feature = 1 << iota
+	FeatureReferenceTypes
+	FeatureBulkMemory
+	FeatureMultiValue
+	FeatureMultiMemory
+
+	allFeatures = FeatureSIMD | FeatureReferenceTypes | FeatureBulkMemory | FeatureMultiValue |
+		FeatureMultiMemory
+)
+
+func (f Feature) String() string {
+	switch f {
+	case FeatureSIMD:
+		return "SIMD"
+	case FeatureReferenceTypes:
+		return "reference types"
+	case FeatureBulkMemory:
+		return "bulk memory"
+	case FeatureMultiValue:
+		return "multi-value"
+	case FeatureMultiMemory:
+		return "multi-memory"
+	}
+	return fmt.Sprintf("Feature(%d)", uint32(f))
+}
+
+// WithFeatures enables only the given features, all of them being enabled
+// by default. Modules using the others fail to compile.
+func WithFeatures(features ...Feature) RuntimeOption {
+	return func(r *Runtime) {
+		r.features = 0
+		for _, f := range features {
+			r.features |= f
+		}
+	}
+}
+
+// featureError is the error of using a disabled feature.
+func featureError(f Feature) error {
+	return fmt.Errorf("%s support is not enabled", f)
+}
+
+// checkFeatures rejects the types, instructions and segments of a module
+// that belong to features not enabled.
+func (m *Module) checkFeatures(enabled Feature) error {
+	c := &featureChecker{enabled: enabled}
+	for _, t := range m.types {
+		c.funcType(t)
+	}
+	for _, imp := range m.imports {
+		switch imp.kind {
+		case ExternGlobal:
+			c.valueType(imp.global.typ)
+		case ExternTable:
+			c.tableType(imp.elem)
+		}
+	}
+	for i, f := range m.funcs {
+		c.funcType(f.typ)
+		for _, t := range f.locals {
+			c.valueType(t)
+		}
+		c.code(f.body)
+		if c.err != nil && f.name != "" {
+			return fmt.Errorf("func $%s: %w", f.name, c.err)
+		} else if c.err != nil {
+			return fmt.Errorf("func %d: %w", countImports(m.imports, ExternFunc)+i, c.err)
+		}
+	}
+	for _, g := range m.globals {
+		c.valueType(g.typ.typ)
+		c.code(g.init)
+	}
+	if len(m.tables)+countImports(m.imports, ExternTable) > 1 {
+		c.require(FeatureReferenceTypes)
+	}
+	for _, t := range m.tables {
+		c.tableType(t.typ)
+	}
+	if len(m.mems)+countImports(m.imports, ExternMemory) > 1 {
+		c.require(FeatureMultiMemory)
+	}
+	for _, e := range m.elems {
+		switch {
+		case e.declare:
+			c.require(FeatureReferenceTypes)
+		case e.offset == nil:
+			c.require(FeatureBulkMemory)
+		}
+	}
+	for _, d := range m.datas {
+		if d.offset == nil {
+			c.require(FeatureBulkMemory)
+		}
+	}
+	return c.err
+}
+
+func countImports(imports []importEntry, kind ExternKind) int {
+	n := 0
+	for _, imp := range imports {
+		if imp.kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// featureChecker keeps the first use of a disabled feature.
+type featureChecker struct {
+	enabled Feature
+	err     error
+}
+
+func (c *featureChecker) require(f Feature) {
+	if c.err == nil && c.enabled&f == 0 {
+		c.err = featureError(f)
+	}
+}
+
+func (c *featureChecker) valueType(t ValueType) {
+	switch t {
+	case ValueTypeV128:
+		c.require(FeatureSIMD)
+	case ValueTypeFuncRef, ValueTypeExternRef:
+		c.require(FeatureReferenceTypes)
+	}
+}
+
+// tableType checks the element type of a table, funcref tables being part
+// of the first version.
+func (c *featureChecker) tableType(t ValueType) {
+	if t != ValueTypeFuncRef {
+		c.valueType(t)
+	}
+}
+
+func (c *featureChecker) funcType(t funcType) {
+	for _, p := range t.params {
+		c.valueType(p)
+	}
+	for _, r := range t.results {
+		c.valueType(r)
+	}
+	if len(t.results) > 1 {
+		c.require(FeatureMultiValue)
+	}
+}
+
+func (c *featureChecker) code(code []*instr) {
+	for _, in := range code {
+		if c.err != nil {
+			return
+		}
+		if f, ok := instrFeature(in); ok {
+			c.require(f)
+			if c.err != nil {
+				c.err = fmt.Errorf("%s: %w", in.op, c.err)
+				return
+			}
+		}
+		switch in.op {
+		case text.OpBlock, text.OpLoop, text.OpIf:
+			if len(in.params) > 0 || len(in.results) > 1 {
+				c.require(FeatureMultiValue)
+			}
+		case text.OpMemoryCopy:
+			if in.imm2 != 0 {
+				c.require(FeatureMultiMemory)
+			}
+		}
+		if in.mem != 0 {
+			c.require(FeatureMultiMemory)
+		}
+		for _, t := range in.params {
+			c.valueType(t)
+		}
+		for _, t := range in.results {
+			c.valueType(t)
+		}
+		c.code(in.args)
+		c.code(in.body)
+		c.code(in.els)
+	}
+}
+
+// instrFeature returns the feature an instruction belongs to, if not the
+// first version.
+func instrFeature(in *instr) (Feature, bool) {
+	switch in.op {
+	case text.OpRefNull, text.OpRefIsNull, text.OpRefFunc,
+		text.OpTableGet, text.OpTableSet, text.OpTableSize, text.OpTableGrow, text.OpTableFill:
+		return FeatureReferenceTypes, true
+	case text.OpMemoryCopy, text.OpMemoryFill, text.OpMemoryInit, text.OpDataDrop,
+		text.OpTableCopy, text.OpTableInit, text.OpElemDrop:
+		return FeatureBulkMemory, true
+	case text.OpSelect:
+		// only the typed select
+		return FeatureReferenceTypes, len(in.results) > 0
+	}
+	if isSIMD(in.op) || strings.HasPrefix(in.op.String(), "v128.") {
+		return FeatureSIMD, true
+	}
+	return 0, false
+}
@@ -0,0 +1,74 @@
+package main_test
+
+import (
+	"strings"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+func TestFeatures(t *testing.T) {
+	all := []war.Feature{war.FeatureSIMD, war.FeatureReferenceTypes, war.FeatureBulkMemory, war.FeatureMultiValue,
+		war.FeatureMultiMemory}
+	tests := []struct {
+		name    string
+		src     string
+		feature war.Feature
+		err     string
+	}{
+		{"v128.const", `(module (func (drop (v128.const i32x4 1 2 3 4))))`,
+			war.FeatureSIMD, "func 0: v128.const: SIMD support is not enabled"},
+		{"v128 param", `(module (func $f (param v128)))`,
+			war.FeatureSIMD, "SIMD support is not enabled"},
+		{"v128 global", `(module (global v128 (v128.const i64x2 0 0)))`,
+			war.FeatureSIMD, "SIMD support is not enabled"},
+		{"externref table", `(module (table 1 externref))`,
+			war.FeatureReferenceTypes, "reference types support is not enabled"},
+		{"ref.null", `(module (func (drop (ref.null func))))`,
+			war.FeatureReferenceTypes, "func 0: ref.null: reference types support is not enabled"},
+		{"memory.fill", `(module (memory 1) (func (memory.fill (i32.const 0) (i32.const 0) (i32.const 0))))`,
+			war.FeatureBulkMemory, "func 0: memory.fill: bulk memory support is not enabled"},
+		{"passive data", `(module (memory 1) (data "x"))`,
+			war.FeatureBulkMemory, "bulk memory support is not enabled"},
+		{"two results", `(module (func $swap (param i32 i32) (result i32 i32) (local.get 1) (local.get 0)))`,
+			war.FeatureMultiValue, "multi-value support is not enabled"},
+		{"block params", `(module (func (i32.const 1) (block (param i32) (drop))))`,
+			war.FeatureMultiValue, "func 0: multi-value support is not enabled"},
+		{"two memories", `(module (memory 1) (memory 1))`,
+			war.FeatureMultiMemory, "multi-memory support is not enabled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// all features are enabled by default
+			if _, err := war.NewRuntime().Instantiate([]byte(tt.src)); err != nil {
+				t.Fatal(err)
+			}
+
+			var others []war.Feature
+			for _, f := range all {
+				if f != tt.feature {
+					others = append(others, f)
+				}
+			}
+			_, err := war.NewRuntime(war.WithFeatures(others...)).Instantiate([]byte(tt.src))
+			if err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+				t.Errorf("got error %v, expected %q", err, tt.err)
+			}
+		})
+	}
+
+	// modules of the first version run with no feature at all
+	r := war.NewRuntime(war.WithFeatures())
+	if _, err := r.Instantiate([]byte(`(module (table 1 funcref) (memory 1) (data (i32.const 0) "x")
+  (func (export "f") (result i32) (i32.load8_u (i32.const 0))))`)); err != nil {
+		t.Error(err)
+	}
+
+	// functions are numbered in the function index space, imports first
+	_, err := war.NewRuntime(war.WithFeatures()).Instantiate([]byte(`(module (import "lib" "f" (func))
+  (func (drop (ref.null func))))`))
+	if want := "func 1: ref.null: reference types support is not enabled"; err == nil || !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("got error %v, expected %q", err, want)
+	}
+}
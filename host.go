@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// HostFunc is a function implemented in Go that modules can import. Fn
+// receives arguments matching Params and must return values matching
+// Results. An error returned by Fn traps with the error as reason.
+type HostFunc struct {
+	Params  []ValueType
+	Results []ValueType
+	Fn      func(args []Value) ([]Value, error)
+}
+
+var errorType = reflect.TypeFor[error]()
+
+// WrapHostFunc adapts a Go function to a HostFunc. Parameters and results
+// may be of kind int32, int64, float32 and float64, which map to i32, i64,
+// f32 and f64. A final error result, if any, traps when not nil.
+func WrapHostFunc(fn any) (HostFunc, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return HostFunc{}, fmt.Errorf("expected a function, got %T", fn)
+	}
+	t := v.Type()
+	if t.IsVariadic() {
+		return HostFunc{}, fmt.Errorf("unsupported variadic function %s", t)
+	}
+
+	var h HostFunc
+	for i := range t.NumIn() {
+		vt, ok := hostType(t.In(i))
+		if !ok {
+			return HostFunc{}, fmt.Errorf("unsupported parameter type %s", t.In(i))
+		}
+		h.Params = append(h.Params, vt)
+	}
+	nresults := t.NumOut()
+	withErr := nresults > 0 && t.Out(nresults-1) == errorType
+	if withErr {
+		nresults--
+	}
+	for i := range nresults {
+		vt, ok := hostType(t.Out(i))
+		if !ok {
+			return HostFunc{}, fmt.Errorf("unsupported result type %s", t.Out(i))
+		}
+		h.Results = append(h.Results, vt)
+	}
+
+	h.Fn = func(args []Value) ([]Value, error) {
+		in := make([]reflect.Value, len(args))
+		for i, a := range args {
+			in[i] = toReflect(a).Convert(t.In(i))
+		}
+		out := v.Call(in)
+		if withErr {
+			if err := out[nresults]; !err.IsNil() {
+				return nil, err.Interface().(error)
+			}
+			out = out[:nresults]
+		}
+		results := make([]Value, len(out))
+		for i, o := range out {
+			results[i] = fromReflect(o, h.Results[i])
+		}
+		return results, nil
+	}
+	return h, nil
+}
+
+func hostType(t reflect.Type) (ValueType, bool) {
+	switch t.Kind() {
+	case reflect.Int32:
+		return ValueTypeI32, true
+	case reflect.Int64:
+		return ValueTypeI64, true
+	case reflect.Float32:
+		return ValueTypeF32, true
+	case reflect.Float64:
+		return ValueTypeF64, true
+	}
+	return 0, false
+}
+
+func toReflect(v Value) reflect.Value {
+	switch v.typ {
+	case ValueTypeI32:
+		return reflect.ValueOf(v.I32())
+	case ValueTypeI64:
+		return reflect.ValueOf(v.I64())
+	case ValueTypeF32:
+		return reflect.ValueOf(v.F32())
+	}
+	return reflect.ValueOf(v.F64())
+}
+
+func fromReflect(v reflect.Value, t ValueType) Value {
+	switch t {
+	case ValueTypeI32:
+		return I32(int32(v.Int()))
+	case ValueTypeI64:
+		return I64(v.Int())
+	case ValueTypeF32:
+		return F32(float32(v.Float()))
+	}
+	return F64(v.Float())
+}
+
+// HostModule is what the host provides for modules to import under a
+// module name. Names must be distinct across the kinds.
+type HostModule struct {
+	Funcs    map[string]HostFunc
+	Globals  map[string]*Global
+	Tables   map[string]*Table
+	Memories map[string]*Memory
+}
+
+// RegisterHost makes funcs available for import under the module name, as
+// if exported by an instance, and returns that instance.
+func (r *Runtime) RegisterHost(name string, funcs map[string]HostFunc) *Instance {
+	return r.RegisterHostModule(name, HostModule{Funcs: funcs})
+}
+
+// RegisterHostModule makes the functions, globals, tables and memories of h
+// available for import under the module name, as if exported by an
+// instance, and returns that instance. Globals, tables and memories are
+// shared with the modules importing them, which see the changes the host
+// makes and the other way around.
+func (r *Runtime) RegisterHostModule(name string, h HostModule) *Instance {
+	inst := &Instance{rt: r, exports: map[string]export{}}
+	for i, n := range sortedNames(h.Funcs) {
+		f := h.Funcs[n]
+		inst.funcs = append(inst.funcs, &funcInst{idx: uint32(i), typ: funcType{params: f.Params, results: f.Results}, inst: inst, host: &f})
+		inst.export(export{name: n, kind: ExternFunc, index: uint32(i)})
+	}
+	for i, n := range sortedNames(h.Globals) {
+		inst.globals = append(inst.globals, h.Globals[n])
+		inst.export(export{name: n, kind: ExternGlobal, index: uint32(i)})
+	}
+	for i, n := range sortedNames(h.Tables) {
+		inst.tables = append(inst.tables, h.Tables[n])
+		inst.export(export{name: n, kind: ExternTable, index: uint32(i)})
+	}
+	for i, n := range sortedNames(h.Memories) {
+		inst.mems = append(inst.mems, h.Memories[n])
+		inst.export(export{name: n, kind: ExternMemory, index: uint32(i)})
+	}
+	r.Register(name, inst)
+	return inst
+}
+
+// WithImports registers h under the module name, as RegisterHostModule
+// does, when the runtime is created.
+func WithImports(name string, h HostModule) RuntimeOption {
+	return func(r *Runtime) {
+		r.RegisterHostModule(name, h)
+	}
+}
+
+func sortedNames[T any](m map[string]T) []string {
+	names := make([]string, 0, len(m))
+	for n := range m {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// callHost calls a host function with the arguments on top of the stack.
+func (m *machine) callHost(f *funcInst) {
+	nparams := len(f.typ.params)
+	args := slices.Clone(m.stack[len(m.stack)-nparams:])
+	m.truncate(len(m.stack) - nparams)
+
+	results, err := f.host.Fn(args)
+	if err != nil {
+		m.trap(err.Error())
+	}
+	if len(results) != len(f.typ.results) {
+		m.trap(fmt.Sprintf("host function %s returned %d results, expected %d", f, len(results), len(f.typ.results)))
+	}
+	for i, v := range results {
+		if v.typ != f.typ.results[i] {
+			m.trap(fmt.Sprintf("host function %s returned %s, expected %s", f, v.typ, f.typ.results[i]))
+		}
+	}
+	m.stack = append(m.stack, results...)
+}
@@ -0,0 +1,182 @@
+package main_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+func TestWrapHostFunc(t *testing.T) {
+	add, err := war.WrapHostFunc(func(a, b int32) int32 { return a + b })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(add.Params, []war.ValueType{war.ValueTypeI32, war.ValueTypeI32}) ||
+		!slices.Equal(add.Results, []war.ValueType{war.ValueTypeI32}) {
+		t.Errorf("got signature %v -> %v", add.Params, add.Results)
+	}
+	got, err := add.Fn([]war.Value{war.I32(40), war.I32(2)})
+	if err != nil || len(got) != 1 || got[0] != war.I32(42) {
+		t.Errorf("got %v, %v, expected [i32:42]", got, err)
+	}
+
+	if _, err := war.WrapHostFunc(func(string) {}); err == nil {
+		t.Error("expected an error wrapping func(string)")
+	}
+	if _, err := war.WrapHostFunc(42); err == nil {
+		t.Error("expected an error wrapping a non function")
+	}
+}
+
+func TestHostImports(t *testing.T) {
+	r := war.NewRuntime()
+	add, err := war.WrapHostFunc(func(a, b int32) int32 { return a + b })
+	if err != nil {
+		t.Fatal(err)
+	}
+	half, err := war.WrapHostFunc(func(x float64) (float64, error) {
+		if x < 0 {
+			return 0, errors.New("negative")
+		}
+		return x / 2, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.RegisterHost("env", map[string]war.HostFunc{"add": add, "half": half})
+
+	_, err = r.Instantiate([]byte(`(module
+  (import "env" "add" (func $add (param i32 i32) (result i32)))
+  (import "env" "half" (func $half (param f64) (result f64)))
+  (func (export "main") (result i32)
+    (call $add (i32.const 40) (i32.const 2)))
+  (func (export "half") (param f64) (result f64)
+    (call $half (local.get 0))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Invoke("main")
+	if err != nil || got[0] != war.I32(42) {
+		t.Errorf("main: got %v, %v, expected [i32:42]", got, err)
+	}
+	got, err = r.Invoke("half", war.F64(3))
+	if err != nil || got[0] != war.F64(1.5) {
+		t.Errorf("half: got %v, %v, expected [f64:1.5]", got, err)
+	}
+	_, err = r.Invoke("half", war.F64(-1))
+	var trap *war.Trap
+	if !errors.As(err, &trap) || trap.Reason != "negative" {
+		t.Errorf("half: got %v, expected a trap", err)
+	}
+
+	_, err = r.Instantiate([]byte(`(module
+  (import "env" "add" (func (param i64 i64) (result i64))))`))
+	var linkErr *war.LinkError
+	if !errors.As(err, &linkErr) {
+		t.Errorf("got %v, expected a link error", err)
+	}
+}
+
+func TestHostModule(t *testing.T) {
+	base, err := war.NewGlobal(war.ExternType{Value: war.ValueTypeI32}, war.I32(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter, err := war.NewGlobal(war.ExternType{Value: war.ValueTypeI64, Mutable: true}, war.I64(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tab, err := war.NewTable(war.ExternType{Value: war.ValueTypeFuncRef, Min: 2, Max: 4, HasMax: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, err := war.NewMemory(war.ExternType{Min: 1, Max: 2, HasMax: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := war.NewRuntime(war.WithImports("env", war.HostModule{
+		Globals:  map[string]*war.Global{"base": base, "counter": counter},
+		Tables:   map[string]*war.Table{"tab": tab},
+		Memories: map[string]*war.Memory{"mem": mem},
+	}))
+	_, err = r.Instantiate([]byte(`(module
+  (import "env" "base" (global $base i32))
+  (import "env" "counter" (global $counter (mut i64)))
+  (import "env" "tab" (table 1 funcref))
+  (import "env" "mem" (memory 1))
+  (func $f)
+  (elem declare func $f)
+  (func (export "base") (result i32) (global.get $base))
+  (func (export "tick") (result i64)
+    (global.set $counter (i64.add (global.get $counter) (i64.const 1)))
+    (global.get $counter))
+  (func (export "store") (param i32 i32) (i32.store (local.get 0) (local.get 1)))
+  (func (export "set") (param i32) (table.set (local.get 0) (ref.func $f))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := r.Invoke("base"); err != nil || got[0] != war.I32(100) {
+		t.Errorf("base: got %v, %v, expected [i32:100]", got, err)
+	}
+
+	// the host and the module share the globals
+	if err := counter.Set(war.I64(41)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.Invoke("tick"); err != nil || got[0] != war.I64(42) {
+		t.Errorf("tick: got %v, %v, expected [i64:42]", got, err)
+	}
+	if counter.Get() != war.I64(42) {
+		t.Errorf("got counter %v, expected 42", counter.Get())
+	}
+	if err := base.Set(war.I32(1)); err == nil {
+		t.Error("expected an error setting an immutable global")
+	}
+
+	// and so the memory and the table
+	if _, err := r.Invoke("store", war.I32(8), war.I32(0x01020304)); err != nil {
+		t.Fatal(err)
+	}
+	if b := mem.Bytes()[8:12]; !slices.Equal(b, []byte{4, 3, 2, 1}) {
+		t.Errorf("got memory % x", b)
+	}
+	if _, err := r.Invoke("set", war.I32(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// imports are checked against the host types
+	for _, src := range []string{
+		`(module (import "env" "base" (global (mut i32))))`,
+		`(module (import "env" "counter" (global i64)))`,
+		`(module (import "env" "tab" (table 3 funcref)))`,
+		`(module (import "env" "tab" (table 1 externref)))`,
+		`(module (import "env" "mem" (memory 1 1)))`,
+		`(module (import "env" "mem" (func)))`,
+	} {
+		_, err := r.Instantiate([]byte(src))
+		var linkErr *war.LinkError
+		if !errors.As(err, &linkErr) {
+			t.Errorf("%s: got %v, expected a link error", src, err)
+		}
+	}
+}
+
+func TestHostModuleTypes(t *testing.T) {
+	if _, err := war.NewGlobal(war.ExternType{Value: war.ValueTypeI32}, war.I64(1)); err == nil {
+		t.Error("expected an error creating an i32 global with an i64")
+	}
+	if _, err := war.NewTable(war.ExternType{Value: war.ValueTypeI32}); err == nil {
+		t.Error("expected an error creating a table of i32")
+	}
+	if _, err := war.NewMemory(war.ExternType{Min: 2, Max: 1, HasMax: true}); err == nil {
+		t.Error("expected an error creating a memory with min over max")
+	}
+	if _, err := war.NewMemory(war.ExternType{Min: 65537}); err == nil {
+		t.Error("expected an error creating a memory over 4GiB")
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"github.com/bluescreen10/war/text"
+)
+
+// Global is a global variable instance.
+type Global struct {
+	typ globalType
+	val Value
+}
+
+// NewGlobal creates a global of the type t set to v, for the host to
+// provide to modules through RegisterHostModule.
+func NewGlobal(t ExternType, v Value) (*Global, error) {
+	if v.typ != t.Value {
+		return nil, fmt.Errorf("global: got %s value, expected %s", v.typ, t.Value)
+	}
+	return &Global{typ: globalType{typ: t.Value, mut: t.Mutable}, val: v}, nil
+}
+
+// Get returns the current value of the global.
+func (g *Global) Get() Value {
+	return g.val
+}
+
+// Set sets the value of a mutable global.
+func (g *Global) Set(v Value) error {
+	if !g.typ.mut {
+		return fmt.Errorf("global is immutable")
+	}
+	if v.typ != g.typ.typ {
+		return fmt.Errorf("got %s value, expected %s", v.typ, g.typ.typ)
+	}
+	g.val = v
+	return nil
+}
+
+type funcInst struct {
+	idx  uint32
+	typ  funcType
+	inst *Instance
+	code *function
+	host *HostFunc // instead of code for host functions
+}
+
+func (f *funcInst) name() string {
+	if f.code != nil {
+		return f.code.name
+	}
+	return ""
+}
+
+func (f *funcInst) String() string {
+	if name := f.name(); name != "" {
+		return name
+	}
+	return fmt.Sprintf("func[%d]", f.idx)
+}
+
+// Instance is an instantiated module.
+type Instance struct {
+	module  *Module
+	rt      *Runtime
+	funcs   []*funcInst
+	globals []*Global
+	tables  []*Table
+	mems    []*Memory
+	// exports by name, and in the order they are defined
+	exports     map[string]export
+	exportOrder []export
+
+	// elements of the segments, nil once dropped
+	elems [][]Value
+	// bytes of the data segments, nil once dropped
+	datas [][]byte
+}
+
+// instantiate instantiates m in store, resolving its imports against the
+// modules registered there.
+func (r *Runtime) instantiate(store *Store, m *Module) (*Instance, error) {
+	inst := &Instance{module: m, rt: r, exports: map[string]export{}}
+
+	for _, imp := range m.imports {
+		if err := inst.link(store, imp); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range m.funcs {
+		idx := uint32(len(inst.funcs))
+		inst.funcs = append(inst.funcs, &funcInst{idx: idx, typ: f.typ, inst: inst, code: f})
+	}
+
+	for _, t := range m.tables {
+		if r.maxTableElems > 0 && t.limits.min > r.maxTableElems {
+			return nil, fmt.Errorf("table of %d elements exceeds the limit of %d", t.limits.min, r.maxTableElems)
+		}
+		tab := newTable(t)
+		tab.limit = r.maxTableElems
+		inst.tables = append(inst.tables, tab)
+	}
+
+	for _, l := range m.mems {
+		if r.maxMemoryPages > 0 && l.limits.min > r.maxMemoryPages {
+			return nil, fmt.Errorf("memory of %d pages exceeds the limit of %d", l.limits.min, r.maxMemoryPages)
+		}
+		mem := newMemory(l.limits)
+		mem.limit = r.maxMemoryPages
+		mem.growHook = r.memoryGrowHook
+		inst.mems = append(inst.mems, mem)
+	}
+
+	for _, g := range m.globals {
+		v, err := inst.eval(g.init)
+		if err != nil {
+			return nil, err
+		}
+		inst.globals = append(inst.globals, &Global{typ: g.typ, val: v})
+	}
+
+	for _, e := range m.exports {
+		inst.export(e)
+	}
+
+	for _, e := range m.elems {
+		elems := make([]Value, len(e.init))
+		for j, item := range e.init {
+			v, err := inst.eval(item)
+			if err != nil {
+				return nil, err
+			}
+			elems[j] = v
+		}
+		inst.elems = append(inst.elems, elems)
+	}
+
+	// active segments are copied into their table and dropped, declarative
+	// ones are dropped right away
+	for i, e := range m.elems {
+		if e.offset == nil {
+			if e.declare {
+				inst.elems[i] = nil
+			}
+			continue
+		}
+		v, err := inst.eval(e.offset)
+		if err != nil {
+			return nil, err
+		}
+		if int(e.table) >= len(inst.tables) {
+			return nil, fmt.Errorf("unknown table %d", e.table)
+		}
+		tab := inst.tables[e.table]
+		if !tab.inBounds(uint32(v.I32()), uint32(len(inst.elems[i]))) {
+			return nil, &Trap{Reason: trapTableBounds}
+		}
+		copy(tab.elems[uint32(v.I32()):], inst.elems[i])
+		inst.elems[i] = nil
+	}
+
+	// active segments are copied into their memory and dropped
+	for i, d := range m.datas {
+		inst.datas = append(inst.datas, d.init)
+		if d.offset == nil {
+			continue
+		}
+		v, err := inst.eval(d.offset)
+		if err != nil {
+			return nil, err
+		}
+		if int(d.mem) >= len(inst.mems) {
+			return nil, fmt.Errorf("unknown memory %d", d.mem)
+		}
+		mem := inst.mems[d.mem]
+		if !mem.inBounds(uint32(v.I32()), 0, uint64(len(d.init))) {
+			return nil, &Trap{Reason: trapMemoryBounds}
+		}
+		copy(mem.data[uint32(v.I32()):], d.init)
+		inst.datas[i] = nil
+	}
+
+	// the start function runs last, a trap fails the instantiation
+	if m.hasStart {
+		if _, err := newMachine(r).invoke(inst.funcs[m.start], nil); err != nil {
+			return nil, fmt.Errorf("start function: %w", err)
+		}
+	}
+	store.instances = append(store.instances, inst)
+	return inst, nil
+}
+
+// link resolves an import against the instances registered in store.
+// Imported items are shared with the instance exporting them.
+func (i *Instance) link(store *Store, imp importEntry) error {
+	fail := func(reason string) error {
+		return &LinkError{Module: imp.module, Name: imp.name, Reason: reason}
+	}
+
+	src, ok := store.Module(imp.module)
+	var e export
+	if ok {
+		e, ok = src.exports[imp.name]
+	}
+	if !ok {
+		return fail("unknown import")
+	}
+	if e.kind != imp.kind {
+		return fail("incompatible import type")
+	}
+
+	switch imp.kind {
+	case ExternFunc:
+		f := src.funcs[e.index]
+		if !f.typ.equal(imp.typ) {
+			return fail("incompatible import type")
+		}
+		i.funcs = append(i.funcs, f)
+	case ExternGlobal:
+		g := src.globals[e.index]
+		if g.typ != imp.global {
+			return fail("incompatible import type")
+		}
+		i.globals = append(i.globals, g)
+	case ExternTable:
+		tab := src.tables[e.index]
+		if tab.typ != imp.elem || !tab.matches(imp.limits) {
+			return fail("incompatible import type")
+		}
+		i.tables = append(i.tables, tab)
+	case ExternMemory:
+		mem := src.mems[e.index]
+		if !mem.matches(imp.limits) {
+			return fail("incompatible import type")
+		}
+		i.mems = append(i.mems, mem)
+	}
+	return nil
+}
+
+// eval evaluates a constant expression.
+func (i *Instance) eval(code []*instr) (v Value, err error) {
+	m := newMachine(i.rt)
+	defer m.recover(&err)
+	m.exec(&frame{inst: i}, code)
+	if len(m.stack) != 1 {
+		return v, fmt.Errorf("type mismatch in constant expression")
+	}
+	return m.stack[0], nil
+}
+
+// EvalConstExpr evaluates a constant expression, such as the offset of a
+// data segment, outside of any module. The expression reads the globals
+// given by index, as it would imported immutable ones, and can't refer to
+// functions.
+func EvalConstExpr(expr *text.Node, globals ...Value) (Value, error) {
+	m := &Module{}
+	inst := &Instance{module: m, rt: NewRuntime()}
+	for _, v := range globals {
+		typ := globalType{typ: v.typ}
+		m.imports = append(m.imports, importEntry{kind: ExternGlobal, global: typ})
+		inst.globals = append(inst.globals, &Global{typ: typ, val: v})
+	}
+
+	c := &compiler{m: m}
+	for i := range c.names {
+		c.names[i] = map[string]uint32{}
+	}
+	code, err := c.constExpr([]*text.Node{expr})
+	if err != nil {
+		return Value{}, err
+	}
+	ch := &checker{m: m, importedGlobals: len(globals)}
+	for _, g := range inst.globals {
+		ch.globals = append(ch.globals, g.typ)
+	}
+	if _, err := ch.constType(code); err != nil {
+		return Value{}, err
+	}
+	return inst.eval(code)
+}
+
+// Invoke calls the exported function name with args. Each call runs on
+// its own stack, so calls may run from several goroutines at once as long
+// as they only read the memories, tables and globals of the instance and
+// the runtime isn't profiling. Calls changing them need an instance each,
+// see Clone.
+func (i *Instance) Invoke(name string, args ...Value) ([]Value, error) {
+	e, ok := i.exports[name]
+	if !ok || e.kind != ExternFunc {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	f := i.funcs[e.index]
+	if err := f.checkArgs(args); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return newMachine(i.rt).invoke(f, args)
+}
+
+// Clone returns an independent copy of the instance in its current state:
+// the globals, tables and memories it defines are copied, with references
+// to its functions referring to those of the copy, while imported ones are
+// still shared with the instances exporting them.
+func (i *Instance) Clone() *Instance {
+	c := &Instance{
+		module:      i.module,
+		rt:          i.rt,
+		exports:     i.exports,
+		exportOrder: i.exportOrder,
+		datas:       slices.Clone(i.datas),
+	}
+
+	funcs := map[*funcInst]*funcInst{}
+	for _, f := range i.funcs {
+		if f.inst == i {
+			clone := *f
+			clone.inst = c
+			funcs[f] = &clone
+			f = &clone
+		}
+		c.funcs = append(c.funcs, f)
+	}
+	// ref moves a reference to a function of i to the copy
+	ref := func(v Value) Value {
+		if f, ok := v.ref.(*funcInst); ok && funcs[f] != nil {
+			v.ref = funcs[f]
+		}
+		return v
+	}
+
+	imported := len(i.globals) - len(i.module.globals)
+	for j, g := range i.globals {
+		if j >= imported {
+			g = &Global{typ: g.typ, val: ref(g.val)}
+		}
+		c.globals = append(c.globals, g)
+	}
+	imported = len(i.tables) - len(i.module.tables)
+	for j, t := range i.tables {
+		if j >= imported {
+			clone := *t
+			clone.elems = make([]Value, len(t.elems))
+			for k, v := range t.elems {
+				clone.elems[k] = ref(v)
+			}
+			t = &clone
+		}
+		c.tables = append(c.tables, t)
+	}
+	imported = len(i.mems) - len(i.module.mems)
+	for j, m := range i.mems {
+		if j >= imported {
+			clone := *m
+			clone.data = bytes.Clone(m.data)
+			m = &clone
+		}
+		c.mems = append(c.mems, m)
+	}
+	for _, e := range i.elems {
+		var elems []Value
+		if e != nil {
+			elems = make([]Value, len(e))
+			for k, v := range e {
+				elems[k] = ref(v)
+			}
+		}
+		c.elems = append(c.elems, elems)
+	}
+	return c
+}
+
+// checkArgs checks the arguments of a call against the type of f.
+func (f *funcInst) checkArgs(args []Value) error {
+	if len(args) != len(f.typ.params) {
+		return fmt.Errorf("expected %d arguments, got %d", len(f.typ.params), len(args))
+	}
+	for j, a := range args {
+		if a.typ != f.typ.params[j] {
+			return fmt.Errorf("argument %d: expected %s, got %s", j, f.typ.params[j], a.typ)
+		}
+	}
+	return nil
+}
+
+// export adds an export of the instance.
+func (i *Instance) export(e export) {
+	i.exports[e.name] = e
+	i.exportOrder = append(i.exportOrder, e)
+}
+
+// Exports returns the exports of the instance in the order they are
+// defined, with the current limits of its tables and memories.
+func (i *Instance) Exports() []ExportDesc {
+	descs := make([]ExportDesc, len(i.exportOrder))
+	for j, e := range i.exportOrder {
+		descs[j] = ExportDesc{Name: e.name, Kind: e.kind}
+		switch e.kind {
+		case ExternFunc:
+			f := i.funcs[e.index]
+			descs[j].Type = ExternType{Params: f.typ.params, Results: f.typ.results}
+		case ExternGlobal:
+			g := i.globals[e.index]
+			descs[j].Type = ExternType{Value: g.typ.typ, Mutable: g.typ.mut}
+		case ExternTable:
+			t := i.tables[e.index]
+			descs[j].Type = limitsType(t.limits(), t.typ)
+		case ExternMemory:
+			mem := i.mems[e.index]
+			descs[j].Type = limitsType(mem.limits(), 0)
+		}
+	}
+	return descs
+}
+
+// ExportedFunc returns a reference to the exported function name, which
+// Runtime.Call calls.
+func (i *Instance) ExportedFunc(name string) (Value, bool) {
+	e, ok := i.exports[name]
+	if !ok || e.kind != ExternFunc {
+		return Value{}, false
+	}
+	return Value{typ: ValueTypeFuncRef, ref: i.funcs[e.index]}, true
+}
+
+// Global returns the exported global name.
+func (i *Instance) Global(name string) (*Global, bool) {
+	e, ok := i.exports[name]
+	if !ok || e.kind != ExternGlobal {
+		return nil, false
+	}
+	return i.globals[e.index], true
+}
+
+// Memory returns the exported memory name.
+func (i *Instance) Memory(name string) (*Memory, bool) {
+	e, ok := i.exports[name]
+	if !ok || e.kind != ExternMemory {
+		return nil, false
+	}
+	return i.mems[e.index], true
+}
+
+// allocators are the exported functions WriteString calls to allocate the
+// bytes of a string, in order of preference.
+var allocators = []string{"alloc", "malloc"}
+
+// stringMemory returns the memory the strings of the instance live in: the
+// one it exports as "memory", or else the first one it exports.
+func (i *Instance) stringMemory() (*Memory, error) {
+	if m, ok := i.Memory("memory"); ok {
+		return m, nil
+	}
+	for _, e := range i.exportOrder {
+		if e.kind == ExternMemory {
+			return i.mems[e.index], nil
+		}
+	}
+	return nil, fmt.Errorf("no exported memory")
+}
+
+// ReadString returns the n bytes of the exported memory of the instance at
+// ptr as a string.
+func (i *Instance) ReadString(ptr, n uint32) (string, error) {
+	mem, err := i.stringMemory()
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if err := mem.ReadAt(ptr, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// WriteString copies s into the exported memory of the instance and returns
+// where. The bytes are allocated by calling the exported function alloc, or
+// malloc if there is none, which takes a size and returns a pointer, so the
+// module owns them.
+func (i *Instance) WriteString(s string) (uint32, error) {
+	var alloc string
+	for _, name := range allocators {
+		if e, ok := i.exports[name]; ok && e.kind == ExternFunc {
+			alloc = name
+			break
+		}
+	}
+	if alloc == "" {
+		return 0, fmt.Errorf("no exported alloc or malloc function")
+	}
+	res, err := i.Invoke(alloc, I32(int32(len(s))))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", alloc, err)
+	}
+	if len(res) != 1 || res[0].typ != ValueTypeI32 {
+		return 0, fmt.Errorf("%s: expected an i32 result, got %v", alloc, res)
+	}
+	ptr := uint32(res[0].I32())
+	if err := i.WriteStringAt(ptr, s); err != nil {
+		return 0, err
+	}
+	return ptr, nil
+}
+
+// WriteStringAt copies s into the exported memory of the instance at ptr,
+// for modules that set the bytes aside by other means than an allocator.
+func (i *Instance) WriteStringAt(ptr uint32, s string) error {
+	mem, err := i.stringMemory()
+	if err != nil {
+		return err
+	}
+	return mem.WriteAt(ptr, []byte(s))
+}
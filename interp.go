@@ -0,0 +1,928 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+
+	"github.com/bluescreen10/war/text"
+)
+
+const maxCallDepth = 10000
+
+// branchReturn is the branch depth used to unwind a whole function.
+const branchReturn = math.MaxInt32
+
+type frame struct {
+	fn     *funcInst
+	inst   *Instance
+	locals []Value
+	in     *instr // executing instruction
+}
+
+// machine executes code for a single invocation.
+type machine struct {
+	rt       *Runtime
+	stack    []Value
+	frames   []*frame
+	labels   []int // stack heights of the blocks of the bytecode interpreter
+	profile  *Profile
+	canonNaN bool
+	treeWalk bool
+	trapGrow bool
+	tracer   Tracer
+}
+
+func newMachine(rt *Runtime) *machine {
+	return &machine{rt: rt, stack: make([]Value, 0, 64), profile: rt.profile, canonNaN: rt.canonNaN, treeWalk: rt.treeWalk, trapGrow: rt.trapGrow, tracer: rt.tracer}
+}
+
+func (m *machine) invoke(f *funcInst, args []Value) (results []Value, err error) {
+	defer m.recover(&err)
+	m.stack = append(m.stack, args...)
+	m.call(f)
+	return append([]Value(nil), m.stack...), nil
+}
+
+// recover turns a trap raised during execution into an error, and so any
+// other panic, which is a bug of the runtime or of a host function, as an
+// InternalError.
+func (m *machine) recover(errp *error) {
+	if e := recover(); e != nil {
+		if t, ok := e.(*Trap); ok {
+			*errp = t
+			return
+		}
+		ie := &InternalError{Value: e}
+		if len(m.frames) > 0 {
+			if in := m.frames[len(m.frames)-1].in; in != nil {
+				ie.Op, ie.Pos = in.op, in.node.Span.Start
+			}
+		}
+		*errp = ie
+	}
+}
+
+// trap aborts the execution capturing the current call stack.
+func (m *machine) trap(reason string) {
+	t := &Trap{Reason: reason}
+	for i := len(m.frames) - 1; i >= 0; i-- {
+		f := m.frames[i]
+		fr := Frame{Func: f.fn.idx, Name: f.fn.name()}
+		if f.in != nil {
+			fr.Offset = f.in.pc
+			if len(t.frames) == 0 {
+				t.Pos = f.in.node.Span.Start
+			}
+		}
+		t.frames = append(t.frames, fr)
+	}
+	panic(t)
+}
+
+func (m *machine) push(v Value) { m.stack = append(m.stack, v) }
+func (m *machine) pushI32(v uint32) {
+	m.stack = append(m.stack, Value{typ: ValueTypeI32, bits: uint64(v)})
+}
+func (m *machine) pushI64(v uint64) {
+	m.stack = append(m.stack, Value{typ: ValueTypeI64, bits: v})
+}
+
+// pushF32 and pushF64 push the result of an arithmetic operation, making
+// NaNs canonical if enabled. Bitwise operations use pushF32Bits and
+// pushF64Bits instead.
+func (m *machine) pushF32(v float32) {
+	if v != v && m.canonNaN {
+		m.pushF32Bits(canonNaN32)
+		return
+	}
+	m.pushF32Bits(math.Float32bits(v))
+}
+
+func (m *machine) pushF64(v float64) {
+	if v != v && m.canonNaN {
+		m.pushF64Bits(canonNaN64)
+		return
+	}
+	m.pushF64Bits(math.Float64bits(v))
+}
+
+func (m *machine) pushF32Bits(v uint32) {
+	m.stack = append(m.stack, Value{typ: ValueTypeF32, bits: uint64(v)})
+}
+func (m *machine) pushF64Bits(v uint64) {
+	m.stack = append(m.stack, Value{typ: ValueTypeF64, bits: v})
+}
+
+func (m *machine) pushBool(b bool) {
+	if b {
+		m.pushI32(1)
+	} else {
+		m.pushI32(0)
+	}
+}
+
+func (m *machine) pop() Value {
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v
+}
+
+func (m *machine) popI32() uint32      { return uint32(m.pop().bits) }
+func (m *machine) popI64() uint64      { return m.pop().bits }
+func (m *machine) popF32() float32     { return math.Float32frombits(uint32(m.pop().bits)) }
+func (m *machine) popF64() float64     { return math.Float64frombits(m.pop().bits) }
+func (m *machine) popF32Bits() uint32  { return uint32(m.pop().bits) }
+func (m *machine) popF64Bits() uint64  { return m.pop().bits }
+func (m *machine) top() *Value         { return &m.stack[len(m.stack)-1] }
+func (m *machine) truncate(height int) { m.stack = m.stack[:height] }
+
+// unwind drops the values between height and the top n values of the stack.
+func (m *machine) unwind(height, n int) {
+	if len(m.stack)-n != height {
+		copy(m.stack[height:], m.stack[len(m.stack)-n:])
+		m.stack = m.stack[:height+n]
+	}
+}
+
+func (m *machine) call(f *funcInst) {
+	if len(m.frames) >= maxCallDepth {
+		m.trap(trapStackExhausted)
+	}
+	if f.host != nil {
+		m.callHost(f)
+		return
+	}
+
+	nparams := len(f.typ.params)
+	fr := &frame{fn: f, inst: f.inst, locals: make([]Value, nparams+len(f.code.locals))}
+	copy(fr.locals, m.stack[len(m.stack)-nparams:])
+	m.truncate(len(m.stack) - nparams)
+	for i, t := range f.code.locals {
+		fr.locals[nparams+i] = zero(t)
+	}
+
+	height := len(m.stack)
+	m.frames = append(m.frames, fr)
+	if m.treeWalk {
+		m.exec(fr, f.code.body)
+	} else {
+		m.run(fr, f.code.flat)
+	}
+	m.unwind(height, len(f.typ.results))
+	m.frames = m.frames[:len(m.frames)-1]
+}
+
+// indirect returns the function call_indirect calls, whose index in the
+// table is on top of the stack. The index must be within the table, the
+// element not null and the function of the expected type.
+func (m *machine) indirect(f *frame, in *instr) *funcInst {
+	tab := f.inst.tables[in.imm2]
+	i := m.popI32()
+	if i >= tab.Size() {
+		m.trap(trapUndefinedElement)
+	}
+	fn, ok := tab.elems[i].ref.(*funcInst)
+	if !ok {
+		m.trap(trapUninitialized)
+	}
+	if !fn.typ.equal(funcType{params: in.params, results: in.results}) {
+		m.trap(trapIndirectCallType)
+	}
+	return fn
+}
+
+// exec runs a sequence of instructions. It returns -1 when the execution
+// falls through the end of code, or the relative depth of the label being
+// branched to otherwise.
+func (m *machine) exec(f *frame, code []*instr) int {
+	for _, in := range code {
+		if len(in.args) > 0 {
+			if depth := m.exec(f, in.args); depth >= 0 {
+				return depth
+			}
+		}
+		f.in = in
+		if m.profile != nil {
+			m.profile.count(f, in.op)
+		}
+		if m.tracer != nil {
+			m.tracer(in.pc, in.op, m.stack)
+		}
+
+		switch in.op {
+		case text.OpBlock:
+			height := len(m.stack) - len(in.params)
+			if depth := m.exec(f, in.body); depth > 0 {
+				return depth - 1
+			} else if depth == 0 {
+				m.unwind(height, len(in.results))
+			}
+		case text.OpLoop:
+			height := len(m.stack) - len(in.params)
+			for {
+				depth := m.exec(f, in.body)
+				if depth > 0 {
+					return depth - 1
+				} else if depth < 0 {
+					break
+				}
+				// branching to a loop takes its params
+				m.unwind(height, len(in.params))
+			}
+		case text.OpIf:
+			body := in.body
+			if m.popI32() == 0 {
+				body = in.els
+			}
+			height := len(m.stack) - len(in.params)
+			if depth := m.exec(f, body); depth > 0 {
+				return depth - 1
+			} else if depth == 0 {
+				m.unwind(height, len(in.results))
+			}
+		case text.OpBr:
+			return int(in.imm)
+		case text.OpBrIf:
+			if m.popI32() != 0 {
+				return int(in.imm)
+			}
+		case text.OpBrTable:
+			i := m.popI32()
+			if int(i) >= len(in.labels)-1 {
+				i = uint32(len(in.labels) - 1)
+			}
+			return int(in.labels[i])
+		case text.OpReturn:
+			return branchReturn
+		default:
+			m.step(f, in)
+		}
+	}
+	return -1
+}
+
+// step executes an instruction other than the control instructions, which
+// each interpreter handles its own way.
+func (m *machine) step(f *frame, in *instr) {
+	switch in.op {
+	case text.OpUnreachable:
+		m.trap(trapUnreachable)
+	case text.OpNop:
+	case text.OpCall:
+		m.call(f.inst.funcs[in.imm])
+	case text.OpCallIndirect:
+		m.call(m.indirect(f, in))
+	case text.OpDrop:
+		m.pop()
+	case text.OpSelect:
+		c := m.popI32()
+		b := m.pop()
+		if c == 0 {
+			*m.top() = b
+		}
+
+	case text.OpLocalGet:
+		m.push(f.locals[in.imm])
+	case text.OpLocalSet:
+		f.locals[in.imm] = m.pop()
+	case text.OpLocalTee:
+		f.locals[in.imm] = *m.top()
+	case text.OpGlobalGet:
+		m.push(f.inst.globals[in.imm].val)
+	case text.OpGlobalSet:
+		f.inst.globals[in.imm].val = m.pop()
+
+	case text.OpMemorySize:
+		m.pushI32(f.inst.mems[in.mem].Size())
+	case text.OpMemoryGrow:
+		if old, ok := f.inst.mems[in.mem].grow(m.popI32()); ok {
+			m.pushI32(old)
+		} else if m.trapGrow {
+			m.trap(trapMemoryGrow)
+		} else {
+			m.pushI32(math.MaxUint32)
+		}
+
+	case text.OpRefNull:
+		m.push(Value{typ: ValueType(in.imm)})
+	case text.OpRefFunc:
+		m.push(Value{typ: ValueTypeFuncRef, ref: f.inst.funcs[in.imm]})
+	case text.OpRefIsNull:
+		m.pushBool(m.pop().ref == nil)
+
+	case text.OpTableGet, text.OpTableSet, text.OpTableSize, text.OpTableGrow,
+		text.OpTableFill, text.OpTableCopy, text.OpTableInit:
+		m.execTable(f, in)
+	case text.OpElemDrop:
+		f.inst.elems[in.imm] = nil
+	case text.OpMemoryInit:
+		n, s, d := m.popI32(), m.popI32(), m.popI32()
+		data, mem := f.inst.datas[in.imm], f.inst.mems[in.mem]
+		if uint64(s)+uint64(n) > uint64(len(data)) || !mem.inBounds(d, 0, uint64(n)) {
+			m.trap(trapMemoryBounds)
+		}
+		copy(mem.data[d:], data[s:s+n])
+	case text.OpMemoryCopy:
+		n, s, d := m.popI32(), m.popI32(), m.popI32()
+		dst, src := f.inst.mems[in.mem], f.inst.mems[in.imm2]
+		if !src.inBounds(s, 0, uint64(n)) || !dst.inBounds(d, 0, uint64(n)) {
+			m.trap(trapMemoryBounds)
+		}
+		// copy handles overlapping ranges
+		copy(dst.data[d:d+n], src.data[s:s+n])
+	case text.OpMemoryFill:
+		n, v, d := m.popI32(), m.popI32(), m.popI32()
+		mem := f.inst.mems[in.mem]
+		if !mem.inBounds(d, 0, uint64(n)) {
+			m.trap(trapMemoryBounds)
+		}
+		for i := range n {
+			mem.data[d+i] = byte(v)
+		}
+	case text.OpDataDrop:
+		f.inst.datas[in.imm] = nil
+
+	case text.OpI32Const:
+		m.pushI32(uint32(in.imm))
+	case text.OpI64Const:
+		m.pushI64(in.imm)
+	case text.OpF32Const:
+		m.pushF32Bits(uint32(in.imm))
+	case text.OpF64Const:
+		m.pushF64Bits(in.imm)
+
+	default:
+		if isMemoryAccess(in.op) {
+			m.execMemory(f, in)
+		} else if isSIMD(in.op) {
+			m.execSIMD(in)
+		} else {
+			m.execNumeric(in)
+		}
+	}
+}
+
+func (m *machine) execTable(f *frame, in *instr) {
+	tab := f.inst.tables[in.imm]
+	switch in.op {
+	case text.OpTableGet:
+		i := m.popI32()
+		if !tab.inBounds(i, 1) {
+			m.trap(trapTableBounds)
+		}
+		m.push(tab.elems[i])
+	case text.OpTableSet:
+		v := m.pop()
+		i := m.popI32()
+		if !tab.inBounds(i, 1) {
+			m.trap(trapTableBounds)
+		}
+		tab.elems[i] = v
+	case text.OpTableSize:
+		m.pushI32(tab.Size())
+	case text.OpTableGrow:
+		n := m.popI32()
+		if old, ok := tab.grow(n, m.pop()); ok {
+			m.pushI32(old)
+		} else if m.trapGrow {
+			m.trap(trapTableGrow)
+		} else {
+			m.pushI32(math.MaxUint32)
+		}
+	case text.OpTableFill:
+		n := m.popI32()
+		v := m.pop()
+		i := m.popI32()
+		if !tab.inBounds(i, n) {
+			m.trap(trapTableBounds)
+		}
+		for j := range n {
+			tab.elems[i+j] = v
+		}
+	case text.OpTableCopy:
+		n, s, d := m.popI32(), m.popI32(), m.popI32()
+		src := f.inst.tables[in.imm2]
+		if !src.inBounds(s, n) || !tab.inBounds(d, n) {
+			m.trap(trapTableBounds)
+		}
+		// copy handles overlapping ranges
+		copy(tab.elems[d:d+n], src.elems[s:s+n])
+	case text.OpTableInit:
+		n, s, d := m.popI32(), m.popI32(), m.popI32()
+		elems := f.inst.elems[in.imm2]
+		if uint64(s)+uint64(n) > uint64(len(elems)) || !tab.inBounds(d, n) {
+			m.trap(trapTableBounds)
+		}
+		copy(tab.elems[d:d+n], elems[s:s+n])
+	}
+}
+
+func (m *machine) effectiveAddr(mem *Memory, in *instr, n uint64) uint64 {
+	addr := m.popI32()
+	if !mem.inBounds(addr, in.imm, n) {
+		m.trap(trapMemoryBounds)
+	}
+	return uint64(addr) + in.imm
+}
+
+func (m *machine) execMemory(f *frame, in *instr) {
+	mem := f.inst.mems[in.mem]
+	le := binary.LittleEndian
+	switch in.op {
+	case text.OpI32Load:
+		ea := m.effectiveAddr(mem, in, 4)
+		m.pushI32(le.Uint32(mem.data[ea:]))
+	case text.OpI64Load:
+		ea := m.effectiveAddr(mem, in, 8)
+		m.pushI64(le.Uint64(mem.data[ea:]))
+	case text.OpF32Load:
+		ea := m.effectiveAddr(mem, in, 4)
+		m.pushF32Bits(le.Uint32(mem.data[ea:]))
+	case text.OpF64Load:
+		ea := m.effectiveAddr(mem, in, 8)
+		m.pushF64Bits(le.Uint64(mem.data[ea:]))
+	case text.OpI32Load8S:
+		ea := m.effectiveAddr(mem, in, 1)
+		m.pushI32(uint32(int8(mem.data[ea])))
+	case text.OpI32Load8U:
+		ea := m.effectiveAddr(mem, in, 1)
+		m.pushI32(uint32(mem.data[ea]))
+	case text.OpI32Load16S:
+		ea := m.effectiveAddr(mem, in, 2)
+		m.pushI32(uint32(int16(le.Uint16(mem.data[ea:]))))
+	case text.OpI32Load16U:
+		ea := m.effectiveAddr(mem, in, 2)
+		m.pushI32(uint32(le.Uint16(mem.data[ea:])))
+	case text.OpI64Load8S:
+		ea := m.effectiveAddr(mem, in, 1)
+		m.pushI64(uint64(int8(mem.data[ea])))
+	case text.OpI64Load8U:
+		ea := m.effectiveAddr(mem, in, 1)
+		m.pushI64(uint64(mem.data[ea]))
+	case text.OpI64Load16S:
+		ea := m.effectiveAddr(mem, in, 2)
+		m.pushI64(uint64(int16(le.Uint16(mem.data[ea:]))))
+	case text.OpI64Load16U:
+		ea := m.effectiveAddr(mem, in, 2)
+		m.pushI64(uint64(le.Uint16(mem.data[ea:])))
+	case text.OpI64Load32S:
+		ea := m.effectiveAddr(mem, in, 4)
+		m.pushI64(uint64(int32(le.Uint32(mem.data[ea:]))))
+	case text.OpI64Load32U:
+		ea := m.effectiveAddr(mem, in, 4)
+		m.pushI64(uint64(le.Uint32(mem.data[ea:])))
+
+	case text.OpI32Store, text.OpF32Store:
+		v := uint32(m.pop().bits)
+		ea := m.effectiveAddr(mem, in, 4)
+		le.PutUint32(mem.data[ea:], v)
+	case text.OpI64Store, text.OpF64Store:
+		v := m.pop().bits
+		ea := m.effectiveAddr(mem, in, 8)
+		le.PutUint64(mem.data[ea:], v)
+	case text.OpI32Store8, text.OpI64Store8:
+		v := m.pop().bits
+		ea := m.effectiveAddr(mem, in, 1)
+		mem.data[ea] = byte(v)
+	case text.OpI32Store16, text.OpI64Store16:
+		v := m.pop().bits
+		ea := m.effectiveAddr(mem, in, 2)
+		le.PutUint16(mem.data[ea:], uint16(v))
+	case text.OpI64Store32:
+		v := m.pop().bits
+		ea := m.effectiveAddr(mem, in, 4)
+		le.PutUint32(mem.data[ea:], uint32(v))
+	default:
+		panic("unhandled instruction")
+	}
+}
+
+func (m *machine) execNumeric(in *instr) {
+	switch in.op {
+	// i32
+	case text.OpI32Eqz:
+		m.pushBool(m.popI32() == 0)
+	case text.OpI32Eq:
+		b, a := m.popI32(), m.popI32()
+		m.pushBool(a == b)
+	case text.OpI32Ne:
+		b, a := m.popI32(), m.popI32()
+		m.pushBool(a != b)
+	case text.OpI32LtS:
+		b, a := m.popI32(), m.popI32()
+		m.pushBool(int32(a) < int32(b))
+	case text.OpI32LtU:
+		b, a := m.popI32(), m.popI32()
+		m.pushBool(a < b)
+	case text.OpI32GtS:
+		b, a := m.popI32(), m.popI32()
+		m.pushBool(int32(a) > int32(b))
+	case text.OpI32GtU:
+		b, a := m.popI32(), m.popI32()
+		m.pushBool(a > b)
+	case text.OpI32LeS:
+		b, a := m.popI32(), m.popI32()
+		m.pushBool(int32(a) <= int32(b))
+	case text.OpI32LeU:
+		b, a := m.popI32(), m.popI32()
+		m.pushBool(a <= b)
+	case text.OpI32GeS:
+		b, a := m.popI32(), m.popI32()
+		m.pushBool(int32(a) >= int32(b))
+	case text.OpI32GeU:
+		b, a := m.popI32(), m.popI32()
+		m.pushBool(a >= b)
+
+	case text.OpI32Clz:
+		m.pushI32(uint32(bits.LeadingZeros32(m.popI32())))
+	case text.OpI32Ctz:
+		m.pushI32(uint32(bits.TrailingZeros32(m.popI32())))
+	case text.OpI32Popcnt:
+		m.pushI32(uint32(bits.OnesCount32(m.popI32())))
+	case text.OpI32Extend8S:
+		m.pushI32(uint32(int8(m.popI32())))
+	case text.OpI32Extend16S:
+		m.pushI32(uint32(int16(m.popI32())))
+
+	case text.OpI32Add:
+		b, a := m.popI32(), m.popI32()
+		m.pushI32(a + b)
+	case text.OpI32Sub:
+		b, a := m.popI32(), m.popI32()
+		m.pushI32(a - b)
+	case text.OpI32Mul:
+		b, a := m.popI32(), m.popI32()
+		m.pushI32(a * b)
+	case text.OpI32DivS:
+		b, a := int32(m.popI32()), int32(m.popI32())
+		if b == 0 {
+			m.trap(trapDivideByZero)
+		}
+		if a == math.MinInt32 && b == -1 {
+			m.trap(trapIntegerOverflow)
+		}
+		m.pushI32(uint32(a / b))
+	case text.OpI32DivU:
+		b, a := m.popI32(), m.popI32()
+		if b == 0 {
+			m.trap(trapDivideByZero)
+		}
+		m.pushI32(a / b)
+	case text.OpI32RemS:
+		b, a := int32(m.popI32()), int32(m.popI32())
+		if b == 0 {
+			m.trap(trapDivideByZero)
+		}
+		if b == -1 {
+			m.pushI32(0)
+		} else {
+			m.pushI32(uint32(a % b))
+		}
+	case text.OpI32RemU:
+		b, a := m.popI32(), m.popI32()
+		if b == 0 {
+			m.trap(trapDivideByZero)
+		}
+		m.pushI32(a % b)
+	case text.OpI32And:
+		b, a := m.popI32(), m.popI32()
+		m.pushI32(a & b)
+	case text.OpI32Or:
+		b, a := m.popI32(), m.popI32()
+		m.pushI32(a | b)
+	case text.OpI32Xor:
+		b, a := m.popI32(), m.popI32()
+		m.pushI32(a ^ b)
+	case text.OpI32Shl:
+		b, a := m.popI32(), m.popI32()
+		m.pushI32(a << (b & 31))
+	case text.OpI32ShrS:
+		b, a := m.popI32(), m.popI32()
+		m.pushI32(uint32(int32(a) >> (b & 31)))
+	case text.OpI32ShrU:
+		b, a := m.popI32(), m.popI32()
+		m.pushI32(a >> (b & 31))
+	case text.OpI32Rotl:
+		b, a := m.popI32(), m.popI32()
+		m.pushI32(bits.RotateLeft32(a, int(b&31)))
+	case text.OpI32Rotr:
+		b, a := m.popI32(), m.popI32()
+		m.pushI32(bits.RotateLeft32(a, -int(b&31)))
+
+	// i64
+	case text.OpI64Eqz:
+		m.pushBool(m.popI64() == 0)
+	case text.OpI64Eq:
+		b, a := m.popI64(), m.popI64()
+		m.pushBool(a == b)
+	case text.OpI64Ne:
+		b, a := m.popI64(), m.popI64()
+		m.pushBool(a != b)
+	case text.OpI64LtS:
+		b, a := m.popI64(), m.popI64()
+		m.pushBool(int64(a) < int64(b))
+	case text.OpI64LtU:
+		b, a := m.popI64(), m.popI64()
+		m.pushBool(a < b)
+	case text.OpI64GtS:
+		b, a := m.popI64(), m.popI64()
+		m.pushBool(int64(a) > int64(b))
+	case text.OpI64GtU:
+		b, a := m.popI64(), m.popI64()
+		m.pushBool(a > b)
+	case text.OpI64LeS:
+		b, a := m.popI64(), m.popI64()
+		m.pushBool(int64(a) <= int64(b))
+	case text.OpI64LeU:
+		b, a := m.popI64(), m.popI64()
+		m.pushBool(a <= b)
+	case text.OpI64GeS:
+		b, a := m.popI64(), m.popI64()
+		m.pushBool(int64(a) >= int64(b))
+	case text.OpI64GeU:
+		b, a := m.popI64(), m.popI64()
+		m.pushBool(a >= b)
+
+	case text.OpI64Clz:
+		m.pushI64(uint64(bits.LeadingZeros64(m.popI64())))
+	case text.OpI64Ctz:
+		m.pushI64(uint64(bits.TrailingZeros64(m.popI64())))
+	case text.OpI64Popcnt:
+		m.pushI64(uint64(bits.OnesCount64(m.popI64())))
+	case text.OpI64Extend8S:
+		m.pushI64(uint64(int8(m.popI64())))
+	case text.OpI64Extend16S:
+		m.pushI64(uint64(int16(m.popI64())))
+	case text.OpI64Extend32S:
+		m.pushI64(uint64(int32(m.popI64())))
+
+	case text.OpI64Add:
+		b, a := m.popI64(), m.popI64()
+		m.pushI64(a + b)
+	case text.OpI64Sub:
+		b, a := m.popI64(), m.popI64()
+		m.pushI64(a - b)
+	case text.OpI64Mul:
+		b, a := m.popI64(), m.popI64()
+		m.pushI64(a * b)
+	case text.OpI64DivS:
+		b, a := int64(m.popI64()), int64(m.popI64())
+		if b == 0 {
+			m.trap(trapDivideByZero)
+		}
+		if a == math.MinInt64 && b == -1 {
+			m.trap(trapIntegerOverflow)
+		}
+		m.pushI64(uint64(a / b))
+	case text.OpI64DivU:
+		b, a := m.popI64(), m.popI64()
+		if b == 0 {
+			m.trap(trapDivideByZero)
+		}
+		m.pushI64(a / b)
+	case text.OpI64RemS:
+		b, a := int64(m.popI64()), int64(m.popI64())
+		if b == 0 {
+			m.trap(trapDivideByZero)
+		}
+		if b == -1 {
+			m.pushI64(0)
+		} else {
+			m.pushI64(uint64(a % b))
+		}
+	case text.OpI64RemU:
+		b, a := m.popI64(), m.popI64()
+		if b == 0 {
+			m.trap(trapDivideByZero)
+		}
+		m.pushI64(a % b)
+	case text.OpI64And:
+		b, a := m.popI64(), m.popI64()
+		m.pushI64(a & b)
+	case text.OpI64Or:
+		b, a := m.popI64(), m.popI64()
+		m.pushI64(a | b)
+	case text.OpI64Xor:
+		b, a := m.popI64(), m.popI64()
+		m.pushI64(a ^ b)
+	case text.OpI64Shl:
+		b, a := m.popI64(), m.popI64()
+		m.pushI64(a << (b & 63))
+	case text.OpI64ShrS:
+		b, a := m.popI64(), m.popI64()
+		m.pushI64(uint64(int64(a) >> (b & 63)))
+	case text.OpI64ShrU:
+		b, a := m.popI64(), m.popI64()
+		m.pushI64(a >> (b & 63))
+	case text.OpI64Rotl:
+		b, a := m.popI64(), m.popI64()
+		m.pushI64(bits.RotateLeft64(a, int(b&63)))
+	case text.OpI64Rotr:
+		b, a := m.popI64(), m.popI64()
+		m.pushI64(bits.RotateLeft64(a, -int(b&63)))
+
+	// f32
+	case text.OpF32Eq:
+		b, a := m.popF32(), m.popF32()
+		m.pushBool(a == b)
+	case text.OpF32Ne:
+		b, a := m.popF32(), m.popF32()
+		m.pushBool(a != b)
+	case text.OpF32Lt:
+		b, a := m.popF32(), m.popF32()
+		m.pushBool(a < b)
+	case text.OpF32Gt:
+		b, a := m.popF32(), m.popF32()
+		m.pushBool(a > b)
+	case text.OpF32Le:
+		b, a := m.popF32(), m.popF32()
+		m.pushBool(a <= b)
+	case text.OpF32Ge:
+		b, a := m.popF32(), m.popF32()
+		m.pushBool(a >= b)
+
+	case text.OpF32Abs:
+		m.pushF32Bits(fabs32(m.popF32Bits()))
+	case text.OpF32Neg:
+		m.pushF32Bits(fneg32(m.popF32Bits()))
+	case text.OpF32Ceil:
+		m.pushF32(fceil32(m.popF32()))
+	case text.OpF32Floor:
+		m.pushF32(ffloor32(m.popF32()))
+	case text.OpF32Trunc:
+		m.pushF32(ftrunc32(m.popF32()))
+	case text.OpF32Nearest:
+		m.pushF32(fnearest32(m.popF32()))
+	case text.OpF32Sqrt:
+		m.pushF32(float32(math.Sqrt(float64(m.popF32()))))
+
+	case text.OpF32Add:
+		b, a := m.popF32(), m.popF32()
+		m.pushF32(a + b)
+	case text.OpF32Sub:
+		b, a := m.popF32(), m.popF32()
+		m.pushF32(a - b)
+	case text.OpF32Mul:
+		b, a := m.popF32(), m.popF32()
+		m.pushF32(a * b)
+	case text.OpF32Div:
+		b, a := m.popF32(), m.popF32()
+		m.pushF32(a / b)
+	case text.OpF32Min:
+		b, a := m.popF32(), m.popF32()
+		m.pushF32(fmin32(a, b))
+	case text.OpF32Max:
+		b, a := m.popF32(), m.popF32()
+		m.pushF32(fmax32(a, b))
+	case text.OpF32Copysign:
+		b, a := m.popF32Bits(), m.popF32Bits()
+		m.pushF32Bits(fcopysign32(a, b))
+
+	// f64
+	case text.OpF64Eq:
+		b, a := m.popF64(), m.popF64()
+		m.pushBool(a == b)
+	case text.OpF64Ne:
+		b, a := m.popF64(), m.popF64()
+		m.pushBool(a != b)
+	case text.OpF64Lt:
+		b, a := m.popF64(), m.popF64()
+		m.pushBool(a < b)
+	case text.OpF64Gt:
+		b, a := m.popF64(), m.popF64()
+		m.pushBool(a > b)
+	case text.OpF64Le:
+		b, a := m.popF64(), m.popF64()
+		m.pushBool(a <= b)
+	case text.OpF64Ge:
+		b, a := m.popF64(), m.popF64()
+		m.pushBool(a >= b)
+
+	case text.OpF64Abs:
+		m.pushF64Bits(fabs64(m.popF64Bits()))
+	case text.OpF64Neg:
+		m.pushF64Bits(fneg64(m.popF64Bits()))
+	case text.OpF64Ceil:
+		m.pushF64(math.Ceil(m.popF64()))
+	case text.OpF64Floor:
+		m.pushF64(math.Floor(m.popF64()))
+	case text.OpF64Trunc:
+		m.pushF64(math.Trunc(m.popF64()))
+	case text.OpF64Nearest:
+		m.pushF64(fnearest64(m.popF64()))
+	case text.OpF64Sqrt:
+		m.pushF64(math.Sqrt(m.popF64()))
+
+	case text.OpF64Add:
+		b, a := m.popF64(), m.popF64()
+		m.pushF64(a + b)
+	case text.OpF64Sub:
+		b, a := m.popF64(), m.popF64()
+		m.pushF64(a - b)
+	case text.OpF64Mul:
+		b, a := m.popF64(), m.popF64()
+		m.pushF64(a * b)
+	case text.OpF64Div:
+		b, a := m.popF64(), m.popF64()
+		m.pushF64(a / b)
+	case text.OpF64Min:
+		b, a := m.popF64(), m.popF64()
+		m.pushF64(fmin64(a, b))
+	case text.OpF64Max:
+		b, a := m.popF64(), m.popF64()
+		m.pushF64(fmax64(a, b))
+	case text.OpF64Copysign:
+		b, a := m.popF64Bits(), m.popF64Bits()
+		m.pushF64Bits(fcopysign64(a, b))
+
+	// conversions
+	case text.OpI32WrapI64:
+		m.pushI32(uint32(m.popI64()))
+	case text.OpI64ExtendI32S:
+		m.pushI64(uint64(int32(m.popI32())))
+	case text.OpI64ExtendI32U:
+		m.pushI64(uint64(m.popI32()))
+
+	case text.OpI32TruncF32S:
+		m.pushI32(m.checkTrunc32(truncS32(float64(m.popF32()))))
+	case text.OpI32TruncF32U:
+		m.pushI32(m.checkTrunc32(truncU32(float64(m.popF32()))))
+	case text.OpI32TruncF64S:
+		m.pushI32(m.checkTrunc32(truncS32(m.popF64())))
+	case text.OpI32TruncF64U:
+		m.pushI32(m.checkTrunc32(truncU32(m.popF64())))
+	case text.OpI64TruncF32S:
+		m.pushI64(m.checkTrunc64(truncS64(float64(m.popF32()))))
+	case text.OpI64TruncF32U:
+		m.pushI64(m.checkTrunc64(truncU64(float64(m.popF32()))))
+	case text.OpI64TruncF64S:
+		m.pushI64(m.checkTrunc64(truncS64(m.popF64())))
+	case text.OpI64TruncF64U:
+		m.pushI64(m.checkTrunc64(truncU64(m.popF64())))
+
+	case text.OpI32TruncSatF32S:
+		m.pushI32(truncSatS32(float64(m.popF32())))
+	case text.OpI32TruncSatF32U:
+		m.pushI32(truncSatU32(float64(m.popF32())))
+	case text.OpI32TruncSatF64S:
+		m.pushI32(truncSatS32(m.popF64()))
+	case text.OpI32TruncSatF64U:
+		m.pushI32(truncSatU32(m.popF64()))
+	case text.OpI64TruncSatF32S:
+		m.pushI64(truncSatS64(float64(m.popF32())))
+	case text.OpI64TruncSatF32U:
+		m.pushI64(truncSatU64(float64(m.popF32())))
+	case text.OpI64TruncSatF64S:
+		m.pushI64(truncSatS64(m.popF64()))
+	case text.OpI64TruncSatF64U:
+		m.pushI64(truncSatU64(m.popF64()))
+
+	case text.OpF32ConvertI32S:
+		m.pushF32(float32(int32(m.popI32())))
+	case text.OpF32ConvertI32U:
+		m.pushF32(float32(m.popI32()))
+	case text.OpF32ConvertI64S:
+		m.pushF32(float32(int64(m.popI64())))
+	case text.OpF32ConvertI64U:
+		m.pushF32(convertU64F32(m.popI64()))
+	case text.OpF64ConvertI32S:
+		m.pushF64(float64(int32(m.popI32())))
+	case text.OpF64ConvertI32U:
+		m.pushF64(float64(m.popI32()))
+	case text.OpF64ConvertI64S:
+		m.pushF64(float64(int64(m.popI64())))
+	case text.OpF64ConvertI64U:
+		m.pushF64(convertU64F64(m.popI64()))
+	case text.OpF32DemoteF64:
+		m.pushF32(float32(m.popF64()))
+	case text.OpF64PromoteF32:
+		m.pushF64(float64(m.popF32()))
+
+	case text.OpI32ReinterpretF32:
+		m.pushI32(m.popF32Bits())
+	case text.OpI64ReinterpretF64:
+		m.pushI64(m.popF64Bits())
+	case text.OpF32ReinterpretI32:
+		m.pushF32Bits(m.popI32())
+	case text.OpF64ReinterpretI64:
+		m.pushF64Bits(m.popI64())
+
+	default:
+		panic("unhandled instruction")
+	}
+}
+
+func (m *machine) checkTrunc32(v uint32, reason string) uint32 {
+	if reason != "" {
+		m.trap(reason)
+	}
+	return v
+}
+
+func (m *machine) checkTrunc64(v uint64, reason string) uint64 {
+	if reason != "" {
+		m.trap(reason)
+	}
+	return v
+}
@@ -0,0 +1,62 @@
+package main_test
+
+import (
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+const locals = `(module
+  (func (export "named") (param $a i32) (param i64 i32) (result i32)
+    (local.get $a))
+  (func (export "group") (param $a i32) (param i64 i32) (result i32)
+    (local.get 2))
+  (func (export "locals") (param i32 i32) (result i32) (local f32 i32) (local $l i32)
+    (local.set 3 (i32.const 3))
+    (local.set $l (i32.const 4))
+    (i32.add (local.get 3) (local.get 4))))`
+
+func TestLocalGroups(t *testing.T) {
+	r := war.NewRuntime()
+	if _, err := r.Instantiate([]byte(locals)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fn   string
+		args []war.Value
+		want int32
+	}{
+		{"named", []war.Value{war.I32(1), war.I64(2), war.I32(3)}, 1},
+		{"group", []war.Value{war.I32(1), war.I64(2), war.I32(3)}, 3},
+		{"locals", []war.Value{war.I32(1), war.I32(2)}, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fn, func(t *testing.T) {
+			got, err := r.Invoke(tt.fn, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got[0] != war.I32(tt.want) {
+				t.Errorf("got %v, expected %d", got[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestNamedGroups(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"param", `(module (func (param $x i32 i32)))`},
+		{"local", `(module (func (local $x i64 i64)))`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := war.NewRuntime().Instantiate([]byte(tt.src)); err == nil {
+				t.Error("expected an error for a named group of several entries")
+			}
+		})
+	}
+}
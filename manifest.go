@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bluescreen10/war/text"
+)
+
+// manifest is a script converted by wast2json: its commands in order, with
+// the modules written to files next to it.
+type manifest struct {
+	SourceFilename string            `json:"source_filename"`
+	Commands       []manifestCommand `json:"commands"`
+}
+
+type manifestCommand struct {
+	Type     string          `json:"type"`
+	Line     int             `json:"line"`
+	Name     string          `json:"name"`
+	As       string          `json:"as"`
+	Filename string          `json:"filename"`
+	Text     string          `json:"text"`
+	Action   *manifestAction `json:"action"`
+	Expected []manifestValue `json:"expected"`
+}
+
+type manifestAction struct {
+	Type   string          `json:"type"`
+	Module string          `json:"module"`
+	Field  string          `json:"field"`
+	Args   []manifestValue `json:"args"`
+}
+
+// manifestValue is a value of a manifest. Numbers are written as the
+// decimal of their bits, floats also being nan:canonical or nan:arithmetic
+// when expected, and v128 as a list of lanes of LaneType.
+type manifestValue struct {
+	Type     string          `json:"type"`
+	LaneType string          `json:"lane_type"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// execManifest runs the script converted by wast2json in the manifest at
+// path, loading the binary modules it refers to from the same directory and
+// instantiating them in store.
+// Assertions are reported as by Exec, except that assert_invalid and
+// assert_malformed only check that the module fails, as the messages of
+// the runtime differ from those of the reference interpreter. The reasons
+// of assert_trap, assert_uninstantiable and assert_unlinkable must start
+// with the expected text.
+func (r *Runtime) execManifest(path string, store *Store, result *ScriptResult) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error opening file: %s", path)
+	}
+	var mf manifest
+	if err := json.Unmarshal(data, &mf); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	s := &script{rt: r, store: store, instances: map[string]*Instance{}, result: result}
+	dir := filepath.Dir(path)
+	for _, cmd := range mf.Commands {
+		err := s.execManifestCommand(dir, &cmd)
+		switch {
+		case err == nil:
+		case result != nil && strings.HasPrefix(cmd.Type, "assert_"):
+			s.fail(&ScriptFailure{File: mf.SourceFilename, Line: cmd.Line, Err: err})
+		default:
+			return fmt.Errorf("%s:%d: %w", mf.SourceFilename, cmd.Line, err)
+		}
+	}
+	return nil
+}
+
+func (s *script) execManifestCommand(dir string, cmd *manifestCommand) error {
+	switch cmd.Type {
+	case "module":
+		n, err := loadModule(filepath.Join(dir, cmd.Filename))
+		if err != nil {
+			return err
+		}
+		n.Meta = cmd.Name
+		return s.module(n)
+	case "register":
+		return s.register(&text.RegisterCommand{Name: cmd.As, Module: cmd.Name})
+	case "action":
+		_, err := s.action(cmd.Action)
+		return err
+	case "assert_return":
+		return s.manifestAssertReturn(cmd)
+	case "assert_trap", "assert_exhaustion":
+		_, err := s.action(cmd.Action)
+		return s.assertTrapped(cmd.Type, err, cmd.Text)
+	case "assert_uninstantiable":
+		n, err := loadModule(filepath.Join(dir, cmd.Filename))
+		if err != nil {
+			return err
+		}
+		return s.assertTrapped(cmd.Type, s.module(n), cmd.Text)
+	case "assert_unlinkable":
+		n, err := loadModule(filepath.Join(dir, cmd.Filename))
+		if err != nil {
+			return err
+		}
+		m, err := s.rt.compile(n)
+		if err != nil {
+			return err
+		}
+		_, err = s.rt.instantiate(s.store, m)
+		return s.assertUnlinked(cmd.Type, err, cmd.Text)
+	case "assert_invalid", "assert_malformed":
+		n, err := loadModule(filepath.Join(dir, cmd.Filename))
+		if err == nil {
+			_, err = s.rt.compile(n)
+		}
+		var got string
+		if err != nil {
+			got = cmd.Text
+		}
+		return s.assert(cmd.Type, got, cmd.Text)
+	}
+	if strings.HasPrefix(cmd.Type, "assert_") {
+		// such as the assert_exception of proposals
+		if s.result != nil {
+			s.result.Skipped++
+		}
+		return nil
+	}
+	return fmt.Errorf("unexpected %s command", cmd.Type)
+}
+
+// loadModule reads a module of a manifest, in the binary format or, for
+// some malformed ones, in the text format.
+func loadModule(path string) (*text.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %s", path)
+	}
+	if filepath.Ext(path) == ".wat" {
+		return parseModule(data)
+	}
+	n, _, err := decodeModule(data)
+	return n, err
+}
+
+// action invokes an export or gets the value of an exported global.
+func (s *script) action(a *manifestAction) ([]Value, error) {
+	if a == nil {
+		return nil, fmt.Errorf("missing action")
+	}
+	inst, err := s.instance(a.Type, a.Module)
+	if err != nil {
+		return nil, err
+	}
+	switch a.Type {
+	case "invoke":
+		args := make([]Value, len(a.Args))
+		for i, arg := range a.Args {
+			if args[i], err = arg.value(nil); err != nil {
+				return nil, fmt.Errorf("invoke %q: %w", a.Field, err)
+			}
+		}
+		return inst.Invoke(a.Field, args...)
+	case "get":
+		g, ok := inst.Global(a.Field)
+		if !ok {
+			return nil, fmt.Errorf("get %q: unknown global", a.Field)
+		}
+		return []Value{g.Get()}, nil
+	}
+	return nil, fmt.Errorf("unexpected %s action", a.Type)
+}
+
+func (s *script) manifestAssertReturn(cmd *manifestCommand) error {
+	got, err := s.action(cmd.Action)
+	if err != nil {
+		return err
+	}
+	if len(got) != len(cmd.Expected) {
+		return fmt.Errorf("assert_return %q: got %d results, expected %d", cmd.Action.Field, len(got), len(cmd.Expected))
+	}
+	want := make([]Value, len(got))
+	for i, e := range cmd.Expected {
+		if want[i], err = e.value(&got[i]); err != nil {
+			return fmt.Errorf("assert_return: %w", err)
+		}
+	}
+	return s.assertResults(got, want)
+}
+
+// value returns the value v stands for. When v is an expected NaN pattern,
+// in whole or in some lanes, got is returned in place of the NaNs it
+// matches, so that it compares equal, and the canonical NaN otherwise.
+func (v manifestValue) value(got *Value) (Value, error) {
+	switch v.Type {
+	case "i32", "i64", "f32", "f64":
+		var s string
+		if err := json.Unmarshal(v.Value, &s); err != nil {
+			return Value{}, err
+		}
+		typ, _ := parseValueType(v.Type)
+		var g uint64
+		if got != nil && got.typ == typ {
+			g = got.bits
+		}
+		bits, err := laneBits(v.Type, s, g)
+		return Value{typ: typ, bits: bits}, err
+	case "v128":
+		var lanes []string
+		if err := json.Unmarshal(v.Value, &lanes); err != nil {
+			return Value{}, err
+		}
+		size := laneSize(v.LaneType)
+		if size == 0 || len(lanes)*size != 128 {
+			return Value{}, fmt.Errorf("invalid v128 of %d %s lanes", len(lanes), v.LaneType)
+		}
+		var g, r [2]uint64
+		if got != nil && got.typ == ValueTypeV128 {
+			g = [2]uint64{got.bits, got.hi}
+		}
+		mask := uint64(1)<<size - 1
+		for i, lane := range lanes {
+			h, off := i*size/64, i*size%64
+			bits, err := laneBits(v.LaneType, lane, g[h]>>off&mask)
+			if err != nil {
+				return Value{}, err
+			}
+			r[h] |= bits & mask << off
+		}
+		return V128(r[0], r[1]), nil
+	case "funcref", "externref":
+		var s string
+		if err := json.Unmarshal(v.Value, &s); err != nil {
+			return Value{}, err
+		}
+		typ, _ := parseValueType(v.Type)
+		if s == "null" {
+			return zero(typ), nil
+		}
+		if typ == ValueTypeFuncRef {
+			return Value{}, fmt.Errorf("unexpected funcref %s", s)
+		}
+		n, err := strconv.ParseUint(s, 10, 32)
+		return ExternRef(uint32(n)), err
+	}
+	return Value{}, fmt.Errorf("unknown value type %q", v.Type)
+}
+
+func laneSize(typ string) int {
+	switch typ {
+	case "i8":
+		return 8
+	case "i16":
+		return 16
+	case "i32", "f32":
+		return 32
+	case "i64", "f64":
+		return 64
+	}
+	return 0
+}
+
+// laneBits parses the bits of a number of the given type, or returns got
+// when s is a NaN pattern it matches and the canonical NaN when it doesn't.
+func laneBits(typ, s string, got uint64) (uint64, error) {
+	// an arithmetic NaN has all the bits of the canonical one set, and
+	// maybe more of the payload
+	var canonical uint64
+	switch typ {
+	case "f32":
+		canonical = canonNaN32
+	case "f64":
+		canonical = canonNaN64
+	}
+	switch s {
+	case "nan:canonical":
+		sign := uint64(1) << (laneSize(typ) - 1)
+		if canonical != 0 && got&^sign == canonical {
+			return got, nil
+		}
+		return canonical, nil
+	case "nan:arithmetic":
+		if canonical != 0 && got&canonical == canonical {
+			return got, nil
+		}
+		return canonical, nil
+	}
+	return strconv.ParseUint(s, 10, laneSize(typ))
+}
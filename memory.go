@@ -0,0 +1,141 @@
+package main
+
+import "fmt"
+
+const (
+	pageSize = 65536
+	maxPages = 65536
+)
+
+// Memory is a linear memory instance.
+type Memory struct {
+	data   []byte
+	max    uint32 // in pages
+	hasMax bool
+	shared bool   // only recorded, without atomics it behaves as unshared
+	limit  uint32 // set by the runtime on top of max, 0 if none
+	grows  uint64 // times the memory grew, for views to tell they are stale
+
+	// growHook is asked before growing from old to new pages, if set
+	growHook func(old, new uint32) bool
+}
+
+func newMemory(l limits) *Memory {
+	m := &Memory{data: make([]byte, int(l.min)*pageSize), max: maxPages}
+	if l.hasMax {
+		m.max, m.hasMax = l.max, true
+	}
+	m.shared = l.shared
+	return m
+}
+
+// NewMemory creates a memory of the type t, in pages, for the host to
+// provide to modules through RegisterHostModule.
+func NewMemory(t ExternType) (*Memory, error) {
+	l, err := t.limits(maxPages)
+	if err != nil {
+		return nil, fmt.Errorf("memory: %w", err)
+	}
+	return newMemory(l), nil
+}
+
+// limits returns the current size and the declared maximum of the memory.
+func (m *Memory) limits() limits {
+	l := limits{min: m.Size(), shared: m.shared}
+	if m.hasMax {
+		l.max, l.hasMax = m.max, true
+	}
+	return l
+}
+
+// matches reports whether the memory can be imported with limits l, which
+// must be shared if it is.
+func (m *Memory) matches(l limits) bool {
+	if m.Size() < l.min || m.shared != l.shared {
+		return false
+	}
+	return !l.hasMax || m.hasMax && m.max <= l.max
+}
+
+// Size returns the size of the memory in pages.
+func (m *Memory) Size() uint32 {
+	return uint32(len(m.data) / pageSize)
+}
+
+// Bytes returns the contents of the memory, for the host to access without
+// copying. The slice stays valid until the memory grows, which may move its
+// contents, so it must be fetched again after calls that can grow it, or
+// taken through View to be told. Its capacity is its length, so appending
+// to it never writes into the memory.
+func (m *Memory) Bytes() []byte {
+	return m.data[:len(m.data):len(m.data)]
+}
+
+// MemoryView is the contents of a memory at some point, which knows whether
+// the memory grew since, leaving it pointing at stale contents.
+type MemoryView struct {
+	mem   *Memory
+	data  []byte
+	grows uint64
+}
+
+// View returns a view of the current contents of the memory.
+func (m *Memory) View() MemoryView {
+	return MemoryView{mem: m, data: m.Bytes(), grows: m.grows}
+}
+
+// Bytes returns the contents of the memory the view was taken of, as
+// Memory.Bytes does. It returns an error wrapping ErrMemoryGrown if the
+// memory grew since.
+func (v MemoryView) Bytes() ([]byte, error) {
+	if v.mem.grows != v.grows {
+		return nil, fmt.Errorf("%w: from %d to %d pages", ErrMemoryGrown, len(v.data)/pageSize, v.mem.Size())
+	}
+	return v.data, nil
+}
+
+// ReadAt copies len(p) bytes of the memory at off into p. It returns an
+// error wrapping ErrOutOfBounds if they aren't all within the memory.
+func (m *Memory) ReadAt(off uint32, p []byte) error {
+	if !m.inBounds(off, 0, uint64(len(p))) {
+		return fmt.Errorf("%w: %d bytes at %d", ErrOutOfBounds, len(p), off)
+	}
+	copy(p, m.data[off:])
+	return nil
+}
+
+// WriteAt copies p into the memory at off. It returns an error wrapping
+// ErrOutOfBounds, writing nothing, if p doesn't fit within the memory.
+func (m *Memory) WriteAt(off uint32, p []byte) error {
+	if !m.inBounds(off, 0, uint64(len(p))) {
+		return fmt.Errorf("%w: %d bytes at %d", ErrOutOfBounds, len(p), off)
+	}
+	copy(m.data[off:], p)
+	return nil
+}
+
+// grow grows the memory by n pages and returns the previous size, or false
+// if the memory can't grow that much.
+func (m *Memory) grow(n uint32) (uint32, bool) {
+	old := m.Size()
+	if uint64(old)+uint64(n) > uint64(m.max) {
+		return old, false
+	}
+	if m.limit > 0 && uint64(old)+uint64(n) > uint64(m.limit) {
+		return old, false
+	}
+	if m.growHook != nil && !m.growHook(old, old+n) {
+		return old, false
+	}
+	if n > 0 {
+		m.data = append(m.data, make([]byte, int(n)*pageSize)...)
+		m.grows++
+	}
+	return old, true
+}
+
+// inBounds reports whether n bytes at the effective address addr+offset are
+// within the memory.
+func (m *Memory) inBounds(addr uint32, offset uint64, n uint64) bool {
+	return uint64(addr)+offset+n <= uint64(len(m.data))
+}
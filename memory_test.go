@@ -0,0 +1,672 @@
+package main_test
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+const memoryInit = `(module
+  (memory 1)
+  (data (i32.const 0) "ab")
+  (data $p "wxyz")
+  (func (export "load") (param i32) (result i32)
+    (i32.load8_u (local.get 0)))
+  (func (export "init") (param i32 i32 i32)
+    (memory.init $p (local.get 0) (local.get 1) (local.get 2)))
+  (func (export "drop")
+    (data.drop $p)))`
+
+func TestMemoryInit(t *testing.T) {
+	tests := []struct {
+		name string
+		drop bool
+		args [3]int32
+		want string
+		trap string
+	}{
+		{"whole segment", false, [3]int32{1, 0, 4}, "awxyz", ""},
+		{"part of segment", false, [3]int32{2, 1, 2}, "abxy\x00", ""},
+		{"dst out of bounds", false, [3]int32{65535, 0, 2}, "", "out of bounds memory access"},
+		{"src out of bounds", false, [3]int32{0, 3, 2}, "", "out of bounds memory access"},
+		{"dropped segment", true, [3]int32{0, 0, 1}, "", "out of bounds memory access"},
+		{"empty from dropped segment", true, [3]int32{0, 0, 0}, "ab\x00\x00\x00", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := war.NewRuntime()
+			if _, err := r.Instantiate([]byte(memoryInit)); err != nil {
+				t.Fatal(err)
+			}
+			if tt.drop {
+				if _, err := r.Invoke("drop"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := r.Invoke("init", war.I32(tt.args[0]), war.I32(tt.args[1]), war.I32(tt.args[2]))
+			if tt.trap != "" {
+				var trap *war.Trap
+				if !errors.As(err, &trap) || trap.Reason != tt.trap {
+					t.Errorf("got error %v, expected trap %q", err, tt.trap)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []byte
+			for i := range len(tt.want) {
+				v, err := r.Invoke("load", war.I32(int32(i)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, byte(v[0].I32()))
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxMemoryPages(t *testing.T) {
+	r := war.NewRuntime(war.WithMaxMemoryPages(3))
+	_, err := r.Instantiate([]byte(`(module
+  (memory 1 10)
+  (func (export "grow") (param i32) (result i32)
+    (memory.grow (local.get 0))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the module allows 10 pages, the runtime only 3
+	for _, tt := range []struct{ n, expected int32 }{{2, 1}, {1, -1}, {0, 3}} {
+		got, err := r.Invoke("grow", war.I32(tt.n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0] != war.I32(tt.expected) {
+			t.Errorf("grow %d: got %v, expected %d", tt.n, got[0], tt.expected)
+		}
+	}
+
+	if _, err := r.Instantiate([]byte(`(module (memory 4))`)); err == nil {
+		t.Error("expected an error instantiating a memory over the limit")
+	}
+}
+
+func TestMemoryGrowFailure(t *testing.T) {
+	r := war.NewRuntime()
+	_, err := r.Instantiate([]byte(`(module
+  (memory $capped 1 2)
+  (memory $open 1)
+  (func (export "grow") (param i32) (result i32)
+    (memory.grow $capped (local.get 0)))
+  (func (export "grow_open") (param i32) (result i32)
+    (memory.grow $open (local.get 0)))
+  (func (export "sizes") (result i32 i32)
+    (memory.size $capped) (memory.size $open)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		n    int32
+	}{
+		{"grow", 2},  // past the declared maximum
+		{"grow", -1}, // 0xffffffff pages
+		{"grow_open", 65536},
+		{"grow_open", -1},
+	} {
+		got, err := r.Invoke(tt.name, war.I32(tt.n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0].Bits() != 0xffffffff {
+			t.Errorf("%s %d: got %v, expected -1", tt.name, uint32(tt.n), got[0])
+		}
+	}
+
+	// the memories are left as they were
+	got, err := r.Invoke("sizes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []war.Value{war.I32(1), war.I32(1)}) {
+		t.Errorf("got sizes %v, expected 1 and 1", got)
+	}
+}
+
+func TestMemoryGrowHook(t *testing.T) {
+	const src = `(module
+  (memory 1)
+  (func (export "grow") (param i32) (result i32)
+    (memory.grow (local.get 0)))
+  (func (export "size") (result i32)
+    (memory.size)))`
+
+	tests := []struct {
+		name     string
+		hook     func(old, new uint32) bool
+		expected []int32 // results of growing by 1 page three times
+		size     int32
+	}{
+		{"caps", func(old, new uint32) bool { return new <= 2 }, []int32{1, -1, -1}, 2},
+		{"allows", func(old, new uint32) bool { return true }, []int32{1, 2, 3}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls [][2]uint32
+			r := war.NewRuntime(war.WithMemoryGrowHook(func(old, new uint32) bool {
+				calls = append(calls, [2]uint32{old, new})
+				return tt.hook(old, new)
+			}))
+			if _, err := r.Instantiate([]byte(src)); err != nil {
+				t.Fatal(err)
+			}
+			for i, expected := range tt.expected {
+				got, err := r.Invoke("grow", war.I32(1))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got[0] != war.I32(expected) {
+					t.Errorf("grow %d: got %v, expected %d", i, got[0], expected)
+				}
+			}
+			if calls[0] != [2]uint32{1, 2} || len(calls) != 3 {
+				t.Errorf("got calls %v", calls)
+			}
+
+			// a denied growth leaves the memory as it was
+			size, err := r.Invoke("size")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size[0] != war.I32(tt.size) {
+				t.Errorf("got size %v, expected %d", size[0], tt.size)
+			}
+		})
+	}
+}
+
+func TestTrapOnGrowFailure(t *testing.T) {
+	const src = `(module
+  (memory 1 2)
+  (table 1 2 funcref)
+  (func (export "grow") (param i32) (result i32)
+    (memory.grow (local.get 0)))
+  (func (export "grow_table") (param i32) (result i32)
+    (table.grow (ref.null func) (local.get 0))))`
+
+	for _, trap := range []bool{false, true} {
+		var opts []war.RuntimeOption
+		if trap {
+			opts = append(opts, war.WithTrapOnGrowFailure())
+		}
+		r := war.NewRuntime(opts...)
+		if _, err := r.Instantiate([]byte(src)); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"grow", "grow_table"} {
+			// within the maximum the growth succeeds either way
+			got, err := r.Invoke(name, war.I32(1))
+			if err != nil || got[0] != war.I32(1) {
+				t.Errorf("trap %v: %s 1: got %v, %v, expected 1", trap, name, got, err)
+			}
+
+			got, err = r.Invoke(name, war.I32(1))
+			var tr *war.Trap
+			switch {
+			case trap && !errors.As(err, &tr):
+				t.Errorf("trap %v: %s past the maximum: got %v, %v, expected a trap", trap, name, got, err)
+			case !trap && (err != nil || got[0] != war.I32(-1)):
+				t.Errorf("trap %v: %s past the maximum: got %v, %v, expected -1", trap, name, got, err)
+			}
+		}
+	}
+}
+
+func TestStoreOperandOrder(t *testing.T) {
+	// flat so that the address is pushed before the value
+	const src = `(module
+  (memory 1)
+  (func (export "i32") (result i32)
+    i32.const 0
+    i32.const 42
+    i32.store
+    i32.const 0
+    i32.load)
+  (func (export "i64") (result i64)
+    i32.const 8
+    i64.const -2
+    i64.store
+    i32.const 8
+    i64.load)
+  (func (export "narrow") (result i32)
+    i32.const 16
+    i32.const 0x1234
+    i32.store16
+    i32.const 18
+    i64.const 0x56
+    i64.store8
+    i32.const 16
+    i32.load)
+  (func (export "f64") (result f64)
+    i32.const 24
+    f64.const 1.5
+    f64.store
+    i32.const 24
+    f64.load)
+  (func (export "oob")
+    i32.const 65535
+    i32.const 0
+    i32.store))`
+
+	for _, opts := range [][]war.RuntimeOption{nil, {war.WithTreeWalker()}} {
+		r := war.NewRuntime(opts...)
+		if _, err := r.Instantiate([]byte(src)); err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range []struct {
+			name     string
+			expected war.Value
+		}{
+			{"i32", war.I32(42)},
+			{"i64", war.I64(-2)},
+			{"narrow", war.I32(0x561234)},
+			{"f64", war.F64(1.5)},
+		} {
+			got, err := r.Invoke(tt.name)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if got[0] != tt.expected {
+				t.Errorf("%s: got %v, expected %v", tt.name, got[0], tt.expected)
+			}
+		}
+
+		// the address is the operand below, so this one is out of bounds
+		_, err := r.Invoke("oob")
+		var trap *war.Trap
+		if !errors.As(err, &trap) {
+			t.Errorf("oob: got %v, expected a trap", err)
+		}
+	}
+}
+
+func TestMemoryCopyFill(t *testing.T) {
+	r := war.NewRuntime()
+	_, err := r.Instantiate([]byte(`(module
+  (memory 1)
+  (data (i32.const 0) "abcdef")
+  (func (export "run")
+    (memory.copy (i32.const 2) (i32.const 0) (i32.const 4))
+    (memory.fill (i32.const 6) (i32.const 0x7a) (i32.const 2)))
+  (func (export "load") (param i32) (result i32)
+    (i32.load8_u (local.get 0))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Invoke("run"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the copy overlaps its source
+	const want = "ababcdzz"
+	var got []byte
+	for i := range len(want) {
+		v, err := r.Invoke("load", war.I32(int32(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, byte(v[0].I32()))
+	}
+	if string(got) != want {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+}
+
+func TestMultiMemory(t *testing.T) {
+	r := war.NewRuntime()
+	_, err := r.Instantiate([]byte(`(module
+  (memory 1)
+  (memory $b 1)
+  (data (memory $b) (i32.const 0) "xy")
+  (func (export "store") (param i32 i32)
+    (i32.store8 $b (local.get 0) (local.get 1)))
+  (func (export "load") (param i32) (result i32 i32)
+    (i32.load8_u (local.get 0))
+    (i32.load8_u 1 (local.get 0)))
+  (func (export "copy")
+    (memory.copy 0 $b (i32.const 0) (i32.const 0) (i32.const 2)))
+  (func (export "sizes") (result i32 i32)
+    (drop (memory.grow $b (i32.const 2)))
+    (memory.size)
+    (memory.size $b)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	load := func(addr int32) [2]int32 {
+		t.Helper()
+		v, err := r.Invoke("load", war.I32(addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return [2]int32{v[0].I32(), v[1].I32()}
+	}
+
+	if _, err := r.Invoke("store", war.I32(2), war.I32('z')); err != nil {
+		t.Fatal(err)
+	}
+	// memory 0 is left untouched
+	for addr, want := range [][2]int32{{0, 'x'}, {0, 'y'}, {0, 'z'}} {
+		if got := load(int32(addr)); got != want {
+			t.Errorf("load %d: got %v, expected %v", addr, got, want)
+		}
+	}
+
+	if _, err := r.Invoke("copy"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := load(1), [2]int32{'y', 'y'}; got != want {
+		t.Errorf("after copy: got %v, expected %v", got, want)
+	}
+
+	v, err := r.Invoke("sizes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v[0].I32() != 1 || v[1].I32() != 3 {
+		t.Errorf("got sizes %d and %d, expected 1 and 3", v[0].I32(), v[1].I32())
+	}
+
+	if _, err := war.NewRuntime().Instantiate([]byte(`(module (memory 1)
+  (func (drop (i32.load 1 (i32.const 0)))))`)); err == nil || !strings.Contains(err.Error(), "unknown memory 1") {
+		t.Errorf("got error %v, expected an unknown memory", err)
+	}
+}
+
+func TestSegmentDropState(t *testing.T) {
+	m, err := war.CompileModule([]byte(`(module
+  (memory 1)
+  (table 4 funcref)
+  (func $f)
+  (data $active (i32.const 0) "ab")
+  (data $passive "cd")
+  (elem $table (i32.const 0) func $f)
+  (elem $declared declare func $f)
+  (elem $elems func $f)
+  (func (export "memory.init") (param i32 i32)
+    (memory.init $active (i32.const 0) (local.get 0) (local.get 1)))
+  (func (export "memory.init passive") (param i32 i32)
+    (memory.init $passive (i32.const 0) (local.get 0) (local.get 1)))
+  (func (export "table.init") (param i32 i32)
+    (table.init $table (i32.const 0) (local.get 0) (local.get 1)))
+  (func (export "table.init declared") (param i32 i32)
+    (table.init $declared (i32.const 0) (local.get 0) (local.get 1)))
+  (func (export "table.init passive") (param i32 i32)
+    (table.init $elems (i32.const 0) (local.get 0) (local.get 1)))
+  (func (export "drop")
+    (data.drop $passive)
+    (elem.drop $elems)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const trap = "out of bounds"
+	tests := []struct {
+		name      string
+		drop      bool
+		src, size int32
+		trap      bool
+	}{
+		// active and declarative segments are dropped by the instantiation
+		{"memory.init", false, 0, 1, true},
+		{"memory.init", false, 0, 0, false},
+		{"table.init", false, 0, 1, true},
+		{"table.init", false, 0, 0, false},
+		{"table.init declared", false, 0, 1, true},
+		{"table.init declared", false, 0, 0, false},
+		// passive ones by the drop instructions
+		{"memory.init passive", false, 0, 2, false},
+		{"memory.init passive", true, 0, 1, true},
+		{"memory.init passive", true, 0, 0, false},
+		{"memory.init passive", true, 1, 0, true},
+		{"table.init passive", false, 0, 1, false},
+		{"table.init passive", true, 0, 1, true},
+		{"table.init passive", true, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		// each instance has segments of its own
+		inst, err := war.NewRuntime().InstantiateModule(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.drop {
+			if _, err := inst.Invoke("drop"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err = inst.Invoke(tt.name, war.I32(tt.src), war.I32(tt.size))
+		var got *war.Trap
+		switch {
+		case tt.trap && (!errors.As(err, &got) || !strings.HasPrefix(got.Reason, trap)):
+			t.Errorf("%s(%d, %d), dropped %t: got %v, expected an %s trap", tt.name, tt.src, tt.size, tt.drop, err, trap)
+		case !tt.trap && err != nil:
+			t.Errorf("%s(%d, %d), dropped %t: %v", tt.name, tt.src, tt.size, tt.drop, err)
+		}
+	}
+}
+
+func TestMemoryViews(t *testing.T) {
+	r := war.NewRuntime()
+	var mem *war.Memory
+	var logged string
+	log, err := war.WrapHostFunc(func(ptr, n int32) error {
+		buf := make([]byte, n)
+		if err := mem.ReadAt(uint32(ptr), buf); err != nil {
+			return err
+		}
+		logged = string(buf)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := war.WrapHostFunc(func(ptr int32) (int32, error) {
+		return 5, mem.WriteAt(uint32(ptr), []byte("guest"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.RegisterHost("env", map[string]war.HostFunc{"log": log, "name": name})
+
+	inst, err := r.Instantiate([]byte(`(module
+  (import "env" "log" (func $log (param i32 i32)))
+  (import "env" "name" (func $name (param i32) (result i32)))
+  (memory (export "mem") 1)
+  (data (i32.const 0) "hello")
+  (func (export "hello") (call $log (i32.const 0) (i32.const 5)))
+  (func (export "name") (param i32) (result i32)
+    ;; the sum of the bytes of the name the host writes
+    (local $n i32) (local $sum i32)
+    (local.set $n (call $name (local.get 0)))
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $n)))
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        (local.set $sum (i32.add (local.get $sum)
+          (i32.load8_u (i32.add (local.get 0) (local.get $n)))))
+        (br $next)))
+    (local.get $sum))
+  (func (export "grow") (drop (memory.grow (i32.const 1)))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, _ = inst.Memory("mem")
+
+	if _, err := inst.Invoke("hello"); err != nil || logged != "hello" {
+		t.Errorf("got %q, %v, expected \"hello\"", logged, err)
+	}
+
+	want := int32(0)
+	for _, c := range []byte("guest") {
+		want += int32(c)
+	}
+	if got, err := inst.Invoke("name", war.I32(100)); err != nil || got[0] != war.I32(want) {
+		t.Errorf("got %v, %v, expected [i32:%d]", got, err, want)
+	}
+	if got := string(mem.Bytes()[100:105]); got != "guest" {
+		t.Errorf("got %q in memory, expected \"guest\"", got)
+	}
+
+	var trap *war.Trap
+	if _, err := inst.Invoke("name", war.I32(65534)); !errors.As(err, &trap) {
+		t.Errorf("got %v, expected a trap writing out of bounds", err)
+	}
+	if err := mem.ReadAt(65535, make([]byte, 2)); !errors.Is(err, war.ErrOutOfBounds) {
+		t.Errorf("got %v, expected ErrOutOfBounds", err)
+	}
+
+	view := mem.View()
+	data, err := view.Bytes()
+	if err != nil || string(data[100:105]) != "guest" {
+		t.Errorf("got %q, %v through the view, expected \"guest\"", data[100:105], err)
+	}
+	if len(data) != cap(data) {
+		t.Errorf("got a view of capacity %d, expected %d", cap(data), len(data))
+	}
+	if _, err := inst.Invoke("grow"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := view.Bytes(); !errors.Is(err, war.ErrMemoryGrown) {
+		t.Errorf("got %v through the view taken before growing, expected ErrMemoryGrown", err)
+	}
+	if data, err := mem.View().Bytes(); err != nil || len(data) != 2*65536 {
+		t.Errorf("got %d bytes, %v after growing, expected %d", len(data), err, 2*65536)
+	}
+	if err := mem.WriteAt(65535, []byte("ok")); err != nil {
+		t.Errorf("got %v writing across the new page", err)
+	}
+}
+
+func TestStrings(t *testing.T) {
+	inst, err := war.NewRuntime().Instantiate([]byte(`(module
+  (memory (export "memory") 1)
+  (global $next (mut i32) (i32.const 16))
+  (func $alloc (export "alloc") (param i32) (result i32)
+    (global.get $next)
+    (global.set $next (i32.add (global.get $next) (local.get 0))))
+  (func (export "copy") (param $ptr i32) (param $n i32) (result i32)
+    (local $dst i32)
+    (local.set $dst (call $alloc (local.get $n)))
+    (memory.copy (local.get $dst) (local.get $ptr) (local.get $n))
+    (local.get $dst)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const s = "héllo, 世界"
+	ptr, err := inst.WriteString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ptr != 16 {
+		t.Errorf("got %d, expected the string at 16", ptr)
+	}
+	res, err := inst.Invoke("copy", war.I32(int32(ptr)), war.I32(int32(len(s))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := inst.ReadString(uint32(res[0].I32()), uint32(len(s)))
+	if err != nil || got != s {
+		t.Errorf("got %q, %v, expected %q", got, err, s)
+	}
+
+	if _, err := inst.ReadString(65535, 2); !errors.Is(err, war.ErrOutOfBounds) {
+		t.Errorf("got %v, expected ErrOutOfBounds", err)
+	}
+
+	noAlloc, err := war.NewRuntime().Instantiate([]byte(`(module (memory 1))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := noAlloc.WriteString(s); err == nil {
+		t.Error("expected an error writing without an alloc function")
+	}
+
+	// malloc allocates in the exported memory, which isn't the first one
+	heap, err := war.NewRuntime().Instantiate([]byte(`(module
+  (memory 1)
+  (memory (export "heap") 1)
+  (func (export "malloc") (param i32) (result i32) (i32.const 32)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ptr, err = heap.WriteString(s); err != nil || ptr != 32 {
+		t.Fatalf("got %d, %v, expected the string at 32", ptr, err)
+	}
+	if err := heap.WriteStringAt(64, "at"); err != nil {
+		t.Fatal(err)
+	}
+	mem, _ := heap.Memory("heap")
+	buf := make([]byte, len(s))
+	if err := mem.ReadAt(32, buf); err != nil || string(buf) != s {
+		t.Errorf("got %q, %v in the exported memory, expected %q", buf, err, s)
+	}
+	if got, err := heap.ReadString(64, 2); err != nil || got != "at" {
+		t.Errorf("got %q, %v, expected %q", got, err, "at")
+	}
+}
+
+func TestSharedMemory(t *testing.T) {
+	r := war.NewRuntime()
+	const shared = `(module (memory (export "mem") 1 2 shared)
+  (func (export "load") (result i32) (i32.load (i32.const 0))))`
+	inst, err := r.Instantiate([]byte(shared))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Register("env", inst)
+	if _, err := inst.Invoke("load"); err != nil {
+		t.Error(err)
+	}
+
+	// the flag survives a round trip through the binary format
+	wasm, err := war.Assemble([]byte(shared))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(wasm, []byte{0x05, 0x04, 0x01, 0x03, 0x01, 0x02}) {
+		t.Errorf("got\n% x\nexpected a shared memory section", wasm)
+	}
+	text, err := war.Disassemble(wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(text, []byte("(memory 1 2 shared)")) {
+		t.Errorf("got\n%s\nexpected a shared memory", text)
+	}
+
+	// imports must agree on sharing
+	if _, err := r.Instantiate([]byte(`(module (import "env" "mem" (memory 1 2 shared)))`)); err != nil {
+		t.Error(err)
+	}
+	var le *war.LinkError
+	if _, err := r.Instantiate([]byte(`(module (import "env" "mem" (memory 1 2)))`)); !errors.As(err, &le) {
+		t.Errorf("got %v, expected a link error importing a shared memory as unshared", err)
+	}
+
+	if _, err := war.Disassemble([]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x05, 0x03, 0x01, 0x02, 0x01}); err == nil {
+		t.Error("expected an error decoding a shared memory without maximum")
+	}
+}
@@ -0,0 +1,887 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bluescreen10/war/text"
+)
+
+type funcType struct {
+	params  []ValueType
+	results []ValueType
+}
+
+func (t funcType) equal(o funcType) bool {
+	return equalTypes(t.params, o.params) && equalTypes(t.results, o.results)
+}
+
+func (t funcType) String() string {
+	return fmt.Sprintf("%v -> %v", t.params, t.results)
+}
+
+func equalTypes(a, b []ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ExternKind is the kind of an imported or exported item.
+type ExternKind byte
+
+const (
+	ExternFunc ExternKind = iota
+	ExternTable
+	ExternMemory
+	ExternGlobal
+)
+
+func (k ExternKind) String() string {
+	return [...]string{"func", "table", "memory", "global"}[k]
+}
+
+type function struct {
+	name    string
+	typeIdx uint32
+	typ     funcType
+	locals  []ValueType
+	body    []*instr
+	flat    []flatInstr // bytecode of body
+	node    *text.Node
+}
+
+type globalType struct {
+	typ ValueType
+	mut bool
+}
+
+type global struct {
+	name string
+	typ  globalType
+	init []*instr
+}
+
+type limits struct {
+	min    uint32
+	max    uint32
+	hasMax bool
+	shared bool // of memories, from the threads proposal
+}
+
+// validate checks that the minimum is within the maximum, and for the limits
+// of a memory that both are within the pages it can address.
+func (l limits) validate(memory bool) error {
+	if l.hasMax && l.min > l.max {
+		return fmt.Errorf("size minimum must not be greater than maximum")
+	}
+	if memory && (l.min > maxPages || l.hasMax && l.max > maxPages) {
+		return fmt.Errorf("memory size must be at most 65536 pages (4GiB)")
+	}
+	return nil
+}
+
+type table struct {
+	name   string
+	typ    ValueType
+	limits limits
+}
+
+type memory struct {
+	name   string
+	limits limits
+}
+
+type export struct {
+	name  string
+	kind  ExternKind
+	index uint32
+}
+
+type importEntry struct {
+	module  string
+	name    string
+	kind    ExternKind
+	typeIdx uint32     // of functions
+	typ     funcType   // of functions
+	global  globalType // of globals
+	elem    ValueType  // of tables
+	limits  limits     // of tables and memories
+}
+
+type elemSegment struct {
+	name    string
+	typ     ValueType
+	table   uint32
+	offset  []*instr // nil for passive and declarative segments
+	declare bool
+	init    [][]*instr
+}
+
+type dataSegment struct {
+	name   string
+	mem    uint32
+	offset []*instr // nil for passive segments
+	init   []byte
+}
+
+// Module is a compiled wasm module, ready to be instantiated.
+type Module struct {
+	name    string
+	types   []funcType
+	imports []importEntry
+	funcs   []*function
+	globals []*global
+	tables  []*table
+	mems    []*memory
+	exports []export
+	elems   []*elemSegment
+	datas   []*dataSegment
+
+	start    uint32 // function run at instantiation, if hasStart
+	hasStart bool
+
+	// identifiers of the index spaces, as written in the text format
+	ids [numSpaces]map[string]uint32
+
+	customs []CustomSection // of a decoded module
+}
+
+type space int
+
+const (
+	spaceType space = iota
+	spaceFunc
+	spaceGlobal
+	spaceTable
+	spaceMemory
+	spaceElem
+	spaceData
+	numSpaces
+)
+
+var spaceNames = [...]string{"type", "function", "global", "table", "memory", "elem segment", "data segment"}
+
+// compiler turns the syntax tree of a module into a Module, resolving
+// symbolic references against the module's index spaces.
+type compiler struct {
+	m     *Module
+	names [numSpaces]map[string]uint32
+}
+
+func compileModule(n *text.Node) (*Module, error) {
+	c := &compiler{m: &Module{name: n.Meta}}
+	for i := range c.names {
+		c.names[i] = map[string]uint32{}
+	}
+	err := validateFields(n)
+	if err == nil {
+		err = c.compile(n)
+	}
+	if err == nil {
+		err = checkModule(c.m)
+	}
+	if err != nil {
+		if n.Meta != "" {
+			return nil, fmt.Errorf("module %s: %w", n.Meta, err)
+		}
+		return nil, err
+	}
+	c.m.ids = c.names
+	return c.m, nil
+}
+
+func (c *compiler) define(s space, id string, idx int) error {
+	if id == "" {
+		return nil
+	}
+	if _, ok := c.names[s][id]; ok {
+		return fmt.Errorf("duplicate %s %s", spaceNames[s], id)
+	}
+	c.names[s][id] = uint32(idx)
+	return nil
+}
+
+func (c *compiler) resolve(s space, ref string) (uint32, error) {
+	if strings.HasPrefix(ref, "$") {
+		idx, ok := c.names[s][ref]
+		if !ok {
+			return 0, fmt.Errorf("unknown %s %s", spaceNames[s], ref)
+		}
+		return idx, nil
+	}
+	idx, err := text.ParseUint(ref, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s index %q", spaceNames[s], ref)
+	}
+	return uint32(idx), nil
+}
+
+// splitID splits the leading identifier, if any, from the atoms of a node.
+func splitID(meta string) (string, []string) {
+	fields := text.Fields(meta)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "$") {
+		return fields[0], fields[1:]
+	}
+	return "", fields
+}
+
+func (c *compiler) compile(n *text.Node) error {
+	// first pass: explicit types and the identifiers of every index space,
+	// so fields can refer to items defined after them
+	var nfuncs, nglobals, ntables, nmems, nelems, ndatas int
+	for _, f := range n.Args {
+		var err error
+		if f.Op == text.OpImport {
+			// imported items take the same indices as the defined ones
+			f = f.Args[0]
+		}
+		switch f.Op {
+		case text.OpType:
+			err = c.compileType(f)
+		case text.OpFunc:
+			err = c.define(spaceFunc, f.Meta, nfuncs)
+			nfuncs++
+		case text.OpGlobal:
+			id, _ := splitID(f.Meta)
+			err = c.define(spaceGlobal, id, nglobals)
+			nglobals++
+		case text.OpTable:
+			id, _ := splitID(f.Meta)
+			err = c.define(spaceTable, id, ntables)
+			ntables++
+		case text.OpMemory:
+			id, _ := splitID(f.Meta)
+			err = c.define(spaceMemory, id, nmems)
+			nmems++
+		case text.OpElem:
+			id, _ := splitID(f.Meta)
+			err = c.define(spaceElem, id, nelems)
+			nelems++
+		case text.OpData:
+			id, _ := splitID(f.Meta)
+			err = c.define(spaceData, id, ndatas)
+			ndatas++
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range n.Args {
+		var err error
+		switch f.Op {
+		case text.OpImport:
+			err = c.compileImport(f)
+		case text.OpFunc:
+			err = c.compileFunc(f)
+		case text.OpGlobal:
+			err = c.compileGlobal(f)
+		case text.OpTable:
+			err = c.compileTable(f)
+		case text.OpMemory:
+			err = c.compileMemory(f)
+		case text.OpExport:
+			err = c.compileExport(f)
+		case text.OpElem:
+			err = c.compileElem(f)
+		case text.OpData:
+			err = c.compileData(f)
+		case text.OpStart:
+			err = c.compileStart(f)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *compiler) compileType(n *text.Node) error {
+	if err := c.define(spaceType, n.Meta, len(c.m.types)); err != nil {
+		return err
+	}
+	t, _, err := c.signature(n.Args[0].Args)
+	if err != nil {
+		return err
+	}
+	c.m.types = append(c.m.types, t)
+	return nil
+}
+
+// signature collects the params and results of a list of nodes, returning
+// the names of the params as well.
+func (c *compiler) signature(nodes []*text.Node) (funcType, []string, error) {
+	var t funcType
+	var names []string
+	for _, n := range nodes {
+		if n.Op != text.OpParam && n.Op != text.OpResult {
+			continue
+		}
+		id, types := splitID(n.Meta)
+		for _, s := range types {
+			vt, err := parseValueType(s)
+			if err != nil {
+				return t, nil, err
+			}
+			if n.Op == text.OpParam {
+				t.params = append(t.params, vt)
+				names = append(names, id)
+			} else {
+				t.results = append(t.results, vt)
+			}
+		}
+	}
+	return t, names, nil
+}
+
+// typeUse resolves the type of a function from its (type x) reference and
+// inline signature. Inline signatures without a reference use the first
+// matching type or append a new one.
+func (c *compiler) typeUse(nodes []*text.Node) (uint32, funcType, []string, error) {
+	t, names, err := c.signature(nodes)
+	if err != nil {
+		return 0, t, nil, err
+	}
+
+	for _, n := range nodes {
+		if n.Op != text.OpTypeUse {
+			continue
+		}
+		idx, err := c.resolve(spaceType, n.Meta)
+		if err != nil {
+			return 0, t, nil, err
+		}
+		if int(idx) >= len(c.m.types) {
+			return 0, t, nil, fmt.Errorf("unknown type %s", n.Meta)
+		}
+		ref := c.m.types[idx]
+		inline := len(t.params) > 0 || len(t.results) > 0
+		if inline && !ref.equal(t) {
+			return 0, t, nil, fmt.Errorf("inline function type %v does not match type %s", t, n.Meta)
+		}
+		if !inline {
+			names = make([]string, len(ref.params))
+		}
+		return idx, ref, names, nil
+	}
+
+	for i, ref := range c.m.types {
+		if ref.equal(t) {
+			return uint32(i), t, names, nil
+		}
+	}
+	c.m.types = append(c.m.types, t)
+	return uint32(len(c.m.types) - 1), t, names, nil
+}
+
+func (c *compiler) compileFunc(n *text.Node) error {
+	idx, t, names, err := c.typeUse(n.Args)
+	if err != nil {
+		return err
+	}
+
+	f := &function{name: strings.TrimPrefix(n.Meta, "$"), typeIdx: idx, typ: t, node: n}
+	fc := &funcCompiler{compiler: c, locals: map[string]uint32{}, labels: []string{""}}
+	for i, name := range names {
+		if err := fc.defineLocal(name, i); err != nil {
+			return err
+		}
+	}
+
+	var body []*text.Node
+	for _, a := range n.Args {
+		switch a.Op {
+		case text.OpTypeUse, text.OpParam, text.OpResult:
+		case text.OpLocal:
+			id, types := splitID(a.Meta)
+			for _, s := range types {
+				vt, err := parseValueType(s)
+				if err != nil {
+					return err
+				}
+				if err := fc.defineLocal(id, len(t.params)+len(f.locals)); err != nil {
+					return err
+				}
+				f.locals = append(f.locals, vt)
+			}
+		default:
+			body = append(body, a)
+		}
+	}
+	if f.body, err = fc.lowerAll(body); err != nil {
+		if n.Meta != "" {
+			return fmt.Errorf("func %s: %w", n.Meta, err)
+		}
+		return fmt.Errorf("func %d: %w", len(c.m.funcs), err)
+	}
+	f.flat = flatten(f.body, len(t.results))
+	c.m.funcs = append(c.m.funcs, f)
+	return nil
+}
+
+func (c *compiler) constExpr(nodes []*text.Node) ([]*instr, error) {
+	fc := &funcCompiler{compiler: c, labels: []string{""}}
+	return fc.lowerAll(nodes)
+}
+
+func (c *compiler) compileImport(n *text.Node) error {
+	names := text.Fields(n.Meta)
+	mod, err := text.Unquote(names[0])
+	if err != nil {
+		return err
+	}
+	name, err := text.Unquote(names[1])
+	if err != nil {
+		return err
+	}
+
+	imp := importEntry{module: string(mod), name: string(name)}
+	desc := n.Args[0]
+	switch desc.Op {
+	case text.OpFunc:
+		imp.kind = ExternFunc
+		imp.typeIdx, imp.typ, _, err = c.typeUse(desc.Args)
+	case text.OpGlobal:
+		imp.kind = ExternGlobal
+		imp.global, _, err = parseGlobalType(desc)
+	case text.OpTable:
+		imp.kind = ExternTable
+		_, imp.elem, imp.limits, err = parseTableType(desc)
+	case text.OpMemory:
+		imp.kind = ExternMemory
+		_, atoms := splitID(desc.Meta)
+		imp.limits, err = parseMemoryType(atoms)
+	}
+	if err != nil {
+		return fmt.Errorf("import %s %s: %w", names[0], names[1], err)
+	}
+	c.m.imports = append(c.m.imports, imp)
+	return nil
+}
+
+// parseGlobalType returns the type of a global along with the nodes that
+// follow it.
+func parseGlobalType(n *text.Node) (globalType, []*text.Node, error) {
+	var t globalType
+	_, atoms := splitID(n.Meta)
+	rest := n.Args
+
+	if len(rest) > 0 && rest[0].Op == text.OpMut {
+		atoms = []string{rest[0].Meta}
+		t.mut = true
+		rest = rest[1:]
+	}
+	if len(atoms) != 1 {
+		return t, nil, fmt.Errorf("invalid global type %q", n.Meta)
+	}
+
+	var err error
+	t.typ, err = parseValueType(atoms[0])
+	return t, rest, err
+}
+
+func (c *compiler) compileGlobal(n *text.Node) error {
+	id, _ := splitID(n.Meta)
+	g := &global{name: strings.TrimPrefix(id, "$")}
+
+	var init []*text.Node
+	var err error
+	if g.typ, init, err = parseGlobalType(n); err != nil {
+		return err
+	}
+	if g.init, err = c.constExpr(init); err != nil {
+		return err
+	}
+	c.m.globals = append(c.m.globals, g)
+	return nil
+}
+
+func parseLimits(atoms []string) (limits, error) {
+	var l limits
+	if len(atoms) < 1 || len(atoms) > 2 {
+		return l, fmt.Errorf("invalid limits %q", strings.Join(atoms, " "))
+	}
+	min, err := text.ParseUint(atoms[0], 32)
+	if err != nil {
+		return l, err
+	}
+	l.min = uint32(min)
+	if len(atoms) == 2 {
+		max, err := text.ParseUint(atoms[1], 32)
+		if err != nil {
+			return l, err
+		}
+		l.max, l.hasMax = uint32(max), true
+	}
+	return l, l.validate(false)
+}
+
+// parseMemoryType returns the limits of a memory, which may be shared if
+// they have a maximum.
+func parseMemoryType(atoms []string) (limits, error) {
+	shared := len(atoms) > 0 && atoms[len(atoms)-1] == "shared"
+	if shared {
+		atoms = atoms[:len(atoms)-1]
+	}
+	l, err := parseLimits(atoms)
+	if err != nil {
+		return l, err
+	}
+	if shared && !l.hasMax {
+		return l, fmt.Errorf("shared memory must have maximum")
+	}
+	l.shared = shared
+	return l, l.validate(true)
+}
+
+// parseTableType returns the id, element type and limits of a table.
+func parseTableType(n *text.Node) (string, ValueType, limits, error) {
+	id, atoms := splitID(n.Meta)
+	if len(atoms) < 2 {
+		return "", 0, limits{}, fmt.Errorf("invalid table type %q", n.Meta)
+	}
+	l, err := parseLimits(atoms[:len(atoms)-1])
+	if err != nil {
+		return "", 0, limits{}, err
+	}
+	typ, err := parseValueType(atoms[len(atoms)-1])
+	return id, typ, l, err
+}
+
+func (c *compiler) compileTable(n *text.Node) error {
+	id, typ, l, err := parseTableType(n)
+	if err != nil {
+		return err
+	}
+	c.m.tables = append(c.m.tables, &table{name: strings.TrimPrefix(id, "$"), typ: typ, limits: l})
+	return nil
+}
+
+func (c *compiler) compileMemory(n *text.Node) error {
+	id, atoms := splitID(n.Meta)
+	l, err := parseMemoryType(atoms)
+	if err != nil {
+		return err
+	}
+	c.m.mems = append(c.m.mems, &memory{name: strings.TrimPrefix(id, "$"), limits: l})
+	return nil
+}
+
+func (c *compiler) compileStart(n *text.Node) error {
+	if c.m.hasStart {
+		return fmt.Errorf("multiple start sections")
+	}
+	idx, err := c.resolve(spaceFunc, n.Meta)
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	c.m.start, c.m.hasStart = idx, true
+	return nil
+}
+
+func (c *compiler) compileExport(n *text.Node) error {
+	name, err := text.Unquote(n.Meta)
+	if err != nil {
+		return err
+	}
+
+	desc := n.Args[0]
+	e := export{name: string(name)}
+	var s space
+	switch desc.Op {
+	case text.OpFunc:
+		e.kind, s = ExternFunc, spaceFunc
+	case text.OpGlobal:
+		e.kind, s = ExternGlobal, spaceGlobal
+	case text.OpTable:
+		e.kind, s = ExternTable, spaceTable
+	case text.OpMemory:
+		e.kind, s = ExternMemory, spaceMemory
+	}
+	if e.index, err = c.resolve(s, desc.Meta); err != nil {
+		return err
+	}
+	c.m.exports = append(c.m.exports, e)
+	return nil
+}
+
+func (c *compiler) compileElem(n *text.Node) error {
+	id, atoms := splitID(n.Meta)
+	e := &elemSegment{name: strings.TrimPrefix(id, "$")}
+	if len(atoms) == 2 && atoms[0] == "declare" {
+		e.declare = true
+		atoms = atoms[1:]
+	}
+
+	var err error
+	if e.typ, err = parseValueType(atoms[0]); err != nil {
+		return err
+	}
+	for _, a := range n.Args {
+		switch a.Op {
+		case text.OpTable:
+			e.table, err = c.resolve(spaceTable, a.Meta)
+		case text.OpOffset:
+			e.offset, err = c.constExpr(a.Args)
+		case text.OpItem:
+			var item []*instr
+			item, err = c.constExpr(a.Args)
+			e.init = append(e.init, item)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	c.m.elems = append(c.m.elems, e)
+	return nil
+}
+
+func (c *compiler) compileData(n *text.Node) error {
+	id, atoms := splitID(n.Meta)
+	d := &dataSegment{name: strings.TrimPrefix(id, "$")}
+	for _, s := range atoms {
+		b, err := text.Unquote(s)
+		if err != nil {
+			return err
+		}
+		d.init = append(d.init, b...)
+	}
+
+	for _, a := range n.Args {
+		var err error
+		switch a.Op {
+		case text.OpMemory:
+			d.mem, err = c.resolve(spaceMemory, a.Meta)
+		case text.OpOffset:
+			d.offset, err = c.constExpr(a.Args)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	c.m.datas = append(c.m.datas, d)
+	return nil
+}
+
+// ExternType is the type of an imported or exported item. Only the fields
+// that apply to its kind are set.
+type ExternType struct {
+	Params  []ValueType // of functions
+	Results []ValueType // of functions
+	Value   ValueType   // of globals, the element type of tables
+	Mutable bool        // of globals
+	Min     uint32      // of tables and memories
+	Max     uint32      // of tables and memories, when HasMax is set
+	HasMax  bool
+}
+
+// limits returns the limits of a table or memory type, checking that the
+// minimum is within the maximum and both within max.
+func (t ExternType) limits(max uint32) (limits, error) {
+	l := limits{min: t.Min, max: t.Max, hasMax: t.HasMax}
+	if t.HasMax && t.Min > t.Max {
+		return l, fmt.Errorf("size minimum must not be greater than maximum")
+	}
+	if t.Min > max || t.HasMax && t.Max > max {
+		return l, fmt.Errorf("size must be at most %d", max)
+	}
+	return l, nil
+}
+
+// ImportDesc describes an import of a module.
+type ImportDesc struct {
+	Module string
+	Name   string
+	Kind   ExternKind
+	Type   ExternType
+}
+
+// ExportDesc describes an export of a module.
+type ExportDesc struct {
+	Name string
+	Kind ExternKind
+	Type ExternType
+}
+
+// Imports returns the imports of the module, in order.
+func (m *Module) Imports() []ImportDesc {
+	descs := make([]ImportDesc, len(m.imports))
+	for i, imp := range m.imports {
+		descs[i] = ImportDesc{Module: imp.module, Name: imp.name, Kind: imp.kind, Type: imp.externType()}
+	}
+	return descs
+}
+
+// Exports returns the exports of the module, in order.
+func (m *Module) Exports() []ExportDesc {
+	// types of the index spaces, imports first
+	var types [ExternGlobal + 1][]ExternType
+	for _, imp := range m.imports {
+		types[imp.kind] = append(types[imp.kind], imp.externType())
+	}
+	for _, f := range m.funcs {
+		types[ExternFunc] = append(types[ExternFunc], ExternType{Params: f.typ.params, Results: f.typ.results})
+	}
+	for _, t := range m.tables {
+		types[ExternTable] = append(types[ExternTable], limitsType(t.limits, t.typ))
+	}
+	for _, mem := range m.mems {
+		types[ExternMemory] = append(types[ExternMemory], limitsType(mem.limits, 0))
+	}
+	for _, g := range m.globals {
+		types[ExternGlobal] = append(types[ExternGlobal], ExternType{Value: g.typ.typ, Mutable: g.typ.mut})
+	}
+
+	descs := make([]ExportDesc, len(m.exports))
+	for i, e := range m.exports {
+		descs[i] = ExportDesc{Name: e.name, Kind: e.kind}
+		if int(e.index) < len(types[e.kind]) {
+			descs[i].Type = types[e.kind][e.index]
+		}
+	}
+	return descs
+}
+
+// CustomSections returns the custom sections of a module decoded by
+// DecodeModule, in order.
+func (m *Module) CustomSections() []CustomSection {
+	return m.customs
+}
+
+func (imp importEntry) externType() ExternType {
+	switch imp.kind {
+	case ExternFunc:
+		return ExternType{Params: imp.typ.params, Results: imp.typ.results}
+	case ExternGlobal:
+		return ExternType{Value: imp.global.typ, Mutable: imp.global.mut}
+	case ExternTable:
+		return limitsType(imp.limits, imp.elem)
+	case ExternMemory:
+		return limitsType(imp.limits, 0)
+	}
+	return ExternType{}
+}
+
+func limitsType(l limits, elem ValueType) ExternType {
+	return ExternType{Value: elem, Min: l.min, Max: l.max, HasMax: l.hasMax}
+}
+
+// DumpIndexSpaces writes the index spaces of the module, imports first, with
+// the identifier and the type of every item, to help find out what an index
+// refers to.
+func (m *Module) DumpIndexSpaces(w io.Writer) error {
+	var b strings.Builder
+	var ids map[uint32]string
+	section := func(s space, n int) bool {
+		if n == 0 {
+			return false
+		}
+		ids = map[uint32]string{}
+		for id, idx := range m.ids[s] {
+			ids[idx] = id
+		}
+		fmt.Fprintf(&b, "%s:\n", spaceNames[s])
+		return true
+	}
+	item := func(idx int, format string, args ...any) {
+		fmt.Fprintf(&b, "  %d", idx)
+		if id, ok := ids[uint32(idx)]; ok {
+			fmt.Fprintf(&b, " %s", id)
+		}
+		fmt.Fprintf(&b, " "+format+"\n", args...)
+	}
+	imported := func(kind ExternKind, fn func(idx int, imp importEntry)) int {
+		n := 0
+		for _, imp := range m.imports {
+			if imp.kind == kind {
+				fn(n, imp)
+				n++
+			}
+		}
+		return n
+	}
+	if section(spaceType, len(m.types)) {
+		for i, t := range m.types {
+			item(i, "func %v", t)
+		}
+	}
+	if section(spaceFunc, countImports(m.imports, ExternFunc)+len(m.funcs)) {
+		n := imported(ExternFunc, func(i int, imp importEntry) {
+			item(i, "import %q %q: type %d %v", imp.module, imp.name, imp.typeIdx, imp.typ)
+		})
+		for i, f := range m.funcs {
+			item(n+i, "type %d %v", f.typeIdx, f.typ)
+		}
+	}
+	if section(spaceGlobal, countImports(m.imports, ExternGlobal)+len(m.globals)) {
+		n := imported(ExternGlobal, func(i int, imp importEntry) {
+			item(i, "import %q %q: %v", imp.module, imp.name, imp.global)
+		})
+		for i, g := range m.globals {
+			item(n+i, "%v", g.typ)
+		}
+	}
+	if section(spaceTable, countImports(m.imports, ExternTable)+len(m.tables)) {
+		n := imported(ExternTable, func(i int, imp importEntry) {
+			item(i, "import %q %q: %v %s", imp.module, imp.name, imp.limits, imp.elem)
+		})
+		for i, t := range m.tables {
+			item(n+i, "%v %s", t.limits, t.typ)
+		}
+	}
+	if section(spaceMemory, countImports(m.imports, ExternMemory)+len(m.mems)) {
+		n := imported(ExternMemory, func(i int, imp importEntry) {
+			item(i, "import %q %q: %v", imp.module, imp.name, imp.limits)
+		})
+		for i, mem := range m.mems {
+			item(n+i, "%v", mem.limits)
+		}
+	}
+	if section(spaceElem, len(m.elems)) {
+		for i, e := range m.elems {
+			mode := fmt.Sprintf("active table %d", e.table)
+			if e.declare {
+				mode = "declarative"
+			} else if e.offset == nil {
+				mode = "passive"
+			}
+			item(i, "%s: %d %s", mode, len(e.init), e.typ)
+		}
+	}
+	if section(spaceData, len(m.datas)) {
+		for i, d := range m.datas {
+			mode := fmt.Sprintf("active memory %d", d.mem)
+			if d.offset == nil {
+				mode = "passive"
+			}
+			item(i, "%s: %d bytes", mode, len(d.init))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (t globalType) String() string {
+	if t.mut {
+		return fmt.Sprintf("(mut %s)", t.typ)
+	}
+	return t.typ.String()
+}
+
+func (l limits) String() string {
+	s := fmt.Sprint(l.min)
+	if l.hasMax {
+		s += fmt.Sprintf(" %d", l.max)
+	}
+	if l.shared {
+		s += " shared"
+	}
+	return s
+}
@@ -0,0 +1,132 @@
+package main_test
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+func TestModuleImportsExports(t *testing.T) {
+	m, err := war.CompileModule([]byte(`(module
+  (import "env" "log" (func (param i32)))
+  (import "env" "base" (global i64))
+  (import "env" "mem" (memory 1 4))
+  (func (export "add") (param i32 i32) (result i32)
+    (i32.add (local.get 0) (local.get 1)))
+  (global (export "count") (mut i32) (i32.const 0))
+  (table (export "tab") 2 funcref)
+  (export "log" (func 0))
+  (export "mem" (memory 0)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i32, i64 := war.ValueTypeI32, war.ValueTypeI64
+	wantImports := []war.ImportDesc{
+		{Module: "env", Name: "log", Kind: war.ExternFunc, Type: war.ExternType{Params: []war.ValueType{i32}}},
+		{Module: "env", Name: "base", Kind: war.ExternGlobal, Type: war.ExternType{Value: i64}},
+		{Module: "env", Name: "mem", Kind: war.ExternMemory, Type: war.ExternType{Min: 1, Max: 4, HasMax: true}},
+	}
+	if got := m.Imports(); !reflect.DeepEqual(got, wantImports) {
+		t.Errorf("imports: got %+v, expected %+v", got, wantImports)
+	}
+
+	wantExports := []war.ExportDesc{
+		{Name: "add", Kind: war.ExternFunc, Type: war.ExternType{Params: []war.ValueType{i32, i32}, Results: []war.ValueType{i32}}},
+		{Name: "count", Kind: war.ExternGlobal, Type: war.ExternType{Value: i32, Mutable: true}},
+		{Name: "tab", Kind: war.ExternTable, Type: war.ExternType{Value: war.ValueTypeFuncRef, Min: 2}},
+		{Name: "log", Kind: war.ExternFunc, Type: war.ExternType{Params: []war.ValueType{i32}}},
+		{Name: "mem", Kind: war.ExternMemory, Type: war.ExternType{Min: 1, Max: 4, HasMax: true}},
+	}
+	if got := m.Exports(); !reflect.DeepEqual(got, wantExports) {
+		t.Errorf("exports: got %+v, expected %+v", got, wantExports)
+	}
+}
+
+func TestInstanceExports(t *testing.T) {
+	r := war.NewRuntime()
+	// enough exports that a map would be unlikely to keep them in order
+	var src strings.Builder
+	src.WriteString("(module\n  (memory 1)\n  (export \"z_mem\" (memory 0))\n")
+	var want []string
+	want = append(want, "z_mem")
+	for i := range 20 {
+		name := fmt.Sprintf("f%02d", 19-i)
+		fmt.Fprintf(&src, "  (func (export %q) (result i32) (i32.const %d))\n", name, i)
+		want = append(want, name)
+	}
+	src.WriteString("  (global (export \"a_global\") i32 (i32.const 0)))")
+	want = append(want, "a_global")
+
+	inst, err := r.Instantiate([]byte(src.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range inst.Exports() {
+		got = append(got, e.Name)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got exports %v, expected %v", got, want)
+	}
+	if mem := inst.Exports()[0]; mem.Kind != war.ExternMemory || mem.Type.Min != 1 {
+		t.Errorf("got %+v, expected a memory of 1 page", mem)
+	}
+
+	f, ok := inst.ExportedFunc("f07")
+	if !ok {
+		t.Fatal("f07 not found")
+	}
+	if res, err := r.Call(f); err != nil || res[0] != war.I32(12) {
+		t.Errorf("got %v, %v, expected [i32:12]", res, err)
+	}
+	if _, ok := inst.ExportedFunc("a_global"); ok {
+		t.Error("got a function for a global export")
+	}
+	if _, ok := inst.ExportedFunc("missing"); ok {
+		t.Error("got a function for a missing export")
+	}
+}
+
+func TestDumpIndexSpaces(t *testing.T) {
+	m, err := war.CompileModule([]byte(`(module
+  (type $bin (func (param i32 i32) (result i32)))
+  (import "env" "log" (func $log (param i32)))
+  (import "env" "g" (global $g (mut i32)))
+  (global $h f64 (f64.const 0))
+  (func $add (type $bin) (i32.add (local.get 0) (local.get 1)))
+  (func (export "main") (call $log (i32.const 1)))
+  (memory 1 2)
+  (data $p "abc"))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := m.DumpIndexSpaces(&b); err != nil {
+		t.Fatal(err)
+	}
+	// imports take the first indices of their index space
+	const want = `type:
+  0 $bin func [i32 i32] -> [i32]
+  1 func [i32] -> []
+  2 func [] -> []
+function:
+  0 $log import "env" "log": type 1 [i32] -> []
+  1 $add type 0 [i32 i32] -> [i32]
+  2 type 2 [] -> []
+global:
+  0 $g import "env" "g": (mut i32)
+  1 $h f64
+memory:
+  0 1 2
+data segment:
+  0 $p passive: 3 bytes
+`
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nexpected\n%s", got, want)
+	}
+}
@@ -0,0 +1,44 @@
+package main_test
+
+import (
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+func TestCanonicalNaN(t *testing.T) {
+	src := `(module
+  (func (export "div32") (result f32) (f32.div (f32.const 0) (f32.const 0)))
+  (func (export "add32") (result f32) (f32.add (f32.const -nan:0x200001) (f32.const 1)))
+  (func (export "sqrt64") (result f64) (f64.sqrt (f64.const -1)))
+  (func (export "mul64") (result f64) (f64.mul (f64.const nan:0x4000000000001) (f64.const 2)))
+  (func (export "neg32") (result f32) (f32.neg (f32.const nan:0x200001))))`
+
+	tests := []struct {
+		name string
+		want uint64
+	}{
+		{"div32", 0x7fc00000},
+		{"add32", 0x7fc00000},
+		{"sqrt64", 0x7ff8000000000000},
+		{"mul64", 0x7ff8000000000000},
+		// bitwise operations keep the payload
+		{"neg32", 0xffa00001},
+	}
+
+	r := war.NewRuntime(war.WithCanonicalNaN())
+	if _, err := r.Instantiate([]byte(src)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Invoke(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got[0].Bits() != tt.want {
+				t.Errorf("got %#x, expected %#x", got[0].Bits(), tt.want)
+			}
+		})
+	}
+}
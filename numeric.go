@@ -0,0 +1,206 @@
+package main
+
+import (
+	"math"
+)
+
+// https://webassembly.github.io/spec/core/exec/numerics.html
+
+func fmin32(a, b float32) float32 {
+	switch {
+	case a != a || b != b:
+		return a + b
+	case a == 0 && b == 0:
+		if math.Signbit(float64(a)) {
+			return a
+		}
+		return b
+	case a < b:
+		return a
+	}
+	return b
+}
+
+func fmax32(a, b float32) float32 {
+	switch {
+	case a != a || b != b:
+		return a + b
+	case a == 0 && b == 0:
+		if math.Signbit(float64(a)) {
+			return b
+		}
+		return a
+	case a > b:
+		return a
+	}
+	return b
+}
+
+func fmin64(a, b float64) float64 {
+	switch {
+	case a != a || b != b:
+		return a + b
+	case a == 0 && b == 0:
+		if math.Signbit(a) {
+			return a
+		}
+		return b
+	case a < b:
+		return a
+	}
+	return b
+}
+
+func fmax64(a, b float64) float64 {
+	switch {
+	case a != a || b != b:
+		return a + b
+	case a == 0 && b == 0:
+		if math.Signbit(a) {
+			return b
+		}
+		return a
+	case a > b:
+		return a
+	}
+	return b
+}
+
+const (
+	f32SignBit = 1 << 31
+	f64SignBit = 1 << 63
+
+	// canonical NaNs: positive, quiet, with an empty payload
+	canonNaN32 = 0x7fc00000
+	canonNaN64 = 0x7ff8000000000000
+)
+
+// The sign operations work on the bits, so they never alter a NaN payload.
+
+func fabs32(a uint32) uint32 { return a &^ f32SignBit }
+func fneg32(a uint32) uint32 { return a ^ f32SignBit }
+func fcopysign32(a, b uint32) uint32 {
+	return a&^f32SignBit | b&f32SignBit
+}
+
+func fabs64(a uint64) uint64 { return a &^ f64SignBit }
+func fneg64(a uint64) uint64 { return a ^ f64SignBit }
+func fcopysign64(a, b uint64) uint64 {
+	return a&^f64SignBit | b&f64SignBit
+}
+
+// Rounding of f32 goes through float64, which represents every float32
+// exactly, so the result is exact too.
+func fceil32(a float32) float32    { return float32(math.Ceil(float64(a))) }
+func ffloor32(a float32) float32   { return float32(math.Floor(float64(a))) }
+func ftrunc32(a float32) float32   { return float32(math.Trunc(float64(a))) }
+func fnearest32(a float32) float32 { return float32(math.RoundToEven(float64(a))) }
+func fnearest64(a float64) float64 { return math.RoundToEven(a) }
+
+// Truncation of floats to integers. The returned reason is empty on success
+// and the trap reason otherwise.
+
+func truncS32(f float64) (uint32, string) {
+	if f != f {
+		return 0, trapInvalidConversion
+	}
+	t := math.Trunc(f)
+	if t < math.MinInt32 || t > math.MaxInt32 {
+		return 0, trapIntegerOverflow
+	}
+	return uint32(int32(t)), ""
+}
+
+func truncU32(f float64) (uint32, string) {
+	if f != f {
+		return 0, trapInvalidConversion
+	}
+	t := math.Trunc(f)
+	if t < 0 || t > math.MaxUint32 {
+		return 0, trapIntegerOverflow
+	}
+	return uint32(t), ""
+}
+
+func truncS64(f float64) (uint64, string) {
+	if f != f {
+		return 0, trapInvalidConversion
+	}
+	t := math.Trunc(f)
+	if t < math.MinInt64 || t >= math.MaxInt64 {
+		return 0, trapIntegerOverflow
+	}
+	return uint64(int64(t)), ""
+}
+
+func truncU64(f float64) (uint64, string) {
+	if f != f {
+		return 0, trapInvalidConversion
+	}
+	t := math.Trunc(f)
+	if t < 0 || t >= math.MaxUint64 {
+		return 0, trapIntegerOverflow
+	}
+	return uint64(t), ""
+}
+
+func truncSatS32(f float64) uint32 {
+	switch {
+	case f != f:
+		return 0
+	case f < math.MinInt32:
+		return uint32(math.MaxInt32 + 1)
+	case f > math.MaxInt32:
+		return math.MaxInt32
+	}
+	return uint32(int32(f))
+}
+
+func truncSatU32(f float64) uint32 {
+	switch {
+	case f != f || f < 0:
+		return 0
+	case f > math.MaxUint32:
+		return math.MaxUint32
+	}
+	return uint32(f)
+}
+
+func truncSatS64(f float64) uint64 {
+	switch {
+	case f != f:
+		return 0
+	case f < math.MinInt64:
+		return 1 << 63
+	case f >= math.MaxInt64:
+		return math.MaxInt64
+	}
+	return uint64(int64(f))
+}
+
+func truncSatU64(f float64) uint64 {
+	switch {
+	case f != f || f < 0:
+		return 0
+	case f >= math.MaxUint64:
+		return math.MaxUint64
+	}
+	return uint64(f)
+}
+
+// The unsigned 64-bit conversions halve the value keeping the lost bit as a
+// sticky bit, so the result is rounded once, to nearest even.
+
+func convertU64F32(v uint64) float32 {
+	if v < 1<<63 {
+		return float32(int64(v))
+	}
+	return float32(int64(v>>1|v&1)) * 2
+}
+
+func convertU64F64(v uint64) float64 {
+	if v < 1<<63 {
+		return float64(int64(v))
+	}
+	return float64(int64(v>>1|v&1)) * 2
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/bluescreen10/war/text"
+)
+
+// Profile counts the instructions executed by a runtime.
+type Profile struct {
+	Ops   map[text.Op]uint64 // executions per opcode
+	Funcs map[string]uint64  // instructions executed per function
+}
+
+func newProfile() *Profile {
+	return &Profile{Ops: map[text.Op]uint64{}, Funcs: map[string]uint64{}}
+}
+
+func (p *Profile) count(f *frame, op text.Op) {
+	p.Ops[op]++
+	if f.fn != nil {
+		p.Funcs[f.fn.String()]++
+	}
+}
+
+// OpCount is the number of executions of an opcode.
+type OpCount struct {
+	Op    text.Op
+	Count uint64
+}
+
+// Top returns the n most executed opcodes, most executed first.
+func (p *Profile) Top(n int) []OpCount {
+	counts := make([]OpCount, 0, len(p.Ops))
+	for op, c := range p.Ops {
+		counts = append(counts, OpCount{op, c})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Op < counts[j].Op
+	})
+	if n < len(counts) {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// Reset clears the collected counts.
+func (p *Profile) Reset() {
+	clear(p.Ops)
+	clear(p.Funcs)
+}
+
+// Coverage aggregates the opcodes executed according to several profiles,
+// such as those of the runtimes running a test suite, to find out which
+// instructions none of them executed.
+type Coverage struct {
+	Ops map[text.Op]uint64 // executions per opcode, across the profiles
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{Ops: map[text.Op]uint64{}}
+}
+
+// Add adds the executions counted by p.
+func (c *Coverage) Add(p *Profile) {
+	for op, n := range p.Ops {
+		c.Ops[op] += n
+	}
+}
+
+// Missing returns the instructions never executed, in opcode order.
+func (c *Coverage) Missing() []text.Op {
+	var missing []text.Op
+	for _, op := range text.Instrs() {
+		if c.Ops[op] == 0 {
+			missing = append(missing, op)
+		}
+	}
+	return missing
+}
+
+// Report writes how many instructions were executed out of all of them,
+// followed by the missing ones, one per line.
+func (c *Coverage) Report(w io.Writer) error {
+	all, missing := text.Instrs(), c.Missing()
+	if _, err := fmt.Fprintf(w, "%d of %d instructions executed\n", len(all)-len(missing), len(all)); err != nil {
+		return err
+	}
+	for _, op := range missing {
+		if _, err := fmt.Fprintf(w, "  %s\n", op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main_test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+	"github.com/bluescreen10/war/text"
+)
+
+func TestProfile(t *testing.T) {
+	runtime := war.NewRuntime(war.WithProfiler())
+	_, err := runtime.Instantiate([]byte(`(module
+  (func $sum (export "sum") (param $n i32) (result i32) (local $acc i32)
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $n)))
+        (local.set $acc (i32.add (local.get $acc) (local.get $n)))
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        (br $next)))
+    (local.get $acc)))`))
+	if err != nil {
+		t.Fatalf("instantiate: %v", err)
+	}
+
+	if _, err := runtime.Invoke("sum", war.I32(10)); err != nil {
+		t.Fatalf("invoke: %v", err)
+	}
+
+	p := runtime.Profile()
+	expected := map[text.Op]uint64{
+		text.OpLocalGet: 11 + 3*10 + 1,
+		text.OpLocalSet: 2 * 10,
+		text.OpI32Eqz:   11,
+		text.OpBrIf:     11,
+		text.OpI32Add:   10,
+		text.OpI32Sub:   10,
+		text.OpBr:       10,
+		text.OpLoop:     1,
+	}
+	for op, count := range expected {
+		if p.Ops[op] != count {
+			t.Errorf("%s: got %d expected %d", op, p.Ops[op], count)
+		}
+	}
+
+	if top := p.Top(1); top[0].Op != text.OpLocalGet {
+		t.Errorf("top: got %v", top)
+	}
+	if p.Funcs["sum"] == 0 {
+		t.Errorf("expected instructions counted for sum, got %v", p.Funcs)
+	}
+}
+
+func TestProfileDisabled(t *testing.T) {
+	if war.NewRuntime().Profile() != nil {
+		t.Errorf("expected no profile")
+	}
+}
+
+func TestTracer(t *testing.T) {
+	type step struct {
+		pc    int
+		op    text.Op
+		stack string
+	}
+	for _, opts := range [][]war.RuntimeOption{nil, {war.WithTreeWalker()}} {
+		var got []step
+		tracer := war.WithTracer(func(pc int, op text.Op, stack []war.Value) {
+			got = append(got, step{pc, op, fmt.Sprint(stack)})
+		})
+		r := war.NewRuntime(append(opts, tracer)...)
+		_, err := r.Instantiate([]byte(`(module
+  (func (export "inc") (param i32) (result i32)
+    (i32.add (local.get 0) (i32.const 1))))`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Invoke("inc", war.I32(41)); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []step{
+			{0, text.OpLocalGet, "[]"},
+			{1, text.OpI32Const, "[i32:41]"},
+			{2, text.OpI32Add, "[i32:41 i32:1]"},
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("got steps %v, expected %v", got, want)
+		}
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	coverage := war.NewCoverage()
+	for _, src := range []string{
+		`(module (func (export "f") (result i32) (i32.add (i32.const 1) (i32.const 2))))`,
+		`(module (func (export "f") (result i32) (i32.sub (i32.const 1) (i32.const 2))))`,
+	} {
+		r := war.NewRuntime(war.WithProfiler())
+		if _, err := r.Instantiate([]byte(src)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Invoke("f"); err != nil {
+			t.Fatal(err)
+		}
+		coverage.Add(r.Profile())
+	}
+
+	if got := coverage.Ops[text.OpI32Const]; got != 4 {
+		t.Errorf("i32.const: got %d executions, expected 4", got)
+	}
+	missing := coverage.Missing()
+	for _, op := range []text.Op{text.OpI32Const, text.OpI32Add, text.OpI32Sub} {
+		if slices.Contains(missing, op) {
+			t.Errorf("%s reported missing", op)
+		}
+	}
+	if all := text.Instrs(); len(missing) != len(all)-3 || !slices.Contains(missing, text.OpI32Mul) {
+		t.Errorf("got %d missing of %d, expected all but 3", len(missing), len(all))
+	}
+
+	var report strings.Builder
+	if err := coverage.Report(&report); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("3 of %d instructions executed\n  unreachable\n  nop\n", len(text.Instrs()))
+	if got := report.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("got report %q, expected it to start with %q", got, want)
+	}
+}
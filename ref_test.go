@@ -0,0 +1,135 @@
+package main_test
+
+import (
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+const refs = `(module
+  (table $t 2 funcref)
+  (global $g (mut funcref) (ref.null func))
+  (func $answer (result i32) (i32.const 42))
+  (func (export "store")
+    (table.set $t (i32.const 1) (ref.func $answer)))
+  (func (export "get") (param i32) (result funcref)
+    (table.get $t (local.get 0)))
+  (func (export "pick") (param i32) (result funcref)
+    (select (result funcref) (ref.func $answer) (ref.null func) (local.get 0)))
+  (func (export "global") (result funcref)
+    (global.set $g (table.get $t (i32.const 1)))
+    (global.get $g)))`
+
+func TestFuncRefIdentity(t *testing.T) {
+	r := war.NewRuntime()
+	if _, err := r.Instantiate([]byte(refs)); err != nil {
+		t.Fatal(err)
+	}
+	invoke := func(name string, args ...war.Value) war.Value {
+		t.Helper()
+		got, err := r.Invoke(name, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got[0]
+	}
+
+	if _, err := r.Invoke("store"); err != nil {
+		t.Fatal(err)
+	}
+	stored := invoke("get", war.I32(1))
+	if picked := invoke("pick", war.I32(1)); stored != picked {
+		t.Errorf("table: got %v, expected %v", stored, picked)
+	}
+	if global := invoke("global"); global != stored {
+		t.Errorf("global: got %v, expected %v", global, stored)
+	}
+	if null := invoke("get", war.I32(0)); !null.IsNull() || null != invoke("pick", war.I32(0)) {
+		t.Errorf("null: got %v", null)
+	}
+
+	got, err := r.Call(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != war.I32(42) {
+		t.Errorf("call: got %v, expected 42", got[0])
+	}
+	if _, err := r.Call(invoke("get", war.I32(0))); err == nil {
+		t.Error("expected an error calling a null reference")
+	}
+}
+
+func TestTypedSelect(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"untyped reference", `(module (func (result funcref)
+  (select (ref.null func) (ref.null func) (i32.const 1))))`},
+		{"wrong type", `(module (func (result funcref)
+  (select (result funcref) (ref.null extern) (ref.null func) (i32.const 1))))`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := war.NewRuntime().Instantiate([]byte(tt.src)); err == nil {
+				t.Error("expected a type mismatch")
+			}
+		})
+	}
+}
+
+func TestExternRef(t *testing.T) {
+	r := war.NewRuntime()
+	_, err := r.Instantiate([]byte(`(module
+  (table $t 1 externref)
+  (global $g (mut externref) (ref.null extern))
+  (func (export "id") (param externref) (result externref)
+    (local $l externref)
+    (local.set $l (local.get 0))
+    (local.get $l))
+  (func (export "global") (param externref) (result externref)
+    (global.set $g (local.get 0))
+    (global.get $g))
+  (func (export "table") (param externref) (result externref)
+    (table.set $t (i32.const 0) (local.get 0))
+    (table.get $t (i32.const 0)))
+  (func (export "is_null") (param externref) (result i32)
+    (ref.is_null (local.get 0))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type point struct{ x, y int }
+	p := &point{1, 2}
+	for _, name := range []string{"id", "global", "table"} {
+		got, err := r.Invoke(name, war.ExternRef(p))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got[0].Type() != war.ValueTypeExternRef || got[0].Extern() != p {
+			t.Errorf("%s: got %v, expected %p", name, got[0], p)
+		}
+	}
+
+	tests := []struct {
+		arg      war.Value
+		expected int32
+	}{
+		{war.ExternRef(p), 0},
+		{war.ExternRef(nil), 1},
+	}
+	for _, tt := range tests {
+		got, err := r.Invoke("is_null", tt.arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0] != war.I32(tt.expected) {
+			t.Errorf("is_null(%v): got %v, expected %d", tt.arg, got[0], tt.expected)
+		}
+	}
+
+	if _, err := r.Invoke("id", war.I32(0)); err == nil {
+		t.Error("expected an error passing an i32 as externref")
+	}
+}
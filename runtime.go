@@ -12,12 +12,30 @@ type FuncMap map[string]func(_, _ any)
 
 type Runtime struct {
 	globalFuncs FuncMap
+	current     *Instance
+	profile     *Profile
+	canonNaN    bool
+	treeWalk    bool
+	fold        bool
+	features    Feature
+
+	// limits on the size of memories and tables, 0 if none
+	maxMemoryPages uint32
+	maxTableElems  uint32
+
+	memoryGrowHook func(old, new uint32) bool
+	trapGrow       bool
+
+	tracer Tracer
+
+	// instances created and registered for import
+	store *Store
 }
 
 type RuntimeOption func(*Runtime)
 
 func NewRuntime(opts ...RuntimeOption) *Runtime {
-	r := &Runtime{}
+	r := &Runtime{store: NewStore(), features: allFeatures}
 	for _, o := range opts {
 		o(r)
 	}
@@ -30,22 +48,267 @@ func WithFuncs(funcs FuncMap) RuntimeOption {
 	}
 }
 
+// CompileModule parses and validates the module in src without
+// instantiating it, so its imports and exports can be inspected.
+func CompileModule(src []byte) (*Module, error) {
+	n, err := parseModule(src)
+	if err != nil {
+		return nil, err
+	}
+	return compileModule(n)
+}
+
+// parseModule parses src, which must hold a single module.
+func parseModule(src []byte) (*text.Node, error) {
+	p := text.NewParser(src)
+	if err := p.Parse(); err != nil {
+		return nil, fmt.Errorf("parsing error: %v", err)
+	}
+
+	root := p.Root()
+	if len(root.Args) != 1 || root.Args[0].Op != text.OpModule {
+		return nil, fmt.Errorf("expected a single module")
+	}
+	return root.Args[0], nil
+}
+
+// compile compiles a module with the options of the runtime. The module is
+// validated as written, since folding and dropping dead code can turn an
+// invalid module into a valid one, and only then optimized.
+func (r *Runtime) compile(n *text.Node) (*Module, error) {
+	m, err := compileModule(n)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkFeatures(r.features); err != nil {
+		return nil, err
+	}
+	if r.fold {
+		return compileModule(text.Optimize(n))
+	}
+	return m, nil
+}
+
+// Instantiate parses and instantiates the module in src, which becomes the
+// current module of the runtime.
+func (r *Runtime) Instantiate(src []byte) (*Instance, error) {
+	n, err := parseModule(src)
+	if err != nil {
+		return nil, err
+	}
+	m, err := r.compile(n)
+	if err != nil {
+		return nil, err
+	}
+
+	inst, err := r.instantiate(r.store, m)
+	if err != nil {
+		return nil, err
+	}
+	r.current = inst
+	return inst, nil
+}
+
+// InstantiateModule instantiates a module compiled with CompileModule,
+// which becomes the current module of the runtime. A module can be
+// instantiated any number of times, by any runtime, without parsing it
+// again.
+func (r *Runtime) InstantiateModule(m *Module) (*Instance, error) {
+	if err := m.checkFeatures(r.features); err != nil {
+		return nil, err
+	}
+	inst, err := r.instantiate(r.store, m)
+	if err != nil {
+		return nil, err
+	}
+	r.current = inst
+	return inst, nil
+}
+
+// Register makes the exports of inst available for import under the module
+// name, in the store of the runtime.
+func (r *Runtime) Register(name string, inst *Instance) {
+	r.store.Register(name, inst)
+}
+
+// Store returns the store of the runtime, holding the instances it created
+// and the registered ones.
+func (r *Runtime) Store() *Store {
+	return r.store
+}
+
+// WithStore makes the runtime create and link its instances in s instead of
+// a store of its own, so that runtimes sharing s import from each other.
+func WithStore(s *Store) RuntimeOption {
+	return func(r *Runtime) {
+		r.store = s
+	}
+}
+
+// Call calls the function a funcref refers to, which may belong to any
+// instance of the runtime.
+func (r *Runtime) Call(ref Value, args ...Value) ([]Value, error) {
+	if ref.typ != ValueTypeFuncRef {
+		return nil, fmt.Errorf("expected funcref, got %s", ref.typ)
+	}
+	if ref.ref == nil {
+		return nil, fmt.Errorf("null function reference")
+	}
+	f := ref.ref.(*funcInst)
+	if err := f.checkArgs(args); err != nil {
+		return nil, fmt.Errorf("%s: %w", f, err)
+	}
+	return newMachine(r).invoke(f, args)
+}
+
+// Reset forgets the current module and removes from the store the
+// instances of the runtime and the modules registered under their names, so
+// it can run an unrelated script. A store given through WithStore is kept,
+// along with what other runtimes sharing it put there. Instances never
+// share state unless one imports from another, so instances created before
+// a reset keep working. Options and the profile, if enabled, are kept.
+func (r *Runtime) Reset() {
+	r.current = nil
+	r.store.forget(r)
+}
+
+// Invoke calls the exported function name of the current module.
+func (r *Runtime) Invoke(name string, args ...Value) ([]Value, error) {
+	if r.current == nil {
+		return nil, fmt.Errorf("no module instantiated")
+	}
+	return r.current.Invoke(name, args...)
+}
+
+// WithProfiler enables counting the instructions executed by the runtime.
+// The counts are available through Profile.
+func WithProfiler() RuntimeOption {
+	return func(r *Runtime) {
+		r.profile = newProfile()
+	}
+}
+
+// WithCanonicalNaN makes every NaN produced by a float arithmetic operation
+// the canonical NaN, so results are the same bit for bit on every platform.
+// The spec allows this, but it differs from implementations passing NaN
+// payloads through: sign and payload of NaN operands are lost. The bitwise
+// operations (abs, neg, copysign, reinterpret) are not affected.
+func WithCanonicalNaN() RuntimeOption {
+	return func(r *Runtime) {
+		r.canonNaN = true
+	}
+}
+
+// WithTreeWalker makes the runtime interpret the instruction trees of
+// functions instead of their bytecode. It's slower and kept as a reference
+// for testing the bytecode interpreter against.
+func WithTreeWalker() RuntimeOption {
+	return func(r *Runtime) {
+		r.treeWalk = true
+	}
+}
+
+// WithConstantFolding optimizes the modules compiled by the runtime with
+// text.Optimize, folding arithmetic on constants and removing dead code.
+func WithConstantFolding() RuntimeOption {
+	return func(r *Runtime) {
+		r.fold = true
+	}
+}
+
+// WithMaxMemoryPages limits the memories created by the runtime to n pages,
+// whatever maximum they declare: memory.grow fails past the limit and
+// modules whose memories start larger fail to instantiate.
+func WithMaxMemoryPages(n uint32) RuntimeOption {
+	return func(r *Runtime) {
+		r.maxMemoryPages = n
+	}
+}
+
+// WithMaxTableElems limits the tables created by the runtime to n elements,
+// whatever maximum they declare: table.grow fails past the limit and
+// modules whose tables start larger fail to instantiate.
+func WithMaxTableElems(n uint32) RuntimeOption {
+	return func(r *Runtime) {
+		r.maxTableElems = n
+	}
+}
+
+// WithMemoryGrowHook calls hook with the old and new size in pages before
+// a memory created by the runtime grows, once the growth is within its
+// limits. Returning false denies the growth, memory.grow returning -1.
+func WithMemoryGrowHook(hook func(old, new uint32) bool) RuntimeOption {
+	return func(r *Runtime) {
+		r.memoryGrowHook = hook
+	}
+}
+
+// WithTrapOnGrowFailure makes memory.grow and table.grow trap when they
+// fail, whether past the maximum, a limit of the runtime or denied by the
+// grow hook, instead of returning -1.
+//
+// This departs from the spec, where a failed growth is an ordinary result
+// the module can handle, and is meant for embedders running modules that
+// never check it.
+func WithTrapOnGrowFailure() RuntimeOption {
+	return func(r *Runtime) {
+		r.trapGrow = true
+	}
+}
+
+// Tracer is called before each instruction executes with its offset within
+// its function, its opcode and the operand stack, whose top is last. The
+// stack belongs to the runtime and must not be kept or modified.
+type Tracer func(pc int, op text.Op, stack []Value)
+
+// WithTracer calls t before each instruction the runtime executes, for
+// logging execution step by step or building a debugger on top of it.
+func WithTracer(t Tracer) RuntimeOption {
+	return func(r *Runtime) {
+		r.tracer = t
+	}
+}
+
+// Profile returns the instruction counts collected so far, or nil if the
+// profiler is not enabled.
+func (r *Runtime) Profile() *Profile {
+	return r.profile
+}
+
+// ExecFile runs the script at path, written in the text format or, for a
+// .json file, converted by wast2json. The script links against the modules
+// registered in the runtime, but those it registers are its own, so that
+// scripts run one after the other don't see each other's.
+// The script keeps running past a failed assertion, and the first failure
+// is returned once it ends.
 func (r *Runtime) ExecFile(path string) error {
+	result, err := r.ExecFileResult(path)
+	if err != nil {
+		return err
+	}
+	if len(result.Failures) > 0 {
+		return result.Failures[0]
+	}
+	return nil
+}
+
+// ExecFileResult runs the script at path like ExecFile, except that the
+// outcomes of all the assertions are returned. An error is returned only
+// when another command fails, which stops the script.
+func (r *Runtime) ExecFileResult(path string) (*ScriptResult, error) {
+	result := &ScriptResult{}
+	var err error
 	switch filepath.Ext(path) {
 	case ".wat", ".wast":
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("error opening file: %s", path)
-		}
-
-		p := text.NewParser(data)
-
-		if err := p.Parse(); err != nil {
-			return fmt.Errorf("parsing error: %v", err)
+		var data []byte
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("error opening file: %s", path)
 		}
-		return nil
-		//return t.Exec()
+		err = r.execScript(path, data, r.store.fork(), result)
+	case ".json":
+		err = r.execManifest(path, r.store.fork(), result)
 	default:
-		return ErrNotImplemented
+		return nil, ErrNotImplemented
 	}
+	return result, err
 }
@@ -0,0 +1,391 @@
+package main_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+const counter = `(module
+  (memory 1)
+  (global $n (mut i32) (i32.const 0))
+  (func (export "bump") (result i32)
+    (global.set $n (i32.add (global.get $n) (i32.const 1)))
+    (i32.store (i32.const 0) (i32.add (i32.load (i32.const 0)) (i32.const 10)))
+    (i32.add (global.get $n) (i32.load (i32.const 0)))))`
+
+func TestInstancesIndependent(t *testing.T) {
+	r := war.NewRuntime()
+	for i := range 2 {
+		if _, err := r.Instantiate([]byte(counter)); err != nil {
+			t.Fatal(err)
+		}
+		for want := int32(11); want <= 22; want += 11 {
+			got, err := r.Invoke("bump")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got[0] != war.I32(want) {
+				t.Errorf("instance %d: got %v, expected %d", i, got[0], want)
+			}
+		}
+	}
+}
+
+func TestConcurrentInvoke(t *testing.T) {
+	inst, err := war.NewRuntime().Instantiate([]byte(`(module
+  (func $fib (export "fib") (param i32) (result i32)
+    (if (result i32) (i32.lt_u (local.get 0) (i32.const 2))
+      (then (local.get 0))
+      (else (i32.add
+        (call $fib (i32.sub (local.get 0) (i32.const 1)))
+        (call $fib (i32.sub (local.get 0) (i32.const 2))))))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for n := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := inst.Invoke("fib", war.I32(int32(10+n)))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			a, b := 0, 1
+			for range 10 + n {
+				a, b = b, a+b
+			}
+			if got[0] != war.I32(int32(a)) {
+				t.Errorf("fib(%d): got %v, expected %d", 10+n, got[0], a)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClone(t *testing.T) {
+	inst, err := war.NewRuntime().Instantiate([]byte(`(module
+  (memory 1)
+  (global $n (mut i32) (i32.const 0))
+  (table 1 funcref)
+  (elem (i32.const 0) $get)
+  (func $get (result i32) (global.get $n))
+  (func (export "bump") (result i32)
+    (global.set $n (i32.add (global.get $n) (i32.const 1)))
+    (i32.store (i32.const 0) (i32.add (i32.load (i32.const 0)) (i32.const 10)))
+    (i32.add (call_indirect (result i32) (i32.const 0)) (i32.load (i32.const 0)))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inst.Invoke("bump"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the clones start from the state of inst, then go their own ways
+	clones := make([]*war.Instance, 4)
+	for i := range clones {
+		clones[i] = inst.Clone()
+	}
+	var wg sync.WaitGroup
+	for i, c := range clones {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range i {
+				if _, err := c.Invoke("bump"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, c := range append(clones, inst) {
+		got, err := c.Invoke("bump")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// one bump before cloning, i in the clone and this one
+		n := int32(i + 2)
+		if i == len(clones) {
+			n = 2
+		}
+		if want := war.I32(n + 10*n); got[0] != want {
+			t.Errorf("instance %d: got %v, expected %v", i, got[0], want)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	r := war.NewRuntime()
+	if err := r.Exec([]byte(lib)); err != nil {
+		t.Fatal(err)
+	}
+	r.Reset()
+
+	if _, err := r.Invoke("add", war.I32(1), war.I32(2)); err == nil {
+		t.Error("expected an error invoking without a current module")
+	}
+	_, err := r.Instantiate([]byte(`(module (import "lib" "add" (func (param i32 i32) (result i32))))`))
+	var link *war.LinkError
+	if !errors.As(err, &link) || link.Reason != "unknown import" {
+		t.Errorf("got error %v, expected unknown import", err)
+	}
+}
+
+func TestInstantiateModule(t *testing.T) {
+	m, err := war.CompileModule([]byte(counter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := war.NewRuntime()
+	a, err := r.InstantiateModule(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.InstantiateModule(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an instance of another runtime
+	c, err := war.NewRuntime().InstantiateModule(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each instance has its own global and memory
+	for _, step := range []struct {
+		inst *war.Instance
+		want int32
+	}{{a, 11}, {a, 22}, {b, 11}, {a, 33}, {c, 11}, {b, 22}} {
+		got, err := step.inst.Invoke("bump")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0] != war.I32(step.want) {
+			t.Errorf("got %v, expected %d", got[0], step.want)
+		}
+	}
+
+	// the last instance is the current one
+	if got, err := r.Invoke("bump"); err != nil || got[0] != war.I32(33) {
+		t.Errorf("got %v, %v, expected the second instance to be current", got, err)
+	}
+
+	simd, err := war.CompileModule([]byte(`(module (func (result v128) (v128.const i64x2 0 0)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := war.NewRuntime(war.WithFeatures()).InstantiateModule(simd); err == nil {
+		t.Error("expected an error instantiating a SIMD module without SIMD support")
+	}
+}
+
+func TestSharedImports(t *testing.T) {
+	r := war.NewRuntime()
+	a, err := r.Instantiate([]byte(`(module
+  (memory (export "mem") 1)
+  (table (export "tab") 2 funcref)
+  (global (export "g") (mut i32) (i32.const 0))
+  (func (export "load") (param i32) (result i32) (i32.load (local.get 0)))
+  (func (export "size") (result i32) (memory.size))
+  (func (export "get") (result i32) (global.get 0))
+  (func (export "is_null") (param i32) (result i32) (ref.is_null (table.get 0 (local.get 0)))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Register("a", a)
+
+	b, err := r.Instantiate([]byte(`(module
+  (import "a" "mem" (memory 1))
+  (import "a" "tab" (table 2 funcref))
+  (import "a" "g" (global (mut i32)))
+  (func $f)
+  (elem declare func $f)
+  (func (export "write")
+    (i32.store (i32.const 8) (i32.const 42))
+    (drop (memory.grow (i32.const 1)))
+    (global.set 0 (i32.const 7))
+    (table.set 0 (i32.const 1) (ref.func $f))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Invoke("write"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a sees what b did to the memory, the global and the table
+	for _, tt := range []struct {
+		name string
+		args []war.Value
+		want int32
+	}{
+		{"load", []war.Value{war.I32(8)}, 42},
+		{"size", nil, 2},
+		{"get", nil, 7},
+		{"is_null", []war.Value{war.I32(1)}, 0},
+		{"is_null", []war.Value{war.I32(0)}, 1},
+	} {
+		got, err := a.Invoke(tt.name, tt.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0] != war.I32(tt.want) {
+			t.Errorf("%s%v: got %v, expected %d", tt.name, tt.args, got[0], tt.want)
+		}
+	}
+
+	memA, _ := a.Memory("mem")
+	g, _ := a.Global("g")
+	if memA.Size() != 2 || g.Get() != war.I32(7) {
+		t.Errorf("got %d pages and global %v, expected 2 pages and 7", memA.Size(), g.Get())
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := war.NewStore()
+	r1 := war.NewRuntime(war.WithStore(store))
+	lib, err := r1.Instantiate([]byte(`(module
+  (func (export "add") (param i32 i32) (result i32)
+    (i32.add (local.get 0) (local.get 1))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Register("lib", lib)
+
+	// another runtime in the store links against what the first registered
+	r2 := war.NewRuntime(war.WithStore(store))
+	inst, err := r2.Instantiate([]byte(`(module
+  (import "lib" "add" (func $add (param i32 i32) (result i32)))
+  (func (export "inc") (param i32) (result i32)
+    (call $add (local.get 0) (i32.const 1))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := inst.Invoke("inc", war.I32(41))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != war.I32(42) {
+		t.Errorf("got %v, expected [i32:42]", got)
+	}
+
+	if got := store.Instances(); len(got) != 2 || got[0] != lib || got[1] != inst {
+		t.Errorf("got instances %v, expected lib and inst", got)
+	}
+	if m, ok := store.Module("lib"); !ok || m != lib {
+		t.Errorf("lib: got %v, %t", m, ok)
+	}
+	if _, err := war.NewRuntime().Instantiate([]byte(`(module (import "lib" "add" (func)))`)); err == nil {
+		t.Error("expected a runtime with a store of its own not to see lib")
+	}
+
+	// resetting the second runtime leaves the store and what the first put
+	// there in place
+	r2.Reset()
+	if r2.Store() != store {
+		t.Error("got another store after Reset")
+	}
+	if got := store.Instances(); len(got) != 1 || got[0] != lib {
+		t.Errorf("got instances %v after Reset, expected lib", got)
+	}
+	if _, err := r2.Instantiate([]byte(`(module (import "lib" "add" (func (param i32 i32) (result i32))))`)); err != nil {
+		t.Errorf("linking against lib after Reset: %v", err)
+	}
+	r1.Reset()
+	if _, ok := store.Module("lib"); ok {
+		t.Error("lib is still registered after the runtime that created it was reset")
+	}
+}
+
+func TestExecFileStore(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.wast": lib + `(module (import "env" "one" (func (result i32))))`,
+		// the module a.wast registered is not there
+		"b.wast": `(assert_unlinkable (module (import "lib" "add" (func (param i32 i32) (result i32))))
+  "unknown import")`,
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := war.NewRuntime()
+	r.RegisterHost("env", map[string]war.HostFunc{
+		"one": {Results: []war.ValueType{war.ValueTypeI32}, Fn: func([]war.Value) ([]war.Value, error) {
+			return []war.Value{war.I32(1)}, nil
+		}},
+	})
+	for _, name := range []string{"a.wast", "b.wast"} {
+		if err := r.ExecFile(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, ok := r.Store().Module("lib"); ok {
+		t.Error("a.wast registered lib in the store of the runtime")
+	}
+}
+
+func TestExecFileResult(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mixed.wast")
+	src := `(module
+  (func (export "one") (result i32) (i32.const 1))
+  (func (export "boom") unreachable))
+(assert_return (invoke "one") (i32.const 1))
+(assert_return (invoke "one") (i32.const 2))
+(assert_trap (invoke "boom") "unreachable")
+  (assert_return (invoke "missing"))
+(assert_trap (invoke "one") "unreachable")
+(assert_return (invoke "one") (i32.const 1))`
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the traps are left to the function
+	r := war.NewRuntime(war.WithFuncs(war.FuncMap{"assert_trap": func(_, _ any) {}}))
+	res, err := r.ExecFileResult(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Passed != 2 || res.Failed != 2 || res.Reported != 2 || res.Skipped != 0 {
+		t.Errorf("got %d passed, %d failed, %d reported and %d skipped, expected 2, 2, 2 and 0",
+			res.Passed, res.Failed, res.Reported, res.Skipped)
+	}
+	want := []string{
+		path + `:5:1: assert_return: got "[i32:1]", expected "[i32:2]"`,
+		path + `:7:3: unknown function "missing"`,
+	}
+	var got []string
+	for _, f := range res.Failures {
+		got = append(got, f.Error())
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got failures %q, expected %q", got, want)
+	}
+
+	// ExecFile fails with the first of them
+	if err := r.ExecFile(path); err == nil || err.Error() != want[0] {
+		t.Errorf("got error %v, expected %q", err, want[0])
+	}
+
+	// other commands stop the script
+	if err := os.WriteFile(path, []byte(`(module (func (result i32)))
+(assert_return (invoke "one") (i32.const 1))`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ExecFileResult(path); err == nil {
+		t.Error("expected an invalid module to fail the script")
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bluescreen10/war/text"
+)
+
+// script holds the state of a running script.
+type script struct {
+	rt *Runtime
+	// where the modules of the script are instantiated and registered
+	store *Store
+
+	// instances of the modules defined with an id
+	instances map[string]*Instance
+
+	// the outcomes of the assertions when the script goes on after a
+	// failed one, nil when it stops
+	result *ScriptResult
+}
+
+// ScriptResult is the outcome of the assertions of a script run by
+// ExecFileResult.
+type ScriptResult struct {
+	Passed int
+	Failed int
+	// reported to a function registered through WithFuncs, which decides
+	// what they mean
+	Reported int
+	// not evaluated, as the runtime doesn't support them
+	Skipped  int
+	Failures []*ScriptFailure
+}
+
+// ScriptFailure is a failed assertion of a script.
+type ScriptFailure struct {
+	File string // the source of the script
+	Line int
+	Col  int // zero for manifests, which only record lines
+	Err  error
+}
+
+func (f *ScriptFailure) Error() string {
+	if f.Col == 0 {
+		return fmt.Sprintf("%s:%d: %v", f.File, f.Line, f.Err)
+	}
+	return fmt.Sprintf("%s:%d:%d: %v", f.File, f.Line, f.Col, f.Err)
+}
+
+func (f *ScriptFailure) Unwrap() error {
+	return f.Err
+}
+
+// fail records a failed assertion, for the script to go on.
+func (s *script) fail(f *ScriptFailure) {
+	s.result.Failed++
+	s.result.Failures = append(s.result.Failures, f)
+}
+
+// Exec runs the commands of a script. Modules are instantiated in turn, the
+// last one becoming the current module of the runtime, and assertions are
+// checked. An assertion is reported to the function registered with its
+// name through WithFuncs if there is one, and fails the script otherwise.
+// Modules are registered in the store of the runtime.
+func (r *Runtime) Exec(src []byte) error {
+	return r.exec(src, r.store)
+}
+
+// exec runs the commands of a script, instantiating its modules in store.
+func (r *Runtime) exec(src []byte, store *Store) error {
+	return r.execScript("", src, store, nil)
+}
+
+// execScript runs the commands of the script read from path. When result
+// is given, failed assertions are recorded in it rather than stopping the
+// script.
+func (r *Runtime) execScript(path string, src []byte, store *Store, result *ScriptResult) error {
+	p := text.NewParser(src)
+	if err := p.Parse(); err != nil {
+		return fmt.Errorf("parsing error: %v", err)
+	}
+
+	s := &script{rt: r, store: store, instances: map[string]*Instance{}, result: result}
+	// the nodes of the commands, in the same order
+	nodes := p.Root().Args
+	for i, cmd := range p.Commands() {
+		err := s.exec(cmd)
+		if err == nil {
+			continue
+		}
+		if !isAssertion(cmd) || result == nil {
+			return err
+		}
+		pos := nodes[i].Span.Start
+		s.fail(&ScriptFailure{File: path, Line: pos.Line, Col: pos.Col, Err: err})
+	}
+	return nil
+}
+
+func isAssertion(cmd text.Command) bool {
+	switch cmd.(type) {
+	case *text.AssertReturnCommand, *text.AssertTrapCommand, *text.AssertUnlinkableCommand,
+		*text.AssertInvalidCommand:
+		return true
+	}
+	return false
+}
+
+func (s *script) exec(cmd text.Command) error {
+	switch cmd := cmd.(type) {
+	case *text.ModuleCommand:
+		return s.module(cmd.Module)
+	case *text.RegisterCommand:
+		return s.register(cmd)
+	case *text.InvokeCommand:
+		_, err := s.invoke(cmd)
+		return err
+	case *text.AssertReturnCommand:
+		return s.assertReturn(cmd)
+	case *text.AssertTrapCommand:
+		return s.assertTrap(cmd)
+	case *text.AssertUnlinkableCommand:
+		return s.assertUnlinkable(cmd)
+	case *text.AssertInvalidCommand:
+		return s.assertInvalid(cmd)
+	default:
+		return fmt.Errorf("unexpected %T command", cmd)
+	}
+}
+
+// module instantiates a module, which becomes the current one.
+func (s *script) module(n *text.Node) error {
+	m, err := s.rt.compile(n)
+	if err != nil {
+		return err
+	}
+	inst, err := s.rt.instantiate(s.store, m)
+	if err != nil {
+		return err
+	}
+	s.rt.current = inst
+	if n.Meta != "" {
+		s.instances[n.Meta] = inst
+	}
+	return nil
+}
+
+// instance returns the instance of the module with the given id, or the
+// current one.
+func (s *script) instance(cmd, id string) (*Instance, error) {
+	inst := s.rt.current
+	if id != "" {
+		var ok bool
+		if inst, ok = s.instances[id]; !ok {
+			return nil, fmt.Errorf("%s: unknown module %s", cmd, id)
+		}
+	}
+	if inst == nil {
+		return nil, fmt.Errorf("%s: no module instantiated", cmd)
+	}
+	return inst, nil
+}
+
+func (s *script) register(cmd *text.RegisterCommand) error {
+	inst, err := s.instance("register", cmd.Module)
+	if err != nil {
+		return err
+	}
+	s.store.Register(cmd.Name, inst)
+	return nil
+}
+
+func (s *script) invoke(cmd *text.InvokeCommand) ([]Value, error) {
+	inst, err := s.instance("invoke", cmd.Module)
+	if err != nil {
+		return nil, err
+	}
+	args, err := scriptValues(cmd.Args)
+	if err != nil {
+		return nil, fmt.Errorf("invoke %q: %w", cmd.Name, err)
+	}
+	return inst.Invoke(cmd.Name, args...)
+}
+
+// assertReturn runs the invocation of the assertion and compares all of its
+// results with the expected ones.
+func (s *script) assertReturn(cmd *text.AssertReturnCommand) error {
+	got, err := s.invoke(cmd.Invoke)
+	if err != nil {
+		return err
+	}
+	// a missing or extra result fails the script, before any value is
+	// compared
+	if len(got) != len(cmd.Results) {
+		return fmt.Errorf("assert_return %q: got %d results, expected %d", cmd.Invoke.Name, len(got), len(cmd.Results))
+	}
+	want := make([]Value, len(got))
+	for i, n := range cmd.Results {
+		if want[i], err = scriptResult(n, got[i]); err != nil {
+			return fmt.Errorf("assert_return: %w", err)
+		}
+	}
+	return s.assertResults(got, want)
+}
+
+// assertResults reports the outcome of assert_return, whose results must
+// have the types and the bits of the expected ones. Hooks get them as
+// resultString formats them.
+func (s *script) assertResults(got, want []Value) error {
+	g, w := resultString(got), resultString(want)
+	if _, ok := s.rt.globalFuncs["assert_return"]; !ok && !sameResults(got, want) {
+		return fmt.Errorf("assert_return: got %q, expected %q", g, w)
+	}
+	return s.assert("assert_return", g, w)
+}
+
+// sameResults reports whether the values have the same types and bits.
+// Floats compare bit for bit, so that assert_return tells NaNs apart by
+// their sign and payload as it does zeros of either sign, and references
+// by what they refer to.
+func sameResults(got, want []Value) bool {
+	return slices.EqualFunc(got, want, func(g, w Value) bool {
+		return g.typ == w.typ && g.bits == w.bits && g.hi == w.hi && g.ref == w.ref
+	})
+}
+
+// resultString formats values as fmt.Sprint does, but with the sign and
+// payload of NaNs and the bits of floats, which are what assert_return
+// compares.
+func resultString(values []Value) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		var sign string
+		switch f := v.F64(); {
+		case v.typ == ValueTypeF32 && v.F32() != v.F32():
+			if v.bits&(1<<31) != 0 {
+				sign = "-"
+			}
+			s[i] = fmt.Sprintf("f32:%snan:%#x (0x%08x)", sign, v.bits&(1<<23-1), v.bits)
+		case v.typ == ValueTypeF64 && f != f:
+			if v.bits&(1<<63) != 0 {
+				sign = "-"
+			}
+			s[i] = fmt.Sprintf("f64:%snan:%#x (0x%016x)", sign, v.bits&(1<<52-1), v.bits)
+		case v.typ == ValueTypeF32:
+			s[i] = fmt.Sprintf("%v (0x%08x)", v, v.bits)
+		case v.typ == ValueTypeF64:
+			s[i] = fmt.Sprintf("%v (0x%016x)", v, v.bits)
+		default:
+			s[i] = v.String()
+		}
+	}
+	return fmt.Sprint(s)
+}
+
+// assertTrap runs the action of the assertion, which must trap. Errors
+// other than traps fail the script.
+func (s *script) assertTrap(cmd *text.AssertTrapCommand) error {
+	var err error
+	if cmd.Module != nil {
+		err = s.module(cmd.Module)
+	} else {
+		_, err = s.invoke(cmd.Invoke)
+	}
+	return s.assertTrapped("assert_trap", err, cmd.Reason)
+}
+
+// assertTrapped reports whether err is a trap for the given reason. As in
+// the reference interpreter, the reason only has to start the one of the
+// trap, which can add some context to it. Errors other than traps fail the
+// script.
+func (s *script) assertTrapped(name string, err error, reason string) error {
+	var got string
+	var trap *Trap
+	if errors.As(err, &trap) {
+		got = trap.Reason
+		if strings.HasPrefix(got, reason) {
+			got = reason
+		}
+	} else if err != nil {
+		return err
+	}
+	return s.assert(name, got, reason)
+}
+
+// scriptValues returns the values of the constants of a command.
+func scriptValues(nodes []*text.Node) ([]Value, error) {
+	values := make([]Value, len(nodes))
+	for i, n := range nodes {
+		var err error
+		if values[i], err = scriptValue(n); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func scriptValue(n *text.Node) (Value, error) {
+	var bits uint64
+	var err error
+	switch n.Op {
+	case text.OpI32Const:
+		bits, err = text.ParseInt(n.Meta, 32)
+		return Value{typ: ValueTypeI32, bits: bits}, err
+	case text.OpI64Const:
+		bits, err = text.ParseInt(n.Meta, 64)
+		return Value{typ: ValueTypeI64, bits: bits}, err
+	case text.OpF32Const:
+		bits, err = text.ParseFloat(n.Meta, 32)
+		return Value{typ: ValueTypeF32, bits: bits}, err
+	case text.OpF64Const:
+		bits, err = text.ParseFloat(n.Meta, 64)
+		return Value{typ: ValueTypeF64, bits: bits}, err
+	case text.OpV128Const:
+		lo, hi, err := parseV128(n.Meta)
+		return V128(lo, hi), err
+	case text.OpRefNull:
+		switch n.Meta {
+		case "func":
+			return zero(ValueTypeFuncRef), nil
+		case "extern":
+			return zero(ValueTypeExternRef), nil
+		}
+		return Value{}, fmt.Errorf("unknown heap type %q", n.Meta)
+	}
+	return Value{}, fmt.Errorf("unexpected %s, expected a constant", n.Op)
+}
+
+// scriptResult returns the value an expected result stands for. Like in
+// manifests, a NaN pattern, in place of a float or of the float lanes of a
+// vector, stands for the bits of got it matches, so that they compare
+// equal, and for the canonical NaN otherwise. Other lanes match exactly.
+func scriptResult(n *text.Node, got Value) (Value, error) {
+	switch n.Op {
+	case text.OpF32Const, text.OpF64Const:
+		if !isNaNPattern(n.Meta) {
+			break
+		}
+		typ, size := ValueTypeF32, "f32"
+		if n.Op == text.OpF64Const {
+			typ, size = ValueTypeF64, "f64"
+		}
+		var g uint64
+		if got.typ == typ {
+			g = got.bits
+		}
+		bits, err := laneBits(size, n.Meta, g)
+		return Value{typ: typ, bits: bits}, err
+	case text.OpV128Const:
+		fields := text.Fields(n.Meta)
+		if len(fields) == 0 {
+			break
+		}
+		lane, lanes, ok := shape(fields[0])
+		if !ok || len(fields)-1 != lanes {
+			// parseV128 reports the error
+			break
+		}
+		var g [2]uint64
+		if got.typ == ValueTypeV128 {
+			g = [2]uint64{got.bits, got.hi}
+		}
+		// the lanes of the patterns are parsed as zeros and filled in
+		// afterwards
+		size := 128 / lanes
+		mask := uint64(1)<<size - 1
+		var nans [2]uint64
+		for i, s := range fields[1:] {
+			if !isNaNPattern(s) {
+				continue
+			}
+			if lane[0] != 'f' {
+				return Value{}, fmt.Errorf("unexpected %s in %s lane", s, lane)
+			}
+			h, off := i*size/64, i*size%64
+			bits, err := laneBits(lane, s, g[h]>>off&mask)
+			if err != nil {
+				return Value{}, err
+			}
+			nans[h] |= bits << off
+			fields[i+1] = "0"
+		}
+		lo, hi, err := parseV128(strings.Join(fields, " "))
+		return V128(lo|nans[0], hi|nans[1]), err
+	}
+	return scriptValue(n)
+}
+
+func isNaNPattern(s string) bool {
+	return s == "nan:canonical" || s == "nan:arithmetic"
+}
+
+func (s *script) assertUnlinkable(cmd *text.AssertUnlinkableCommand) error {
+	m, err := s.rt.compile(cmd.Module)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.rt.instantiate(s.store, m)
+	return s.assertUnlinked("assert_unlinkable", err, cmd.Reason)
+}
+
+// assertInvalid checks that a module fails to compile with an error that
+// contains the reason, as errors name the field that fails.
+func (s *script) assertInvalid(cmd *text.AssertInvalidCommand) error {
+	var got string
+	if _, err := s.rt.compile(cmd.Module); err != nil {
+		got = err.Error()
+		if strings.Contains(got, cmd.Reason) {
+			got = cmd.Reason
+		}
+	}
+	return s.assert("assert_invalid", got, cmd.Reason)
+}
+
+// assertUnlinked checks that err is a link error whose reason starts with
+// reason, as for assertTrapped.
+func (s *script) assertUnlinked(name string, err error, reason string) error {
+	var got string
+	var le *LinkError
+	if errors.As(err, &le) {
+		got = le.Reason
+		if strings.HasPrefix(got, reason) {
+			got = reason
+		}
+	} else if err != nil {
+		return err
+	}
+	return s.assert(name, got, reason)
+}
+
+// assert reports the outcome of an assertion.
+func (s *script) assert(name string, got, want any) error {
+	if f, ok := s.rt.globalFuncs[name]; ok {
+		f(got, want)
+		if s.result != nil {
+			s.result.Reported++
+		}
+		return nil
+	}
+	if got != want {
+		return fmt.Errorf("%s: got %q, expected %q", name, got, want)
+	}
+	if s.result != nil {
+		s.result.Passed++
+	}
+	return nil
+}
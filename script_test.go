@@ -0,0 +1,375 @@
+package main_test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+const lib = `(module $lib
+  (func (export "add") (param i32 i32) (result i32)
+    (i32.add (local.get 0) (local.get 1)))
+  (global (export "g") i32 (i32.const 7))
+  (memory (export "mem") 1 2))
+(register "lib" $lib)
+`
+
+func TestAssertUnlinkable(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"missing module", `(assert_unlinkable
+  (module (import "nowhere" "f" (func)))
+  "unknown import")`},
+		{"missing function", lib + `(assert_unlinkable
+  (module (import "lib" "missing" (func)))
+  "unknown import")`},
+		{"wrong kind", lib + `(assert_unlinkable
+  (module (import "lib" "add" (global i32)))
+  "incompatible import type")`},
+		{"wrong signature", lib + `(assert_unlinkable
+  (module (func (import "lib" "add") (param i32) (result i32)))
+  "incompatible import type")`},
+		{"wrong global type", lib + `(assert_unlinkable
+  (module (import "lib" "g" (global (mut i32))))
+  "incompatible import type")`},
+		{"memory too small", lib + `(assert_unlinkable
+  (module (import "lib" "mem" (memory 2)))
+  "incompatible import type")`},
+		{"memory max too large", lib + `(assert_unlinkable
+  (module (import "lib" "mem" (memory 1 1)))
+  "incompatible import type")`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := war.NewRuntime().Exec([]byte(tt.src)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestAssertUnlinkableLinks(t *testing.T) {
+	src := lib + `(assert_unlinkable
+  (module (import "lib" "add" (func (param i32 i32) (result i32))))
+  "unknown import")`
+
+	var got, want any
+	r := war.NewRuntime(war.WithFuncs(war.FuncMap{
+		"assert_unlinkable": func(g, w any) { got, want = g, w },
+	}))
+	if err := r.Exec([]byte(src)); err != nil {
+		t.Fatal(err)
+	}
+	if got != "" || want != "unknown import" {
+		t.Errorf("got (%q, %q), expected (\"\", \"unknown import\")", got, want)
+	}
+
+	if err := war.NewRuntime().Exec([]byte(src)); err == nil {
+		t.Error("expected the assertion to fail")
+	}
+}
+
+func TestImports(t *testing.T) {
+	r := war.NewRuntime()
+	if err := r.Exec([]byte(lib)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := r.Instantiate([]byte(`(module
+  (import "lib" "add" (func $add (param i32 i32) (result i32)))
+  (import "lib" "g" (global $g i32))
+  (import "lib" "mem" (memory 1))
+  (data (i32.const 0) "\05")
+  (func (export "main") (result i32)
+    (call $add (global.get $g) (i32.load8_u (i32.const 0)))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Invoke("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != war.I32(12) {
+		t.Errorf("got %v, expected [i32:12]", got)
+	}
+}
+
+func TestImportAbbreviations(t *testing.T) {
+	const tablib = `(module $tablib
+  (func $seven (result i32) (i32.const 7))
+  (table (export "tab") funcref (elem $seven))
+  (global (export "g") (mut i32) (i32.const 5)))
+(register "tablib" $tablib)`
+
+	tests := []struct {
+		name    string
+		imports string
+	}{
+		{"explicit", `(import "tablib" "tab" (table $t 1 funcref))
+  (import "tablib" "g" (global $g (mut i32)))`},
+		{"abbreviated", `(table $t (import "tablib" "tab") 1 funcref)
+  (global $g (import "tablib" "g") (mut i32))`},
+		{"abbreviated with exports", `(table $t (export "t") (import "tablib" "tab") 1 funcref)
+  (global $g (export "g") (import "tablib" "g") (mut i32))`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := war.NewRuntime()
+			if err := r.Exec([]byte(tablib)); err != nil {
+				t.Fatal(err)
+			}
+
+			// the imported items take index 0 ahead of the defined ones
+			_, err := r.Instantiate([]byte(`(module
+  ` + tt.imports + `
+  (table 3 funcref)
+  (global i32 (i32.const 100))
+  (func (export "main") (result i32)
+    (global.set 0 (i32.add (global.get $g) (i32.const 1)))
+    (i32.add
+      (i32.add (global.get 0) (global.get 1))
+      (i32.add (table.size 0) (table.size 1)))))`))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := r.Invoke("main")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0] != war.I32(6+100+1+3) {
+				t.Errorf("got %v, expected [i32:110]", got)
+			}
+		})
+	}
+}
+
+func TestImportTypeUse(t *testing.T) {
+	tests := []struct {
+		name string
+		desc string
+		err  string
+	}{
+		{"type only", `(func $add (type $t))`, ""},
+		{"inline only", `(func $add (param i32 i32) (result i32))`, ""},
+		{"both agreeing", `(func $add (type $t) (param i32 i32) (result i32))`, ""},
+		{"named params", `(func $add (type $t) (param $a i32) (param $b i32) (result i32))`, ""},
+		{"both disagreeing", `(func $add (type $t) (param i32) (result i32))`, "does not match type $t"},
+		{"unknown type", `(func $add (type $u))`, "unknown type $u"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := war.NewRuntime()
+			if err := r.Exec([]byte(lib)); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := r.Instantiate([]byte(`(module
+  (type $t (func (param i32 i32) (result i32)))
+  (import "lib" "add" ` + tt.desc + `)
+  (func (export "main") (result i32)
+    (call $add (i32.const 2) (i32.const 3))))`))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got error %v, expected %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := r.Invoke("main")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0] != war.I32(5) {
+				t.Errorf("got %v, expected [i32:5]", got)
+			}
+		})
+	}
+}
+
+func TestScriptModules(t *testing.T) {
+	var asserts []string
+	r := war.NewRuntime(war.WithFuncs(war.FuncMap{
+		"assert_return": func(got, want any) {
+			asserts = append(asserts, fmt.Sprintf("%v %v", got, want))
+		},
+	}))
+	err := r.Exec([]byte(`(module $counter
+  (global $n (mut i32) (i32.const 0))
+  (func (export "inc") (result i32)
+    (global.set $n (i32.add (global.get $n) (i32.const 1)))
+    (global.get $n)))
+(invoke "inc")
+(assert_return (invoke "inc") (i32.const 2))
+(module
+  (func (export "add") (param i64 i64) (result i64)
+    (i64.add (local.get 0) (local.get 1))))
+(assert_return (invoke "add" (i64.const 40) (i64.const 2)) (i64.const 42))
+(assert_return (invoke $counter "inc") (i32.const 3))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"[i32:2] [i32:2]", "[i64:42] [i64:42]", "[i32:3] [i32:3]"}
+	if !slices.Equal(asserts, want) {
+		t.Errorf("got asserts %q, expected %q", asserts, want)
+	}
+
+	// the last module is the current one
+	if _, err := r.Invoke("add", war.I64(1), war.I64(1)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAssertTrap(t *testing.T) {
+	var asserts []string
+	r := war.NewRuntime(war.WithFuncs(war.FuncMap{
+		"assert_trap": func(got, want any) {
+			asserts = append(asserts, fmt.Sprintf("%v|%v", got, want))
+		},
+	}))
+	err := r.Exec([]byte(`(module
+  (func (export "div") (param i32 i32) (result i32)
+    (i32.div_u (local.get 0) (local.get 1))))
+(assert_trap (invoke "div" (i32.const 1) (i32.const 0)) "integer divide by zero")
+(assert_trap (invoke "div" (i32.const 1) (i32.const 1)) "integer divide by zero")
+(assert_trap (module (func $f unreachable) (start $f)) "unreachable")
+(assert_trap (invoke "div" (i32.const 1) (i32.const 0)) "integer divide")
+(assert_trap (invoke "div" (i32.const 1) (i32.const 0)) "divide by zero")`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a call that doesn't trap reports an empty reason, and a trap whose
+	// reason adds to the expected one matches it
+	want := []string{"integer divide by zero|integer divide by zero", "|integer divide by zero", "unreachable|unreachable",
+		"integer divide|integer divide", "integer divide by zero|divide by zero"}
+	if !slices.Equal(asserts, want) {
+		t.Errorf("got asserts %q, expected %q", asserts, want)
+	}
+}
+
+func TestAssertReturnMultiple(t *testing.T) {
+	const swap = `(module
+  (func (export "swap") (param i32 i32) (result i32 i32)
+    (local.get 1) (local.get 0)))
+`
+	tests := []struct {
+		name   string
+		assert string
+		err    string
+	}{
+		{"equal", `(assert_return (invoke "swap" (i32.const 1) (i32.const 2)) (i32.const 2) (i32.const 1))`, ""},
+		{"value mismatch", `(assert_return (invoke "swap" (i32.const 1) (i32.const 2)) (i32.const 1) (i32.const 2))`,
+			`assert_return: got "[i32:2 i32:1]", expected "[i32:1 i32:2]"`},
+		{"too few", `(assert_return (invoke "swap" (i32.const 1) (i32.const 2)) (i32.const 2))`,
+			`assert_return "swap": got 2 results, expected 1`},
+		{"too many", `(assert_return (invoke "swap" (i32.const 1) (i32.const 2)) (i32.const 2) (i32.const 1) (i32.const 0))`,
+			`assert_return "swap": got 2 results, expected 3`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := war.NewRuntime().Exec([]byte(swap + tt.assert))
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.err {
+				t.Errorf("got error %q, expected %q", got, tt.err)
+			}
+		})
+	}
+}
+
+func TestAssertReturnV128(t *testing.T) {
+	// the NaNs are canonical, arithmetic with a payload, negative canonical
+	// and signaling
+	const nans = `(module
+  (func (export "nans") (result v128)
+    (v128.const f32x4 1.5 nan nan:0x400001 -nan))
+  (func (export "snan") (result v128 f32)
+    (v128.const f32x4 nan:0x200000 0 0 0) (f32.const nan:0x200000)))
+`
+	tests := []struct {
+		name   string
+		assert string
+		err    string
+	}{
+		{"lanes match", `(assert_return (invoke "nans") (v128.const f32x4 1.5 nan:canonical nan:arithmetic nan:canonical))`, ""},
+		{"concrete lane", `(assert_return (invoke "nans") (v128.const f32x4 2.5 nan:canonical nan:arithmetic nan:canonical))`,
+			`assert_return: got "[v128:0xffc000007fc000017fc000003fc00000]", expected "[v128:0xffc000007fc000017fc0000040200000]"`},
+		{"not canonical", `(assert_return (invoke "nans") (v128.const f32x4 1.5 nan:canonical nan:canonical nan:canonical))`,
+			`assert_return: got "[v128:0xffc000007fc000017fc000003fc00000]", expected "[v128:0xffc000007fc000007fc000003fc00000]"`},
+		{"integer lanes", `(assert_return (invoke "nans") (v128.const i32x4 0x3fc00000 0x7fc00000 0x7fc00001 0xffc00000))`, ""},
+		{"pattern in integer lane", `(assert_return (invoke "nans") (v128.const i32x4 0x3fc00000 nan:canonical 0x7fc00001 0xffc00000))`,
+			`assert_return: unexpected nan:canonical in i32 lane`},
+		{"signaling", `(assert_return (invoke "snan") (v128.const f32x4 nan:arithmetic 0 0 0) (f32.const nan:arithmetic))`,
+			`assert_return: got "[v128:0x0000000000000000000000007fa00000 f32:nan:0x200000 (0x7fa00000)]", expected "[v128:0x0000000000000000000000007fc00000 f32:nan:0x400000 (0x7fc00000)]"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := war.NewRuntime().Exec([]byte(nans + tt.assert))
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.err {
+				t.Errorf("got error %q, expected %q", got, tt.err)
+			}
+		})
+	}
+}
+
+func TestAssertReturnFloatBits(t *testing.T) {
+	const floats = `(module
+  (func (export "zero32") (result f32) (f32.const -0))
+  (func (export "zero64") (result f64) (f64.const -0))
+  (func (export "nan64") (result f64) (f64.const nan:0x8000000001234)))
+`
+	tests := []struct {
+		name   string
+		assert string
+		err    string
+	}{
+		{"f32 -0", `(assert_return (invoke "zero32") (f32.const -0))`, ""},
+		{"f32 +0", `(assert_return (invoke "zero32") (f32.const 0))`,
+			`assert_return: got "[f32:-0 (0x80000000)]", expected "[f32:0 (0x00000000)]"`},
+		{"f64 +0", `(assert_return (invoke "zero64") (f64.const 0))`,
+			`assert_return: got "[f64:-0 (0x8000000000000000)]", expected "[f64:0 (0x0000000000000000)]"`},
+		{"same payload", `(assert_return (invoke "nan64") (f64.const nan:0x8000000001234))`, ""},
+		{"other payload", `(assert_return (invoke "nan64") (f64.const nan:0x8000000001235))`,
+			`assert_return: got "[f64:nan:0x8000000001234 (0x7ff8000000001234)]", expected "[f64:nan:0x8000000001235 (0x7ff8000000001235)]"`},
+		{"other sign", `(assert_return (invoke "nan64") (f64.const -nan:0x8000000001234))`,
+			`assert_return: got "[f64:nan:0x8000000001234 (0x7ff8000000001234)]", expected "[f64:-nan:0x8000000001234 (0xfff8000000001234)]"`},
+		{"arithmetic", `(assert_return (invoke "nan64") (f64.const nan:arithmetic))`, ""},
+		{"canonical", `(assert_return (invoke "nan64") (f64.const nan:canonical))`,
+			`assert_return: got "[f64:nan:0x8000000001234 (0x7ff8000000001234)]", expected "[f64:nan:0x8000000000000 (0x7ff8000000000000)]"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := war.NewRuntime().Exec([]byte(floats + tt.assert))
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.err {
+				t.Errorf("got error %q, expected %q", got, tt.err)
+			}
+		})
+	}
+}
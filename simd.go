@@ -0,0 +1,492 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/bluescreen10/war/text"
+)
+
+// shape splits a vector shape such as i64x2 into its lane type and lane
+// count.
+func shape(s string) (lane string, lanes int, ok bool) {
+	lane, count, ok := strings.Cut(s, "x")
+	if !ok {
+		return "", 0, false
+	}
+	lanes, err := strconv.Atoi(count)
+	if err != nil {
+		return "", 0, false
+	}
+	switch s {
+	case "i8x16", "i16x8", "i32x4", "i64x2", "f32x4", "f64x2":
+		return lane, lanes, true
+	}
+	return "", 0, false
+}
+
+// parseV128 decodes the immediates of v128.const, a shape followed by one
+// number per lane, into the low and high halves of the vector.
+func parseV128(meta string) (lo, hi uint64, err error) {
+	fields := text.Fields(meta)
+	if len(fields) == 0 {
+		return 0, 0, fmt.Errorf("missing vector shape")
+	}
+	lane, lanes, ok := shape(fields[0])
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown vector shape %q", fields[0])
+	}
+	if len(fields)-1 != lanes {
+		return 0, 0, fmt.Errorf("wrong number of lane literals for %s", fields[0])
+	}
+
+	size := 128 / lanes
+	for i, s := range fields[1:] {
+		var v uint64
+		if lane[0] == 'f' {
+			v, err = text.ParseFloat(s, size)
+		} else {
+			v, err = text.ParseInt(s, size)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		off := i * size
+		if off < 64 {
+			lo |= v << off
+		} else {
+			hi |= v << (off - 64)
+		}
+	}
+	return lo, hi, nil
+}
+
+func isLaneAccess(op text.Op) bool {
+	return op >= text.OpI8x16ExtractLaneU && op <= text.OpF64x2ReplaceLane
+}
+
+// parseLane decodes the lane index of extract_lane and replace_lane and
+// checks it against the number of lanes of the shape.
+func parseLane(op text.Op, meta string) (uint64, error) {
+	prefix, _, _ := strings.Cut(op.String(), ".")
+	_, lanes, _ := shape(prefix)
+	lane, err := strconv.ParseUint(meta, 10, 8)
+	if err != nil || lane >= uint64(lanes) {
+		return 0, fmt.Errorf("invalid lane index %q", meta)
+	}
+	return lane, nil
+}
+
+func isSIMD(op text.Op) bool {
+	return op == text.OpV128Const || op >= text.OpV128Not && op <= text.OpF64x2ReplaceLane
+}
+
+// simdSignature returns the operand and result types of the vector
+// instructions the interpreter supports.
+func simdSignature(op text.Op) (params, results []ValueType, ok bool) {
+	v := []ValueType{ValueTypeV128}
+	if op == text.OpV128Const {
+		return nil, v, true
+	}
+
+	if _, _, ok := laneBinaryOp(op); ok {
+		return []ValueType{ValueTypeV128, ValueTypeV128}, v, true
+	}
+	if _, _, ok := floatRounding(op); ok {
+		return v, v, true
+	}
+	if _, _, ok := narrowing(op); ok || op == text.OpI32x4DotI16x8S {
+		return []ValueType{ValueTypeV128, ValueTypeV128}, v, true
+	}
+	prefix, name, _ := strings.Cut(op.String(), ".")
+	if prefix != "i64x2" {
+		return nil, nil, false
+	}
+	lane := ValueTypeI64
+
+	switch strings.Split(name, "_")[0] {
+	case "splat":
+		return []ValueType{lane}, v, true
+	case "extract":
+		return v, []ValueType{lane}, true
+	case "replace":
+		return []ValueType{ValueTypeV128, lane}, v, true
+	case "all", "bitmask":
+		return v, []ValueType{ValueTypeI32}, true
+	case "shl", "shr":
+		return []ValueType{ValueTypeV128, ValueTypeI32}, v, true
+	case "neg", "abs", "extend":
+		return v, v, true
+	case "eq", "ne", "lt", "le", "gt", "ge", "add", "sub", "mul", "extmul":
+		return []ValueType{ValueTypeV128, ValueTypeV128}, v, true
+	}
+	return nil, nil, false
+}
+
+func (m *machine) pushV128(lo, hi uint64) {
+	m.stack = append(m.stack, Value{typ: ValueTypeV128, bits: lo, hi: hi})
+}
+
+func (m *machine) popV128() (lo, hi uint64) {
+	v := m.pop()
+	return v.bits, v.hi
+}
+
+// i64x2Unary applies fn to both lanes of the operand.
+func (m *machine) i64x2Unary(fn func(a uint64) uint64) {
+	lo, hi := m.popV128()
+	m.pushV128(fn(lo), fn(hi))
+}
+
+// i64x2Binary applies fn lane by lane to both operands.
+func (m *machine) i64x2Binary(fn func(a, b uint64) uint64) {
+	blo, bhi := m.popV128()
+	alo, ahi := m.popV128()
+	m.pushV128(fn(alo, blo), fn(ahi, bhi))
+}
+
+// i64x2Compare sets a lane to all ones when fn holds and to zero otherwise.
+func (m *machine) i64x2Compare(fn func(a, b int64) bool) {
+	m.i64x2Binary(func(a, b uint64) uint64 {
+		if fn(int64(a), int64(b)) {
+			return ^uint64(0)
+		}
+		return 0
+	})
+}
+
+// i64x2Shift shifts both lanes by the count taken modulo the lane width.
+func (m *machine) i64x2Shift(fn func(a uint64, n uint) uint64) {
+	n := uint(m.popI32() & 63)
+	m.i64x2Unary(func(a uint64) uint64 { return fn(a, n) })
+}
+
+// laneBinary applies fn to the lanes of size bits of both operands, which
+// it gets zero extended.
+func (m *machine) laneBinary(size int, fn func(a, b uint64) uint64) {
+	b := m.popV128Halves()
+	a := m.popV128Halves()
+	mask := uint64(1)<<size - 1
+	var r [2]uint64
+	for h := range r {
+		for off := 0; off < 64; off += size {
+			r[h] |= fn(a[h]>>off&mask, b[h]>>off&mask) & mask << off
+		}
+	}
+	m.pushV128(r[0], r[1])
+}
+
+// laneUnary applies fn to each lane of the given bit size of the operand.
+func (m *machine) laneUnary(size int, fn func(a uint64) uint64) {
+	a := m.popV128Halves()
+	mask := uint64(1)<<size - 1
+	var r [2]uint64
+	for h := range r {
+		for off := 0; off < 64; off += size {
+			r[h] |= fn(a[h]>>off&mask) & mask << off
+		}
+	}
+	m.pushV128(r[0], r[1])
+}
+
+func (m *machine) popV128Halves() [2]uint64 {
+	lo, hi := m.popV128()
+	return [2]uint64{lo, hi}
+}
+
+// laneBinaryOp returns the lane size and the function of the lanewise
+// binary instructions run by laneBinary.
+func laneBinaryOp(op text.Op) (size int, fn func(a, b uint64) uint64, ok bool) {
+	if size, fn, ok := intMinMax(op); ok {
+		return size, fn, true
+	}
+	return intSaturating(op)
+}
+
+// intSaturating returns the lane size and the function of the saturating
+// integer instructions, which clamp results to the range of the lane
+// instead of wrapping around.
+func intSaturating(op text.Op) (size int, fn func(a, b uint64) uint64, ok bool) {
+	prefix, name, _ := strings.Cut(op.String(), ".")
+	lane, lanes, ok := shape(prefix)
+	if !ok || lane[0] != 'i' {
+		return 0, nil, false
+	}
+	size = 128 / lanes
+
+	// lanes are read as signed or unsigned and the result clamped to
+	// [lo, hi]
+	shift := 64 - size
+	signed := func(a uint64) int64 { return int64(a<<shift) >> shift }
+	unsigned := func(a uint64) int64 { return int64(a) }
+	lo, hi := int64(-1)<<(size-1), int64(1)<<(size-1)-1
+	read := signed
+	if strings.HasSuffix(name, "_u") {
+		read = unsigned
+		lo, hi = 0, int64(1)<<size-1
+	}
+
+	var op2 func(a, b int64) int64
+	switch name {
+	case "add_sat_s", "add_sat_u":
+		op2 = func(a, b int64) int64 { return a + b }
+	case "sub_sat_s", "sub_sat_u":
+		op2 = func(a, b int64) int64 { return a - b }
+	case "q15mulr_sat_s":
+		// the Q15 product rounded to nearest, ties up
+		op2 = func(a, b int64) int64 { return (a*b + 0x4000) >> 15 }
+	default:
+		return 0, nil, false
+	}
+	return size, func(a, b uint64) uint64 {
+		return uint64(min(max(op2(read(a), read(b)), lo), hi))
+	}, true
+}
+
+// intMinMax returns the lane size and the function of the lanewise integer
+// min and max instructions, comparing lanes as signed or unsigned per the
+// suffix of the instruction.
+func intMinMax(op text.Op) (size int, fn func(a, b uint64) uint64, ok bool) {
+	prefix, name, _ := strings.Cut(op.String(), ".")
+	lane, lanes, ok := shape(prefix)
+	if !ok || lane[0] != 'i' {
+		return 0, nil, false
+	}
+	switch name {
+	case "min_s", "min_u", "max_s", "max_u":
+	default:
+		return 0, nil, false
+	}
+	size = 128 / lanes
+
+	less := func(a, b uint64) bool { return a < b }
+	if strings.HasSuffix(name, "_s") {
+		// shifted to the top, signed lanes compare as int64
+		shift := 64 - size
+		less = func(a, b uint64) bool { return int64(a<<shift) < int64(b<<shift) }
+	}
+	if strings.HasPrefix(name, "min") {
+		return size, func(a, b uint64) uint64 {
+			if less(b, a) {
+				return b
+			}
+			return a
+		}, true
+	}
+	return size, func(a, b uint64) uint64 {
+		if less(a, b) {
+			return b
+		}
+		return a
+	}, true
+}
+
+// floatRounding returns the lane size and the function of the lanewise
+// ceil, floor, trunc and nearest instructions, which round each lane as the
+// scalar instructions do.
+func floatRounding(op text.Op) (size int, fn func(a uint64) uint64, ok bool) {
+	prefix, name, _ := strings.Cut(op.String(), ".")
+	var f32 func(float32) float32
+	var f64 func(float64) float64
+	switch name {
+	case "ceil":
+		f32, f64 = fceil32, math.Ceil
+	case "floor":
+		f32, f64 = ffloor32, math.Floor
+	case "trunc":
+		f32, f64 = ftrunc32, math.Trunc
+	case "nearest":
+		f32, f64 = fnearest32, fnearest64
+	default:
+		return 0, nil, false
+	}
+	switch prefix {
+	case "f32x4":
+		return 32, func(a uint64) uint64 {
+			return uint64(math.Float32bits(f32(math.Float32frombits(uint32(a)))))
+		}, true
+	case "f64x2":
+		return 64, func(a uint64) uint64 {
+			return math.Float64bits(f64(math.Float64frombits(a)))
+		}, true
+	}
+	return 0, nil, false
+}
+
+// narrowing returns the lane size of the result of the narrow
+// instructions, and whether it saturates to the signed range.
+func narrowing(op text.Op) (size int, signed bool, ok bool) {
+	switch op {
+	case text.OpI8x16NarrowI16x8S:
+		return 8, true, true
+	case text.OpI8x16NarrowI16x8U:
+		return 8, false, true
+	case text.OpI16x8NarrowI32x4S:
+		return 16, true, true
+	case text.OpI16x8NarrowI32x4U:
+		return 16, false, true
+	}
+	return 0, false, false
+}
+
+// narrow packs the lanes of twice size bits of both operands into lanes of
+// size bits, those of the first operand making the low half of the result.
+// The wide lanes are always read as signed and saturate to the signed or
+// unsigned range of the narrow ones.
+func (m *machine) narrow(size int, signed bool) {
+	b := m.popV128Halves()
+	a := m.popV128Halves()
+	wide := 2 * size
+	shift := 64 - wide
+	lo, hi := int64(-1)<<(size-1), int64(1)<<(size-1)-1
+	if !signed {
+		lo, hi = 0, int64(1)<<size-1
+	}
+	mask := uint64(1)<<size - 1
+	var r [2]uint64
+	for h, src := range [2][2]uint64{a, b} {
+		off := 0
+		for _, half := range src {
+			for s := 0; s < 64; s += wide {
+				v := int64(half>>s<<shift) >> shift
+				r[h] |= uint64(min(max(v, lo), hi)) & mask << off
+				off += size
+			}
+		}
+	}
+	m.pushV128(r[0], r[1])
+}
+
+// dot multiplies the i16 lanes held in a and b widened to i32, and adds
+// the products of each pair of adjacent lanes into an i32 lane. Only the
+// sum of two products of -0x8000 by itself doesn't fit, and wraps around.
+func dot(a, b uint64) uint64 {
+	var r uint64
+	for s := 0; s < 64; s += 32 {
+		lo := int32(int16(a>>s)) * int32(int16(b>>s))
+		hi := int32(int16(a>>(s+16))) * int32(int16(b>>(s+16)))
+		r |= uint64(uint32(lo+hi)) << s
+	}
+	return r
+}
+
+// extend widens the two i32 lanes held in half to i64 lanes.
+func extend(half uint64, signed bool) (lo, hi uint64) {
+	if signed {
+		return uint64(int64(int32(half))), uint64(int64(int32(half >> 32)))
+	}
+	return half & 0xffffffff, half >> 32
+}
+
+// extmul multiplies the two i32 lanes held in a and b to full i64 products.
+func extmul(a, b uint64, signed bool) (lo, hi uint64) {
+	alo, ahi := extend(a, signed)
+	blo, bhi := extend(b, signed)
+	return alo * blo, ahi * bhi
+}
+
+func (m *machine) execSIMD(in *instr) {
+	switch in.op {
+	case text.OpV128Const:
+		m.pushV128(in.imm, in.imm2)
+
+	case text.OpI64x2Splat:
+		v := m.popI64()
+		m.pushV128(v, v)
+	case text.OpI64x2ExtractLane:
+		lo, hi := m.popV128()
+		if in.imm == 0 {
+			m.pushI64(lo)
+		} else {
+			m.pushI64(hi)
+		}
+	case text.OpI64x2ReplaceLane:
+		v := m.popI64()
+		lo, hi := m.popV128()
+		if in.imm == 0 {
+			lo = v
+		} else {
+			hi = v
+		}
+		m.pushV128(lo, hi)
+
+	case text.OpI64x2Neg:
+		m.i64x2Unary(func(a uint64) uint64 { return -a })
+	case text.OpI64x2Abs:
+		m.i64x2Unary(func(a uint64) uint64 {
+			if int64(a) < 0 {
+				return -a
+			}
+			return a
+		})
+	case text.OpI64x2Add:
+		m.i64x2Binary(func(a, b uint64) uint64 { return a + b })
+	case text.OpI64x2Sub:
+		m.i64x2Binary(func(a, b uint64) uint64 { return a - b })
+	case text.OpI64x2Mul:
+		m.i64x2Binary(func(a, b uint64) uint64 { return a * b })
+
+	case text.OpI64x2Eq:
+		m.i64x2Compare(func(a, b int64) bool { return a == b })
+	case text.OpI64x2Ne:
+		m.i64x2Compare(func(a, b int64) bool { return a != b })
+	case text.OpI64x2LtS:
+		m.i64x2Compare(func(a, b int64) bool { return a < b })
+	case text.OpI64x2LeS:
+		m.i64x2Compare(func(a, b int64) bool { return a <= b })
+	case text.OpI64x2GtS:
+		m.i64x2Compare(func(a, b int64) bool { return a > b })
+	case text.OpI64x2GeS:
+		m.i64x2Compare(func(a, b int64) bool { return a >= b })
+
+	case text.OpI64x2Shl:
+		m.i64x2Shift(func(a uint64, n uint) uint64 { return a << n })
+	case text.OpI64x2ShrS:
+		m.i64x2Shift(func(a uint64, n uint) uint64 { return uint64(int64(a) >> n) })
+	case text.OpI64x2ShrU:
+		m.i64x2Shift(func(a uint64, n uint) uint64 { return a >> n })
+
+	case text.OpI64x2AllTrue:
+		lo, hi := m.popV128()
+		m.pushBool(lo != 0 && hi != 0)
+	case text.OpI64x2Bitmask:
+		lo, hi := m.popV128()
+		m.pushI32(uint32(lo>>63 | hi>>63<<1))
+
+	case text.OpI64x2ExtendLowI32x4S, text.OpI64x2ExtendLowI32x4U:
+		lo, _ := m.popV128()
+		m.pushV128(extend(lo, in.op == text.OpI64x2ExtendLowI32x4S))
+	case text.OpI64x2ExtendHighI32x4S, text.OpI64x2ExtendHighI32x4U:
+		_, hi := m.popV128()
+		m.pushV128(extend(hi, in.op == text.OpI64x2ExtendHighI32x4S))
+	case text.OpI64x2ExtmulLowI32x4S, text.OpI64x2ExtmulLowI32x4U:
+		b, _ := m.popV128()
+		a, _ := m.popV128()
+		m.pushV128(extmul(a, b, in.op == text.OpI64x2ExtmulLowI32x4S))
+	case text.OpI64x2ExtmulHighI32x4S, text.OpI64x2ExtmulHighI32x4U:
+		_, b := m.popV128()
+		_, a := m.popV128()
+		m.pushV128(extmul(a, b, in.op == text.OpI64x2ExtmulHighI32x4S))
+	case text.OpI32x4DotI16x8S:
+		blo, bhi := m.popV128()
+		alo, ahi := m.popV128()
+		m.pushV128(dot(alo, blo), dot(ahi, bhi))
+
+	default:
+		if size, fn, ok := laneBinaryOp(in.op); ok {
+			m.laneBinary(size, fn)
+			return
+		}
+		if size, fn, ok := floatRounding(in.op); ok {
+			m.laneUnary(size, fn)
+			return
+		}
+		if size, signed, ok := narrowing(in.op); ok {
+			m.narrow(size, signed)
+			return
+		}
+		panic("unhandled instruction")
+	}
+}
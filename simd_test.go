@@ -0,0 +1,284 @@
+package main_test
+
+import (
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+const i64x2 = `(module
+  (func (export "mul") (param v128 v128) (result v128)
+    (i64x2.mul (local.get 0) (local.get 1)))
+  (func (export "extmul_low_s") (param v128 v128) (result v128)
+    (i64x2.extmul_low_i32x4_s (local.get 0) (local.get 1)))
+  (func (export "extmul_high_u") (param v128 v128) (result v128)
+    (i64x2.extmul_high_i32x4_u (local.get 0) (local.get 1)))
+  (func (export "shl") (param v128 i32) (result v128)
+    (i64x2.shl (local.get 0) (local.get 1)))
+  (func (export "bitmask") (param v128) (result i32)
+    (i64x2.bitmask (local.get 0)))
+  (func (export "lt_s") (param v128 v128) (result v128)
+    (i64x2.lt_s (local.get 0) (local.get 1)))
+  (func (export "const") (result i64)
+    (i64x2.extract_lane 1
+      (i64x2.extend_low_i32x4_s (v128.const i32x4 7 -3 0 0)))))`
+
+func TestI64x2(t *testing.T) {
+	r := war.NewRuntime()
+	if _, err := r.Instantiate([]byte(i64x2)); err != nil {
+		t.Fatal(err)
+	}
+
+	const minInt64 = 1 << 63
+	tests := []struct {
+		name     string
+		args     []war.Value
+		expected war.Value
+	}{
+		// products wrap around to their low 64 bits
+		{"mul", []war.Value{war.V128(0xffffffffffffffff, 0x7fffffffffffffff), war.V128(0xffffffffffffffff, 3)}, war.V128(1, 0x7ffffffffffffffd)},
+		{"mul", []war.Value{war.V128(0x100000000, minInt64), war.V128(0x100000000, 2)}, war.V128(0, 0)},
+		{"mul", []war.Value{war.V128(0x123456789abcdef0, 0xfedcba9876543210), war.V128(0x0fedcba987654321, 0x1111111111111111)}, war.V128(0x2236d88fe5618cf0, 0xef0259f5d5fa6310)},
+
+		// lanes 0 and 1 of i32x4 widen to signed 64-bit products
+		{"extmul_low_s", []war.Value{war.V128(0x7fffffff_80000000, 0), war.V128(0x7fffffff_80000000, 0)}, war.V128(1<<62, 0x3fffffff00000001)},
+		{"extmul_low_s", []war.Value{war.V128(0x00000002_ffffffff, 0xdead), war.V128(0xfffffffd_00000005, 0xbeef)}, war.V128(0xfffffffffffffffb, 0xfffffffffffffffa)},
+		{"extmul_high_u", []war.Value{war.V128(0, 0xffffffff_ffffffff), war.V128(0, 0xffffffff_00000002)}, war.V128(0x1fffffffe, 0xfffffffe00000001)},
+
+		{"shl", []war.Value{war.V128(1, 3), war.I32(65)}, war.V128(2, 6)},
+		{"bitmask", []war.Value{war.V128(minInt64, 1)}, war.I32(1)},
+		{"lt_s", []war.Value{war.V128(minInt64, 1), war.V128(0, 0)}, war.V128(0xffffffffffffffff, 0)},
+		{"const", nil, war.I64(-3)},
+	}
+	for _, tt := range tests {
+		got, err := r.Invoke(tt.name, tt.args...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got[0] != tt.expected {
+			t.Errorf("%s%v: got %v, expected %v", tt.name, tt.args, got[0], tt.expected)
+		}
+	}
+}
+
+func TestIntMinMax(t *testing.T) {
+	r := war.NewRuntime()
+	_, err := r.Instantiate([]byte(`(module
+  (func (export "i8x16.min_u") (result v128)
+    (i8x16.min_u
+      (v128.const i8x16 0x80 0xff 0x7f 1 0 0 0 0 0 0 0 0 0 0 0 0xfe)
+      (v128.const i8x16 0x7f 0x01 0x80 0xff 0 0 0 0 0 0 0 0 0 0 0 0xff)))
+  (func (export "i8x16.min_s") (result v128)
+    (i8x16.min_s
+      (v128.const i8x16 0x80 0xff 0x7f 1 0 0 0 0 0 0 0 0 0 0 0 0xfe)
+      (v128.const i8x16 0x7f 0x01 0x80 0xff 0 0 0 0 0 0 0 0 0 0 0 0xff)))
+  (func (export "i32x4.max_s") (result v128)
+    (i32x4.max_s
+      (v128.const i32x4 -1 -2147483648 5 -7)
+      (v128.const i32x4 -2 2147483647 -5 -6)))
+  (func (export "i32x4.max_u") (result v128)
+    (i32x4.max_u
+      (v128.const i32x4 -1 -2147483648 5 -7)
+      (v128.const i32x4 -2 2147483647 -5 -6)))
+  (func (export "i16x8.max_u") (result v128)
+    (i16x8.max_u
+      (v128.const i16x8 0x8000 1 0 0 0 0 0 0xffff)
+      (v128.const i16x8 0x7fff 2 0 0 0 0 0 0))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected war.Value
+	}{
+		// unsigned lanes with the high bit set are the largest
+		{"i8x16.min_u", war.V128(0x00000000_017f017f, 0xfe00000000000000)},
+		{"i8x16.min_s", war.V128(0x00000000_ff80ff80, 0xfe00000000000000)},
+		// negative lanes compare below positive ones
+		{"i32x4.max_s", war.V128(0x7fffffff_ffffffff, 0xfffffffa_00000005)},
+		{"i32x4.max_u", war.V128(0x80000000_ffffffff, 0xfffffffa_fffffffb)},
+		{"i16x8.max_u", war.V128(0x0000_0000_0002_8000, 0xffff_0000_0000_0000)},
+	}
+	for _, tt := range tests {
+		got, err := r.Invoke(tt.name)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got[0] != tt.expected {
+			t.Errorf("%s: got %v, expected %v", tt.name, got[0], tt.expected)
+		}
+	}
+}
+
+func TestFloatRounding(t *testing.T) {
+	r := war.NewRuntime()
+	_, err := r.Instantiate([]byte(`(module
+  (func (export "f32x4.nearest") (result v128)
+    (f32x4.nearest (v128.const f32x4 0.5 1.5 2.5 -0.5)))
+  (func (export "f64x2.nearest") (result v128)
+    (f64x2.nearest (v128.const f64x2 -2.5 3.5)))
+  (func (export "f64x2.trunc") (result v128)
+    (f64x2.trunc (v128.const f64x2 -1.7 -0.3)))
+  (func (export "f32x4.trunc") (result v128)
+    (f32x4.trunc (v128.const f32x4 -1.7 1.7 -0x1p30 inf)))
+  (func (export "f64x2.floor") (result v128)
+    (f64x2.floor (v128.const f64x2 -0.5 0.5)))
+  (func (export "f32x4.ceil") (result v128)
+    (f32x4.ceil (v128.const f32x4 -0.5 0.5 0 -0))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected war.Value
+	}{
+		// ties go to even, keeping the sign of zero
+		{"f32x4.nearest", war.V128(0x40000000_00000000, 0x80000000_40000000)},
+		{"f64x2.nearest", war.V128(0xc000000000000000, 0x4010000000000000)},
+		// toward zero, negatives too
+		{"f64x2.trunc", war.V128(0xbff0000000000000, 0x8000000000000000)},
+		{"f32x4.trunc", war.V128(0x3f800000_bf800000, 0x7f800000_ce800000)},
+		{"f64x2.floor", war.V128(0xbff0000000000000, 0)},
+		{"f32x4.ceil", war.V128(0x3f800000_80000000, 0x80000000_00000000)},
+	}
+	for _, tt := range tests {
+		got, err := r.Invoke(tt.name)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got[0] != tt.expected {
+			t.Errorf("%s: got %v, expected %v", tt.name, got[0], tt.expected)
+		}
+	}
+}
+
+func TestIntSaturating(t *testing.T) {
+	r := war.NewRuntime()
+	_, err := r.Instantiate([]byte(`(module
+  (func (export "i8x16.add_sat_u") (result v128)
+    (i8x16.add_sat_u
+      (v128.const i8x16 0xff 0x80 1 0 0 0 0 0 0 0 0 0 0 0 0 0)
+      (v128.const i8x16 1 0x80 2 0 0 0 0 0 0 0 0 0 0 0 0 0)))
+  (func (export "i8x16.add_sat_s") (result v128)
+    (i8x16.add_sat_s
+      (v128.const i8x16 0x7f 0x80 0x7f 0 0 0 0 0 0 0 0 0 0 0 0 0)
+      (v128.const i8x16 1 0xff 0x80 0 0 0 0 0 0 0 0 0 0 0 0 0)))
+  (func (export "i16x8.sub_sat_u") (result v128)
+    (i16x8.sub_sat_u
+      (v128.const i16x8 1 0xffff 0 0 0 0 0 0)
+      (v128.const i16x8 2 1 0 0 0 0 0 0)))
+  (func (export "i16x8.sub_sat_s") (result v128)
+    (i16x8.sub_sat_s
+      (v128.const i16x8 0x8000 0x7fff 0 0 0 0 0 0)
+      (v128.const i16x8 1 0xffff 0 0 0 0 0 0)))
+  (func (export "i16x8.q15mulr_sat_s") (result v128)
+    (i16x8.q15mulr_sat_s
+      (v128.const i16x8 0x8000 0x8000 1 0x4000 0x7fff 0 0 0)
+      (v128.const i16x8 0x8000 0x7fff 0x4000 0x4000 0x7fff 0 0 0))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected war.Value
+	}{
+		// overflows clamp to the largest lane, in both directions when
+		// signed
+		{"i8x16.add_sat_u", war.V128(0x03ffff, 0)},
+		{"i8x16.add_sat_s", war.V128(0xff807f, 0)},
+		{"i16x8.sub_sat_u", war.V128(0xfffe_0000, 0)},
+		{"i16x8.sub_sat_s", war.V128(0x7fff_8000, 0)},
+		// -1 * -1 is the one product out of range, and halves round up
+		{"i16x8.q15mulr_sat_s", war.V128(0x2000_0001_8001_7fff, 0x7ffe)},
+	}
+	for _, tt := range tests {
+		got, err := r.Invoke(tt.name)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got[0] != tt.expected {
+			t.Errorf("%s: got %v, expected %v", tt.name, got[0], tt.expected)
+		}
+	}
+}
+
+func TestNarrow(t *testing.T) {
+	r := war.NewRuntime()
+	_, err := r.Instantiate([]byte(`(module
+  (func (export "i8x16.narrow_i16x8_u") (result v128)
+    (i8x16.narrow_i16x8_u
+      (v128.const i16x8 0 1 0xff 0x100 0x7fff 0xffff 0x8000 0x80)
+      (v128.const i16x8 2 3 4 5 6 7 8 9)))
+  (func (export "i8x16.narrow_i16x8_s") (result v128)
+    (i8x16.narrow_i16x8_s
+      (v128.const i16x8 0 1 0x7f 0x80 0xff80 0xff7f 0x7fff 0x8000)
+      (v128.const i16x8 -1 -2 0 0 0 0 0 0x100)))
+  (func (export "i16x8.narrow_i32x4_u") (result v128)
+    (i16x8.narrow_i32x4_u
+      (v128.const i32x4 0xffff 0x10000 -1 0x1234)
+      (v128.const i32x4 1 2 3 0x80000000)))
+  (func (export "i16x8.narrow_i32x4_s") (result v128)
+    (i16x8.narrow_i32x4_s
+      (v128.const i32x4 0x7fff 0x8000 -0x8000 -0x8001)
+      (v128.const i32x4 1 -1 0 0x7fffffff))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected war.Value
+	}{
+		// the lanes of the first operand come first, negative lanes
+		// clamp to 0 and large ones to 0xff even though unsigned
+		{"i8x16.narrow_i16x8_u", war.V128(0x80_00_00_ff_ff_ff_01_00, 0x09_08_07_06_05_04_03_02)},
+		{"i8x16.narrow_i16x8_s", war.V128(0x80_7f_80_80_7f_7f_01_00, 0x7f_00_00_00_00_00_fe_ff)},
+		{"i16x8.narrow_i32x4_u", war.V128(0x1234_0000_ffff_ffff, 0x0000_0003_0002_0001)},
+		{"i16x8.narrow_i32x4_s", war.V128(0x8000_8000_7fff_7fff, 0x7fff_0000_ffff_0001)},
+	}
+	for _, tt := range tests {
+		got, err := r.Invoke(tt.name)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got[0] != tt.expected {
+			t.Errorf("%s: got %v, expected %v", tt.name, got[0], tt.expected)
+		}
+	}
+}
+
+func TestDot(t *testing.T) {
+	r := war.NewRuntime()
+	_, err := r.Instantiate([]byte(`(module
+  (func (export "dot") (param v128 v128) (result v128)
+    (i32x4.dot_i16x8_s (local.get 0) (local.get 1))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		a, b     war.Value
+		expected war.Value
+	}{
+		// lanes 1 2 3 4 by 3 2 1 1
+		{"small", war.V128(0x0004_0003_0002_0001, 0), war.V128(0x0001_0001_0002_0003, 0), war.V128(7<<32|7, 0)},
+		// the products are past 16 bits, and -0x8000 * -0x8000 past 31,
+		// but not their sums
+		{"large", war.V128(0x4000_4000_7fff_7fff, 0xc000_4000_8000_8000), war.V128(0x4000_4000_7fff_7fff, 0x4000_4000_7fff_8000),
+			war.V128(0x20000000<<32|0x7ffe0002, 0x8000)},
+		{"wraps", war.V128(0, 0x8000_8000), war.V128(0, 0x8000_8000), war.V128(0, 0x80000000)},
+	}
+	for _, tt := range tests {
+		got, err := r.Invoke("dot", tt.a, tt.b)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got[0] != tt.expected {
+			t.Errorf("%s: got %v, expected %v", tt.name, got[0], tt.expected)
+		}
+	}
+}
@@ -1,34 +1,280 @@
 package main_test
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	war "github.com/bluescreen10/war"
 )
 
 func TestSpec(t *testing.T) {
-	matches, err := filepath.Glob(filepath.Join("testsuite", "*.wast"))
-	if err != nil {
-		t.Fatal("can't find test files")
+	var matches []string
+	for _, dir := range []string{"testsuite", "testdata"} {
+		for _, pattern := range []string{"*.wast", "*.json"} {
+			m, err := filepath.Glob(filepath.Join(dir, pattern))
+			if err != nil {
+				t.Fatal("can't find test files")
+			}
+			matches = append(matches, m...)
+		}
 	}
 
+	coverage := war.NewCoverage()
 	for _, match := range matches {
 		t.Run(match, func(t *testing.T) {
-			runtime := NewTestRuntime(t)
+			runtime := NewTestRuntime(t, war.WithProfiler())
 			if err := runtime.ExecFile(match); err != nil {
 				t.Errorf("runtime error: %v", err)
 			}
+			coverage.Add(runtime.Profile())
+			crossCheck(t, match)
 		})
 	}
+
+	// shown with -v, the instructions the scripts leave untested
+	var report strings.Builder
+	coverage.Report(&report)
+	t.Log(report.String())
 }
 
-func NewTestRuntime(t *testing.T) *war.Runtime {
-	return war.NewRuntime(war.WithFuncs(war.FuncMap{
+func NewTestRuntime(t *testing.T, opts ...war.RuntimeOption) *war.Runtime {
+	return war.NewRuntime(append(opts, war.WithFuncs(war.FuncMap{
 		"assert_return": func(got, expected any) {
 			if expected != got {
 				t.Errorf("assert_return: got(%v) expected(%v)", got, expected)
 			}
 		},
-	}))
+	}))...)
+}
+
+// variants are the ways of running a script that must agree with each
+// other: the bytecode interpreter, the tree walker, and either of them on
+// constant-folded modules.
+var variants = map[string][]war.RuntimeOption{
+	"bytecode":         nil,
+	"tree walk":        {war.WithTreeWalker()},
+	"folded":           {war.WithConstantFolding()},
+	"folded tree walk": {war.WithConstantFolding(), war.WithTreeWalker()},
+}
+
+// crossCheck runs a script in every variant, recording what each
+// assertion got, and fails if the variants don't get the same results and
+// traps.
+func crossCheck(t *testing.T, path string) {
+	t.Helper()
+	outcomes := map[string][]string{}
+	for name, opts := range variants {
+		var got []string
+		record := func(assertion string) func(_, _ any) {
+			return func(g, _ any) {
+				got = append(got, fmt.Sprintf("%s: %v", assertion, g))
+			}
+		}
+		r := war.NewRuntime(append(opts, war.WithFuncs(war.FuncMap{
+			"assert_return":     record("assert_return"),
+			"assert_trap":       record("assert_trap"),
+			"assert_unlinkable": record("assert_unlinkable"),
+		}))...)
+		if err := r.ExecFile(path); err != nil {
+			got = append(got, fmt.Sprintf("error: %v", err))
+		}
+		outcomes[name] = got
+	}
+
+	want := outcomes["bytecode"]
+	for name, got := range outcomes {
+		if slices.Equal(got, want) {
+			continue
+		}
+		for i := range max(len(got), len(want)) {
+			var g, w string
+			if i < len(got) {
+				g = got[i]
+			}
+			if i < len(want) {
+				w = want[i]
+			}
+			if g != w {
+				t.Errorf("%s: assertion %d got %q, bytecode got %q", name, i, g, w)
+				break
+			}
+		}
+	}
+}
+
+// TestCrossCheckInvalid checks that every variant rejects the modules the
+// validator rejects, before the optimizer gets to simplify them.
+func TestCrossCheckInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"non constant global", `(module (global i32 (i32.add (i32.const 1) (i32.const 2))))`,
+			"constant expression required"},
+		{"ill-typed after return", `(module (func (result i32)
+  (return (i32.const 1))
+  (i64.add (i32.const 1) (i32.const 2))))`, "type mismatch"},
+		{"ill-typed after br", `(module (func (block (br 0) (f32.neg (i32.const 0)))))`, "type mismatch"},
+	}
+
+	for _, tt := range tests {
+		for name, opts := range variants {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				_, err := war.NewRuntime(opts...).Instantiate([]byte(tt.src))
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got error %v, expected %q", err, tt.err)
+				}
+			})
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assemble := func(name, src string) {
+		t.Helper()
+		wasm, err := war.Assemble([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(name, wasm)
+	}
+
+	assemble("test.0.wasm", `(module
+  (global (export "g") i32 (i32.const 7))
+  (func (export "add") (param i32 i32) (result i32)
+    (i32.add (local.get 0) (local.get 1)))
+  (func (export "div") (param i32 i32) (result i32)
+    (i32.div_s (local.get 0) (local.get 1)))
+  (func (export "nan") (param f32) (result f32)
+    (f32.div (local.get 0) (local.get 0)))
+  (func (export "half") (result f64)
+    (f64.const 0.5))
+  (func (export "swap") (param i64 i64) (result i64 i64)
+    (local.get 1) (local.get 0))
+  (func (export "null") (result externref)
+    (ref.null extern))
+  (func $loop (export "loop") (call $loop)))`)
+	assemble("test.1.wasm", `(module
+  (import "m" "add" (func $add (param i32 i32) (result i32)))
+  (func (export "inc") (param i32) (result i32)
+    (call $add (local.get 0) (i32.const 1))))`)
+	assemble("test.2.wasm", `(module (import "m" "missing" (func)))`)
+	assemble("test.3.wasm", `(module (func $start unreachable) (start $start))`)
+	// a function returning i32 with an empty body
+	write("test.4.wasm", module(
+		[]byte{0x01, 0x05, 0x01, 0x60, 0x00, 0x01, 0x7f},
+		[]byte{0x03, 0x02, 0x01, 0x00},
+		[]byte{0x0a, 0x04, 0x01, 0x02, 0x00, 0x0b},
+	))
+	write("test.5.wasm", []byte("\x00asm\x02\x00\x00\x00"))
+	write("test.6.wat", []byte("(module (func (i32.const)))"))
+
+	write("test.json", []byte(`{"source_filename": "test.wast", "commands": [
+  {"type": "module", "line": 1, "name": "$m", "filename": "test.0.wasm"},
+  {"type": "assert_return", "line": 2,
+   "action": {"type": "invoke", "field": "add", "args": [
+     {"type": "i32", "value": "4294967295"}, {"type": "i32", "value": "3"}]},
+   "expected": [{"type": "i32", "value": "2"}]},
+  {"type": "assert_return", "line": 3, "action": {"type": "get", "field": "g"},
+   "expected": [{"type": "i32", "value": "7"}]},
+  {"type": "assert_return", "line": 4,
+   "action": {"type": "invoke", "field": "nan", "args": [{"type": "f32", "value": "0"}]},
+   "expected": [{"type": "f32", "value": "nan:canonical"}]},
+  {"type": "assert_return", "line": 5,
+   "action": {"type": "invoke", "field": "nan", "args": [{"type": "f32", "value": "0"}]},
+   "expected": [{"type": "f32", "value": "nan:arithmetic"}]},
+  {"type": "assert_return", "line": 6, "action": {"type": "invoke", "field": "half"},
+   "expected": [{"type": "f64", "value": "4602678819172646912"}]},
+  {"type": "assert_return", "line": 7,
+   "action": {"type": "invoke", "field": "swap", "args": [
+     {"type": "i64", "value": "1"}, {"type": "i64", "value": "18446744073709551615"}]},
+   "expected": [{"type": "i64", "value": "18446744073709551615"}, {"type": "i64", "value": "1"}]},
+  {"type": "assert_return", "line": 8, "action": {"type": "invoke", "field": "null"},
+   "expected": [{"type": "externref", "value": "null"}]},
+  {"type": "assert_trap", "line": 9,
+   "action": {"type": "invoke", "field": "div", "args": [
+     {"type": "i32", "value": "1"}, {"type": "i32", "value": "0"}]},
+   "text": "integer divide by zero", "expected": [{"type": "i32"}]},
+  {"type": "assert_exhaustion", "line": 10, "action": {"type": "invoke", "field": "loop"},
+   "text": "call stack exhausted", "expected": []},
+  {"type": "register", "line": 11, "name": "$m", "as": "m"},
+  {"type": "module", "line": 12, "filename": "test.1.wasm"},
+  {"type": "assert_return", "line": 13,
+   "action": {"type": "invoke", "field": "inc", "args": [{"type": "i32", "value": "41"}]},
+   "expected": [{"type": "i32", "value": "42"}]},
+  {"type": "action", "line": 14,
+   "action": {"type": "invoke", "module": "$m", "field": "add", "args": [
+     {"type": "i32", "value": "1"}, {"type": "i32", "value": "2"}]}, "expected": []},
+  {"type": "assert_unlinkable", "line": 15, "filename": "test.2.wasm", "text": "unknown import", "module_type": "binary"},
+  {"type": "assert_uninstantiable", "line": 16, "filename": "test.3.wasm", "text": "unreachable", "module_type": "binary"},
+  {"type": "assert_invalid", "line": 17, "filename": "test.4.wasm", "text": "type mismatch", "module_type": "binary"},
+  {"type": "assert_malformed", "line": 18, "filename": "test.5.wasm", "text": "unknown binary version", "module_type": "binary"},
+  {"type": "assert_malformed", "line": 19, "filename": "test.6.wat", "text": "unexpected token", "module_type": "text"}
+]}`))
+
+	var outcomes []string
+	record := func(assertion string) func(_, _ any) {
+		return func(got, want any) {
+			outcomes = append(outcomes, fmt.Sprintf("%s: %v, %v", assertion, got, want))
+			if got != want {
+				t.Errorf("%s: got %v, expected %v", assertion, got, want)
+			}
+		}
+	}
+	funcs := war.FuncMap{}
+	for _, name := range []string{"assert_return", "assert_trap", "assert_exhaustion",
+		"assert_unlinkable", "assert_uninstantiable", "assert_invalid", "assert_malformed"} {
+		funcs[name] = record(name)
+	}
+	r := war.NewRuntime(war.WithFuncs(funcs))
+	if err := r.ExecFile(filepath.Join(dir, "test.json")); err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes) != 15 {
+		t.Errorf("got %d assertions, expected 15:\n%v", len(outcomes), outcomes)
+	}
+
+	// a wrong expectation fails the script
+	write("wrong.json", []byte(`{"source_filename": "wrong.wast", "commands": [
+  {"type": "module", "line": 1, "filename": "test.0.wasm"},
+  {"type": "assert_return", "line": 2, "action": {"type": "invoke", "field": "half"},
+   "expected": [{"type": "f64", "value": "nan:arithmetic"}]}
+]}`))
+	err := war.NewRuntime().ExecFile(filepath.Join(dir, "wrong.json"))
+	if err == nil || err.Error() != "wrong.wast:2: assert_return: got \"[f64:0.5 (0x3fe0000000000000)]\", expected \"[f64:nan:0x8000000000000 (0x7ff8000000000000)]\"" {
+		t.Errorf("got error %v", err)
+	}
+
+	// as is a link error for another reason
+	write("unlinked.json", []byte(`{"source_filename": "unlinked.wast", "commands": [
+  {"type": "assert_unlinkable", "line": 1, "filename": "test.2.wasm", "text": "incompatible import type", "module_type": "binary"}
+]}`))
+	err = war.NewRuntime().ExecFile(filepath.Join(dir, "unlinked.json"))
+	if err == nil || err.Error() != "unlinked.wast:1: assert_unlinkable: got \"unknown import\", expected \"incompatible import type\"" {
+		t.Errorf("got error %v", err)
+	}
+	// assertions of proposals the runtime doesn't support are skipped
+	write("skipped.json", []byte(`{"source_filename": "skipped.wast", "commands": [
+  {"type": "module", "line": 1, "filename": "test.0.wasm"},
+  {"type": "assert_exception", "line": 2, "action": {"type": "invoke", "field": "half"}}
+]}`))
+	res, err := war.NewRuntime().ExecFileResult(filepath.Join(dir, "skipped.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Skipped != 1 || res.Failed != 0 {
+		t.Errorf("got %d skipped and %d failed, expected 1 and 0", res.Skipped, res.Failed)
+	}
 }
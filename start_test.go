@@ -0,0 +1,72 @@
+package main_test
+
+import (
+	"errors"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+func TestStart(t *testing.T) {
+	r := war.NewRuntime()
+	inst, err := r.Instantiate([]byte(`(module
+  (memory 1)
+  (global $count (export "count") (mut i32) (i32.const 0))
+  (start $init)
+  (func $init
+    (global.set $count (i32.add (global.get $count) (i32.const 1)))
+    ;; segments are initialized before the start function runs
+    (global.set $count (i32.add (global.get $count) (i32.load8_u (i32.const 0)))))
+  (data (i32.const 0) "\0a"))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, ok := inst.Global("count")
+	if !ok {
+		t.Fatal("missing global count")
+	}
+	if got := g.Get(); got != war.I32(11) {
+		t.Errorf("got %v, expected i32:11", got)
+	}
+}
+
+func TestStartTrap(t *testing.T) {
+	r := war.NewRuntime()
+	_, err := r.Instantiate([]byte(`(module
+  (func $boom unreachable)
+  (func (export "main") (result i32) (i32.const 1))
+  (start $boom))`))
+	var trap *war.Trap
+	if !errors.As(err, &trap) || trap.Reason != "unreachable" {
+		t.Fatalf("got %v, expected an unreachable trap", err)
+	}
+	if _, err := r.Invoke("main"); err == nil {
+		t.Error("the module should not be instantiated")
+	}
+}
+
+func TestStartType(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"empty", `(module (func $f) (start $f))`, ""},
+		{"param", `(module (func $f (param i32)) (start $f))`, "start function must have type [] -> []"},
+		{"result", `(module (func $f (result i32) (i32.const 0)) (start $f))`, "start function must have type [] -> []"},
+		{"imported", `(module (import "env" "f" (func $f (param i32))) (start $f))`, "start function must have type [] -> []"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := war.CompileModule([]byte(tt.src))
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.err {
+				t.Errorf("got error %q, expected %q", got, tt.err)
+			}
+		})
+	}
+}
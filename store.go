@@ -0,0 +1,55 @@
+package main
+
+import "maps"
+
+// Store holds the instances created by runtimes and the module names they
+// are registered under, against which the imports of the instances created
+// after resolve. A runtime has a store of its own unless given one with
+// WithStore, which links the modules of runtimes sharing it.
+type Store struct {
+	instances []*Instance
+	modules   map[string]*Instance
+}
+
+// NewStore returns an empty store.
+func NewStore() *Store {
+	return &Store{modules: map[string]*Instance{}}
+}
+
+// Register makes the exports of inst available for import under the module
+// name, replacing the instance registered under it before, if any.
+func (s *Store) Register(name string, inst *Instance) {
+	s.modules[name] = inst
+}
+
+// Module returns the instance registered under the module name.
+func (s *Store) Module(name string) (*Instance, bool) {
+	inst, ok := s.modules[name]
+	return inst, ok
+}
+
+// Instances returns the instances created in the store, in order.
+func (s *Store) Instances() []*Instance {
+	return s.instances
+}
+
+// fork returns a store with the modules registered in s, so that a script
+// can link against them without registering its own modules in s.
+func (s *Store) fork() *Store {
+	return &Store{modules: maps.Clone(s.modules)}
+}
+
+// forget removes from s the instances of the runtime r and the modules
+// registered under their names.
+func (s *Store) forget(r *Runtime) {
+	var kept []*Instance
+	for _, inst := range s.instances {
+		if inst.rt != r {
+			kept = append(kept, inst)
+		}
+	}
+	s.instances = kept
+	maps.DeleteFunc(s.modules, func(_ string, inst *Instance) bool {
+		return inst.rt == r
+	})
+}
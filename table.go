@@ -0,0 +1,84 @@
+package main
+
+import "fmt"
+
+// maxTableSize is the largest number of elements a table can grow to. It's
+// an implementation limit, the spec allows up to 2^32-1.
+const maxTableSize = 1 << 24
+
+// Table is a table instance.
+type Table struct {
+	typ    ValueType
+	elems  []Value
+	max    uint32
+	hasMax bool
+	limit  uint32 // set by the runtime on top of max, 0 if none
+}
+
+func newTable(t *table) *Table {
+	tab := &Table{typ: t.typ, elems: make([]Value, t.limits.min), max: maxTableSize}
+	for i := range tab.elems {
+		tab.elems[i] = zero(t.typ)
+	}
+	if t.limits.hasMax {
+		tab.max, tab.hasMax = t.limits.max, true
+	}
+	return tab
+}
+
+// NewTable creates a table of the type t, its elements being null, for the
+// host to provide to modules through RegisterHostModule.
+func NewTable(t ExternType) (*Table, error) {
+	if t.Value != ValueTypeFuncRef && t.Value != ValueTypeExternRef {
+		return nil, fmt.Errorf("table: invalid element type %s", t.Value)
+	}
+	l, err := t.limits(maxTableSize)
+	if err != nil {
+		return nil, fmt.Errorf("table: %w", err)
+	}
+	return newTable(&table{typ: t.Value, limits: l}), nil
+}
+
+// limits returns the current size and the declared maximum of the table.
+func (t *Table) limits() limits {
+	l := limits{min: t.Size()}
+	if t.hasMax {
+		l.max, l.hasMax = t.max, true
+	}
+	return l
+}
+
+// matches reports whether the table can be imported with limits l.
+func (t *Table) matches(l limits) bool {
+	if t.Size() < l.min {
+		return false
+	}
+	return !l.hasMax || t.hasMax && t.max <= l.max
+}
+
+// Size returns the number of elements of the table.
+func (t *Table) Size() uint32 {
+	return uint32(len(t.elems))
+}
+
+// grow grows the table by n elements set to v and returns the previous size,
+// or false if the table can't grow that much.
+func (t *Table) grow(n uint32, v Value) (uint32, bool) {
+	old := t.Size()
+	if uint64(old)+uint64(n) > uint64(min(t.max, maxTableSize)) {
+		return old, false
+	}
+	if t.limit > 0 && uint64(old)+uint64(n) > uint64(t.limit) {
+		return old, false
+	}
+	for range n {
+		t.elems = append(t.elems, v)
+	}
+	return old, true
+}
+
+// inBounds reports whether the n elements starting at i are within the
+// table.
+func (t *Table) inBounds(i, n uint32) bool {
+	return uint64(i)+uint64(n) <= uint64(len(t.elems))
+}
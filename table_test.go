@@ -0,0 +1,364 @@
+package main_test
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+)
+
+const tables = `(module
+  (table $t 6 funcref)
+  (table $u 4 funcref)
+  (func $a) (func $b) (func $c) (func $d)
+  (elem (table $t) (i32.const 0) func $a $b $c $d)
+  (elem $p func $d $c $b $a)
+  (func (export "get") (param i32) (result funcref)
+    (table.get $t (local.get 0)))
+  (func (export "get_u") (param i32) (result funcref)
+    (table.get $u (local.get 0)))
+  (func (export "copy") (param i32 i32 i32)
+    (table.copy $t $t (local.get 0) (local.get 1) (local.get 2)))
+  (func (export "copy_u") (param i32 i32 i32)
+    (table.copy $u $t (local.get 0) (local.get 1) (local.get 2)))
+  (func (export "init") (param i32 i32 i32)
+    (table.init $t $p (local.get 0) (local.get 1) (local.get 2)))
+  (func (export "drop")
+    (elem.drop $p)))`
+
+// contents returns the elements of a table as strings.
+func contents(t *testing.T, r *war.Runtime, get string, size int) []string {
+	t.Helper()
+	var elems []string
+	for i := range size {
+		got, err := r.Invoke(get, war.I32(int32(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0].IsNull() {
+			elems = append(elems, "null")
+		} else {
+			elems = append(elems, got[0].String())
+		}
+	}
+	return elems
+}
+
+func TestTableCopy(t *testing.T) {
+	const a, b, c, d = "funcref:a", "funcref:b", "funcref:c", "funcref:d"
+	tests := []struct {
+		name string
+		fn   string
+		args [3]int32
+		get  string
+		size int
+		want []string
+		trap string
+	}{
+		{"overlap forward", "copy", [3]int32{1, 0, 3}, "get", 6, []string{a, a, b, c, "null", "null"}, ""},
+		{"overlap backward", "copy", [3]int32{0, 1, 3}, "get", 6, []string{b, c, d, d, "null", "null"}, ""},
+		{"to the end", "copy", [3]int32{2, 0, 4}, "get", 6, []string{a, b, a, b, c, d}, ""},
+		{"across tables", "copy_u", [3]int32{1, 1, 3}, "get_u", 4, []string{"null", b, c, d}, ""},
+		{"empty at the end", "copy", [3]int32{6, 6, 0}, "get", 6, []string{a, b, c, d, "null", "null"}, ""},
+		{"dst out of bounds", "copy", [3]int32{4, 0, 3}, "get", 6, nil, "out of bounds table access"},
+		{"src out of bounds", "copy_u", [3]int32{0, 5, 2}, "get_u", 4, nil, "out of bounds table access"},
+		{"past the end", "copy", [3]int32{7, 0, 0}, "get", 6, nil, "out of bounds table access"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := war.NewRuntime()
+			if _, err := r.Instantiate([]byte(tables)); err != nil {
+				t.Fatal(err)
+			}
+			_, err := r.Invoke(tt.fn, war.I32(tt.args[0]), war.I32(tt.args[1]), war.I32(tt.args[2]))
+			if tt.trap != "" {
+				var trap *war.Trap
+				if !errors.As(err, &trap) || trap.Reason != tt.trap {
+					t.Errorf("got error %v, expected trap %q", err, tt.trap)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := contents(t, r, tt.get, tt.size); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableInit(t *testing.T) {
+	const a, b, c, d = "funcref:a", "funcref:b", "funcref:c", "funcref:d"
+	tests := []struct {
+		name string
+		drop bool
+		args [3]int32
+		want []string
+		trap string
+	}{
+		{"whole segment", false, [3]int32{2, 0, 4}, []string{a, b, d, c, b, a}, ""},
+		{"part of segment", false, [3]int32{0, 1, 2}, []string{c, b, c, d, "null", "null"}, ""},
+		{"dst out of bounds", false, [3]int32{3, 0, 4}, nil, "out of bounds table access"},
+		{"src out of bounds", false, [3]int32{0, 2, 3}, nil, "out of bounds table access"},
+		{"dropped segment", true, [3]int32{0, 0, 1}, nil, "out of bounds table access"},
+		{"empty from dropped segment", true, [3]int32{0, 0, 0}, []string{a, b, c, d, "null", "null"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := war.NewRuntime()
+			if _, err := r.Instantiate([]byte(tables)); err != nil {
+				t.Fatal(err)
+			}
+			if tt.drop {
+				if _, err := r.Invoke("drop"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := r.Invoke("init", war.I32(tt.args[0]), war.I32(tt.args[1]), war.I32(tt.args[2]))
+			if tt.trap != "" {
+				var trap *war.Trap
+				if !errors.As(err, &trap) || trap.Reason != tt.trap {
+					t.Errorf("got error %v, expected trap %q", err, tt.trap)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := contents(t, r, "get", 6); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxTableElems(t *testing.T) {
+	r := war.NewRuntime(war.WithMaxTableElems(5))
+	_, err := r.Instantiate([]byte(`(module
+  (table 2 funcref)
+  (func (export "grow") (param i32) (result i32)
+    (table.grow (ref.null func) (local.get 0))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the table has no maximum, the runtime allows 5 elements
+	for _, tt := range []struct{ n, expected int32 }{{3, 2}, {1, -1}, {0, 5}} {
+		got, err := r.Invoke("grow", war.I32(tt.n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0] != war.I32(tt.expected) {
+			t.Errorf("grow %d: got %v, expected %d", tt.n, got[0], tt.expected)
+		}
+	}
+
+	if _, err := r.Instantiate([]byte(`(module (table 6 funcref))`)); err == nil {
+		t.Error("expected an error instantiating a table over the limit")
+	}
+}
+
+func TestTableGrowInit(t *testing.T) {
+	r := war.NewRuntime()
+	_, err := r.Instantiate([]byte(`(module
+  (table $f 1 funcref)
+  (table $e 0 externref)
+  (func $a) (func $b)
+  (elem declare func $a $b)
+  (func (export "get") (param i32) (result funcref)
+    (table.get $f (local.get 0)))
+  (func (export "a") (result funcref) (ref.func $a))
+  (func (export "grow") (param i32) (result i32)
+    (table.grow $f (ref.func $b) (local.get 0)))
+  (func (export "grow_null") (param i32) (result i32)
+    (table.grow $f (ref.null func) (local.get 0)))
+  (func (export "fill") (param i32 i32)
+    (table.fill $f (local.get 0) (ref.func $a) (local.get 1)))
+  (func (export "get_e") (param i32) (result externref)
+    (table.get $e (local.get 0)))
+  (func (export "grow_e") (param externref i32) (result i32)
+    (table.grow $e (local.get 0) (local.get 1))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// new tables hold nulls of their element type
+	if got := contents(t, r, "get", 1); !slices.Equal(got, []string{"null"}) {
+		t.Errorf("got %v, expected a null element", got)
+	}
+
+	for _, step := range []struct {
+		call string
+		arg  int32
+		old  int32
+	}{{"grow", 2, 1}, {"grow_null", 1, 3}} {
+		got, err := r.Invoke(step.call, war.I32(step.arg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0].I32() != step.old {
+			t.Errorf("%s: got old size %d, expected %d", step.call, got[0].I32(), step.old)
+		}
+	}
+	elems := contents(t, r, "get", 4)
+	if elems[0] != "null" || elems[1] == "null" || elems[2] != elems[1] || elems[3] != "null" {
+		t.Errorf("got %v, expected the new slots set to the init value", elems)
+	}
+
+	if _, err := r.Invoke("fill", war.I32(2), war.I32(2)); err != nil {
+		t.Fatal(err)
+	}
+	a, err := r.Invoke("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	elems = contents(t, r, "get", 4)
+	if elems[1] == a[0].String() || elems[2] != a[0].String() || elems[3] != a[0].String() {
+		t.Errorf("got %v, expected the last two slots filled with %v", elems, a[0])
+	}
+
+	host := war.ExternRef("host")
+	if _, err := r.Invoke("grow_e", host, war.I32(2)); err != nil {
+		t.Fatal(err)
+	}
+	for i := range int32(2) {
+		got, err := r.Invoke("get_e", war.I32(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0].Extern() != "host" {
+			t.Errorf("slot %d: got %v, expected the host reference", i, got[0].Extern())
+		}
+	}
+}
+
+func TestCallIndirect(t *testing.T) {
+	const src = `(module
+  (type $unop (func (param i32) (result i32)))
+  (table $t 4 funcref)
+  (table $other 1 funcref)
+  (func $inc (type $unop) (i32.add (local.get 0) (i32.const 1)))
+  (func $double (param i32) (result i32) (i32.mul (local.get 0) (i32.const 2)))
+  (func $seven (result i32) (i32.const 7))
+  (elem (table $t) (i32.const 0) func $inc $double $seven)
+  (elem (table $other) (i32.const 0) func $seven)
+  (func (export "call") (param i32 i32) (result i32)
+    (call_indirect $t (type $unop) (local.get 1) (local.get 0)))
+  (func (export "call_flat") (param i32 i32) (result i32)
+    local.get 1
+    local.get 0
+    call_indirect (param i32) (result i32))
+  (func (export "call_other") (result i32)
+    (call_indirect 1 (result i32) (i32.const 0))))`
+
+	wasm, err := war.Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	disasm, err := war.Disassemble(wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []string{src, string(disasm)} {
+		for _, opts := range [][]war.RuntimeOption{nil, {war.WithTreeWalker()}} {
+			r := war.NewRuntime(opts...)
+			if _, err := r.Instantiate([]byte(src)); err != nil {
+				t.Fatalf("%v\n%s", err, src)
+			}
+			tests := []struct {
+				name string
+				args []war.Value
+				want int32
+				trap string
+			}{
+				{"call", []war.Value{war.I32(0), war.I32(10)}, 11, ""},
+				{"call_flat", []war.Value{war.I32(1), war.I32(10)}, 20, ""},
+				{"call_other", nil, 7, ""},
+				{"call", []war.Value{war.I32(2), war.I32(10)}, 0, "indirect call type mismatch"},
+				{"call", []war.Value{war.I32(3), war.I32(10)}, 0, "uninitialized element"},
+				{"call", []war.Value{war.I32(4), war.I32(10)}, 0, "undefined element"},
+				{"call_flat", []war.Value{war.I32(-1), war.I32(10)}, 0, "undefined element"},
+			}
+			for _, tt := range tests {
+				got, err := r.Invoke(tt.name, tt.args...)
+				if tt.trap != "" {
+					var trap *war.Trap
+					if !errors.As(err, &trap) || trap.Reason != tt.trap {
+						t.Errorf("%s%v: got %v, %v, expected a trap for %q", tt.name, tt.args, got, err, tt.trap)
+					}
+					continue
+				}
+				if err != nil || got[0] != war.I32(tt.want) {
+					t.Errorf("%s%v: got %v, %v, expected %d", tt.name, tt.args, got, err, tt.want)
+				}
+			}
+		}
+	}
+
+	for _, src := range []string{
+		`(module (func (call_indirect (type 0) (i32.const 0))))`,
+		`(module (type (func)) (func (call_indirect (type 0) (i32.const 0))))`,
+		`(module (type (func)) (table 1 externref) (func (call_indirect (type 0) (i32.const 0))))`,
+		`(module (type (func)) (table 1 funcref) (func (call_indirect (type 0) (i64.const 0))))`,
+		`(module (table 1 funcref) (func (call_indirect (param $x i32) (i32.const 0) (i32.const 0))))`,
+	} {
+		if _, err := war.NewRuntime().Instantiate([]byte(src)); err == nil {
+			t.Errorf("expected an error instantiating %s", src)
+		}
+	}
+}
+
+func TestTableIndexSpace(t *testing.T) {
+	r := war.NewRuntime()
+	err := r.Exec([]byte(`(module $lib
+  (table (export "t") 2 funcref)
+  (func $one (result i32) (i32.const 1))
+  (elem (i32.const 0) func $one))
+(register "lib" $lib)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the imported table is table 0 and the defined one table 1
+	_, err = r.Instantiate([]byte(`(module
+  (import "lib" "t" (table $imported 2 funcref))
+  (table $defined 5 funcref)
+  (func $two (result i32) (i32.const 2))
+  (elem (table 1) (i32.const 0) func $two)
+  (func (export "sizes") (result i32 i32 i32 i32)
+    (table.size 0) (table.size 1) (table.size $imported) (table.size $defined))
+  (func (export "calls") (result i32 i32)
+    (call_indirect 0 (result i32) (i32.const 0))
+    (call_indirect $defined (result i32) (i32.const 0))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		want []war.Value
+	}{
+		{"sizes", []war.Value{war.I32(2), war.I32(5), war.I32(2), war.I32(5)}},
+		{"calls", []war.Value{war.I32(1), war.I32(2)}},
+	} {
+		got, err := r.Invoke(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := war.CompileModule([]byte(`(module
+  (import "lib" "t" (table 2 funcref))
+  (func (drop (table.size 1))))`)); err == nil || !strings.Contains(err.Error(), "unknown table 1") {
+		t.Errorf("got error %v, expected an unknown table", err)
+	}
+}
@@ -0,0 +1,54 @@
+;; branch checks control flow, which the bytecode lowers to jumps.
+(module
+  (func (export "switch") (param i32) (result i32)
+    (block $default
+      (block $two
+        (block $one
+          (block $zero
+            (br_table $zero $one $two $default (local.get 0)))
+          (return (i32.const 10)))
+        (return (i32.const 11)))
+      (return (i32.const 12)))
+    (i32.const 99))
+  (func (export "sum") (param i32) (result i32)
+    (local $s i32)
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get 0)))
+        (local.set $s (i32.add (local.get $s) (local.get 0)))
+        (local.set 0 (i32.sub (local.get 0) (i32.const 1)))
+        (br $next)))
+    (local.get $s))
+  (func (export "loop_params") (param i32) (result i32)
+    (i32.const 0)
+    (loop $l (param i32) (result i32)
+      (i32.add (local.get 0))
+      (local.tee 0 (i32.sub (local.get 0) (i32.const 1)))
+      (br_if $l)))
+  (func (export "if_else") (param i32) (result i32)
+    (if (result i32) (local.get 0)
+      (then (if (result i32) (i32.gt_s (local.get 0) (i32.const 0))
+        (then (i32.const 1))
+        (else (i32.const -1))))
+      (else (i32.const 0))))
+  (func (export "br_value") (result i32)
+    (i32.add
+      (block (result i32) (drop (br_if 0 (i32.const 5) (i32.const 1))) (i32.const 6))
+      (i32.const 100)))
+  (func (export "unreachable") (param i32) (result i32)
+    (if (local.get 0) (then (unreachable)))
+    (i32.const 1)))
+
+(assert_return (invoke "switch" (i32.const 0)) (i32.const 10))
+(assert_return (invoke "switch" (i32.const 1)) (i32.const 11))
+(assert_return (invoke "switch" (i32.const 2)) (i32.const 12))
+(assert_return (invoke "switch" (i32.const 3)) (i32.const 99))
+(assert_return (invoke "switch" (i32.const -1)) (i32.const 99))
+(assert_return (invoke "sum" (i32.const 100)) (i32.const 5050))
+(assert_return (invoke "loop_params" (i32.const 4)) (i32.const 10))
+(assert_return (invoke "if_else" (i32.const 5)) (i32.const 1))
+(assert_return (invoke "if_else" (i32.const -5)) (i32.const -1))
+(assert_return (invoke "if_else" (i32.const 0)) (i32.const 0))
+(assert_return (invoke "br_value") (i32.const 105))
+(assert_return (invoke "unreachable" (i32.const 0)) (i32.const 1))
+(assert_trap (invoke "unreachable" (i32.const 1)) "unreachable")
@@ -0,0 +1,19 @@
+(module
+  (type $binop (func (param i32 i32) (result i32)))
+  (table 3 funcref)
+  (func $add (type $binop) (i32.add (local.get 0) (local.get 1)))
+  (func $sub (type $binop) (i32.sub (local.get 0) (local.get 1)))
+  (elem (i32.const 0) $add $sub)
+  (func (export "apply") (param i32 i32 i32) (result i32)
+    (call_indirect (type $binop)
+      (local.get 1) (local.get 2) (local.get 0)))
+  ;; the operands fold to constants
+  (func (export "apply_const") (result i32)
+    (call_indirect (type $binop)
+      (i32.add (i32.const 1) (i32.const 2)) (i32.const 4) (i32.sub (i32.const 2) (i32.const 1)))))
+
+(assert_return (invoke "apply" (i32.const 0) (i32.const 5) (i32.const 3)) (i32.const 8))
+(assert_return (invoke "apply" (i32.const 1) (i32.const 5) (i32.const 3)) (i32.const 2))
+(assert_return (invoke "apply_const") (i32.const -1))
+(assert_trap (invoke "apply" (i32.const 2) (i32.const 5) (i32.const 3)) "uninitialized element")
+(assert_trap (invoke "apply" (i32.const 3) (i32.const 5) (i32.const 3)) "undefined element")
@@ -0,0 +1,13 @@
+;; copy moves n bytes between two halves of memory one i64 at a time, then
+;; reads a byte back.
+(module
+  (memory 1)
+  (func (export "copy") (param $n i32) (result i32)
+    (local $i i32)
+    (block $done
+      (loop $top
+        (br_if $done (i32.ge_u (local.get $i) (local.get $n)))
+        (i64.store offset=32768 (local.get $i) (i64.load (local.get $i)))
+        (local.set $i (i32.add (local.get $i) (i32.const 8)))
+        (br $top)))
+    (i32.load8_u offset=32768 (i32.const 0))))
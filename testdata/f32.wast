@@ -0,0 +1,25 @@
+;; f32 arithmetic rounds every result to 32 bits, so accumulating gives
+;; other results than computing in f64 and narrowing at the end.
+(module
+  (func (export "sum") (param $x f32) (param $n i32) (result f32)
+    (local $acc f32)
+    (loop $top
+      (local.set $acc (f32.add (local.get $acc) (local.get $x)))
+      (br_if $top (local.tee $n (i32.sub (local.get $n) (i32.const 1)))))
+    (local.get $acc))
+  (func (export "sum_f64") (param $x f32) (param $n i32) (result f32)
+    (local $acc f64)
+    (loop $top
+      (local.set $acc (f64.add (local.get $acc) (f64.promote_f32 (local.get $x))))
+      (br_if $top (local.tee $n (i32.sub (local.get $n) (i32.const 1)))))
+    (f32.demote_f64 (local.get $acc)))
+  ;; the optimizer folds these, rounding the same way
+  (func (export "past_exact") (result f32)
+    (f32.add (f32.add (f32.const 16777216) (f32.const 1)) (f32.const 1)))
+  (func (export "past_exact_f64") (result f32)
+    (f32.demote_f64 (f64.add (f64.add (f64.const 16777216) (f64.const 1)) (f64.const 1)))))
+
+(assert_return (invoke "sum" (f32.const 0.1) (i32.const 10)) (f32.const 0x1.000002p+0))
+(assert_return (invoke "sum_f64" (f32.const 0.1) (i32.const 10)) (f32.const 0x1.000000p+0))
+(assert_return (invoke "past_exact") (f32.const 16777216))
+(assert_return (invoke "past_exact_f64") (f32.const 16777218))
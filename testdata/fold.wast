@@ -0,0 +1,43 @@
+;; fold checks arithmetic on constants, which the optimizer folds, against
+;; the same arithmetic on params, which it can't.
+(module
+  (func (export "add") (result i32) (i32.add (i32.const 0x7fffffff) (i32.const 1)))
+  (func (export "add_p") (param i32 i32) (result i32) (i32.add (local.get 0) (local.get 1)))
+  (func (export "shr_s") (result i64) (i64.shr_s (i64.const -16) (i64.const 66)))
+  (func (export "rotl") (result i32) (i32.rotl (i32.const 0x80000001) (i32.const 33)))
+  (func (export "clz") (result i64) (i64.clz (i64.const 1)))
+  (func (export "wrap") (result i32) (i32.wrap_i64 (i64.const 0x1_2345_6789)))
+  (func (export "lt_u") (result i32) (i32.lt_u (i32.const -1) (i32.const 1)))
+  (func (export "div_s") (result i32) (i32.div_s (i32.const -7) (i32.const 2)))
+  (func (export "div_zero") (result i32) (i32.div_u (i32.const 1) (i32.const 0)))
+  (func (export "div_overflow") (result i64) (i64.div_s (i64.const 0x8000000000000000) (i64.const -1)))
+  (func (export "fmin") (result f32) (f32.min (f32.const -0) (f32.const 0)))
+  (func (export "fdiv") (result f64) (f64.div (f64.const 1) (f64.const 0)))
+  (func (export "fnan") (result i32) (f64.eq (f64.div (f64.const 0) (f64.const 0)) (f64.const 0)))
+  (func (export "nearest") (result f32) (f32.nearest (f32.const 2.5)))
+  (func (export "flat") (result i32)
+    i32.const 6
+    i32.const 7
+    i32.mul
+    i32.const 2
+    i32.sub)
+  (func (export "dead") (result i32)
+    (return (i32.const 1))
+    (unreachable)))
+
+(assert_return (invoke "add") (i32.const 0x80000000))
+(assert_return (invoke "add_p" (i32.const 0x7fffffff) (i32.const 1)) (i32.const 0x80000000))
+(assert_return (invoke "shr_s") (i64.const -4))
+(assert_return (invoke "rotl") (i32.const 3))
+(assert_return (invoke "clz") (i64.const 63))
+(assert_return (invoke "wrap") (i32.const 0x23456789))
+(assert_return (invoke "lt_u") (i32.const 0))
+(assert_return (invoke "div_s") (i32.const -3))
+(assert_trap (invoke "div_zero") "integer divide by zero")
+(assert_trap (invoke "div_overflow") "integer overflow")
+(assert_return (invoke "fmin") (f32.const -0))
+(assert_return (invoke "fdiv") (f64.const inf))
+(assert_return (invoke "fnan") (i32.const 0))
+(assert_return (invoke "nearest") (f32.const 2))
+(assert_return (invoke "flat") (i32.const 40))
+(assert_return (invoke "dead") (i32.const 1))
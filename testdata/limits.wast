@@ -0,0 +1,23 @@
+;; limits checks the sizes tables and memories can be declared with.
+(module
+  (table 0 0 funcref)
+  (table 1 0xffff_ffff externref)
+  (memory 0 65536)
+  (func (export "size") (result i32) (memory.size)))
+
+(assert_return (invoke "size") (i32.const 0))
+
+(assert_invalid (module (table 2 1 funcref))
+  "size minimum must not be greater than maximum")
+(assert_invalid (module (memory 2 1))
+  "size minimum must not be greater than maximum")
+(assert_invalid (module (memory 65537))
+  "memory size must be at most 65536 pages (4GiB)")
+(assert_invalid (module (memory 1 65537))
+  "memory size must be at most 65536 pages (4GiB)")
+(assert_invalid (module (memory 0xffff_ffff))
+  "memory size must be at most 65536 pages (4GiB)")
+(assert_invalid (module (import "env" "mem" (memory 65537)))
+  "memory size must be at most 65536 pages (4GiB)")
+(assert_invalid (module (import "env" "tab" (table 1 0 funcref)))
+  "size minimum must not be greater than maximum")
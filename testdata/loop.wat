@@ -0,0 +1,12 @@
+;; sum adds up the integers below n in a tight loop.
+(module
+  (func (export "sum") (param $n i32) (result i32)
+    (local $i i32)
+    (local $acc i32)
+    (block $done
+      (loop $top
+        (br_if $done (i32.ge_u (local.get $i) (local.get $n)))
+        (local.set $acc (i32.add (local.get $acc) (local.get $i)))
+        (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        (br $top)))
+    (local.get $acc)))
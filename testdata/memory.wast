@@ -0,0 +1,28 @@
+;; memory checks loads, stores and bulk operations on linear memory.
+(module
+  (memory 1)
+  (data (i32.const 8) "\01\02\03\04\05\06\07\08")
+  (func (export "load") (param i32) (result i64) (i64.load (local.get 0)))
+  (func (export "load8_s") (param i32) (result i32) (i32.load8_s offset=2 (local.get 0)))
+  (func (export "store") (param i32 i32) (result i32)
+    (i32.store16 (local.get 0) (local.get 1))
+    (i32.load (local.get 0)))
+  (func (export "fill") (param i32 i32 i32) (result i32)
+    (memory.fill (local.get 0) (local.get 1) (local.get 2))
+    (i32.load8_u (local.get 0)))
+  (func (export "copy") (result i64)
+    (memory.copy (i32.const 9) (i32.const 8) (i32.const 8))
+    (i64.load (i32.const 9)))
+  (func (export "grow") (result i32)
+    (drop (memory.grow (i32.const 1)))
+    (memory.size)))
+
+(assert_return (invoke "load" (i32.const 8)) (i64.const 0x0807060504030201))
+(assert_trap (invoke "load" (i32.const 65530)) "out of bounds memory access")
+(assert_return (invoke "load8_s" (i32.const 6)) (i32.const 1))
+(assert_return (invoke "store" (i32.const 100) (i32.const 0x12345)) (i32.const 0x2345))
+(assert_return (invoke "fill" (i32.const 200) (i32.const 0xff) (i32.const 4)) (i32.const 0xff))
+(assert_trap (invoke "fill" (i32.const 65535) (i32.const 0) (i32.const 2)) "out of bounds memory access")
+(assert_return (invoke "copy") (i64.const 0x0807060504030201))
+(assert_return (invoke "grow") (i32.const 2))
+(assert_return (invoke "load" (i32.const 65530)) (i64.const 0))
@@ -0,0 +1,31 @@
+;; shift and rotate counts are taken modulo the width of the operands
+(module
+  (func (export "i32.shl") (param i32 i32) (result i32) (i32.shl (local.get 0) (local.get 1)))
+  (func (export "i32.shr_s") (param i32 i32) (result i32) (i32.shr_s (local.get 0) (local.get 1)))
+  (func (export "i32.shr_u") (param i32 i32) (result i32) (i32.shr_u (local.get 0) (local.get 1)))
+  (func (export "i32.rotl") (param i32 i32) (result i32) (i32.rotl (local.get 0) (local.get 1)))
+  (func (export "i32.rotr") (param i32 i32) (result i32) (i32.rotr (local.get 0) (local.get 1)))
+  (func (export "i64.shl") (param i64 i64) (result i64) (i64.shl (local.get 0) (local.get 1)))
+  (func (export "i64.shr_s") (param i64 i64) (result i64) (i64.shr_s (local.get 0) (local.get 1)))
+  (func (export "i64.shr_u") (param i64 i64) (result i64) (i64.shr_u (local.get 0) (local.get 1)))
+  (func (export "i64.rotl") (param i64 i64) (result i64) (i64.rotl (local.get 0) (local.get 1)))
+  (func (export "i64.rotr") (param i64 i64) (result i64) (i64.rotr (local.get 0) (local.get 1)))
+  (func (export "const") (result i32)
+    (i32.shl (i32.const 1) (i32.const 33))))
+
+(assert_return (invoke "i32.shl" (i32.const 1) (i32.const 33)) (i32.const 2))
+(assert_return (invoke "i32.shl" (i32.const 1) (i32.const 32)) (i32.const 1))
+(assert_return (invoke "i32.shl" (i32.const 1) (i32.const -1)) (i32.const 0x80000000))
+(assert_return (invoke "i32.shr_s" (i32.const 0x80000000) (i32.const 33)) (i32.const 0xc0000000))
+(assert_return (invoke "i32.shr_u" (i32.const 0x80000000) (i32.const 33)) (i32.const 0x40000000))
+(assert_return (invoke "i32.rotl" (i32.const 0x80000001) (i32.const 33)) (i32.const 3))
+(assert_return (invoke "i32.rotr" (i32.const 3) (i32.const 33)) (i32.const 0x80000001))
+(assert_return (invoke "const") (i32.const 2))
+
+(assert_return (invoke "i64.shl" (i64.const 1) (i64.const 65)) (i64.const 2))
+(assert_return (invoke "i64.shl" (i64.const 1) (i64.const 64)) (i64.const 1))
+(assert_return (invoke "i64.shr_s" (i64.const 0x8000000000000000) (i64.const 65)) (i64.const 0xc000000000000000))
+(assert_return (invoke "i64.shr_u" (i64.const 0x8000000000000000) (i64.const 65)) (i64.const 0x4000000000000000))
+(assert_return (invoke "i64.shr_u" (i64.const -1) (i64.const 0x7fffffffffffffff)) (i64.const 1))
+(assert_return (invoke "i64.rotl" (i64.const 0x8000000000000001) (i64.const 65)) (i64.const 3))
+(assert_return (invoke "i64.rotr" (i64.const 3) (i64.const 65)) (i64.const 0x8000000000000001))
@@ -0,0 +1,120 @@
+package text
+
+import "fmt"
+
+// Command is a top-level command of a script. The parser returns them in
+// order through Commands.
+type Command interface {
+	command()
+}
+
+// ModuleCommand defines a module, which becomes the current module.
+type ModuleCommand struct {
+	Module *Node
+}
+
+// RegisterCommand makes the exports of a module available for import under
+// Name. Module is the id of the module, empty for the current one.
+type RegisterCommand struct {
+	Name   string
+	Module string
+}
+
+// InvokeCommand calls the function exported as Name by a module with
+// constant arguments. Module is the id of the module, empty for the current
+// one.
+type InvokeCommand struct {
+	Module string
+	Name   string
+	Args   []*Node
+}
+
+// AssertReturnCommand asserts that an invocation returns the constants in
+// Results.
+type AssertReturnCommand struct {
+	Invoke  *InvokeCommand
+	Results []*Node
+}
+
+// AssertTrapCommand asserts that either an invocation or the instantiation
+// of a module traps with Reason.
+type AssertTrapCommand struct {
+	Invoke *InvokeCommand // nil when Module is set
+	Module *Node
+	Reason string
+}
+
+// AssertUnlinkableCommand asserts that a module fails to link with Reason.
+type AssertUnlinkableCommand struct {
+	Module *Node
+	Reason string
+}
+
+// AssertInvalidCommand asserts that a module fails to validate with Reason.
+type AssertInvalidCommand struct {
+	Module *Node
+	Reason string
+}
+
+func (*ModuleCommand) command()           {}
+func (*RegisterCommand) command()         {}
+func (*InvokeCommand) command()           {}
+func (*AssertReturnCommand) command()     {}
+func (*AssertTrapCommand) command()       {}
+func (*AssertUnlinkableCommand) command() {}
+func (*AssertInvalidCommand) command()    {}
+
+// Commands returns the commands of the script read by Parse. A module
+// without the module form is a ModuleCommand like any other.
+func (p *Parser) Commands() []Command {
+	return p.commands
+}
+
+// command returns the command of a top-level node.
+func (p *Parser) command(n *Node) Command {
+	switch n.Op {
+	case OpModule:
+		return &ModuleCommand{Module: n}
+	case OpRegister:
+		atoms := Fields(n.Meta)
+		c := &RegisterCommand{Name: p.unquote(atoms[0])}
+		if len(atoms) > 1 {
+			c.Module = atoms[1]
+		}
+		return c
+	case OpInvoke:
+		return p.invokeCommand(n)
+	case OpAssertReturn:
+		return &AssertReturnCommand{Invoke: p.invokeCommand(n.Args[0]), Results: n.Args[1:]}
+	case OpAssertTrap:
+		c := &AssertTrapCommand{Reason: p.unquote(n.Meta)}
+		if action := n.Args[0]; action.Op == OpModule {
+			c.Module = action
+		} else {
+			c.Invoke = p.invokeCommand(action)
+		}
+		return c
+	case OpAssertUnlinkable:
+		return &AssertUnlinkableCommand{Module: n.Args[0], Reason: p.unquote(n.Meta)}
+	case OpAssertInvalid:
+		return &AssertInvalidCommand{Module: n.Args[0], Reason: p.unquote(n.Meta)}
+	}
+	panic(fmt.Sprintf("unexpected %s command", n.Op))
+}
+
+func (p *Parser) invokeCommand(n *Node) *InvokeCommand {
+	atoms := Fields(n.Meta)
+	c := &InvokeCommand{Name: p.unquote(atoms[len(atoms)-1]), Args: n.Args}
+	if len(atoms) > 1 {
+		c.Module = atoms[0]
+	}
+	return c
+}
+
+func (p *Parser) unquote(s string) string {
+	b, err := Unquote(s)
+	if err != nil {
+		p.errorf("%v", err)
+	}
+	return string(b)
+}
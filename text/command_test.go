@@ -0,0 +1,74 @@
+package text_test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bluescreen10/war/text"
+)
+
+func TestCommands(t *testing.T) {
+	p := text.NewParser([]byte(`(module $m
+  (func (export "div") (param i32 i32) (result i32)
+    (i32.div_s (local.get 0) (local.get 1))))
+(register "lib" $m)
+(invoke "div" (i32.const 1) (i32.const 1))
+(assert_return (invoke $m "div" (i32.const 6) (i32.const 3)) (i32.const 2))
+(assert_trap (invoke "div" (i32.const 1) (i32.const 0)) "integer divide by zero")
+(assert_trap (module (func $f unreachable) (start $f)) "unreachable")
+(assert_unlinkable (module (import "lib" "missing" (func))) "unknown import")
+(assert_invalid (module (memory 2 1)) "size minimum must not be greater than maximum")`))
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	// nodes are written in the text format
+	format := func(nodes ...*text.Node) string {
+		var s []string
+		for _, n := range nodes {
+			s = append(s, strings.TrimSpace(string(text.Format(n, text.FormatOptions{Folded: true}))))
+		}
+		return strings.Join(s, " ")
+	}
+	var got []string
+	for _, cmd := range p.Commands() {
+		var s string
+		switch c := cmd.(type) {
+		case *text.ModuleCommand:
+			s = fmt.Sprintf("module %s", c.Module.Meta)
+		case *text.RegisterCommand:
+			s = fmt.Sprintf("register %q %s", c.Name, c.Module)
+		case *text.InvokeCommand:
+			s = fmt.Sprintf("invoke %s %q %s", c.Module, c.Name, format(c.Args...))
+		case *text.AssertReturnCommand:
+			s = fmt.Sprintf("assert_return %s %q %s -> %s", c.Invoke.Module, c.Invoke.Name, format(c.Invoke.Args...), format(c.Results...))
+		case *text.AssertTrapCommand:
+			if c.Module != nil {
+				s = fmt.Sprintf("assert_trap module %q", c.Reason)
+			} else {
+				s = fmt.Sprintf("assert_trap %q %s %q", c.Invoke.Name, format(c.Invoke.Args...), c.Reason)
+			}
+		case *text.AssertUnlinkableCommand:
+			s = fmt.Sprintf("assert_unlinkable %q", c.Reason)
+		case *text.AssertInvalidCommand:
+			s = fmt.Sprintf("assert_invalid %s %q", format(c.Module.Args...), c.Reason)
+		}
+		got = append(got, s)
+	}
+
+	want := []string{
+		`module $m`,
+		`register "lib" $m`,
+		`invoke  "div" (i32.const 1) (i32.const 1)`,
+		`assert_return $m "div" (i32.const 6) (i32.const 3) -> (i32.const 2)`,
+		`assert_trap "div" (i32.const 1) (i32.const 0) "integer divide by zero"`,
+		`assert_trap module "unreachable"`,
+		`assert_unlinkable "unknown import"`,
+		`assert_invalid (memory 2 1) "size minimum must not be greater than maximum"`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got\n%s\nexpected\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
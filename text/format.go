@@ -0,0 +1,560 @@
+package text
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// FormatOptions controls the layout of the text produced by Format.
+type FormatOptions struct {
+	// Folded nests the operands of instructions as S-expressions. Otherwise
+	// instructions are written one per line.
+	Folded bool
+	// Indent is the indentation unit, two spaces if empty.
+	Indent string
+}
+
+// Format writes the tree rooted at n back in the text format.
+func Format(n *Node, opts FormatOptions) []byte {
+	if opts.Indent == "" {
+		opts.Indent = "  "
+	}
+	p := &printer{opts: opts}
+	p.format(n)
+	p.buf.WriteByte('\n')
+	return p.buf.Bytes()
+}
+
+type signature struct {
+	params, results int
+}
+
+type printer struct {
+	opts  FormatOptions
+	buf   bytes.Buffer
+	depth int
+
+	// signatures of the functions of the current module, by index and id
+	funcs map[string]signature
+}
+
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+	for i := 0; i < p.depth; i++ {
+		p.buf.WriteString(p.opts.Indent)
+	}
+}
+
+func (p *printer) format(n *Node) {
+	for _, c := range n.Comments {
+		p.buf.WriteString(c)
+		p.newline()
+	}
+	switch n.Op {
+	case OpScript:
+		for i, c := range n.Args {
+			if i > 0 {
+				p.buf.WriteByte('\n')
+			}
+			p.format(c)
+		}
+	case OpModule:
+		p.module(n)
+	case OpFunc:
+		p.function(n)
+	case OpGlobal:
+		p.global(n)
+	case OpElem:
+		p.elem(n)
+	case OpData:
+		p.data(n)
+	default:
+		p.inline(n)
+	}
+}
+
+// inline writes n and its children on a single line.
+func (p *printer) inline(n *Node) {
+	p.buf.WriteByte('(')
+	p.head(n)
+	for _, c := range n.Args {
+		p.buf.WriteByte(' ')
+		p.inline(c)
+	}
+	p.buf.WriteByte(')')
+}
+
+// head writes the keyword and the atoms of n.
+func (p *printer) head(n *Node) {
+	p.buf.WriteString(n.Op.String())
+	if n.Meta != "" {
+		p.buf.WriteByte(' ')
+		p.buf.WriteString(n.Meta)
+	}
+}
+
+func (p *printer) module(n *Node) {
+	p.funcs = moduleSignatures(n)
+	p.buf.WriteString("(module")
+	if n.Meta != "" {
+		p.buf.WriteByte(' ')
+		p.buf.WriteString(n.Meta)
+	}
+	p.depth++
+	for _, f := range n.Args {
+		p.newline()
+		p.format(f)
+	}
+	p.depth--
+	p.buf.WriteByte(')')
+}
+
+func (p *printer) function(n *Node) {
+	p.buf.WriteString("(func")
+	if n.Meta != "" {
+		p.buf.WriteByte(' ')
+		p.buf.WriteString(n.Meta)
+	}
+
+	var body []*Node
+	p.depth++
+	for _, a := range n.Args {
+		switch a.Op {
+		case OpTypeUse, OpParam, OpResult:
+			p.buf.WriteByte(' ')
+			p.inline(a)
+		case OpLocal:
+			p.newline()
+			p.inline(a)
+		default:
+			body = append(body, a)
+		}
+	}
+	p.instrs(body)
+	p.depth--
+	p.buf.WriteByte(')')
+}
+
+func (p *printer) global(n *Node) {
+	p.buf.WriteString("(global")
+	if n.Meta != "" {
+		p.buf.WriteByte(' ')
+		p.buf.WriteString(n.Meta)
+	}
+	for _, a := range n.Args {
+		p.buf.WriteByte(' ')
+		p.expr(a)
+	}
+	p.buf.WriteByte(')')
+}
+
+func (p *printer) data(n *Node) {
+	p.buf.WriteString("(data")
+	var strs []string
+	for _, f := range Fields(n.Meta) {
+		if strings.HasPrefix(f, "$") {
+			p.buf.WriteByte(' ')
+			p.buf.WriteString(f)
+		} else {
+			strs = append(strs, f)
+		}
+	}
+	for _, a := range n.Args {
+		p.buf.WriteByte(' ')
+		if a.Op == OpOffset && len(a.Args) == 1 {
+			// abbreviated offset
+			p.expr(a.Args[0])
+			continue
+		}
+		p.buf.WriteByte('(')
+		p.head(a)
+		for _, c := range a.Args {
+			p.buf.WriteByte(' ')
+			p.expr(c)
+		}
+		p.buf.WriteByte(')')
+	}
+	for _, s := range strs {
+		p.buf.WriteByte(' ')
+		p.buf.WriteString(s)
+	}
+	p.buf.WriteByte(')')
+}
+
+// elem writes an element segment, with its type after the table and offset.
+func (p *printer) elem(n *Node) {
+	p.buf.WriteString("(elem")
+	fields := Fields(n.Meta)
+	for _, f := range fields[:len(fields)-1] {
+		p.buf.WriteByte(' ')
+		p.buf.WriteString(f)
+	}
+	for _, a := range n.Args {
+		if a.Op == OpItem {
+			continue
+		}
+		p.buf.WriteByte(' ')
+		if a.Op == OpOffset && len(a.Args) == 1 {
+			p.expr(a.Args[0])
+		} else {
+			p.expr(a)
+		}
+	}
+	p.buf.WriteByte(' ')
+	p.buf.WriteString(fields[len(fields)-1])
+	for _, a := range n.Args {
+		if a.Op == OpItem {
+			p.buf.WriteByte(' ')
+			p.expr(a)
+		}
+	}
+	p.buf.WriteByte(')')
+}
+
+// expr writes a constant expression, which is always folded on one line.
+func (p *printer) expr(n *Node) {
+	if !n.Op.IsInstr() {
+		p.inline(n)
+		return
+	}
+	p.buf.WriteByte('(')
+	p.head(n)
+	for _, c := range n.Args {
+		p.buf.WriteByte(' ')
+		p.expr(c)
+	}
+	p.buf.WriteByte(')')
+}
+
+// instrs writes a sequence of instructions, each one on its own line.
+func (p *printer) instrs(code []*Node) {
+	if p.opts.Folded {
+		for _, n := range p.fold(code) {
+			p.newline()
+			p.folded(n)
+		}
+		return
+	}
+	for _, n := range code {
+		p.flat(n)
+	}
+}
+
+// flat writes n in its flat form, preceded by its folded operands.
+func (p *printer) flat(n *Node) {
+	var operands, body []*Node
+	var then, els *Node
+	var blocktype []*Node
+	for _, a := range n.Args {
+		switch {
+		case a.Op == OpThen:
+			then = a
+		case a.Op == OpElse:
+			els = a
+		case !a.Op.IsInstr():
+			blocktype = append(blocktype, a)
+		case n.Op == OpBlock || n.Op == OpLoop:
+			body = append(body, a)
+		default:
+			operands = append(operands, a)
+		}
+	}
+
+	for _, o := range operands {
+		p.flat(o)
+	}
+
+	p.newline()
+	p.head(n)
+	for _, b := range blocktype {
+		p.buf.WriteByte(' ')
+		p.inline(b)
+	}
+
+	switch n.Op {
+	case OpBlock, OpLoop:
+		p.depth++
+		p.instrs(body)
+		p.depth--
+		p.newline()
+		p.buf.WriteString("end")
+	case OpIf:
+		p.depth++
+		if then != nil {
+			p.instrs(then.Args)
+		}
+		p.depth--
+		if els != nil {
+			p.newline()
+			p.buf.WriteString("else")
+			p.depth++
+			p.instrs(els.Args)
+			p.depth--
+		}
+		p.newline()
+		p.buf.WriteString("end")
+	}
+}
+
+// folded writes n as an S-expression, nesting its operands.
+func (p *printer) folded(n *Node) {
+	p.buf.WriteByte('(')
+	p.head(n)
+
+	switch n.Op {
+	case OpBlock, OpLoop, OpIf:
+		var body []*Node
+		p.depth++
+		for _, a := range n.Args {
+			switch {
+			case a.Op == OpThen || a.Op == OpElse:
+				p.newline()
+				p.buf.WriteByte('(')
+				p.head(a)
+				p.depth++
+				p.instrs(a.Args)
+				p.depth--
+				p.buf.WriteByte(')')
+			case !a.Op.IsInstr():
+				p.buf.WriteByte(' ')
+				p.inline(a)
+			case n.Op == OpIf:
+				p.newline()
+				p.folded(a)
+			default:
+				body = append(body, a)
+			}
+		}
+		p.instrs(body)
+		p.depth--
+	default:
+		for _, a := range n.Args {
+			p.buf.WriteByte(' ')
+			p.folded(a)
+		}
+	}
+	p.buf.WriteByte(')')
+}
+
+// fold nests the operands of each instruction of code into it when they are
+// produced by the instructions right before it. Instructions whose stack
+// effect is unknown are left alone, so the evaluation order never changes.
+func (p *printer) fold(code []*Node) []*Node {
+	type item struct {
+		n       *Node
+		results int // -1 when unknown
+	}
+
+	var out []item
+	for _, n := range code {
+		params, results, ok := p.stackEffect(n)
+		if !ok {
+			out = append(out, item{n, -1})
+			continue
+		}
+
+		// the operands of a block or loop would read as its first instructions
+		folds := n.Op != OpBlock && n.Op != OpLoop
+		if folds && len(operands(n)) == 0 && params > 0 && params <= len(out) {
+			foldable := true
+			for _, o := range out[len(out)-params:] {
+				if o.results != 1 {
+					foldable = false
+				}
+			}
+			if foldable {
+				// operands go after the block type of a folded if
+				c := *n
+				c.Args = nil
+				for _, a := range n.Args {
+					if !isBody(a) {
+						c.Args = append(c.Args, a)
+					}
+				}
+				for _, o := range out[len(out)-params:] {
+					c.Args = append(c.Args, o.n)
+				}
+				for _, a := range n.Args {
+					if isBody(a) {
+						c.Args = append(c.Args, a)
+					}
+				}
+				n = &c
+				out = out[:len(out)-params]
+			}
+		}
+		if n.Op == OpBlock || n.Op == OpLoop || n.Op == OpIf {
+			// blocks span several lines, keep them out of other expressions
+			results = -1
+		}
+		out = append(out, item{n, results})
+	}
+
+	folded := make([]*Node, len(out))
+	for i, o := range out {
+		folded[i] = o.n
+	}
+	return folded
+}
+
+// isBody reports whether a is part of the body of an instruction rather
+// than of its immediates or block type.
+func isBody(a *Node) bool {
+	return a.Op.IsInstr() || a.Op == OpThen || a.Op == OpElse
+}
+
+// operands returns the folded operands of an instruction.
+func operands(n *Node) []*Node {
+	var ops []*Node
+	for _, a := range n.Args {
+		if a.Op.IsInstr() && n.Op != OpBlock && n.Op != OpLoop {
+			ops = append(ops, a)
+		}
+	}
+	return ops
+}
+
+// stackEffect returns the number of values an instruction pops and pushes.
+func (p *printer) stackEffect(n *Node) (int, int, bool) {
+	switch n.Op {
+	case OpBlock, OpLoop, OpIf:
+		params, results := 0, 0
+		typeUse := false
+		for _, a := range n.Args {
+			switch a.Op {
+			case OpTypeUse:
+				typeUse = true
+			case OpParam:
+				params += len(Fields(a.Meta))
+			case OpResult:
+				results += len(Fields(a.Meta))
+			}
+		}
+		if typeUse && params == 0 && results == 0 {
+			// only known from the type
+			return 0, 0, false
+		}
+		if n.Op == OpIf {
+			return params + 1, results, true
+		}
+		return params, results, true
+	case OpCall:
+		sig, ok := p.funcs[n.Meta]
+		return sig.params, sig.results, ok
+	}
+	return arity(n.Op)
+}
+
+// arity returns the stack effect of instructions that don't depend on their
+// context.
+func arity(op Op) (int, int, bool) {
+	name := op.String()
+	memory := isMemoryAccess(op)
+	switch {
+	case op >= OpI32Const && op <= OpV128Const:
+		return 0, 1, true
+	case op >= OpI32Clz && op <= OpF64Nearest:
+		return 1, 1, true
+	case op >= OpI32Add && op <= OpF64Copysign:
+		return 2, 1, true
+	case op == OpI32Eqz || op == OpI64Eqz:
+		return 1, 1, true
+	case op >= OpI32Eq && op <= OpF64Ge:
+		return 2, 1, true
+	case op >= OpI32WrapI64 && op <= OpI64ReinterpretF64:
+		return 1, 1, true
+	case memory && strings.HasSuffix(name, "_lane"):
+		if strings.Contains(name, ".store") {
+			return 2, 0, true
+		}
+		return 2, 1, true
+	case memory && strings.Contains(name, ".store"):
+		return 2, 0, true
+	case memory:
+		return 1, 1, true
+	}
+
+	switch op {
+	case OpNop, OpUnreachable, OpElemDrop, OpDataDrop:
+		return 0, 0, true
+	case OpRefNull, OpRefFunc, OpTableSize:
+		return 0, 1, true
+	case OpRefIsNull, OpTableGet:
+		return 1, 1, true
+	case OpTableSet:
+		return 2, 0, true
+	case OpTableGrow:
+		return 2, 1, true
+	case OpTableFill, OpTableCopy, OpTableInit, OpMemoryInit:
+		return 3, 0, true
+	case OpDrop, OpLocalSet, OpGlobalSet:
+		return 1, 0, true
+	case OpLocalGet, OpGlobalGet, OpMemorySize:
+		return 0, 1, true
+	case OpLocalTee, OpMemoryGrow:
+		return 1, 1, true
+	case OpSelect:
+		return 3, 1, true
+	}
+	return 0, 0, false
+}
+
+// moduleSignatures collects the number of params and results of every
+// function of a module, imported or not, keyed by both index and id.
+func moduleSignatures(m *Node) map[string]signature {
+	count := func(nodes []*Node) signature {
+		var s signature
+		for _, n := range nodes {
+			types := 0
+			for _, f := range Fields(n.Meta) {
+				if !strings.HasPrefix(f, "$") {
+					types++
+				}
+			}
+			switch n.Op {
+			case OpParam:
+				s.params += types
+			case OpResult:
+				s.results += types
+			}
+		}
+		return s
+	}
+
+	types := map[string]signature{}
+	ntypes := 0
+	for _, f := range m.Args {
+		if f.Op == OpType {
+			s := count(f.Args[0].Args)
+			types[strconv.Itoa(ntypes)] = s
+			if f.Meta != "" {
+				types[f.Meta] = s
+			}
+			ntypes++
+		}
+	}
+
+	funcs := map[string]signature{}
+	nfuncs := 0
+	for _, f := range m.Args {
+		if f.Op == OpImport {
+			f = f.Args[0]
+		}
+		if f.Op != OpFunc {
+			continue
+		}
+		s := count(f.Args)
+		for _, a := range f.Args {
+			if a.Op == OpTypeUse {
+				s = types[a.Meta]
+			}
+		}
+		funcs[strconv.Itoa(nfuncs)] = s
+		if f.Meta != "" {
+			funcs[f.Meta] = s
+		}
+		nfuncs++
+	}
+	return funcs
+}
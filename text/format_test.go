@@ -0,0 +1,177 @@
+package text_test
+
+import (
+	"testing"
+
+	"github.com/bluescreen10/war/text"
+)
+
+const sumSquares = `(module
+  (func $sq (param i32) (result i32)
+    local.get 0
+    local.get 0
+    i32.mul)
+  (func $sum (param $n i32) (result i32)
+    (local $acc i32)
+    block $done
+      loop $top
+        local.get $n
+        i32.eqz
+        br_if $done
+        local.get $acc
+        local.get $n
+        call $sq
+        i32.add
+        local.set $acc
+        local.get $n
+        i32.const 1
+        i32.sub
+        local.set $n
+        br $top
+      end
+    end
+    local.get $acc
+    if (result i32)
+      local.get $acc
+    else
+      i32.const -1
+    end))
+`
+
+const sumSquaresFolded = `(module
+  (func $sq (param i32) (result i32)
+    (i32.mul (local.get 0) (local.get 0)))
+  (func $sum (param $n i32) (result i32)
+    (local $acc i32)
+    (block $done
+      (loop $top
+        (i32.eqz (local.get $n))
+        (br_if $done)
+        (local.set $acc (i32.add (local.get $acc) (call $sq (local.get $n))))
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        (br $top)))
+    (if (result i32)
+      (local.get $acc)
+      (then
+        (local.get $acc))
+      (else
+        (i32.const -1)))))
+`
+
+func format(t *testing.T, src string, opts text.FormatOptions) string {
+	t.Helper()
+	p := text.NewParser([]byte(src))
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	return string(text.Format(p.Root(), opts))
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		opts text.FormatOptions
+		want string
+	}{
+		{"flat from flat", sumSquares, text.FormatOptions{}, sumSquares},
+		{"folded from flat", sumSquares, text.FormatOptions{Folded: true}, sumSquaresFolded},
+		{"flat from folded", sumSquaresFolded, text.FormatOptions{}, sumSquares},
+		{"folded from folded", sumSquaresFolded, text.FormatOptions{Folded: true}, sumSquaresFolded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := format(t, tt.src, tt.opts); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatIndent(t *testing.T) {
+	src := `(module (func (result i32) (i32.add (i32.const 1) (i32.const 2))))`
+	want := "(module\n\t(func (result i32)\n\t\ti32.const 1\n\t\ti32.const 2\n\t\ti32.add))\n"
+	if got := format(t, src, text.FormatOptions{Indent: "\t"}); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatUnknownArity(t *testing.T) {
+	// the operands of br_if depend on its label, so they're left unfolded
+	src := `(module (func (param i32) (result i32)
+    block (result i32)
+      i32.const 1
+      local.get 0
+      br_if 0
+    end))`
+	want := `(module
+  (func (param i32) (result i32)
+    (block (result i32)
+      (i32.const 1)
+      (local.get 0)
+      (br_if 0))))
+`
+	if got := format(t, src, text.FormatOptions{Folded: true}); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatMultiValue(t *testing.T) {
+	// a block with params keeps its operands outside, and the block type of
+	// a folded if stays in order
+	src := `(module
+  (func (param i32) (result i32)
+    local.get 0
+    block (param i32) (result i32 i32)
+      i32.const 1
+    end
+    i32.add
+    local.get 0
+    if (param i32) (result i32)
+      i32.const 2
+      i32.add
+    else
+      i32.const 3
+      i32.sub
+    end))
+`
+	folded := format(t, src, text.FormatOptions{Folded: true})
+	if got := format(t, folded, text.FormatOptions{}); got != src {
+		t.Errorf("got:\n%s\nafter folding into:\n%s\nwant:\n%s", got, folded, src)
+	}
+}
+
+func TestFormatComments(t *testing.T) {
+	src := `;; the answer
+(module
+  ;; always 42
+  ;; no matter what
+  (func $answer (result i32)
+    ;; dropped, comments are only kept on fields
+    i32.const 42)
+  (; exported ;) (export "answer" (func $answer)))
+`
+	want := `;; the answer
+(module
+  ;; always 42
+  ;; no matter what
+  (func $answer (result i32)
+    i32.const 42)
+  (; exported ;)
+  (export "answer" (func $answer)))
+`
+	p := text.NewParser([]byte(src))
+	p.KeepComments()
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(text.Format(p.Root(), text.FormatOptions{})); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// comments are dropped unless kept
+	if got := format(t, src, text.FormatOptions{}); got == want {
+		t.Errorf("comments kept without KeepComments:\n%s", got)
+	}
+}
@@ -1,8 +1,11 @@
 package text
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode"
@@ -29,6 +32,7 @@ type tokenKind int
 const (
 	tokenError tokenKind = iota
 	tokenEOF
+	tokenComment
 	tokenLParen
 	tokenRParen
 	tokenIdent
@@ -524,6 +528,12 @@ const (
 type token struct {
 	kind tokenKind
 	val  []byte
+	pos  Pos // of the first byte
+	end  Pos // just past the last byte
+
+	// comments written right before the token, only collected by parsers
+	// keeping comments
+	comments []string
 }
 
 var key = map[string]tokenKind{
@@ -556,6 +566,7 @@ var key = map[string]tokenKind{
 	"block":  tokenBlock,
 	"loop":   tokenLoop,
 	"if":     tokenIf,
+	"then":   tokenThen,
 	"else":   tokenElse,
 	"end":    tokenEnd,
 	"select": tokenSelect,
@@ -1098,16 +1109,38 @@ type lexer struct {
 	width  int
 	state  stateFn
 	tokens chan token
+
+	// when reading, input only holds the bytes from the start of the
+	// current token on, offset being their position in the stream
+	r      io.Reader
+	offset int
+	err    error
+
+	// position tracking: the stream has been scanned for newlines up to
+	// scanned, the current line starting at lineStart
+	scanned   int
+	line      int
+	lineStart int
+
+	// comments are emitted as tokens instead of being skipped
+	comments bool
+	// lexing goes on after an error, from the next token boundary
+	recover bool
 }
 
+// readSize is the number of bytes a lexer reads at once.
+const readSize = 4096
+
 func (l *lexer) nextToken() token {
 	for {
 		select {
-		case token := <-l.tokens:
-			return token
+		case t := <-l.tokens:
+			if t.kind == tokenEOF && l.err != nil {
+				return token{kind: tokenError, val: []byte(l.err.Error()), pos: t.pos, end: t.end}
+			}
+			return t
 		default:
 			if l.state == nil {
-				close(l.tokens)
 				return token{kind: tokenEOF}
 			}
 			l.state = l.state(l)
@@ -1116,6 +1149,9 @@ func (l *lexer) nextToken() token {
 }
 
 func (l *lexer) next() rune {
+	if l.r != nil && !utf8.FullRune(l.input[l.pos:]) {
+		l.fill()
+	}
 	if l.pos >= len(l.input) {
 		l.width = 0
 		return eof
@@ -1127,6 +1163,43 @@ func (l *lexer) next() rune {
 	return r
 }
 
+// fill reads more input, dropping the bytes before the current token.
+func (l *lexer) fill() {
+	l.position(l.start)
+	l.offset += l.start
+	l.input = l.input[:copy(l.input, l.input[l.start:])]
+	l.pos -= l.start
+	l.start = 0
+
+	for !utf8.FullRune(l.input[l.pos:]) {
+		if len(l.input)+readSize > cap(l.input) {
+			l.input = append(l.input, make([]byte, readSize)...)[:len(l.input)]
+		}
+		n, err := l.r.Read(l.input[len(l.input) : len(l.input)+readSize])
+		l.input = l.input[:len(l.input)+n]
+		if err != nil {
+			if err != io.EOF {
+				l.err = err
+			}
+			l.r = nil
+			return
+		}
+	}
+}
+
+// position returns the position of input[i], which must not be before the
+// positions returned so far.
+func (l *lexer) position(i int) Pos {
+	for ; l.scanned < l.offset+i; l.scanned++ {
+		if l.input[l.scanned-l.offset] == '\n' {
+			l.line++
+			l.lineStart = l.scanned + 1
+		}
+	}
+	off := l.offset + i
+	return Pos{Offset: off, Line: l.line + 1, Col: off - l.lineStart + 1}
+}
+
 func (l *lexer) ignore() {
 	l.start = l.pos
 }
@@ -1143,17 +1216,22 @@ func (l *lexer) peek() rune {
 	return r
 }
 
+// lexeme returns the current token. When reading, the input buffer is
+// reused so the token is a copy.
 func (l *lexer) lexeme() []byte {
+	if l.r != nil {
+		return bytes.Clone(l.input[l.start:l.pos])
+	}
 	return l.input[l.start:l.pos]
 }
 
 func (l *lexer) emit(kind tokenKind) {
-	l.tokens <- token{kind, l.input[l.start:l.pos]}
+	l.tokens <- token{kind: kind, val: l.lexeme(), pos: l.position(l.start), end: l.position(l.pos)}
 	l.start = l.pos
 }
 
 func (l *lexer) emitWithData(kind tokenKind, data []byte) {
-	l.tokens <- token{kind, data}
+	l.tokens <- token{kind: kind, val: data, pos: l.position(l.start), end: l.position(l.pos)}
 	l.start = l.pos
 }
 
@@ -1175,8 +1253,20 @@ func (l *lexer) acceptRun(valid string) int {
 }
 
 func (l *lexer) errorf(format string, args ...any) stateFn {
-	l.tokens <- token{tokenError, []byte(fmt.Sprintf(format, args...))}
-	return nil
+	l.tokens <- token{kind: tokenError, val: []byte(fmt.Sprintf(format, args...)), pos: l.position(l.start), end: l.position(l.pos)}
+	if !l.recover {
+		return nil
+	}
+	// skip the rest of the bad token, up to a space or paren
+	for {
+		r := l.next()
+		if r == eof || isSpace(r) || r == '(' || r == ')' {
+			l.backup()
+			break
+		}
+	}
+	l.ignore()
+	return lexDefault
 }
 
 func lexDefault(l *lexer) stateFn {
@@ -1221,21 +1311,41 @@ func lexBlockComment(l *lexer) stateFn {
 	l.accept(";")
 	for level > 0 {
 		switch r := l.next(); {
+		case r == eof:
+			return l.errorf("unterminated block comment")
 		case r == '(':
 			if l.peek() == ';' {
 				level++
 			}
 		case r == ';':
 			if l.peek() == ')' {
+				l.next()
 				level--
 			}
 		}
 	}
+	l.skipComment()
 	return lexDefault
 }
 
+// skipComment drops the comment just scanned unless comments are kept.
+func (l *lexer) skipComment() {
+	if l.comments {
+		l.emit(tokenComment)
+	} else {
+		l.ignore()
+	}
+}
+
 func lexNumber(l *lexer) stateFn {
 	l.accept(sign)
+	// inf, nan and nan:0x... may be signed too
+	if isLowercaseLetter(l.peek()) {
+		l.acceptRun(keyword)
+		l.emit(tokenNumber)
+		return lexDefault
+	}
+
 	// is it hex?
 	valid := digit
 	if l.accept("0") && l.accept("xX") {
@@ -1272,11 +1382,10 @@ func lexComment(l *lexer) stateFn {
 		return l.errorf("expected ';' but got: %q", l.next())
 	}
 
-	for {
-		if r := l.next(); r == eof || r == '\n' {
-			break
-		}
+	for r := l.peek(); r != eof && r != '\n'; r = l.peek() {
+		l.next()
 	}
+	l.skipComment()
 	return lexDefault
 }
 
@@ -1315,6 +1424,8 @@ func escapeSeq(l *lexer) (string, error) {
 	switch r := l.next(); {
 	case r == 't':
 		return "\t", nil
+	case r == 'n':
+		return "\n", nil
 	case r == 'r':
 		return "\r", nil
 	case r == '"':
@@ -1325,8 +1436,8 @@ func escapeSeq(l *lexer) (string, error) {
 		return "\\", nil
 	case isHexDigit(r):
 		if r2 := l.next(); r2 != eof && isHexDigit(r2) {
-			v, _ := strconv.ParseUint(string(r)+string(r2), 16, 16)
-			return string(rune(v)), nil
+			v, _ := strconv.ParseUint(string(r)+string(r2), 16, 8)
+			return string([]byte{byte(v)}), nil
 		} else {
 			return "", fmt.Errorf("invalid escape sequence: %q%q", r, r2)
 		}
@@ -1353,7 +1464,10 @@ func escapeSeq(l *lexer) (string, error) {
 			s += string(d)
 		}
 
-		v, _ := strconv.ParseUint(s, 16, 16)
+		v, err := strconv.ParseUint(s, 16, 32)
+		if err != nil || v > unicode.MaxRune || (v >= 0xd800 && v < 0xe000) {
+			return "", fmt.Errorf("invalid unicode code point: %q", s)
+		}
 
 		if !l.accept("}") {
 			return "", fmt.Errorf("invalid unicode")
@@ -1387,3 +1501,134 @@ func NewLexer(input []byte) *lexer {
 		tokens: make(chan token, 3),
 	}
 }
+
+// NewReaderLexer returns a lexer that reads its input from r as needed,
+// keeping only the current token in memory. It produces the same tokens as
+// NewLexer on the whole input.
+func NewReaderLexer(r io.Reader) *lexer {
+	return &lexer{
+		r:      r,
+		state:  lexDefault,
+		tokens: make(chan token, 3),
+	}
+}
+
+// Token is a token of the text format.
+type Token struct {
+	Text string
+	Pos  Pos // of the first byte
+	End  Pos // just past the last byte
+}
+
+// TokenizeOptions controls how Tokenize handles errors and comments.
+type TokenizeOptions struct {
+	// Recover goes on after a lexical error from the next space or paren,
+	// so that all the errors of the source are reported at once. Otherwise
+	// tokenizing stops at the first one.
+	Recover bool
+	// Comments includes comments in the tokens.
+	Comments bool
+}
+
+// Tokenize splits src into tokens, returning them along with the lexical
+// errors found, each prefixed with its position.
+func Tokenize(src []byte, opts TokenizeOptions) ([]Token, []error) {
+	l := NewLexer(src)
+	l.recover, l.comments = opts.Recover, opts.Comments
+
+	var toks []Token
+	var errs []error
+	for {
+		t := l.nextToken()
+		switch t.kind {
+		case tokenEOF:
+			return toks, errs
+		case tokenError:
+			errs = append(errs, fmt.Errorf("%s: %s", t.pos, t.val))
+			if !opts.Recover {
+				return toks, errs
+			}
+		default:
+			toks = append(toks, Token{Text: string(t.val), Pos: t.pos, End: t.end})
+		}
+	}
+}
+
+// Edit is a change of a source, in byte offsets: the bytes from Start to
+// OldEnd were replaced by those from Start to NewEnd of the new source.
+type Edit struct {
+	Start, OldEnd, NewEnd int
+}
+
+// Retokenize returns the tokens of src, the source toks were returned for
+// with e applied, re-lexing only around the edit. Lexing starts over at
+// the last token ending before the edit and stops at the first token after
+// it starting where one of toks did, the rest of toks being reused with
+// their positions moved. The errors are those of the re-lexed text.
+func Retokenize(toks []Token, src []byte, e Edit, opts TokenizeOptions) ([]Token, []error) {
+	if e.Start < 0 || e.OldEnd < e.Start || e.NewEnd < e.Start || e.NewEnd > len(src) {
+		return Tokenize(src, opts)
+	}
+
+	// a token start is a boundary the lexer is always at the default
+	// state for, so it can start over there
+	k := 0
+	for k < len(toks) && toks[k].End.Offset < e.Start {
+		k++
+	}
+	start := Pos{Offset: 0, Line: 1, Col: 1}
+	if k > 0 {
+		k--
+		start = toks[k].Pos
+	}
+
+	l := NewLexer(src)
+	l.recover, l.comments = opts.Recover, opts.Comments
+	l.pos, l.start, l.scanned = start.Offset, start.Offset, start.Offset
+	l.line, l.lineStart = start.Line-1, start.Offset-start.Col+1
+
+	out := slices.Clone(toks[:k])
+	var errs []error
+	delta := e.NewEnd - e.OldEnd
+	j := k
+	for {
+		t := l.nextToken()
+		switch t.kind {
+		case tokenEOF:
+			return out, errs
+		case tokenError:
+			errs = append(errs, fmt.Errorf("%s: %s", t.pos, t.val))
+			if !opts.Recover {
+				return out, errs
+			}
+			continue
+		}
+		if t.pos.Offset >= e.NewEnd {
+			for j < len(toks) && (toks[j].Pos.Offset < e.OldEnd || toks[j].Pos.Offset+delta < t.pos.Offset) {
+				j++
+			}
+			if j < len(toks) && toks[j].Pos.Offset+delta == t.pos.Offset {
+				// the rest lexes as before
+				return append(out, moveTokens(toks[j:], toks[j].Pos, t.pos)...), errs
+			}
+		}
+		out = append(out, Token{Text: string(t.val), Pos: t.pos, End: t.end})
+	}
+}
+
+// moveTokens returns toks moved so that the position from is now to.
+func moveTokens(toks []Token, from, to Pos) []Token {
+	move := func(p Pos) Pos {
+		if p.Line == from.Line {
+			p.Col += to.Col - from.Col
+		}
+		p.Offset += to.Offset - from.Offset
+		p.Line += to.Line - from.Line
+		return p
+	}
+	moved := make([]Token, len(toks))
+	for i, t := range toks {
+		moved[i] = Token{Text: t.Text, Pos: move(t.Pos), End: move(t.End)}
+	}
+	return moved
+}
@@ -0,0 +1,129 @@
+package text
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// tokens returns the token stream of a lexer up to EOF or an error.
+func tokens(l *lexer) []token {
+	var toks []token
+	for {
+		t := l.nextToken()
+		toks = append(toks, t)
+		if t.kind == tokenEOF || t.kind == tokenError {
+			return toks
+		}
+	}
+}
+
+func TestReaderLexer(t *testing.T) {
+	src := `(module ;; comment
+  (func $f (param i32) (result f64) (; block (; nested ;) ;)
+    (f64.const -0x1.8p+3) i32.const 1_000 drop)
+  (data "h\\u{e9}llo \\00 wörld"))`
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"module", src},
+		{"longer than a read", strings.Repeat(src, 200)},
+		{"unclosed quote", `(data "abc`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tokens(NewLexer([]byte(tt.input)))
+			// one byte at a time splits multi-byte runes across reads
+			got := tokens(NewReaderLexer(iotest.OneByteReader(strings.NewReader(tt.input))))
+			if len(got) != len(want) {
+				t.Fatalf("got %d tokens, expected %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i].kind != want[i].kind || !bytes.Equal(got[i].val, want[i].val) ||
+					got[i].pos != want[i].pos || got[i].end != want[i].end {
+					t.Fatalf("token %d: got %v, expected %v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReaderLexerError(t *testing.T) {
+	r := iotest.DataErrReader(iotest.ErrReader(errors.New("read failed")))
+	got := tokens(NewReaderLexer(r))
+	if last := got[len(got)-1]; last.kind != tokenError || string(last.val) != "read failed" {
+		t.Errorf("got %v, expected read error", last)
+	}
+}
+
+func TestTokenizeRecover(t *testing.T) {
+	src := "(func #bad# (i32.const 1)\n  (data \"\\q\") $ok)"
+
+	toks, errs := Tokenize([]byte(src), TokenizeOptions{Recover: true})
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := []string{`1:7: unknown token: '#'`, `2:9: invalid escape sequence: "\"\\q"`}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got errors %q, expected %q", got, want)
+	}
+
+	var texts []string
+	for _, tok := range toks {
+		texts = append(texts, tok.Text)
+	}
+	// lexing goes on after each error
+	if got, want := strings.Join(texts, " "), `( func ( i32.const 1 ) ( data ) $ok )`; got != want {
+		t.Errorf("got tokens %s, expected %s", got, want)
+	}
+
+	if _, errs := Tokenize([]byte(src), TokenizeOptions{}); len(errs) != 1 {
+		t.Errorf("got %d errors without recovering, expected 1", len(errs))
+	}
+}
+
+func TestRetokenize(t *testing.T) {
+	src := `(module
+  (func $f (param i32) (result i32) ;; adds one
+    (i32.add (local.get 0) (i32.const 1)))
+  (data "abc"))`
+	tests := []struct {
+		name     string
+		old, new string
+	}{
+		{"edit one line", "(i32.const 1)", "(i64.const 0x10)"},
+		{"join tokens", "(local.get 0) (", "(local.get 0)("},
+		{"grow a token", "$f ", "$f$g "},
+		{"insert a line", "  (data", "  (memory 1)\n  (data"},
+		{"open a comment", "(result i32) ", "(result i32) (; "},
+		{"unclose a string", `"abc"`, `"abc`},
+	}
+
+	prev, _ := Tokenize([]byte(src), TokenizeOptions{Recover: true})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := strings.Index(src, tt.old)
+			edited := src[:i] + tt.new + src[i+len(tt.old):]
+			e := Edit{Start: i, OldEnd: i + len(tt.old), NewEnd: i + len(tt.new)}
+
+			want, wantErrs := Tokenize([]byte(edited), TokenizeOptions{Recover: true})
+			got, errs := Retokenize(prev, []byte(edited), e, TokenizeOptions{Recover: true})
+			if len(got) != len(want) {
+				t.Fatalf("got %d tokens, expected %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("token %d: got %+v, expected %+v", i, got[i], want[i])
+				}
+			}
+			if len(errs) != len(wantErrs) {
+				t.Errorf("got errors %v, expected %v", errs, wantErrs)
+			}
+		})
+	}
+}
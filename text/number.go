@@ -0,0 +1,146 @@
+package text
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// https://webassembly.github.io/spec/core/text/values.html#integers
+
+// ParseUint parses an unsigned integer literal of the given bit size.
+func ParseUint(s string, bits int) (uint64, error) {
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		return 0, fmt.Errorf("unexpected sign in %q", s)
+	}
+	mag, base := splitBase(strings.ReplaceAll(s, "_", ""))
+	return strconv.ParseUint(mag, base, bits)
+}
+
+// ParseInt parses an integer literal of the given bit size and returns its
+// two's complement bits. Both the signed and the unsigned range are
+// accepted, anything else is out of range.
+func ParseInt(s string, bits int) (uint64, error) {
+	mag := strings.ReplaceAll(s, "_", "")
+	neg := strings.HasPrefix(mag, "-")
+	if neg || strings.HasPrefix(mag, "+") {
+		mag = mag[1:]
+	}
+	mag, base := splitBase(mag)
+
+	u, err := strconv.ParseUint(mag, base, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("constant out of range for i%d", bits)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer %q", s)
+	}
+
+	switch {
+	case neg && u > 1<<(bits-1):
+		return 0, fmt.Errorf("constant out of range for i%d", bits)
+	case !neg && bits < 64 && u > 1<<bits-1:
+		return 0, fmt.Errorf("constant out of range for i%d", bits)
+	}
+	if neg {
+		u = -u
+	}
+	if bits < 64 {
+		u &= 1<<bits - 1
+	}
+	return u, nil
+}
+
+// splitBase strips the 0x prefix of hexadecimal literals and returns the
+// base of the rest. Leading zeros don't make a literal octal.
+func splitBase(s string) (string, int) {
+	if hex, ok := strings.CutPrefix(s, "0x"); ok {
+		return hex, 16
+	}
+	return s, 10
+}
+
+// https://webassembly.github.io/spec/core/text/values.html#floating-point
+
+// ParseFloat parses a float literal of the given bit size and returns its
+// IEEE 754 bits.
+func ParseFloat(s string, bits int) (uint64, error) {
+	s = strings.ReplaceAll(s, "_", "")
+	neg := strings.HasPrefix(s, "-")
+	mag := strings.TrimLeft(s, "+-")
+
+	var sign uint64
+	if neg {
+		sign = 1 << (bits - 1)
+	}
+
+	switch {
+	case mag == "inf":
+		if bits == 32 {
+			return sign | uint64(math.Float32bits(float32(math.Inf(1)))), nil
+		}
+		return sign | math.Float64bits(math.Inf(1)), nil
+	case mag == "nan":
+		if bits == 32 {
+			return sign | 0x7fc00000, nil
+		}
+		return sign | 0x7ff8000000000000, nil
+	case strings.HasPrefix(mag, "nan:0x"):
+		payload, err := strconv.ParseUint(mag[len("nan:0x"):], 16, 64)
+		if err != nil {
+			return 0, err
+		}
+		// the payload is non zero and fits in the significand, or the bits
+		// would be an infinity or spill into the exponent and sign
+		exp, frac := uint64(0x7ff0000000000000), 52
+		if bits == 32 {
+			exp, frac = 0x7f800000, 23
+		}
+		if payload == 0 || payload >= 1<<frac {
+			return 0, fmt.Errorf("constant out of range for f%d", bits)
+		}
+		return sign | exp | payload, nil
+	case strings.HasPrefix(mag, "0x") && !strings.ContainsAny(mag, "pP"):
+		// the binary exponent is optional in the text format, not in Go
+		s += "p0"
+	}
+
+	f, err := strconv.ParseFloat(s, bits)
+	if err != nil {
+		return 0, err
+	}
+	if bits == 32 {
+		return uint64(math.Float32bits(float32(f))), nil
+	}
+	return math.Float64bits(f), nil
+}
+
+// FormatFloat returns a literal for the IEEE 754 bits of a float of the given
+// bit size, which ParseFloat turns back into the same bits.
+func FormatFloat(v uint64, bits int) string {
+	var sign string
+	if v>>(bits-1) != 0 {
+		sign = "-"
+	}
+
+	exp, quiet := uint64(0x7ff0000000000000), uint64(1)<<51
+	if bits == 32 {
+		exp, quiet = 0x7f800000, 1<<22
+	}
+	mag := v &^ (1 << (bits - 1))
+	switch {
+	case mag == exp:
+		return sign + "inf"
+	case mag == exp|quiet:
+		return sign + "nan"
+	case mag > exp:
+		return sign + "nan:0x" + strconv.FormatUint(mag&^exp, 16)
+	}
+
+	if bits == 32 {
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(v))), 'g', -1, 32)
+	}
+	return strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64)
+}
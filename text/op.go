@@ -0,0 +1,979 @@
+package text
+
+// Op identifies the kind of a Node. Structural ops describe the shape of a
+// script or module, the rest are wasm instructions.
+type Op int
+
+const (
+	OpUnkown Op = iota
+
+	// structure
+	OpScript
+	OpModule
+	OpType
+	OpFunc
+	OpParam
+	OpResult
+	OpLocal
+	OpTypeUse
+	OpExport
+	OpGlobal
+	OpMut
+	OpMemory
+	OpData
+	OpOffset
+	OpThen
+	OpElse
+	OpImport
+	OpTable
+	OpElem
+	OpItem
+	OpStart
+
+	// script commands
+	OpRegister
+	OpInvoke
+	OpAssertReturn
+	OpAssertTrap
+	OpAssertUnlinkable
+	OpAssertInvalid
+
+	// instructions
+	OpUnreachable
+	OpNop
+	OpBr
+	OpBrIf
+	OpBrTable
+	OpReturn
+	OpCall
+	OpCallIndirect
+	OpDrop
+	OpBlock
+	OpLoop
+	OpIf
+	OpSelect
+	OpMemorySize
+	OpMemoryGrow
+	OpMemoryFill
+	OpMemoryCopy
+	OpMemoryInit
+	OpDataDrop
+	OpLocalGet
+	OpLocalSet
+	OpLocalTee
+	OpGlobalGet
+	OpGlobalSet
+	OpTableGet
+	OpTableSet
+	OpTableSize
+	OpTableGrow
+	OpTableFill
+	OpTableCopy
+	OpTableInit
+	OpElemDrop
+	OpI32Load
+	OpI64Load
+	OpF32Load
+	OpF64Load
+	OpI32Store
+	OpI64Store
+	OpF32Store
+	OpF64Store
+	OpI32Load8U
+	OpI32Load8S
+	OpI32Load16U
+	OpI32Load16S
+	OpI64Load8U
+	OpI64Load8S
+	OpI64Load16U
+	OpI64Load16S
+	OpI64Load32U
+	OpI64Load32S
+	OpI32Store8
+	OpI32Store16
+	OpI64Store8
+	OpI64Store16
+	OpI64Store32
+	OpV128Load
+	OpV128Store
+	OpV128Load8x8U
+	OpV128Load8x8S
+	OpV128Load16x4U
+	OpV128Load16x4S
+	OpV128Load32x2U
+	OpV128Load32x2S
+	OpV128Load8Splat
+	OpV128Load16Splat
+	OpV128Load32Splat
+	OpV128Load64Splat
+	OpV128Load32Zero
+	OpV128Load64Zero
+	OpV128Load8Lane
+	OpV128Load16Lane
+	OpV128Load32Lane
+	OpV128Load64Lane
+	OpV128Store8Lane
+	OpV128Store16Lane
+	OpV128Store32Lane
+	OpV128Store64Lane
+	OpI32Const
+	OpI64Const
+	OpF32Const
+	OpF64Const
+	OpV128Const
+	OpRefNull
+	OpRefFunc
+	OpRefIsNull
+	OpI32Clz
+	OpI32Ctz
+	OpI32Popcnt
+	OpI32Extend8S
+	OpI32Extend16S
+	OpI64Clz
+	OpI64Ctz
+	OpI64Popcnt
+	OpI64Extend8S
+	OpI64Extend16S
+	OpI64Extend32S
+	OpF32Neg
+	OpF32Abs
+	OpF32Sqrt
+	OpF32Ceil
+	OpF32Floor
+	OpF32Trunc
+	OpF32Nearest
+	OpF64Neg
+	OpF64Abs
+	OpF64Sqrt
+	OpF64Ceil
+	OpF64Floor
+	OpF64Trunc
+	OpF64Nearest
+	OpI32Add
+	OpI32Sub
+	OpI32Mul
+	OpI32DivU
+	OpI32DivS
+	OpI32RemU
+	OpI32RemS
+	OpI32And
+	OpI32Or
+	OpI32Xor
+	OpI32Shl
+	OpI32ShrU
+	OpI32ShrS
+	OpI32Rotl
+	OpI32Rotr
+	OpI64Add
+	OpI64Sub
+	OpI64Mul
+	OpI64DivU
+	OpI64DivS
+	OpI64RemU
+	OpI64RemS
+	OpI64And
+	OpI64Or
+	OpI64Xor
+	OpI64Shl
+	OpI64ShrU
+	OpI64ShrS
+	OpI64Rotl
+	OpI64Rotr
+	OpF32Add
+	OpF32Sub
+	OpF32Mul
+	OpF32Div
+	OpF32Min
+	OpF32Max
+	OpF32Copysign
+	OpF64Add
+	OpF64Sub
+	OpF64Mul
+	OpF64Div
+	OpF64Min
+	OpF64Max
+	OpF64Copysign
+	OpI32Eqz
+	OpI64Eqz
+	OpI32Eq
+	OpI32Ne
+	OpI32LtU
+	OpI32LtS
+	OpI32LeU
+	OpI32LeS
+	OpI32GtU
+	OpI32GtS
+	OpI32GeU
+	OpI32GeS
+	OpI64Eq
+	OpI64Ne
+	OpI64LtU
+	OpI64LtS
+	OpI64LeU
+	OpI64LeS
+	OpI64GtU
+	OpI64GtS
+	OpI64GeU
+	OpI64GeS
+	OpF32Eq
+	OpF32Ne
+	OpF32Lt
+	OpF32Le
+	OpF32Gt
+	OpF32Ge
+	OpF64Eq
+	OpF64Ne
+	OpF64Lt
+	OpF64Le
+	OpF64Gt
+	OpF64Ge
+	OpI32WrapI64
+	OpI64ExtendI32S
+	OpI64ExtendI32U
+	OpF32DemoteF64
+	OpF64PromoteF32
+	OpI32TruncF32U
+	OpI32TruncF32S
+	OpI64TruncF32U
+	OpI64TruncF32S
+	OpI32TruncF64U
+	OpI32TruncF64S
+	OpI64TruncF64U
+	OpI64TruncF64S
+	OpI32TruncSatF32U
+	OpI32TruncSatF32S
+	OpI64TruncSatF32U
+	OpI64TruncSatF32S
+	OpI32TruncSatF64U
+	OpI32TruncSatF64S
+	OpI64TruncSatF64U
+	OpI64TruncSatF64S
+	OpF32ConvertI32U
+	OpF32ConvertI32S
+	OpF64ConvertI32U
+	OpF64ConvertI32S
+	OpF32ConvertI64U
+	OpF32ConvertI64S
+	OpF64ConvertI64U
+	OpF64ConvertI64S
+	OpF32ReinterpretI32
+	OpF64ReinterpretI64
+	OpI32ReinterpretF32
+	OpI64ReinterpretF64
+	OpV128Not
+	OpV128And
+	OpV128Andnot
+	OpV128Or
+	OpV128Xor
+	OpV128Bitselect
+	OpV128AnyTrue
+	OpI8x16Neg
+	OpI16x8Neg
+	OpI32x4Neg
+	OpI64x2Neg
+	OpI8x16Abs
+	OpI16x8Abs
+	OpI32x4Abs
+	OpI64x2Abs
+	OpI8x16Popcnt
+	OpI8x16AvgrU
+	OpI16x8AvgrU
+	OpF32x4Neg
+	OpF64x2Neg
+	OpF32x4Abs
+	OpF64x2Abs
+	OpF32x4Sqrt
+	OpF64x2Sqrt
+	OpF32x4Ceil
+	OpF64x2Ceil
+	OpF32x4Floor
+	OpF64x2Floor
+	OpF32x4Trunc
+	OpF64x2Trunc
+	OpF32x4Nearest
+	OpF64x2Nearest
+	OpI32x4TruncSatF32x4U
+	OpI32x4TruncSatF32x4S
+	OpI32x4TruncSatF64x2UZero
+	OpI32x4TruncSatF64x2SZero
+	OpF64x2PromoteLowF32x4
+	OpF32x4DemoteF64x2Zero
+	OpF32x4ConvertI32x4U
+	OpF32x4ConvertI32x4S
+	OpF64x2ConvertLowI32x4U
+	OpF64x2ConvertLowI32x4S
+	OpI16x8ExtaddPairwiseI8x16U
+	OpI16x8ExtaddPairwiseI8x16S
+	OpI32x4ExtaddPairwiseI16x8U
+	OpI32x4ExtaddPairwiseI16x8S
+	OpI8x16Eq
+	OpI16x8Eq
+	OpI32x4Eq
+	OpI64x2Eq
+	OpI8x16Ne
+	OpI16x8Ne
+	OpI32x4Ne
+	OpI64x2Ne
+	OpI8x16LtU
+	OpI8x16LtS
+	OpI16x8LtU
+	OpI16x8LtS
+	OpI32x4LtU
+	OpI32x4LtS
+	OpI64x2LtS
+	OpI8x16LeU
+	OpI8x16LeS
+	OpI16x8LeU
+	OpI16x8LeS
+	OpI32x4LeU
+	OpI32x4LeS
+	OpI64x2LeS
+	OpI8x16GtU
+	OpI8x16GtS
+	OpI16x8GtU
+	OpI16x8GtS
+	OpI32x4GtU
+	OpI32x4GtS
+	OpI64x2GtS
+	OpI8x16GeU
+	OpI8x16GeS
+	OpI16x8GeU
+	OpI16x8GeS
+	OpI32x4GeU
+	OpI32x4GeS
+	OpI64x2GeS
+	OpF32x4Eq
+	OpF64x2Eq
+	OpF32x4Ne
+	OpF64x2Ne
+	OpF32x4Lt
+	OpF64x2Lt
+	OpF32x4Le
+	OpF64x2Le
+	OpF32x4Gt
+	OpF64x2Gt
+	OpF32x4Ge
+	OpF64x2Ge
+	OpI8x16Swizzle
+	OpI8x16Add
+	OpI16x8Add
+	OpI32x4Add
+	OpI64x2Add
+	OpI8x16Sub
+	OpI16x8Sub
+	OpI32x4Sub
+	OpI64x2Sub
+	OpI16x8Mul
+	OpI32x4Mul
+	OpI64x2Mul
+	OpI8x16AddSatU
+	OpI8x16AddSatS
+	OpI16x8AddSatU
+	OpI16x8AddSatS
+	OpI8x16SubSatU
+	OpI8x16SubSatS
+	OpI16x8SubSatU
+	OpI16x8SubSatS
+	OpI32x4DotI16x8S
+	OpI8x16MinU
+	OpI16x8MinU
+	OpI32x4MinU
+	OpI8x16MinS
+	OpI16x8MinS
+	OpI32x4MinS
+	OpI8x16MaxU
+	OpI16x8MaxU
+	OpI32x4MaxU
+	OpI8x16MaxS
+	OpI16x8MaxS
+	OpI32x4MaxS
+	OpF32x4Add
+	OpF64x2Add
+	OpF32x4Sub
+	OpF64x2Sub
+	OpF32x4Mul
+	OpF64x2Mul
+	OpF32x4Div
+	OpF64x2Div
+	OpF32x4Min
+	OpF64x2Min
+	OpF32x4Max
+	OpF64x2Max
+	OpF32x4Pmin
+	OpF64x2Pmin
+	OpF32x4Pmax
+	OpF64x2Pmax
+	OpI16x8Q15mulrSatS
+	OpI8x16NarrowI16x8U
+	OpI8x16NarrowI16x8S
+	OpI16x8NarrowI32x4U
+	OpI16x8NarrowI32x4S
+	OpI16x8ExtendLowI8x16U
+	OpI16x8ExtendLowI8x16S
+	OpI16x8ExtendHighI8x16U
+	OpI16x8ExtendHighI8x16S
+	OpI32x4ExtendLowI16x8U
+	OpI32x4ExtendLowI16x8S
+	OpI32x4ExtendHighI16x8U
+	OpI32x4ExtendHighI16x8S
+	OpI64x2ExtendLowI32x4U
+	OpI64x2ExtendLowI32x4S
+	OpI64x2ExtendHighI32x4U
+	OpI64x2ExtendHighI32x4S
+	OpI16x8ExtmulLowI8x16U
+	OpI16x8ExtmulLowI8x16S
+	OpI16x8ExtmulHighI8x16U
+	OpI16x8ExtmulHighI8x16S
+	OpI32x4ExtmulLowI16x8U
+	OpI32x4ExtmulLowI16x8S
+	OpI32x4ExtmulHighI16x8U
+	OpI32x4ExtmulHighI16x8S
+	OpI64x2ExtmulLowI32x4U
+	OpI64x2ExtmulLowI32x4S
+	OpI64x2ExtmulHighI32x4U
+	OpI64x2ExtmulHighI32x4S
+	OpI8x16AllTrue
+	OpI16x8AllTrue
+	OpI32x4AllTrue
+	OpI64x2AllTrue
+	OpI8x16Bitmask
+	OpI16x8Bitmask
+	OpI32x4Bitmask
+	OpI64x2Bitmask
+	OpI8x16Shl
+	OpI16x8Shl
+	OpI32x4Shl
+	OpI64x2Shl
+	OpI8x16ShrU
+	OpI8x16ShrS
+	OpI16x8ShrU
+	OpI16x8ShrS
+	OpI32x4ShrU
+	OpI32x4ShrS
+	OpI64x2ShrU
+	OpI64x2ShrS
+	OpI8x16Shuffle
+	OpI8x16Splat
+	OpI16x8Splat
+	OpI32x4Splat
+	OpI64x2Splat
+	OpF32x4Splat
+	OpF64x2Splat
+	OpI8x16ExtractLaneU
+	OpI8x16ExtractLaneS
+	OpI16x8ExtractLaneU
+	OpI16x8ExtractLaneS
+	OpI32x4ExtractLane
+	OpI64x2ExtractLane
+	OpF32x4ExtractLane
+	OpF64x2ExtractLane
+	OpI8x16ReplaceLane
+	OpI16x8ReplaceLane
+	OpI32x4ReplaceLane
+	OpI64x2ReplaceLane
+	OpF32x4ReplaceLane
+	OpF64x2ReplaceLane
+)
+
+var opNames = [...]string{
+	OpUnkown:  "unknown",
+	OpScript:  "script",
+	OpModule:  "module",
+	OpType:    "type",
+	OpFunc:    "func",
+	OpParam:   "param",
+	OpResult:  "result",
+	OpLocal:   "local",
+	OpTypeUse: "type",
+	OpExport:  "export",
+	OpGlobal:  "global",
+	OpMut:     "mut",
+	OpMemory:  "memory",
+	OpData:    "data",
+	OpOffset:  "offset",
+	OpThen:    "then",
+	OpElse:    "else",
+	OpImport:  "import",
+	OpTable:   "table",
+	OpElem:    "elem",
+	OpItem:    "item",
+	OpStart:   "start",
+
+	OpRegister:         "register",
+	OpInvoke:           "invoke",
+	OpAssertReturn:     "assert_return",
+	OpAssertTrap:       "assert_trap",
+	OpAssertUnlinkable: "assert_unlinkable",
+	OpAssertInvalid:    "assert_invalid",
+
+	OpUnreachable:               "unreachable",
+	OpNop:                       "nop",
+	OpBr:                        "br",
+	OpBrIf:                      "br_if",
+	OpBrTable:                   "br_table",
+	OpReturn:                    "return",
+	OpCall:                      "call",
+	OpCallIndirect:              "call_indirect",
+	OpDrop:                      "drop",
+	OpBlock:                     "block",
+	OpLoop:                      "loop",
+	OpIf:                        "if",
+	OpSelect:                    "select",
+	OpMemorySize:                "memory.size",
+	OpMemoryGrow:                "memory.grow",
+	OpMemoryFill:                "memory.fill",
+	OpMemoryCopy:                "memory.copy",
+	OpMemoryInit:                "memory.init",
+	OpDataDrop:                  "data.drop",
+	OpLocalGet:                  "local.get",
+	OpLocalSet:                  "local.set",
+	OpLocalTee:                  "local.tee",
+	OpGlobalGet:                 "global.get",
+	OpGlobalSet:                 "global.set",
+	OpTableGet:                  "table.get",
+	OpTableSet:                  "table.set",
+	OpTableSize:                 "table.size",
+	OpTableGrow:                 "table.grow",
+	OpTableFill:                 "table.fill",
+	OpTableCopy:                 "table.copy",
+	OpTableInit:                 "table.init",
+	OpElemDrop:                  "elem.drop",
+	OpI32Load:                   "i32.load",
+	OpI64Load:                   "i64.load",
+	OpF32Load:                   "f32.load",
+	OpF64Load:                   "f64.load",
+	OpI32Store:                  "i32.store",
+	OpI64Store:                  "i64.store",
+	OpF32Store:                  "f32.store",
+	OpF64Store:                  "f64.store",
+	OpI32Load8U:                 "i32.load8_u",
+	OpI32Load8S:                 "i32.load8_s",
+	OpI32Load16U:                "i32.load16_u",
+	OpI32Load16S:                "i32.load16_s",
+	OpI64Load8U:                 "i64.load8_u",
+	OpI64Load8S:                 "i64.load8_s",
+	OpI64Load16U:                "i64.load16_u",
+	OpI64Load16S:                "i64.load16_s",
+	OpI64Load32U:                "i64.load32_u",
+	OpI64Load32S:                "i64.load32_s",
+	OpI32Store8:                 "i32.store8",
+	OpI32Store16:                "i32.store16",
+	OpI64Store8:                 "i64.store8",
+	OpI64Store16:                "i64.store16",
+	OpI64Store32:                "i64.store32",
+	OpV128Load:                  "v128.load",
+	OpV128Store:                 "v128.store",
+	OpV128Load8x8U:              "v128.load8x8_u",
+	OpV128Load8x8S:              "v128.load8x8_s",
+	OpV128Load16x4U:             "v128.load16x4_u",
+	OpV128Load16x4S:             "v128.load16x4_s",
+	OpV128Load32x2U:             "v128.load32x2_u",
+	OpV128Load32x2S:             "v128.load32x2_s",
+	OpV128Load8Splat:            "v128.load8_splat",
+	OpV128Load16Splat:           "v128.load16_splat",
+	OpV128Load32Splat:           "v128.load32_splat",
+	OpV128Load64Splat:           "v128.load64_splat",
+	OpV128Load32Zero:            "v128.load32_zero",
+	OpV128Load64Zero:            "v128.load64_zero",
+	OpV128Load8Lane:             "v128.load8_lane",
+	OpV128Load16Lane:            "v128.load16_lane",
+	OpV128Load32Lane:            "v128.load32_lane",
+	OpV128Load64Lane:            "v128.load64_lane",
+	OpV128Store8Lane:            "v128.store8_lane",
+	OpV128Store16Lane:           "v128.store16_lane",
+	OpV128Store32Lane:           "v128.store32_lane",
+	OpV128Store64Lane:           "v128.store64_lane",
+	OpI32Const:                  "i32.const",
+	OpI64Const:                  "i64.const",
+	OpF32Const:                  "f32.const",
+	OpF64Const:                  "f64.const",
+	OpV128Const:                 "v128.const",
+	OpRefNull:                   "ref.null",
+	OpRefFunc:                   "ref.func",
+	OpRefIsNull:                 "ref.is_null",
+	OpI32Clz:                    "i32.clz",
+	OpI32Ctz:                    "i32.ctz",
+	OpI32Popcnt:                 "i32.popcnt",
+	OpI32Extend8S:               "i32.extend8_s",
+	OpI32Extend16S:              "i32.extend16_s",
+	OpI64Clz:                    "i64.clz",
+	OpI64Ctz:                    "i64.ctz",
+	OpI64Popcnt:                 "i64.popcnt",
+	OpI64Extend8S:               "i64.extend8_s",
+	OpI64Extend16S:              "i64.extend16_s",
+	OpI64Extend32S:              "i64.extend32_s",
+	OpF32Neg:                    "f32.neg",
+	OpF32Abs:                    "f32.abs",
+	OpF32Sqrt:                   "f32.sqrt",
+	OpF32Ceil:                   "f32.ceil",
+	OpF32Floor:                  "f32.floor",
+	OpF32Trunc:                  "f32.trunc",
+	OpF32Nearest:                "f32.nearest",
+	OpF64Neg:                    "f64.neg",
+	OpF64Abs:                    "f64.abs",
+	OpF64Sqrt:                   "f64.sqrt",
+	OpF64Ceil:                   "f64.ceil",
+	OpF64Floor:                  "f64.floor",
+	OpF64Trunc:                  "f64.trunc",
+	OpF64Nearest:                "f64.nearest",
+	OpI32Add:                    "i32.add",
+	OpI32Sub:                    "i32.sub",
+	OpI32Mul:                    "i32.mul",
+	OpI32DivU:                   "i32.div_u",
+	OpI32DivS:                   "i32.div_s",
+	OpI32RemU:                   "i32.rem_u",
+	OpI32RemS:                   "i32.rem_s",
+	OpI32And:                    "i32.and",
+	OpI32Or:                     "i32.or",
+	OpI32Xor:                    "i32.xor",
+	OpI32Shl:                    "i32.shl",
+	OpI32ShrU:                   "i32.shr_u",
+	OpI32ShrS:                   "i32.shr_s",
+	OpI32Rotl:                   "i32.rotl",
+	OpI32Rotr:                   "i32.rotr",
+	OpI64Add:                    "i64.add",
+	OpI64Sub:                    "i64.sub",
+	OpI64Mul:                    "i64.mul",
+	OpI64DivU:                   "i64.div_u",
+	OpI64DivS:                   "i64.div_s",
+	OpI64RemU:                   "i64.rem_u",
+	OpI64RemS:                   "i64.rem_s",
+	OpI64And:                    "i64.and",
+	OpI64Or:                     "i64.or",
+	OpI64Xor:                    "i64.xor",
+	OpI64Shl:                    "i64.shl",
+	OpI64ShrU:                   "i64.shr_u",
+	OpI64ShrS:                   "i64.shr_s",
+	OpI64Rotl:                   "i64.rotl",
+	OpI64Rotr:                   "i64.rotr",
+	OpF32Add:                    "f32.add",
+	OpF32Sub:                    "f32.sub",
+	OpF32Mul:                    "f32.mul",
+	OpF32Div:                    "f32.div",
+	OpF32Min:                    "f32.min",
+	OpF32Max:                    "f32.max",
+	OpF32Copysign:               "f32.copysign",
+	OpF64Add:                    "f64.add",
+	OpF64Sub:                    "f64.sub",
+	OpF64Mul:                    "f64.mul",
+	OpF64Div:                    "f64.div",
+	OpF64Min:                    "f64.min",
+	OpF64Max:                    "f64.max",
+	OpF64Copysign:               "f64.copysign",
+	OpI32Eqz:                    "i32.eqz",
+	OpI64Eqz:                    "i64.eqz",
+	OpI32Eq:                     "i32.eq",
+	OpI32Ne:                     "i32.ne",
+	OpI32LtU:                    "i32.lt_u",
+	OpI32LtS:                    "i32.lt_s",
+	OpI32LeU:                    "i32.le_u",
+	OpI32LeS:                    "i32.le_s",
+	OpI32GtU:                    "i32.gt_u",
+	OpI32GtS:                    "i32.gt_s",
+	OpI32GeU:                    "i32.ge_u",
+	OpI32GeS:                    "i32.ge_s",
+	OpI64Eq:                     "i64.eq",
+	OpI64Ne:                     "i64.ne",
+	OpI64LtU:                    "i64.lt_u",
+	OpI64LtS:                    "i64.lt_s",
+	OpI64LeU:                    "i64.le_u",
+	OpI64LeS:                    "i64.le_s",
+	OpI64GtU:                    "i64.gt_u",
+	OpI64GtS:                    "i64.gt_s",
+	OpI64GeU:                    "i64.ge_u",
+	OpI64GeS:                    "i64.ge_s",
+	OpF32Eq:                     "f32.eq",
+	OpF32Ne:                     "f32.ne",
+	OpF32Lt:                     "f32.lt",
+	OpF32Le:                     "f32.le",
+	OpF32Gt:                     "f32.gt",
+	OpF32Ge:                     "f32.ge",
+	OpF64Eq:                     "f64.eq",
+	OpF64Ne:                     "f64.ne",
+	OpF64Lt:                     "f64.lt",
+	OpF64Le:                     "f64.le",
+	OpF64Gt:                     "f64.gt",
+	OpF64Ge:                     "f64.ge",
+	OpI32WrapI64:                "i32.wrap_i64",
+	OpI64ExtendI32S:             "i64.extend_i32_s",
+	OpI64ExtendI32U:             "i64.extend_i32_u",
+	OpF32DemoteF64:              "f32.demote_f64",
+	OpF64PromoteF32:             "f64.promote_f32",
+	OpI32TruncF32U:              "i32.trunc_f32_u",
+	OpI32TruncF32S:              "i32.trunc_f32_s",
+	OpI64TruncF32U:              "i64.trunc_f32_u",
+	OpI64TruncF32S:              "i64.trunc_f32_s",
+	OpI32TruncF64U:              "i32.trunc_f64_u",
+	OpI32TruncF64S:              "i32.trunc_f64_s",
+	OpI64TruncF64U:              "i64.trunc_f64_u",
+	OpI64TruncF64S:              "i64.trunc_f64_s",
+	OpI32TruncSatF32U:           "i32.trunc_sat_f32_u",
+	OpI32TruncSatF32S:           "i32.trunc_sat_f32_s",
+	OpI64TruncSatF32U:           "i64.trunc_sat_f32_u",
+	OpI64TruncSatF32S:           "i64.trunc_sat_f32_s",
+	OpI32TruncSatF64U:           "i32.trunc_sat_f64_u",
+	OpI32TruncSatF64S:           "i32.trunc_sat_f64_s",
+	OpI64TruncSatF64U:           "i64.trunc_sat_f64_u",
+	OpI64TruncSatF64S:           "i64.trunc_sat_f64_s",
+	OpF32ConvertI32U:            "f32.convert_i32_u",
+	OpF32ConvertI32S:            "f32.convert_i32_s",
+	OpF64ConvertI32U:            "f64.convert_i32_u",
+	OpF64ConvertI32S:            "f64.convert_i32_s",
+	OpF32ConvertI64U:            "f32.convert_i64_u",
+	OpF32ConvertI64S:            "f32.convert_i64_s",
+	OpF64ConvertI64U:            "f64.convert_i64_u",
+	OpF64ConvertI64S:            "f64.convert_i64_s",
+	OpF32ReinterpretI32:         "f32.reinterpret_i32",
+	OpF64ReinterpretI64:         "f64.reinterpret_i64",
+	OpI32ReinterpretF32:         "i32.reinterpret_f32",
+	OpI64ReinterpretF64:         "i64.reinterpret_f64",
+	OpV128Not:                   "v128.not",
+	OpV128And:                   "v128.and",
+	OpV128Andnot:                "v128.andnot",
+	OpV128Or:                    "v128.or",
+	OpV128Xor:                   "v128.xor",
+	OpV128Bitselect:             "v128.bitselect",
+	OpV128AnyTrue:               "v128.any_true",
+	OpI8x16Neg:                  "i8x16.neg",
+	OpI16x8Neg:                  "i16x8.neg",
+	OpI32x4Neg:                  "i32x4.neg",
+	OpI64x2Neg:                  "i64x2.neg",
+	OpI8x16Abs:                  "i8x16.abs",
+	OpI16x8Abs:                  "i16x8.abs",
+	OpI32x4Abs:                  "i32x4.abs",
+	OpI64x2Abs:                  "i64x2.abs",
+	OpI8x16Popcnt:               "i8x16.popcnt",
+	OpI8x16AvgrU:                "i8x16.avgr_u",
+	OpI16x8AvgrU:                "i16x8.avgr_u",
+	OpF32x4Neg:                  "f32x4.neg",
+	OpF64x2Neg:                  "f64x2.neg",
+	OpF32x4Abs:                  "f32x4.abs",
+	OpF64x2Abs:                  "f64x2.abs",
+	OpF32x4Sqrt:                 "f32x4.sqrt",
+	OpF64x2Sqrt:                 "f64x2.sqrt",
+	OpF32x4Ceil:                 "f32x4.ceil",
+	OpF64x2Ceil:                 "f64x2.ceil",
+	OpF32x4Floor:                "f32x4.floor",
+	OpF64x2Floor:                "f64x2.floor",
+	OpF32x4Trunc:                "f32x4.trunc",
+	OpF64x2Trunc:                "f64x2.trunc",
+	OpF32x4Nearest:              "f32x4.nearest",
+	OpF64x2Nearest:              "f64x2.nearest",
+	OpI32x4TruncSatF32x4U:       "i32x4.trunc_sat_f32x4_u",
+	OpI32x4TruncSatF32x4S:       "i32x4.trunc_sat_f32x4_s",
+	OpI32x4TruncSatF64x2UZero:   "i32x4.trunc_sat_f64x2_u_zero",
+	OpI32x4TruncSatF64x2SZero:   "i32x4.trunc_sat_f64x2_s_zero",
+	OpF64x2PromoteLowF32x4:      "f64x2.promote_low_f32x4",
+	OpF32x4DemoteF64x2Zero:      "f32x4.demote_f64x2_zero",
+	OpF32x4ConvertI32x4U:        "f32x4.convert_i32x4_u",
+	OpF32x4ConvertI32x4S:        "f32x4.convert_i32x4_s",
+	OpF64x2ConvertLowI32x4U:     "f64x2.convert_low_i32x4_u",
+	OpF64x2ConvertLowI32x4S:     "f64x2.convert_low_i32x4_s",
+	OpI16x8ExtaddPairwiseI8x16U: "i16x8.extadd_pairwise_i8x16_u",
+	OpI16x8ExtaddPairwiseI8x16S: "i16x8.extadd_pairwise_i8x16_s",
+	OpI32x4ExtaddPairwiseI16x8U: "i32x4.extadd_pairwise_i16x8_u",
+	OpI32x4ExtaddPairwiseI16x8S: "i32x4.extadd_pairwise_i16x8_s",
+	OpI8x16Eq:                   "i8x16.eq",
+	OpI16x8Eq:                   "i16x8.eq",
+	OpI32x4Eq:                   "i32x4.eq",
+	OpI64x2Eq:                   "i64x2.eq",
+	OpI8x16Ne:                   "i8x16.ne",
+	OpI16x8Ne:                   "i16x8.ne",
+	OpI32x4Ne:                   "i32x4.ne",
+	OpI64x2Ne:                   "i64x2.ne",
+	OpI8x16LtU:                  "i8x16.lt_u",
+	OpI8x16LtS:                  "i8x16.lt_s",
+	OpI16x8LtU:                  "i16x8.lt_u",
+	OpI16x8LtS:                  "i16x8.lt_s",
+	OpI32x4LtU:                  "i32x4.lt_u",
+	OpI32x4LtS:                  "i32x4.lt_s",
+	OpI64x2LtS:                  "i64x2.lt_s",
+	OpI8x16LeU:                  "i8x16.le_u",
+	OpI8x16LeS:                  "i8x16.le_s",
+	OpI16x8LeU:                  "i16x8.le_u",
+	OpI16x8LeS:                  "i16x8.le_s",
+	OpI32x4LeU:                  "i32x4.le_u",
+	OpI32x4LeS:                  "i32x4.le_s",
+	OpI64x2LeS:                  "i64x2.le_s",
+	OpI8x16GtU:                  "i8x16.gt_u",
+	OpI8x16GtS:                  "i8x16.gt_s",
+	OpI16x8GtU:                  "i16x8.gt_u",
+	OpI16x8GtS:                  "i16x8.gt_s",
+	OpI32x4GtU:                  "i32x4.gt_u",
+	OpI32x4GtS:                  "i32x4.gt_s",
+	OpI64x2GtS:                  "i64x2.gt_s",
+	OpI8x16GeU:                  "i8x16.ge_u",
+	OpI8x16GeS:                  "i8x16.ge_s",
+	OpI16x8GeU:                  "i16x8.ge_u",
+	OpI16x8GeS:                  "i16x8.ge_s",
+	OpI32x4GeU:                  "i32x4.ge_u",
+	OpI32x4GeS:                  "i32x4.ge_s",
+	OpI64x2GeS:                  "i64x2.ge_s",
+	OpF32x4Eq:                   "f32x4.eq",
+	OpF64x2Eq:                   "f64x2.eq",
+	OpF32x4Ne:                   "f32x4.ne",
+	OpF64x2Ne:                   "f64x2.ne",
+	OpF32x4Lt:                   "f32x4.lt",
+	OpF64x2Lt:                   "f64x2.lt",
+	OpF32x4Le:                   "f32x4.le",
+	OpF64x2Le:                   "f64x2.le",
+	OpF32x4Gt:                   "f32x4.gt",
+	OpF64x2Gt:                   "f64x2.gt",
+	OpF32x4Ge:                   "f32x4.ge",
+	OpF64x2Ge:                   "f64x2.ge",
+	OpI8x16Swizzle:              "i8x16.swizzle",
+	OpI8x16Add:                  "i8x16.add",
+	OpI16x8Add:                  "i16x8.add",
+	OpI32x4Add:                  "i32x4.add",
+	OpI64x2Add:                  "i64x2.add",
+	OpI8x16Sub:                  "i8x16.sub",
+	OpI16x8Sub:                  "i16x8.sub",
+	OpI32x4Sub:                  "i32x4.sub",
+	OpI64x2Sub:                  "i64x2.sub",
+	OpI16x8Mul:                  "i16x8.mul",
+	OpI32x4Mul:                  "i32x4.mul",
+	OpI64x2Mul:                  "i64x2.mul",
+	OpI8x16AddSatU:              "i8x16.add_sat_u",
+	OpI8x16AddSatS:              "i8x16.add_sat_s",
+	OpI16x8AddSatU:              "i16x8.add_sat_u",
+	OpI16x8AddSatS:              "i16x8.add_sat_s",
+	OpI8x16SubSatU:              "i8x16.sub_sat_u",
+	OpI8x16SubSatS:              "i8x16.sub_sat_s",
+	OpI16x8SubSatU:              "i16x8.sub_sat_u",
+	OpI16x8SubSatS:              "i16x8.sub_sat_s",
+	OpI32x4DotI16x8S:            "i32x4.dot_i16x8_s",
+	OpI8x16MinU:                 "i8x16.min_u",
+	OpI16x8MinU:                 "i16x8.min_u",
+	OpI32x4MinU:                 "i32x4.min_u",
+	OpI8x16MinS:                 "i8x16.min_s",
+	OpI16x8MinS:                 "i16x8.min_s",
+	OpI32x4MinS:                 "i32x4.min_s",
+	OpI8x16MaxU:                 "i8x16.max_u",
+	OpI16x8MaxU:                 "i16x8.max_u",
+	OpI32x4MaxU:                 "i32x4.max_u",
+	OpI8x16MaxS:                 "i8x16.max_s",
+	OpI16x8MaxS:                 "i16x8.max_s",
+	OpI32x4MaxS:                 "i32x4.max_s",
+	OpF32x4Add:                  "f32x4.add",
+	OpF64x2Add:                  "f64x2.add",
+	OpF32x4Sub:                  "f32x4.sub",
+	OpF64x2Sub:                  "f64x2.sub",
+	OpF32x4Mul:                  "f32x4.mul",
+	OpF64x2Mul:                  "f64x2.mul",
+	OpF32x4Div:                  "f32x4.div",
+	OpF64x2Div:                  "f64x2.div",
+	OpF32x4Min:                  "f32x4.min",
+	OpF64x2Min:                  "f64x2.min",
+	OpF32x4Max:                  "f32x4.max",
+	OpF64x2Max:                  "f64x2.max",
+	OpF32x4Pmin:                 "f32x4.pmin",
+	OpF64x2Pmin:                 "f64x2.pmin",
+	OpF32x4Pmax:                 "f32x4.pmax",
+	OpF64x2Pmax:                 "f64x2.pmax",
+	OpI16x8Q15mulrSatS:          "i16x8.q15mulr_sat_s",
+	OpI8x16NarrowI16x8U:         "i8x16.narrow_i16x8_u",
+	OpI8x16NarrowI16x8S:         "i8x16.narrow_i16x8_s",
+	OpI16x8NarrowI32x4U:         "i16x8.narrow_i32x4_u",
+	OpI16x8NarrowI32x4S:         "i16x8.narrow_i32x4_s",
+	OpI16x8ExtendLowI8x16U:      "i16x8.extend_low_i8x16_u",
+	OpI16x8ExtendLowI8x16S:      "i16x8.extend_low_i8x16_s",
+	OpI16x8ExtendHighI8x16U:     "i16x8.extend_high_i8x16_u",
+	OpI16x8ExtendHighI8x16S:     "i16x8.extend_high_i8x16_s",
+	OpI32x4ExtendLowI16x8U:      "i32x4.extend_low_i16x8_u",
+	OpI32x4ExtendLowI16x8S:      "i32x4.extend_low_i16x8_s",
+	OpI32x4ExtendHighI16x8U:     "i32x4.extend_high_i16x8_u",
+	OpI32x4ExtendHighI16x8S:     "i32x4.extend_high_i16x8_s",
+	OpI64x2ExtendLowI32x4U:      "i64x2.extend_low_i32x4_u",
+	OpI64x2ExtendLowI32x4S:      "i64x2.extend_low_i32x4_s",
+	OpI64x2ExtendHighI32x4U:     "i64x2.extend_high_i32x4_u",
+	OpI64x2ExtendHighI32x4S:     "i64x2.extend_high_i32x4_s",
+	OpI16x8ExtmulLowI8x16U:      "i16x8.extmul_low_i8x16_u",
+	OpI16x8ExtmulLowI8x16S:      "i16x8.extmul_low_i8x16_s",
+	OpI16x8ExtmulHighI8x16U:     "i16x8.extmul_high_i8x16_u",
+	OpI16x8ExtmulHighI8x16S:     "i16x8.extmul_high_i8x16_s",
+	OpI32x4ExtmulLowI16x8U:      "i32x4.extmul_low_i16x8_u",
+	OpI32x4ExtmulLowI16x8S:      "i32x4.extmul_low_i16x8_s",
+	OpI32x4ExtmulHighI16x8U:     "i32x4.extmul_high_i16x8_u",
+	OpI32x4ExtmulHighI16x8S:     "i32x4.extmul_high_i16x8_s",
+	OpI64x2ExtmulLowI32x4U:      "i64x2.extmul_low_i32x4_u",
+	OpI64x2ExtmulLowI32x4S:      "i64x2.extmul_low_i32x4_s",
+	OpI64x2ExtmulHighI32x4U:     "i64x2.extmul_high_i32x4_u",
+	OpI64x2ExtmulHighI32x4S:     "i64x2.extmul_high_i32x4_s",
+	OpI8x16AllTrue:              "i8x16.all_true",
+	OpI16x8AllTrue:              "i16x8.all_true",
+	OpI32x4AllTrue:              "i32x4.all_true",
+	OpI64x2AllTrue:              "i64x2.all_true",
+	OpI8x16Bitmask:              "i8x16.bitmask",
+	OpI16x8Bitmask:              "i16x8.bitmask",
+	OpI32x4Bitmask:              "i32x4.bitmask",
+	OpI64x2Bitmask:              "i64x2.bitmask",
+	OpI8x16Shl:                  "i8x16.shl",
+	OpI16x8Shl:                  "i16x8.shl",
+	OpI32x4Shl:                  "i32x4.shl",
+	OpI64x2Shl:                  "i64x2.shl",
+	OpI8x16ShrU:                 "i8x16.shr_u",
+	OpI8x16ShrS:                 "i8x16.shr_s",
+	OpI16x8ShrU:                 "i16x8.shr_u",
+	OpI16x8ShrS:                 "i16x8.shr_s",
+	OpI32x4ShrU:                 "i32x4.shr_u",
+	OpI32x4ShrS:                 "i32x4.shr_s",
+	OpI64x2ShrU:                 "i64x2.shr_u",
+	OpI64x2ShrS:                 "i64x2.shr_s",
+	OpI8x16Shuffle:              "i8x16.shuffle",
+	OpI8x16Splat:                "i8x16.splat",
+	OpI16x8Splat:                "i16x8.splat",
+	OpI32x4Splat:                "i32x4.splat",
+	OpI64x2Splat:                "i64x2.splat",
+	OpF32x4Splat:                "f32x4.splat",
+	OpF64x2Splat:                "f64x2.splat",
+	OpI8x16ExtractLaneU:         "i8x16.extract_lane_u",
+	OpI8x16ExtractLaneS:         "i8x16.extract_lane_s",
+	OpI16x8ExtractLaneU:         "i16x8.extract_lane_u",
+	OpI16x8ExtractLaneS:         "i16x8.extract_lane_s",
+	OpI32x4ExtractLane:          "i32x4.extract_lane",
+	OpI64x2ExtractLane:          "i64x2.extract_lane",
+	OpF32x4ExtractLane:          "f32x4.extract_lane",
+	OpF64x2ExtractLane:          "f64x2.extract_lane",
+	OpI8x16ReplaceLane:          "i8x16.replace_lane",
+	OpI16x8ReplaceLane:          "i16x8.replace_lane",
+	OpI32x4ReplaceLane:          "i32x4.replace_lane",
+	OpI64x2ReplaceLane:          "i64x2.replace_lane",
+	OpF32x4ReplaceLane:          "f32x4.replace_lane",
+	OpF64x2ReplaceLane:          "f64x2.replace_lane",
+}
+
+var instrs = map[string]Op{}
+
+func init() {
+	for op := OpUnreachable; int(op) < len(opNames); op++ {
+		instrs[opNames[op]] = op
+	}
+}
+
+func (o Op) String() string {
+	if o < 0 || int(o) >= len(opNames) {
+		return opNames[OpUnkown]
+	}
+	return opNames[o]
+}
+
+// IsInstr reports whether o is a wasm instruction rather than a structural
+// node.
+func (o Op) IsInstr() bool {
+	return o >= OpUnreachable
+}
+
+// Instrs returns the instruction ops, in order.
+func Instrs() []Op {
+	ops := make([]Op, 0, len(opNames)-int(OpUnreachable))
+	for op := OpUnreachable; int(op) < len(opNames); op++ {
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// LookupOp returns the instruction op for the given mnemonic.
+func LookupOp(name string) (Op, bool) {
+	op, ok := instrs[name]
+	return op, ok
+}
@@ -0,0 +1,400 @@
+package text
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Optimize rewrites the instructions of a module in place into simpler
+// equivalent ones and returns it. Arithmetic on constant operands is
+// folded into a constant, except for operations that would trap or produce
+// a NaN, whose payload is up to the runtime, and the unreachable code
+// following branches is removed. The module must have been validated first:
+// unreachable code still has to type check, and once removed it can't make
+// the module invalid anymore.
+func Optimize(module *Node) *Node {
+	optimize(module)
+	return module
+}
+
+func optimize(n *Node) {
+	for _, a := range n.Args {
+		optimize(a)
+	}
+	n.Args = foldConsts(n.Args)
+	switch n.Op {
+	case OpFunc, OpBlock, OpLoop, OpThen, OpElse:
+		n.Args = dropDead(n.Args)
+	}
+}
+
+// dropDead removes the instructions of a body following an unconditional
+// branch, which can never run. Their operands still run, so a folded branch
+// is kept whole. Validation only sees what is left, so it must come first.
+func dropDead(body []*Node) []*Node {
+	for i, n := range body {
+		switch n.Op {
+		case OpBr, OpBrTable, OpReturn, OpUnreachable:
+			return body[:i+1]
+		}
+	}
+	return body
+}
+
+// foldConsts folds the instructions of a sequence whose operands are all
+// constants, either folded into them or right before them in the sequence.
+func foldConsts(code []*Node) []*Node {
+	out := code[:0]
+	for _, n := range code {
+		params, ok := foldable(n.Op)
+		switch {
+		case !ok:
+		case len(n.Args) == len(params) && isConsts(n.Args, params):
+			if c := evalConst(n, n.Args); c != nil {
+				n = c
+			}
+		case len(n.Args) == 0 && len(out) >= len(params) && isConsts(out[len(out)-len(params):], params):
+			if c := evalConst(n, out[len(out)-len(params):]); c != nil {
+				out = out[:len(out)-len(params)]
+				n = c
+			}
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// isConsts reports whether nodes are plain constants of the given ops.
+func isConsts(nodes []*Node, ops []Op) bool {
+	for i, n := range nodes {
+		if n.Op != ops[i] || len(n.Args) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// constOps maps the value types to their const instruction.
+var constOps = map[string]Op{"i32": OpI32Const, "i64": OpI64Const, "f32": OpF32Const, "f64": OpF64Const}
+
+// foldable returns the const ops of the operands of an instruction that can
+// be folded.
+func foldable(op Op) ([]Op, bool) {
+	prefix, name, _ := strings.Cut(op.String(), ".")
+	c, ok := constOps[prefix]
+	if !ok {
+		return nil, false
+	}
+	switch name {
+	case "wrap_i64":
+		return []Op{OpI64Const}, true
+	case "extend_i32_s", "extend_i32_u":
+		return []Op{OpI32Const}, true
+	case "eqz", "clz", "ctz", "popcnt", "extend8_s", "extend16_s", "extend32_s",
+		"neg", "abs", "sqrt", "ceil", "floor", "trunc", "nearest":
+		return []Op{c}, true
+	case "add", "sub", "mul", "div", "div_s", "div_u", "rem_s", "rem_u",
+		"and", "or", "xor", "shl", "shr_s", "shr_u", "rotl", "rotr",
+		"min", "max", "copysign",
+		"eq", "ne", "lt", "lt_s", "lt_u", "gt", "gt_s", "gt_u",
+		"le", "le_s", "le_u", "ge", "ge_s", "ge_u":
+		return []Op{c, c}, true
+	}
+	return nil, false
+}
+
+// evalConst evaluates n on constant operands, returning nil when the result
+// can't be folded.
+func evalConst(n *Node, operands []*Node) *Node {
+	prefix, name, _ := strings.Cut(n.Op.String(), ".")
+	size := 32
+	if prefix == "i64" || prefix == "f64" {
+		size = 64
+	}
+
+	args := make([]uint64, len(operands))
+	for i, o := range operands {
+		var err error
+		switch o.Op {
+		case OpI32Const:
+			args[i], err = ParseInt(o.Meta, 32)
+		case OpI64Const:
+			args[i], err = ParseInt(o.Meta, 64)
+		case OpF32Const:
+			args[i], err = ParseFloat(o.Meta, 32)
+		case OpF64Const:
+			args[i], err = ParseFloat(o.Meta, 64)
+		}
+		if err != nil {
+			return nil
+		}
+	}
+
+	var v uint64
+	var ok bool
+	result := constOps[prefix]
+	if prefix[0] == 'i' {
+		v, ok = evalInt(name, size, args)
+		if isComparison(name) {
+			result = OpI32Const
+		}
+	} else {
+		v, ok = evalFloat(name, size, args)
+		if isComparison(name) {
+			result, size = OpI32Const, 32
+		}
+	}
+	if !ok {
+		return nil
+	}
+
+	var meta string
+	switch result {
+	case OpI32Const:
+		meta = strconv.FormatInt(int64(int32(v)), 10)
+	case OpI64Const:
+		meta = strconv.FormatInt(int64(v), 10)
+	default:
+		meta = FormatFloat(v, size)
+	}
+	c := NewNode(result, meta)
+	c.Span = n.Span
+	return c
+}
+
+func isComparison(name string) bool {
+	switch strings.TrimSuffix(strings.TrimSuffix(name, "_s"), "_u") {
+	case "eqz", "eq", "ne", "lt", "gt", "le", "ge":
+		return true
+	}
+	return false
+}
+
+func boolBits(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// evalInt evaluates an integer operation of the given bit size. Operands
+// and result are kept in the low bits.
+func evalInt(name string, size int, args []uint64) (uint64, bool) {
+	mask := uint64(math.MaxUint64) >> (64 - size)
+	// signed reads an operand as a signed integer
+	signed := func(v uint64) int64 {
+		return int64(v<<(64-size)) >> (64 - size)
+	}
+
+	a := args[0]
+	switch name {
+	case "wrap_i64":
+		return a & 0xffffffff, true
+	case "extend_i32_s":
+		return uint64(int64(int32(a))), true
+	case "extend_i32_u":
+		return a & 0xffffffff, true
+	case "eqz":
+		return boolBits(a == 0), true
+	case "clz":
+		return uint64(bits.LeadingZeros64(a) - (64 - size)), true
+	case "ctz":
+		if a == 0 {
+			return uint64(size), true
+		}
+		return uint64(bits.TrailingZeros64(a)), true
+	case "popcnt":
+		return uint64(bits.OnesCount64(a)), true
+	case "extend8_s":
+		return uint64(int64(int8(a))) & mask, true
+	case "extend16_s":
+		return uint64(int64(int16(a))) & mask, true
+	case "extend32_s":
+		return uint64(int64(int32(a))) & mask, true
+	}
+
+	b := args[1]
+	shift := b & uint64(size-1)
+	switch name {
+	case "add":
+		return (a + b) & mask, true
+	case "sub":
+		return (a - b) & mask, true
+	case "mul":
+		return (a * b) & mask, true
+	case "and":
+		return a & b, true
+	case "or":
+		return a | b, true
+	case "xor":
+		return a ^ b, true
+	case "shl":
+		return (a << shift) & mask, true
+	case "shr_s":
+		return uint64(signed(a)>>shift) & mask, true
+	case "shr_u":
+		return a >> shift, true
+	case "rotl":
+		return (a<<shift | a>>((uint64(size)-shift)&uint64(size-1))) & mask, true
+	case "rotr":
+		return (a>>shift | a<<((uint64(size)-shift)&uint64(size-1))) & mask, true
+	case "div_s", "rem_s":
+		// division by zero and overflow trap at run time
+		if b == 0 || name == "div_s" && signed(a) == -1<<(size-1) && signed(b) == -1 {
+			return 0, false
+		}
+		if name == "div_s" {
+			return uint64(signed(a)/signed(b)) & mask, true
+		}
+		return uint64(signed(a)%signed(b)) & mask, true
+	case "div_u":
+		if b == 0 {
+			return 0, false
+		}
+		return a / b, true
+	case "rem_u":
+		if b == 0 {
+			return 0, false
+		}
+		return a % b, true
+	case "eq":
+		return boolBits(a == b), true
+	case "ne":
+		return boolBits(a != b), true
+	case "lt_s":
+		return boolBits(signed(a) < signed(b)), true
+	case "lt_u":
+		return boolBits(a < b), true
+	case "gt_s":
+		return boolBits(signed(a) > signed(b)), true
+	case "gt_u":
+		return boolBits(a > b), true
+	case "le_s":
+		return boolBits(signed(a) <= signed(b)), true
+	case "le_u":
+		return boolBits(a <= b), true
+	case "ge_s":
+		return boolBits(signed(a) >= signed(b)), true
+	case "ge_u":
+		return boolBits(a >= b), true
+	}
+	return 0, false
+}
+
+// evalFloat evaluates a float operation of the given bit size on IEEE 754
+// bits. NaN results aren't folded.
+func evalFloat(name string, size int, args []uint64) (uint64, bool) {
+	sign := uint64(1) << (size - 1)
+	a := args[0]
+	switch name {
+	case "neg":
+		return a ^ sign, true
+	case "abs":
+		return a &^ sign, true
+	}
+
+	if size == 32 {
+		x := math.Float32frombits(uint32(a))
+		var y float32
+		if len(args) > 1 {
+			y = math.Float32frombits(uint32(args[1]))
+		}
+		if name == "copysign" {
+			return a&^sign | args[1]&sign, true
+		}
+		if v, ok := compareFloat(name, float64(x), float64(y)); ok {
+			return v, true
+		}
+		r, ok := float32Op(name, x, y)
+		if !ok || r != r {
+			return 0, false
+		}
+		return uint64(math.Float32bits(r)), true
+	}
+
+	x := math.Float64frombits(a)
+	var y float64
+	if len(args) > 1 {
+		y = math.Float64frombits(args[1])
+	}
+	if name == "copysign" {
+		return a&^sign | args[1]&sign, true
+	}
+	if v, ok := compareFloat(name, x, y); ok {
+		return v, true
+	}
+	r, ok := float64Op(name, x, y)
+	if !ok || r != r {
+		return 0, false
+	}
+	return math.Float64bits(r), true
+}
+
+// compareFloat evaluates float comparisons, which are exact in float64.
+func compareFloat(name string, x, y float64) (uint64, bool) {
+	switch name {
+	case "eq":
+		return boolBits(x == y), true
+	case "ne":
+		return boolBits(x != y), true
+	case "lt":
+		return boolBits(x < y), true
+	case "gt":
+		return boolBits(x > y), true
+	case "le":
+		return boolBits(x <= y), true
+	case "ge":
+		return boolBits(x >= y), true
+	}
+	return 0, false
+}
+
+// float32Op rounds every result to float32, as the runtime does.
+func float32Op(name string, x, y float32) (float32, bool) {
+	switch name {
+	case "add":
+		return x + y, true
+	case "sub":
+		return x - y, true
+	case "mul":
+		return x * y, true
+	case "div":
+		return x / y, true
+	case "min":
+		return min(x, y), true
+	case "max":
+		return max(x, y), true
+	}
+	r, ok := float64Op(name, float64(x), float64(y))
+	return float32(r), ok
+}
+
+func float64Op(name string, x, y float64) (float64, bool) {
+	switch name {
+	case "add":
+		return x + y, true
+	case "sub":
+		return x - y, true
+	case "mul":
+		return x * y, true
+	case "div":
+		return x / y, true
+	case "min":
+		return min(x, y), true
+	case "max":
+		return max(x, y), true
+	case "sqrt":
+		return math.Sqrt(x), true
+	case "ceil":
+		return math.Ceil(x), true
+	case "floor":
+		return math.Floor(x), true
+	case "trunc":
+		return math.Trunc(x), true
+	case "nearest":
+		return math.RoundToEven(x), true
+	}
+	return 0, false
+}
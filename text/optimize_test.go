@@ -0,0 +1,82 @@
+package text_test
+
+import (
+	"testing"
+
+	"github.com/bluescreen10/war/text"
+)
+
+func optimize(t *testing.T, src string) string {
+	t.Helper()
+	p := text.NewParser([]byte(src))
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	return string(text.Format(text.Optimize(p.Root()), text.FormatOptions{Folded: true}))
+}
+
+func TestOptimizeConstants(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"folded", "(i32.add (i32.const 1) (i32.const 2))", "(i32.const 3)"},
+		{"nested", "(i32.mul (i32.add (i32.const 1) (i32.const 2)) (i32.const -4))", "(i32.const -12)"},
+		{"flat", "i64.const 7\n    i64.const 2\n    i64.rem_s", "(i64.const 1)"},
+		{"wraps", "(i32.add (i32.const 0x7fffffff) (i32.const 1))", "(i32.const -2147483648)"},
+		{"comparison", "(i64.lt_u (i64.const -1) (i64.const 1))", "(i32.const 0)"},
+		{"conversion", "(i64.extend_i32_s (i32.const -1))", "(i64.const -1)"},
+		{"float", "(f64.div (f64.const 1) (f64.const 4))", "(f64.const 0.25)"},
+		{"f32 rounding", "(f32.add (f32.const 16777216) (f32.const 1))", "(f32.const 1.6777216e+07)"},
+		{"signed zero", "(f32.neg (f32.const 0))", "(f32.const -0)"},
+
+		// left for the runtime
+		{"division by zero", "(i32.div_s (i32.const 1) (i32.const 0))", "(i32.div_s (i32.const 1) (i32.const 0))"},
+		{"overflow", "(i32.div_s (i32.const -2147483648) (i32.const -1))", "(i32.div_s (i32.const -2147483648) (i32.const -1))"},
+		{"nan", "(f32.div (f32.const 0) (f32.const 0))", "(f32.div (f32.const 0) (f32.const 0))"},
+		{"not constant", "(i32.add (local.get 0) (i32.const 1))", "(i32.add (local.get 0) (i32.const 1))"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "(module\n  (func (param i32)\n    " + tt.body + "\n    drop))"
+			want := "(module\n  (func (param i32)\n    (drop " + tt.want + ")))\n"
+			if got := optimize(t, src); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestOptimizeDeadCode(t *testing.T) {
+	src := `(module
+  (func (param i32) (result i32)
+    (block $b
+      (br_if $b (local.get 0))
+      (return (i32.const 1))
+      (drop (i32.const 2))
+      (nop))
+    (if (result i32) (local.get 0)
+      (then
+        unreachable
+        i32.const 3)
+      (else
+        (br 1 (i32.const 4))
+        (i32.const 5)))))`
+	want := `(module
+  (func (param i32) (result i32)
+    (block $b
+      (br_if $b (local.get 0))
+      (return (i32.const 1)))
+    (if (result i32)
+      (local.get 0)
+      (then
+        (unreachable))
+      (else
+        (br 1 (i32.const 4))))))
+`
+	if got := optimize(t, src); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -3,64 +3,1093 @@ package text
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 var ErrInvalidInput = errors.New("invalid input")
 
-type Op int
+// Node is a single element of the syntax tree. Meta holds the inline atoms of
+// the element as they would be written in the text format (identifiers,
+// immediates, quoted strings) while Args holds the nested elements: the
+// fields of a module, the operands of a folded instruction or the body of a
+// block.
+type Node struct {
+	ID   int // numbers the nodes of a parse in pre-order from 1, 0 otherwise
+	Op   Op
+	Args []*Node // inputs
+	Meta string  // e.g. immediate value, func name
+	Span Span    // source of the node, zero when not parsed from text
 
-const (
-	OpUnkown Op = iota
-	OpStart
-	OpConst
-	OpLocalGet
-	OpLocalSet
-	OpCall
-	OpI32Add
-)
+	// Comments are the comments written right before the node, as written,
+	// when parsed by a parser keeping comments. Only modules and module
+	// fields get them.
+	Comments []string
+}
 
-var idCounter int
+// Pos is a position in the source text.
+type Pos struct {
+	Offset int // in bytes from the start
+	Line   int // starting at 1
+	Col    int // in bytes from the start of the line, starting at 1
+}
 
-func newID() int {
-	idCounter++
-	return idCounter
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
 }
 
-type Node struct {
-	ID   int
-	Op   Op
-	Args []*Node // inputs
-	Meta string  // e.g. immediate value, func name
+// Span is the range of source text a node was parsed from, End being just
+// past its last token.
+type Span struct {
+	Start Pos
+	End   Pos
+}
+
+func (s Span) String() string {
+	return s.Start.String() + "-" + s.End.String()
 }
 
 func NewNode(op Op, meta string, args ...*Node) *Node {
-	return &Node{ID: newID(), Op: op, Meta: meta, Args: args}
+	return &Node{Op: op, Meta: meta, Args: args}
+}
+
+// Equal reports whether n and other are the same tree: same ops, atoms and
+// children. IDs, spans and comments are ignored.
+func (n *Node) Equal(other *Node) bool {
+	if n == nil || other == nil {
+		return n == other
+	}
+	if n.Op != other.Op || n.Meta != other.Meta || len(n.Args) != len(other.Args) {
+		return false
+	}
+	for i, a := range n.Args {
+		if !a.Equal(other.Args[i]) {
+			return false
+		}
+	}
+	return true
 }
 
 type Parser struct {
-	lex  *lexer
-	root *Node
+	lex   *lexer
+	root  *Node
+	toks  []token
+	last  token // the last token consumed
+	opens []Pos // positions of the parens not closed yet
+	// plain blocks not ended yet, which nest like parens
+	blocks   int
+	maxDepth int
+
+	comments []string  // read ahead of the next token
+	commands []Command // of the script, in order
+	ids      int       // last node ID given
+
+	// per module state used to desugar inline exports
+	funcs   int
+	globals int
+	tables  int
+	mems    int
+
+	// where the ids of the module were defined, and those of the params
+	// and locals of the function being parsed
+	idents map[ident]Pos
+	locals map[ident]Pos
+}
+
+// ident is an id in an index space, named after the op defining it.
+type ident struct {
+	space Op
+	id    string
 }
 
+// DefaultMaxDepth is the nesting depth past which a parser fails unless
+// told otherwise with SetMaxDepth.
+const DefaultMaxDepth = 1000
+
 func NewParser(input []byte) *Parser {
 	return &Parser{
-		lex: NewLexer(input),
+		lex:      NewLexer(input),
+		maxDepth: DefaultMaxDepth,
 	}
 }
 
-func (p *Parser) Parse() error {
-	p.root = NewNode(OpStart, "", nil)
-	for {
-		t := p.lex.nextToken()
+// SetMaxDepth makes Parse fail with a "nesting too deep" error past n
+// nested parens and plain blocks, rather than recursing as deep as the
+// input goes. It must be called before Parse.
+func (p *Parser) SetMaxDepth(n int) {
+	p.maxDepth = n
+}
+
+// KeepComments makes Parse record comments in the Comments of the module or
+// module field following them, so that Format writes them back. It must be
+// called before Parse.
+func (p *Parser) KeepComments() {
+	p.lex.comments = true
+}
+
+// Root returns the tree built by Parse.
+func (p *Parser) Root() *Node {
+	return p.root
+}
+
+func (p *Parser) Parse() (err error) {
+	defer p.recover(&err)
 
-		//fmt.Printf("token: %s\n", t)
-		if t.kind == tokenEOF {
-			break
+	p.root = NewNode(OpScript, "")
+	for p.peek(0).kind != tokenEOF {
+		start := p.peek(0).pos
+		var n *Node
+		switch p.peek(1).kind {
+		case tokenModule:
+			comments := p.peek(0).comments
+			n = p.parseModule()
+			n.Comments = comments
+		case tokenRegister:
+			n = p.parseRegister()
+		case tokenInvoke:
+			n = p.parseInvoke()
+		case tokenAssertReturn:
+			n = p.parseAssertReturn()
+		case tokenAssertTrap:
+			n = p.parseAssertTrap()
+		case tokenAssertUnlinkable:
+			n = p.parseAssertUnlinkable()
+		case tokenAssertInvalid:
+			n = p.parseAssertInvalid()
+		default:
+			if t := p.peek(0); t.kind != tokenLParen {
+				// consumed so that a stray ')' is reported as such
+				p.next()
+				p.errorf("unexpected %s, expected '('", t)
+			}
+			// a text file with only module fields is an implicit module
+			n = p.parseFields(NewNode(OpModule, ""))
 		}
+		if n.Span == (Span{}) {
+			n.Span = p.span(start)
+		}
+		p.root.Args = append(p.root.Args, n)
+		p.commands = append(p.commands, p.command(n))
+	}
+	Inspect(p.root, func(n *Node) {
+		p.ids++
+		n.ID = p.ids
+	})
+	return nil
+}
+
+// parseRegister parses (register "name" $module?).
+func (p *Parser) parseRegister() *Node {
+	p.expect(tokenLParen, "'('")
+	p.expect(tokenRegister, "register")
+	meta := Quote(p.name("module name"))
+	if id := p.optionalID(); id != "" {
+		meta += " " + id
+	}
+	p.expect(tokenRParen, "')'")
+	return NewNode(OpRegister, meta)
+}
+
+// parseInvoke parses (invoke $module? "name" const*), the arguments being
+// the args of the node.
+func (p *Parser) parseInvoke() *Node {
+	p.expect(tokenLParen, "'('")
+	p.expect(tokenInvoke, "invoke")
+	meta := p.optionalID()
+	if meta != "" {
+		meta += " "
+	}
+	meta += Quote(p.name("export name"))
+	n := NewNode(OpInvoke, meta, p.parseInstrs()...)
+	p.expect(tokenRParen, "')'")
+	return n
+}
+
+// parseAssertReturn parses (assert_return (invoke ...) const*), the invoke
+// being the first arg followed by the expected results.
+func (p *Parser) parseAssertReturn() *Node {
+	p.expect(tokenLParen, "'('")
+	p.expect(tokenAssertReturn, "assert_return")
+	n := NewNode(OpAssertReturn, "", p.parseInvoke())
+	n.Args = append(n.Args, p.parseInstrs()...)
+	p.expect(tokenRParen, "')'")
+	return n
+}
+
+// parseAssertTrap parses (assert_trap (invoke ...) "reason") and
+// (assert_trap (module ...) "reason").
+func (p *Parser) parseAssertTrap() *Node {
+	p.expect(tokenLParen, "'('")
+	p.expect(tokenAssertTrap, "assert_trap")
+	var action *Node
+	if p.peek(1).kind == tokenModule {
+		action = p.parseModule()
+	} else {
+		action = p.parseInvoke()
+	}
+	reason := p.expect(tokenString, "failure reason")
+	p.expect(tokenRParen, "')'")
+	return NewNode(OpAssertTrap, Quote(reason.val), action)
+}
+
+// parseAssertUnlinkable parses (assert_unlinkable (module ...) "reason").
+func (p *Parser) parseAssertUnlinkable() *Node {
+	p.expect(tokenLParen, "'('")
+	p.expect(tokenAssertUnlinkable, "assert_unlinkable")
+	m := p.parseModule()
+	reason := p.expect(tokenString, "failure reason")
+	p.expect(tokenRParen, "')'")
+	return NewNode(OpAssertUnlinkable, Quote(reason.val), m)
+}
 
+// parseAssertInvalid parses (assert_invalid (module ...) "reason").
+func (p *Parser) parseAssertInvalid() *Node {
+	p.expect(tokenLParen, "'('")
+	p.expect(tokenAssertInvalid, "assert_invalid")
+	m := p.parseModule()
+	reason := p.expect(tokenString, "failure reason")
+	p.expect(tokenRParen, "')'")
+	return NewNode(OpAssertInvalid, Quote(reason.val), m)
+}
+
+func (p *Parser) recover(errp *error) {
+	if e := recover(); e != nil {
+		if err, ok := e.(parseError); ok {
+			*errp = err
+			return
+		}
+		panic(e)
+	}
+}
+
+type parseError struct{ error }
+
+func (p *Parser) errorf(format string, args ...any) {
+	panic(parseError{fmt.Errorf(format, args...)})
+}
+
+func (p *Parser) peek(n int) token {
+	for len(p.toks) <= n {
+		t := p.lex.nextToken()
 		if t.kind == tokenError {
-			return fmt.Errorf("lexing error: %q", t.val)
+			p.errorf("lexing error: %q", t.val)
+		}
+		if t.kind == tokenComment {
+			p.comments = append(p.comments, string(t.val))
+			continue
+		}
+		t.comments, p.comments = p.comments, nil
+		p.toks = append(p.toks, t)
+	}
+	return p.toks[n]
+}
+
+// next consumes a token, keeping track of the parens so that unbalanced
+// ones are reported where they are.
+func (p *Parser) next() token {
+	t := p.peek(0)
+	switch t.kind {
+	case tokenLParen:
+		p.opens = append(p.opens, t.pos)
+		p.checkDepth(t.pos)
+	case tokenRParen:
+		if len(p.opens) == 0 {
+			p.errorf("%s: unexpected ')'", t.pos)
+		}
+		p.opens = p.opens[:len(p.opens)-1]
+	case tokenEOF:
+		if len(p.opens) > 0 {
+			p.errorf("%s: unexpected EOF, expected ')' closing the '(' at %s", t.pos, p.opens[len(p.opens)-1])
+		}
+	}
+	p.toks = p.toks[1:]
+	p.last = t
+	return t
+}
+
+// checkDepth fails when entering a paren or block at pos goes past the
+// maximum depth.
+func (p *Parser) checkDepth(pos Pos) {
+	if len(p.opens)+p.blocks > p.maxDepth {
+		p.errorf("%s: nesting too deep, more than %d levels", pos, p.maxDepth)
+	}
+}
+
+// span returns the span from start to the end of the last token consumed.
+func (p *Parser) span(start Pos) Span {
+	return Span{Start: start, End: p.last.end}
+}
+
+func (p *Parser) expect(kind tokenKind, what string) token {
+	t := p.next()
+	if t.kind != kind {
+		p.errorf("unexpected %s, expected %s", t, what)
+	}
+	return t
+}
+
+// name parses a string used as a name, which unlike other strings must be
+// valid UTF-8.
+func (p *Parser) name(what string) []byte {
+	t := p.expect(tokenString, what)
+	if !utf8.Valid(t.val) {
+		p.errorf("%s: malformed UTF-8 encoding in %s %q", t.pos, what, t.val)
+	}
+	return t.val
+}
+
+func (p *Parser) accept(kind tokenKind) bool {
+	if p.peek(0).kind == kind {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// acceptForm consumes '(' kind if the next tokens match.
+func (p *Parser) acceptForm(kind tokenKind) bool {
+	if p.peek(0).kind == tokenLParen && p.peek(1).kind == kind {
+		p.next()
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *Parser) optionalID() string {
+	if p.peek(0).kind == tokenIdent {
+		return string(p.next().val)
+	}
+	return ""
+}
+
+// defineID parses the optional id of an item of the given index space,
+// which must not be defined twice in the module, or in the function for
+// params and locals.
+func (p *Parser) defineID(space Op) string {
+	t := p.peek(0)
+	if t.kind != tokenIdent {
+		return ""
+	}
+	p.next()
+	id := string(t.val)
+	scope := p.idents
+	if space == OpLocal {
+		scope = p.locals
+	}
+	if scope == nil {
+		return id
+	}
+	if first, ok := scope[ident{space, id}]; ok {
+		p.errorf("%s: duplicate identifier %s, first defined at %s", t.pos, id, first)
+	}
+	scope[ident{space, id}] = t.pos
+	return id
+}
+
+func (p *Parser) index() string {
+	t := p.next()
+	if t.kind != tokenIdent && t.kind != tokenNumber {
+		p.errorf("unexpected %s, expected index", t)
+	}
+	return string(t.val)
+}
+
+func (p *Parser) valtype() string {
+	t := p.next()
+	switch t.kind {
+	case tokenNumtype, tokenVectype, tokenFuncRef, tokenExternRef:
+		return string(t.val)
+	}
+	p.errorf("unexpected %s, expected value type", t)
+	return ""
+}
+
+func (p *Parser) parseModule() *Node {
+	start := p.expect(tokenLParen, "'('").pos
+	p.expect(tokenModule, "module")
+	m := NewNode(OpModule, p.optionalID())
+	p.parseFields(m)
+	p.expect(tokenRParen, "')'")
+	m.Span = p.span(start)
+	return m
+}
+
+// parseFields parses module fields until a closing paren or EOF.
+func (p *Parser) parseFields(m *Node) *Node {
+	p.funcs, p.globals, p.tables, p.mems = 0, 0, 0, 0
+	p.idents = map[ident]Pos{}
+	for p.peek(0).kind == tokenLParen {
+		lparen := p.next()
+		start := lparen.pos
+		fields := len(m.Args)
+		switch t := p.next(); t.kind {
+		case tokenType:
+			m.Args = append(m.Args, p.parseType())
+		case tokenImport:
+			m.Args = append(m.Args, p.parseImport())
+		case tokenFunc:
+			m.Args = append(m.Args, p.parseFunc()...)
+		case tokenExport:
+			m.Args = append(m.Args, p.parseExport())
+		case tokenGlobal:
+			m.Args = append(m.Args, p.parseGlobal()...)
+		case tokenTable:
+			m.Args = append(m.Args, p.parseTable()...)
+		case tokenMemory:
+			m.Args = append(m.Args, p.parseMemory()...)
+		case tokenElem:
+			m.Args = append(m.Args, p.parseElem())
+		case tokenData:
+			m.Args = append(m.Args, p.parseData())
+		case tokenStart:
+			m.Args = append(m.Args, NewNode(OpStart, p.index()))
+		default:
+			p.errorf("unexpected %s, expected module field", t)
+		}
+		p.expect(tokenRParen, "')'")
+		// inline exports and imports share the span of their field
+		for _, f := range m.Args[fields:] {
+			f.Span = p.span(start)
+		}
+		if len(m.Args) > fields {
+			m.Args[fields].Comments = lparen.comments
+		}
+	}
+	return m
+}
+
+func (p *Parser) parseType() *Node {
+	n := NewNode(OpType, p.defineID(OpType))
+	p.expect(tokenLParen, "'('")
+	p.expect(tokenFunc, "func")
+	f := NewNode(OpFunc, "")
+	p.locals = map[ident]Pos{}
+	f.Args = p.parseSignature()
+	p.locals = nil
+	p.expect(tokenRParen, "')'")
+	n.Args = append(n.Args, f)
+	return n
+}
+
+// parseSignature parses (param ...) and (result ...) groups.
+func (p *Parser) parseSignature() []*Node {
+	var nodes []*Node
+	for {
+		switch {
+		case p.acceptForm(tokenParam):
+			nodes = append(nodes, p.parseValtypes(OpParam, true))
+		case p.acceptForm(tokenResult):
+			nodes = append(nodes, p.parseValtypes(OpResult, false))
+		default:
+			return nodes
+		}
+	}
+}
+
+// parseValtypes parses the remainder of a param, result or local group.
+// Anonymous groups may declare several entries, which get consecutive
+// indices, while a named group declares exactly one.
+func (p *Parser) parseValtypes(op Op, named bool) *Node {
+	if named {
+		if id := p.defineID(OpLocal); id != "" {
+			meta := id + " " + p.valtype()
+			p.expect(tokenRParen, "')'")
+			return NewNode(op, meta)
+		}
+	}
+	var meta []string
+	for p.peek(0).kind != tokenRParen {
+		meta = append(meta, p.valtype())
+	}
+	p.next()
+	return NewNode(op, strings.Join(meta, " "))
+}
+
+// parseTypeUse parses an optional (type x) followed by a signature.
+func (p *Parser) parseTypeUse() []*Node {
+	var nodes []*Node
+	if p.acceptForm(tokenType) {
+		nodes = append(nodes, NewNode(OpTypeUse, p.index()))
+		p.expect(tokenRParen, "')'")
+	}
+	return append(nodes, p.parseSignature()...)
+}
+
+// parseInlineExports desugars (export "name")* into export fields for the
+// item with the given kind and index.
+func (p *Parser) parseInlineExports(op Op, ref string) []*Node {
+	var exports []*Node
+	for p.acceptForm(tokenExport) {
+		name := p.name("export name")
+		p.expect(tokenRParen, "')'")
+		exports = append(exports, NewNode(OpExport, Quote(name), NewNode(op, ref)))
+	}
+	return exports
+}
+
+func (p *Parser) ref(id string, idx int) string {
+	if id != "" {
+		return id
+	}
+	return strconv.Itoa(idx)
+}
+
+func (p *Parser) parseFunc() []*Node {
+	id := p.defineID(OpFunc)
+	f := NewNode(OpFunc, id)
+	exports := p.parseInlineExports(OpFunc, p.ref(id, p.funcs))
+	p.funcs++
+
+	p.locals = map[ident]Pos{}
+	if p.acceptForm(tokenImport) {
+		imp := p.parseImportNames()
+		p.expect(tokenRParen, "')'")
+		f.Args = p.parseTypeUse()
+		p.locals = nil
+		imp.Args = append(imp.Args, f)
+		return append([]*Node{imp}, exports...)
+	}
+
+	f.Args = p.parseTypeUse()
+	for p.acceptForm(tokenLocal) {
+		f.Args = append(f.Args, p.parseValtypes(OpLocal, true))
+	}
+	// the params of block types have no scope of their own
+	p.locals = nil
+	f.Args = append(f.Args, p.parseInstrs()...)
+	return append([]*Node{f}, exports...)
+}
+
+// parseImportNames parses the module and item names of an import.
+func (p *Parser) parseImportNames() *Node {
+	mod := p.name("module name")
+	name := p.name("import name")
+	return NewNode(OpImport, Quote(mod)+" "+Quote(name))
+}
+
+func (p *Parser) parseImport() *Node {
+	n := p.parseImportNames()
+	p.expect(tokenLParen, "'('")
+	var desc *Node
+	switch t := p.next(); t.kind {
+	case tokenFunc:
+		desc = NewNode(OpFunc, p.defineID(OpFunc))
+		p.locals = map[ident]Pos{}
+		desc.Args = p.parseTypeUse()
+		p.locals = nil
+		p.funcs++
+	case tokenGlobal:
+		desc = p.parseGlobalType(p.defineID(OpGlobal))
+		p.globals++
+	case tokenTable:
+		desc = NewNode(OpTable, p.parseLimits(p.defineID(OpTable))+" "+p.valtype())
+		p.tables++
+	case tokenMemory:
+		desc = NewNode(OpMemory, p.parseMemoryType(p.defineID(OpMemory)))
+		p.mems++
+	default:
+		p.errorf("unexpected %s, expected import kind", t)
+	}
+	p.expect(tokenRParen, "')'")
+	n.Args = append(n.Args, desc)
+	return n
+}
+
+func (p *Parser) parseExport() *Node {
+	name := p.name("export name")
+	p.expect(tokenLParen, "'('")
+	var op Op
+	switch t := p.next(); t.kind {
+	case tokenFunc:
+		op = OpFunc
+	case tokenGlobal:
+		op = OpGlobal
+	case tokenTable:
+		op = OpTable
+	case tokenMemory:
+		op = OpMemory
+	default:
+		p.errorf("unexpected %s, expected export kind", t)
+	}
+	desc := NewNode(op, p.index())
+	p.expect(tokenRParen, "')'")
+	return NewNode(OpExport, Quote(name), desc)
+}
+
+func (p *Parser) parseGlobal() []*Node {
+	id := p.defineID(OpGlobal)
+	exports := p.parseInlineExports(OpGlobal, p.ref(id, p.globals))
+	p.globals++
+
+	if p.acceptForm(tokenImport) {
+		imp := p.parseImportNames()
+		p.expect(tokenRParen, "')'")
+		imp.Args = append(imp.Args, p.parseGlobalType(id))
+		return append([]*Node{imp}, exports...)
+	}
+
+	g := p.parseGlobalType(id)
+	g.Args = append(g.Args, p.parseInstrs()...)
+	return append([]*Node{g}, exports...)
+}
+
+// parseGlobalType parses the type of a global. Mutable types are kept as an
+// OpMut child, immutable ones go in the atoms after the id.
+func (p *Parser) parseGlobalType(id string) *Node {
+	g := NewNode(OpGlobal, id)
+	if p.acceptForm(tokenMut) {
+		g.Args = append(g.Args, NewNode(OpMut, p.valtype()))
+		p.expect(tokenRParen, "')'")
+	} else {
+		g.Meta = strings.TrimSpace(id + " " + p.valtype())
+	}
+	return g
+}
+
+func (p *Parser) parseTable() []*Node {
+	id := p.defineID(OpTable)
+	ref := p.ref(id, p.tables)
+	exports := p.parseInlineExports(OpTable, ref)
+	p.tables++
+
+	if p.acceptForm(tokenImport) {
+		imp := p.parseImportNames()
+		p.expect(tokenRParen, "')'")
+		imp.Args = append(imp.Args, NewNode(OpTable, p.parseLimits(id)+" "+p.valtype()))
+		return append([]*Node{imp}, exports...)
+	}
+
+	if k := p.peek(0).kind; k == tokenFuncRef || k == tokenExternRef {
+		// inline elements, desugared into an active segment filling a
+		// table of the exact size
+		typ := p.valtype()
+		p.expect(tokenLParen, "'('")
+		p.expect(tokenElem, "elem")
+		_, items := p.parseElemList()
+		p.expect(tokenRParen, "')'")
+
+		size := strconv.Itoa(len(items))
+		table := NewNode(OpTable, strings.TrimSpace(id+" "+size+" "+size+" "+typ))
+		elem := NewNode(OpElem, typ, NewNode(OpTable, ref), NewNode(OpOffset, "", NewNode(OpI32Const, "0")))
+		elem.Args = append(elem.Args, items...)
+		return append([]*Node{table, elem}, exports...)
+	}
+
+	table := NewNode(OpTable, p.parseLimits(id)+" "+p.valtype())
+	return append([]*Node{table}, exports...)
+}
+
+func (p *Parser) parseMemory() []*Node {
+	id := p.defineID(OpMemory)
+	exports := p.parseInlineExports(OpMemory, p.ref(id, p.mems))
+	p.mems++
+
+	if p.acceptForm(tokenImport) {
+		imp := p.parseImportNames()
+		p.expect(tokenRParen, "')'")
+		imp.Args = append(imp.Args, NewNode(OpMemory, p.parseMemoryType(id)))
+		return append([]*Node{imp}, exports...)
+	}
+
+	mem := NewNode(OpMemory, p.parseMemoryType(id))
+	return append([]*Node{mem}, exports...)
+}
+
+// parseLimits parses the limits of a table or memory, returning them after
+// the id.
+func (p *Parser) parseLimits(id string) string {
+	meta := []string{}
+	if id != "" {
+		meta = append(meta, id)
+	}
+	meta = append(meta, string(p.expect(tokenNumber, "limits").val))
+	if p.peek(0).kind == tokenNumber {
+		meta = append(meta, string(p.next().val))
+	}
+	return strings.Join(meta, " ")
+}
+
+// parseMemoryType parses the limits of a memory and the shared keyword of
+// the threads proposal, which requires a maximum.
+func (p *Parser) parseMemoryType(id string) string {
+	start := p.peek(0).pos
+	limits := p.parseLimits("")
+	if t := p.peek(0); t.kind == tokenKeyword && string(t.val) == "shared" {
+		p.next()
+		if !strings.Contains(limits, " ") {
+			p.errorf("%s: shared memory must have maximum", start)
 		}
+		limits += " shared"
+	}
+	if id != "" {
+		return id + " " + limits
+	}
+	return limits
+}
+
+// parseElem parses an element segment. Its atoms are the id, the declare
+// keyword of declarative segments and the type of the elements, which are
+// kept as OpItem children after the table and offset of active segments.
+func (p *Parser) parseElem() *Node {
+	meta := []string{}
+	if id := p.defineID(OpElem); id != "" {
+		meta = append(meta, id)
+	}
+
+	e := NewNode(OpElem, "")
+	if p.accept(tokenDeclare) {
+		meta = append(meta, "declare")
+	} else {
+		if p.acceptForm(tokenTable) {
+			e.Args = append(e.Args, NewNode(OpTable, p.index()))
+			p.expect(tokenRParen, "')'")
+		}
+		if p.acceptForm(tokenOffset) {
+			e.Args = append(e.Args, NewNode(OpOffset, "", p.parseInstrs()...))
+			p.expect(tokenRParen, "')'")
+		} else if p.peek(0).kind == tokenLParen {
+			// abbreviated offset: a single folded instruction
+			e.Args = append(e.Args, NewNode(OpOffset, "", p.parseFolded()))
+		}
+	}
+
+	typ, items := p.parseElemList()
+	e.Meta = strings.Join(append(meta, typ), " ")
+	e.Args = append(e.Args, items...)
+	return e
+}
+
+// parseElemList parses the elements of a segment, either expressions or
+// function indices. Function indices are desugared into ref.func items.
+func (p *Parser) parseElemList() (string, []*Node) {
+	typ := "funcref"
+	switch p.peek(0).kind {
+	case tokenFunc:
+		p.next()
+	case tokenFuncRef, tokenExternRef:
+		typ = p.valtype()
+		return typ, p.parseElemExprs()
+	case tokenLParen:
+		return typ, p.parseElemExprs()
+	}
+
+	var items []*Node
+	for k := p.peek(0).kind; k == tokenIdent || k == tokenNumber; k = p.peek(0).kind {
+		items = append(items, NewNode(OpItem, "", NewNode(OpRefFunc, p.index())))
+	}
+	return typ, items
+}
+
+func (p *Parser) parseElemExprs() []*Node {
+	var items []*Node
+	for p.peek(0).kind == tokenLParen {
+		if p.acceptForm(tokenItem) {
+			items = append(items, NewNode(OpItem, "", p.parseInstrs()...))
+			p.expect(tokenRParen, "')'")
+		} else {
+			items = append(items, NewNode(OpItem, "", p.parseFolded()))
+		}
+	}
+	return items
+}
+
+func (p *Parser) parseData() *Node {
+	meta := []string{}
+	if id := p.defineID(OpData); id != "" {
+		meta = append(meta, id)
+	}
+
+	d := NewNode(OpData, "")
+	if p.acceptForm(tokenMemory) {
+		d.Args = append(d.Args, NewNode(OpMemory, p.index()))
+		p.expect(tokenRParen, "')'")
+	}
+
+	if p.acceptForm(tokenOffset) {
+		d.Args = append(d.Args, NewNode(OpOffset, "", p.parseInstrs()...))
+		p.expect(tokenRParen, "')'")
+	} else if p.peek(0).kind == tokenLParen {
+		// abbreviated offset: a single folded instruction
+		d.Args = append(d.Args, NewNode(OpOffset, "", p.parseFolded()))
+	}
+
+	for p.peek(0).kind == tokenString {
+		meta = append(meta, Quote(p.next().val))
+	}
+	d.Meta = strings.Join(meta, " ")
+	return d
+}
+
+// parseInstrs parses a sequence of plain and folded instructions. It stops
+// at a closing paren, at a non-instruction form or at a block delimiter
+// (else/end), leaving them for the caller.
+func (p *Parser) parseInstrs() []*Node {
+	var instrs []*Node
+	for {
+		t := p.peek(0)
+		switch t.kind {
+		case tokenLParen:
+			if _, ok := LookupOp(string(p.peek(1).val)); !ok {
+				return instrs
+			}
+			instrs = append(instrs, p.parseFolded())
+		case tokenRParen, tokenEOF, tokenElse, tokenEnd:
+			return instrs
+		default:
+			instrs = append(instrs, p.parsePlain())
+		}
+	}
+}
+
+// parsePlain parses an instruction in its flat form.
+func (p *Parser) parsePlain() *Node {
+	t := p.next()
+	op, ok := LookupOp(string(t.val))
+	if !ok {
+		p.errorf("unexpected %s, expected instruction", t)
+	}
+
+	if op == OpBlock || op == OpLoop || op == OpIf {
+		p.blocks++
+		p.checkDepth(t.pos)
+		defer func() { p.blocks-- }()
+	}
+
+	var n *Node
+	switch op {
+	case OpBlock, OpLoop:
+		n = p.parseBlockHeader(op)
+		n.Args = append(n.Args, p.parseInstrs()...)
+		p.parseEnd(n.Meta)
+	case OpIf:
+		n = p.parseBlockHeader(op)
+		then := NewNode(OpThen, "", p.parseInstrs()...)
+		n.Args = append(n.Args, then)
+		if p.accept(tokenElse) {
+			p.parseLabelRepeat(n.Meta)
+			n.Args = append(n.Args, NewNode(OpElse, "", p.parseInstrs()...))
+		}
+		p.parseEnd(n.Meta)
+	default:
+		n = NewNode(op, p.parseImmediates(op))
+		n.Args = p.parseSelectType(op)
+	}
+	n.Span = p.span(t.pos)
+	return n
+}
+
+func (p *Parser) parseEnd(label string) {
+	p.expect(tokenEnd, "end")
+	p.parseLabelRepeat(label)
+}
+
+// parseLabelRepeat parses the optional label that may follow else and end,
+// which must match the label of the block.
+func (p *Parser) parseLabelRepeat(label string) {
+	if p.peek(0).kind == tokenIdent {
+		if t := p.next(); string(t.val) != label {
+			p.errorf("mismatching label %s", t.val)
+		}
+	}
+}
+
+// parseSelectType parses the optional (result t) of a typed select, and the
+// type use of call_indirect.
+func (p *Parser) parseSelectType(op Op) []*Node {
+	switch {
+	case op == OpSelect && p.acceptForm(tokenResult):
+		return []*Node{p.parseValtypes(OpResult, false)}
+	case op == OpCallIndirect:
+		return p.parseTypeUse()
 	}
 	return nil
 }
+
+// parseBlockHeader parses the label and the block type of a block, which
+// can refer to a type with (type x) as well as list its params and results.
+func (p *Parser) parseBlockHeader(op Op) *Node {
+	n := NewNode(op, p.optionalID())
+	if p.acceptForm(tokenType) {
+		n.Args = append(n.Args, NewNode(OpTypeUse, p.index()))
+		p.expect(tokenRParen, "')'")
+	}
+	for p.acceptForm(tokenParam) {
+		n.Args = append(n.Args, p.parseValtypes(OpParam, false))
+	}
+	for p.acceptForm(tokenResult) {
+		n.Args = append(n.Args, p.parseValtypes(OpResult, false))
+	}
+	return n
+}
+
+// parseFolded parses an instruction in its folded (S-expression) form.
+func (p *Parser) parseFolded() *Node {
+	start := p.expect(tokenLParen, "'('").pos
+	t := p.next()
+	op, ok := LookupOp(string(t.val))
+	if !ok {
+		p.errorf("unexpected %s, expected instruction", t)
+	}
+
+	var n *Node
+	switch op {
+	case OpBlock, OpLoop:
+		n = p.parseBlockHeader(op)
+		n.Args = append(n.Args, p.parseInstrs()...)
+	case OpIf:
+		n = p.parseBlockHeader(op)
+		n.Args = append(n.Args, p.parseInstrs()...)
+		p.expect(tokenLParen, "'('")
+		p.expect(tokenThen, "then")
+		n.Args = append(n.Args, NewNode(OpThen, "", p.parseInstrs()...))
+		p.expect(tokenRParen, "')'")
+		if p.acceptForm(tokenElse) {
+			n.Args = append(n.Args, NewNode(OpElse, "", p.parseInstrs()...))
+			p.expect(tokenRParen, "')'")
+		}
+	default:
+		n = NewNode(op, p.parseImmediates(op))
+		n.Args = append(p.parseSelectType(op), p.parseInstrs()...)
+	}
+	p.expect(tokenRParen, "')'")
+	n.Span = p.span(start)
+	return n
+}
+
+// isLiteral reports whether t can be the literal of a constant: a number,
+// a keyword such as inf or nan, or one of the NaN patterns the results of
+// script assertions match against.
+func isLiteral(t token) bool {
+	switch t.kind {
+	case tokenNumber, tokenKeyword, tokenNanCanonical, tokenNanArithmetic:
+		return true
+	}
+	return false
+}
+
+// parseImmediates parses the immediates of a plain instruction and returns
+// them as written.
+func (p *Parser) parseImmediates(op Op) string {
+	switch op {
+	case OpI32Const, OpI64Const, OpF32Const, OpF64Const:
+		t := p.next()
+		if !isLiteral(t) {
+			p.errorf("unexpected %s, expected number", t)
+		}
+		switch op {
+		case OpI32Const:
+			p.checkInt(t, 32)
+		case OpI64Const:
+			p.checkInt(t, 64)
+		}
+		return string(t.val)
+	case OpLocalGet, OpLocalSet, OpLocalTee, OpGlobalGet, OpGlobalSet,
+		OpCall, OpBr, OpBrIf:
+		return p.index()
+	case OpRefNull:
+		t := p.next()
+		if t.kind != tokenFunc && t.kind != tokenExtern {
+			p.errorf("unexpected %s, expected heap type", t)
+		}
+		return string(t.val)
+	case OpRefFunc, OpElemDrop, OpDataDrop:
+		return p.index()
+	case OpMemoryInit:
+		// the memory index before the segment is optional
+		indices := []string{p.index()}
+		if k := p.peek(0).kind; k == tokenIdent || k == tokenNumber {
+			indices = append(indices, p.index())
+		}
+		return strings.Join(indices, " ")
+	case OpCallIndirect:
+		// the table index is optional
+		if k := p.peek(0).kind; k == tokenIdent || k == tokenNumber {
+			return p.index()
+		}
+		return ""
+	case OpTableGet, OpTableSet, OpTableSize, OpTableGrow, OpTableFill,
+		OpTableCopy, OpTableInit,
+		OpMemorySize, OpMemoryGrow, OpMemoryFill, OpMemoryCopy:
+		// the table or memory index is optional
+		var indices []string
+		for k := p.peek(0).kind; k == tokenIdent || k == tokenNumber; k = p.peek(0).kind {
+			indices = append(indices, p.index())
+		}
+		return strings.Join(indices, " ")
+	case OpV128Const:
+		t := p.next()
+		lanes, ok := shapeLanes[string(t.val)]
+		if t.kind != tokenKeyword || !ok {
+			p.errorf("unexpected %s, expected vector shape", t)
+		}
+		imm := []string{string(t.val)}
+		for range lanes {
+			t := p.next()
+			if !isLiteral(t) {
+				p.errorf("unexpected %s, expected number", t)
+			}
+			imm = append(imm, string(t.val))
+		}
+		return strings.Join(imm, " ")
+	case OpI8x16Shuffle:
+		var lanes []string
+		for range 16 {
+			lanes = append(lanes, p.lane())
+		}
+		return strings.Join(lanes, " ")
+	case OpBrTable:
+		labels := []string{p.index()}
+		for k := p.peek(0).kind; k == tokenIdent || k == tokenNumber; k = p.peek(0).kind {
+			labels = append(labels, p.index())
+		}
+		return strings.Join(labels, " ")
+	}
+
+	if op >= OpI8x16ExtractLaneU && op <= OpF64x2ReplaceLane {
+		return p.lane()
+	}
+
+	if isMemoryAccess(op) {
+		var memarg []string
+		// the memory index of the multi-memory proposal is optional
+		if k := p.peek(0).kind; k == tokenIdent || k == tokenNumber {
+			memarg = append(memarg, p.index())
+		}
+		for k := p.peek(0); k.kind == tokenKeyword; k = p.peek(0) {
+			s := string(k.val)
+			if !strings.HasPrefix(s, "offset=") && !strings.HasPrefix(s, "align=") {
+				break
+			}
+			memarg = append(memarg, string(p.next().val))
+		}
+		return strings.Join(memarg, " ")
+	}
+	return ""
+}
+
+// checkInt rejects integer literals that don't fit in the given bit size.
+func (p *Parser) checkInt(t token, bits int) {
+	if _, err := ParseInt(string(t.val), bits); err != nil {
+		p.errorf("%v", err)
+	}
+}
+
+// shapeLanes is the number of lanes of each vector shape.
+var shapeLanes = map[string]int{
+	"i8x16": 16, "i16x8": 8, "i32x4": 4, "i64x2": 2, "f32x4": 4, "f64x2": 2,
+}
+
+// lane parses a lane index.
+func (p *Parser) lane() string {
+	t := p.next()
+	if t.kind != tokenNumber {
+		p.errorf("unexpected %s, expected lane index", t)
+	}
+	return string(t.val)
+}
+
+func isMemoryAccess(op Op) bool {
+	return op >= OpI32Load && op <= OpV128Store64Lane
+}
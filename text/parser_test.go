@@ -0,0 +1,459 @@
+package text_test
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bluescreen10/war/text"
+)
+
+func TestSpan(t *testing.T) {
+	src := "(module\n  (func (result i32)\n    i32.const 1\n    (i32.add (i32.const 2) (i32.const 3))))"
+	p := text.NewParser([]byte(src))
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	m := p.Root().Args[0]
+	f := m.Args[0]
+	plain, folded := f.Args[1], f.Args[2]
+
+	tests := []struct {
+		name  string
+		node  *text.Node
+		start string
+		text  string
+	}{
+		{"module", m, "1:1", src},
+		{"field", f, "2:3", "(func (result i32)\n    i32.const 1\n    (i32.add (i32.const 2) (i32.const 3)))"},
+		{"plain", plain, "3:5", "i32.const 1"},
+		{"folded", folded, "4:5", "(i32.add (i32.const 2) (i32.const 3))"},
+		{"operand", folded.Args[1], "4:28", "(i32.const 3)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := tt.node.Span
+			if got := span.Start.String(); got != tt.start {
+				t.Errorf("got start %s, expected %s", got, tt.start)
+			}
+			if got := src[span.Start.Offset:span.End.Offset]; got != tt.text {
+				t.Errorf("got %q, expected %q", got, tt.text)
+			}
+		})
+	}
+}
+
+func TestConstRange(t *testing.T) {
+	tests := []struct {
+		instr string
+		err   string
+	}{
+		{"i32.const 4294967295", ""},
+		{"i32.const -2147483648", ""},
+		{"i32.const 0xffff_ffff", ""},
+		{"i32.const 4294967296", "constant out of range for i32"},
+		{"i32.const -2147483649", "constant out of range for i32"},
+		{"i32.const 0x1_0000_0000", "constant out of range for i32"},
+		{"i64.const 18446744073709551615", ""},
+		{"i64.const -9223372036854775808", ""},
+		{"i64.const 18446744073709551616", "constant out of range for i64"},
+		{"i64.const -9223372036854775809", "constant out of range for i64"},
+		{"i64.const 0x1_0000_0000_0000_0000", "constant out of range for i64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.instr, func(t *testing.T) {
+			p := text.NewParser([]byte("(module (func " + tt.instr + " drop))"))
+			err := p.Parse()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || err.Error() != tt.err):
+				t.Errorf("got error %v, expected %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestUnbalancedParens(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"extra", "(module\n  (func (nop)))\n)", "3:1: unexpected ')'"},
+		{"extra in module", "(module (func)))", "1:16: unexpected ')'"},
+		{"missing", "(module\n  (func (nop)\n", "3:1: unexpected EOF, expected ')' closing the '(' at 2:3"},
+		{"missing in func", "(module (func (i32.const 1) drop", "1:33: unexpected EOF, expected ')' closing the '(' at 1:9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := text.NewParser([]byte(tt.src)).Parse()
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("got error %v, expected %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	// nested returns a module with a function nesting n levels of blocks
+	// written as open, closed by close
+	nested := func(n int, open, close string) string {
+		return "(module (func " + strings.Repeat(open, n) + strings.Repeat(close, n) + "))"
+	}
+	tests := []struct {
+		name     string
+		src      string
+		maxDepth int
+		err      string
+	}{
+		{"parens", strings.Repeat("(", 100000), 0, `unexpected "(", expected module field`},
+		{"folded", nested(100000, "(i32.eqz ", ")"), 0, "1:8997: nesting too deep, more than 1000 levels"},
+		{"folded blocks", nested(100000, "(block ", ")"), 0, "1:7001: nesting too deep, more than 1000 levels"},
+		{"plain blocks", nested(100000, "block ", "end "), 0, "1:6003: nesting too deep, more than 1000 levels"},
+		// the module and the func are two levels already
+		{"within the limit", nested(8, "(block ", ")"), 10, ""},
+		{"past the limit", nested(9, "block ", "end "), 10, "1:63: nesting too deep, more than 10 levels"},
+		{"raised limit", nested(5000, "(block ", ")"), 6000, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := text.NewParser([]byte(tt.src))
+			if tt.maxDepth > 0 {
+				p.SetMaxDepth(tt.maxDepth)
+			}
+			err := p.Parse()
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Errorf("got error %v, expected %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseInt(t *testing.T) {
+	tests := []struct {
+		s    string
+		bits int
+		want uint64
+	}{
+		{"010", 32, 10},
+		{"-1", 32, 0xffffffff},
+		{"+0x7f", 8, 0x7f},
+		{"-128", 8, 0x80},
+		{"255", 8, 0xff},
+	}
+	for _, tt := range tests {
+		if got, err := text.ParseInt(tt.s, tt.bits); err != nil || got != tt.want {
+			t.Errorf("ParseInt(%q, %d) = %#x, %v; expected %#x", tt.s, tt.bits, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"+-1", "-", "0o17", "256", "-129"} {
+		if _, err := text.ParseInt(s, 8); err == nil {
+			t.Errorf("ParseInt(%q, 8): expected an error", s)
+		}
+	}
+}
+
+func TestNodeEqual(t *testing.T) {
+	parse := func(src string) *text.Node {
+		t.Helper()
+		p := text.NewParser([]byte(src))
+		if err := p.Parse(); err != nil {
+			t.Fatal(err)
+		}
+		return p.Root()
+	}
+
+	src := `(module
+  (func $f (export "f") (param i32) (result i32)
+    (i32.add (local.get 0) (i32.const 1))))`
+	a, b := parse(src), parse(src)
+	if !a.Equal(b) {
+		t.Error("two parses of the same source are not equal")
+	}
+	// layout doesn't matter, only the tree
+	if !a.Equal(parse(`(module (func $f (export "f") (param i32) (result i32) (i32.add (local.get 0) (i32.const 1))))`)) {
+		t.Error("reformatted source is not equal")
+	}
+	if a.Equal(parse(`(module
+  (func $f (export "f") (param i32) (result i32)
+    (i32.add (local.get 0) (i32.const 2))))`)) {
+		t.Error("different constants are equal")
+	}
+	if a.Equal(nil) || !(*text.Node)(nil).Equal(nil) {
+		t.Error("nil comparisons are wrong")
+	}
+
+	// formatting round-trips to the same tree
+	if !a.Equal(parse(string(text.Format(a, text.FormatOptions{Folded: true})))) {
+		t.Error("formatted tree is not equal")
+	}
+}
+
+func TestInlineExports(t *testing.T) {
+	parse := func(src string) *text.Node {
+		t.Helper()
+		p := text.NewParser([]byte(src))
+		if err := p.Parse(); err != nil {
+			t.Fatal(err)
+		}
+		return p.Root()
+	}
+
+	// the exports refer to the ids, or the indices of anonymous fields
+	got := parse(`(module
+  (memory (export "m") (export "mem") 1)
+  (table $t (export "t") 1 funcref)
+  (global (export "g") i32 (i32.const 0)))`)
+	want := parse(`(module
+  (memory 1) (export "m" (memory 0)) (export "mem" (memory 0))
+  (table $t 1 funcref) (export "t" (table $t))
+  (global i32 (i32.const 0)) (export "g" (global 0)))`)
+	if !got.Equal(want) {
+		t.Errorf("got\n%s\nexpected\n%s", text.Format(got, text.FormatOptions{}), text.Format(want, text.FormatOptions{}))
+	}
+}
+
+func TestNodeIDs(t *testing.T) {
+	src := []byte(`(module
+  (func $f (param i32) (result i32)
+    (i32.add (local.get 0) (i32.const 1))))`)
+
+	// ids collects the IDs of a tree in pre-order
+	var ids func(n *text.Node) []int
+	ids = func(n *text.Node) []int {
+		got := []int{n.ID}
+		for _, a := range n.Args {
+			got = append(got, ids(a)...)
+		}
+		return got
+	}
+
+	const parsers = 8
+	results := make([][]int, parsers)
+	var wg sync.WaitGroup
+	for i := range parsers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := text.NewParser(src)
+			if err := p.Parse(); err != nil {
+				t.Error(err)
+				return
+			}
+			results[i] = ids(p.Root())
+		}()
+	}
+	wg.Wait()
+
+	for i, got := range results {
+		for j, id := range got {
+			if id != j+1 {
+				t.Fatalf("parser %d: got IDs %v, expected 1 to %d", i, got, len(got))
+			}
+		}
+		if !slices.Equal(got, results[0]) {
+			t.Errorf("parser %d: got IDs %v, expected %v", i, got, results[0])
+		}
+	}
+}
+
+func TestParseFloat(t *testing.T) {
+	tests := []struct {
+		s    string
+		bits int
+		want uint64
+	}{
+		{"0x1p+0", 64, math.Float64bits(1)},
+		{"0x1.921fb54442d18p+1", 64, math.Float64bits(math.Pi)},
+		{"-0x1.921fb54442d18p+1", 64, math.Float64bits(-math.Pi)},
+		{"0x1.921fb6p+1", 32, uint64(math.Float32bits(math.Pi))},
+		{"0x1.8", 64, math.Float64bits(1.5)},
+		{"0x1_0", 32, uint64(math.Float32bits(16))},
+		{"0x1p-1074", 64, 1},
+		{"0x1P-149", 32, 1},
+		{"nan:0x1", 32, 0x7f800001},
+		{"-nan:0x7fffff", 32, 0xffffffff},
+		{"nan:0xf_ffff_ffff_ffff", 64, 0x7fffffffffffffff},
+	}
+	for _, tt := range tests {
+		if got, err := text.ParseFloat(tt.s, tt.bits); err != nil || got != tt.want {
+			t.Errorf("ParseFloat(%q, %d) = %#x, %v; expected %#x", tt.s, tt.bits, got, err, tt.want)
+		}
+	}
+
+	// a payload of zero is an infinity, a larger one spills into the exponent
+	for _, tt := range []struct {
+		s    string
+		bits int
+	}{
+		{"nan:0x0", 32},
+		{"nan:0x800000", 32},
+		{"-nan:0xffffffff", 32},
+		{"nan:0x0", 64},
+		{"nan:0x10000000000000", 64},
+	} {
+		if got, err := text.ParseFloat(tt.s, tt.bits); err == nil {
+			t.Errorf("ParseFloat(%q, %d) = %#x, expected an error", tt.s, tt.bits, got)
+		}
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		bits int
+		v    uint64
+	}{
+		{32, 0},
+		{32, 0x80000000},                         // -0
+		{32, 1},                                  // smallest subnormal
+		{32, 0x007fffff},                         // largest subnormal
+		{32, 0x00800000},                         // smallest normal
+		{32, 0x7f7fffff},                         // largest normal
+		{32, uint64(math.Float32bits(0.1))},      // not exact in decimal
+		{32, uint64(math.Float32bits(16777217))}, // past the exact integers
+		{32, 0x7f800000},                         // inf
+		{32, 0xff800000},                         // -inf
+		{32, 0x7fc00000},                         // canonical nan
+		{32, 0xffc00000},                         // negative nan
+		{32, 0x7f800001},                         // signalling nan
+		{32, 0x7fffffff},                         // full payload
+		{64, 0},
+		{64, 1 << 63},
+		{64, 1},
+		{64, 0x000fffffffffffff},
+		{64, 0x0010000000000000},
+		{64, 0x7fefffffffffffff},
+		{64, math.Float64bits(0.1)},
+		{64, math.Float64bits(math.Pi)},
+		{64, math.Float64bits(1<<53 + 2)},
+		{64, 0x7ff0000000000000},
+		{64, 0xfff0000000000000},
+		{64, 0x7ff8000000000000},
+		{64, 0x7ff0000000000001},
+		{64, 0xfff4000000000000},
+	}
+	for _, tt := range tests {
+		s := text.FormatFloat(tt.v, tt.bits)
+		if got, err := text.ParseFloat(s, tt.bits); err != nil || got != tt.v {
+			t.Errorf("ParseFloat(%q, %d) = %#x, %v; expected %#x", s, tt.bits, got, err, tt.v)
+		}
+
+		// the literal is a valid token of the text format too
+		src := fmt.Sprintf("(module (func (result f%d) (f%d.const %s)))", tt.bits, tt.bits, s)
+		p := text.NewParser([]byte(src))
+		if err := p.Parse(); err != nil {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+}
+
+func TestSharedMemory(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		meta string
+		err  string
+	}{
+		{"shared", "(module (memory $m 1 2 shared))", "$m 1 2 shared", ""},
+		{"unshared", "(module (memory 1 2))", "1 2", ""},
+		{"import", `(module (import "env" "mem" (memory 1 2 shared)))`, "1 2 shared", ""},
+		{"inline import", `(module (memory (import "env" "mem") 1 2 shared))`, "1 2 shared", ""},
+		{"missing max", "(module (memory 1 shared))", "", "1:17: shared memory must have maximum"},
+		{"import missing max", `(module (import "env" "mem" (memory 1 shared)))`, "", "1:37: shared memory must have maximum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := text.NewParser([]byte(tt.src))
+			err := p.Parse()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("got error %v, expected %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var meta string
+			text.Inspect(p.Root(), func(n *text.Node) {
+				if n.Op == text.OpMemory {
+					meta = n.Meta
+				}
+			})
+			if meta != tt.meta {
+				t.Errorf("got memory %q, expected %q", meta, tt.meta)
+			}
+		})
+	}
+}
+
+func TestDuplicateIdentifiers(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"funcs", `(module (func $f) (func $f))`, "1:25: duplicate identifier $f, first defined at 1:15"},
+		{"imported func", `(module (import "m" "f" (func $f)) (func $f))`, "1:42: duplicate identifier $f, first defined at 1:31"},
+		{"inline import", `(module (func $f (import "m" "f")) (func $f))`, "1:42: duplicate identifier $f, first defined at 1:15"},
+		{"locals", `(module (func (local $x i32) (local $x i64)))`, "1:37: duplicate identifier $x, first defined at 1:22"},
+		{"param and local", `(module (func (param $x i32) (local $x i32)))`, "1:37: duplicate identifier $x, first defined at 1:22"},
+		{"type params", `(module (type (func (param $x i32) (param $x i32))))`, "1:43: duplicate identifier $x, first defined at 1:28"},
+		{"globals", "(module\n  (global $g i32 (i32.const 0))\n  (global $g i32 (i32.const 0)))", "3:11: duplicate identifier $g, first defined at 2:11"},
+		{"memories", `(module (memory $m 1) (memory $m 1))`, "1:31: duplicate identifier $m, first defined at 1:17"},
+		{"data", `(module (data $d "") (data $d ""))`, "1:28: duplicate identifier $d, first defined at 1:15"},
+		// each index space, function and module has ids of its own
+		{"spaces", `(module (type $x (func)) (func $x (param $x i32) (local $y i32)) (global $x i32 (i32.const 0))
+  (table $x 1 funcref) (memory $x 1) (elem $x func) (data $x ""))`, ""},
+		{"functions", `(module (func (param $x i32)) (func (local $x i32)))`, ""},
+		{"modules", `(module (func $f)) (module (func $f))`, ""},
+		{"labels", `(module (func (local $x i32) (block $x (block $x))))`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := text.NewParser([]byte(tt.src)).Parse()
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.err {
+				t.Errorf("got error %q, expected %q", got, tt.err)
+			}
+		})
+	}
+}
+
+func TestNameEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"export", `(module (func) (export "\ff" (func 0)))`, `1:24: malformed UTF-8 encoding in export name "\xff"`},
+		{"inline export", `(module (func (export "a\c0") ))`, `1:23: malformed UTF-8 encoding in export name "a\xc0"`},
+		{"import module", `(module (import "\80" "f" (func)))`, `1:17: malformed UTF-8 encoding in module name "\x80"`},
+		{"import name", `(module (import "m" "\ed\a0\80" (func)))`, `1:21: malformed UTF-8 encoding in import name "\xed\xa0\x80"`},
+		{"invoke", `(invoke "\ff")`, `1:9: malformed UTF-8 encoding in export name "\xff"`},
+		{"unicode", `(module (func (export "\u{1f600}é")))`, ""},
+		// data is arbitrary bytes
+		{"data", `(module (memory 1) (data (i32.const 0) "\ff\c0"))`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := text.NewParser([]byte(tt.src)).Parse()
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("got error %v, expected %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package text
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Quote returns b as a text format string literal. Printable ASCII is kept
+// as is, tabs and line breaks are written as \t, \n and \r, and every other
+// byte as a \hh escape, so the literal decodes back to exactly the same
+// bytes.
+func Quote(b []byte) string {
+	var s strings.Builder
+	s.WriteByte('"')
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\':
+			s.WriteByte('\\')
+			s.WriteByte(c)
+		case c == '\t':
+			s.WriteString(`\t`)
+		case c == '\n':
+			s.WriteString(`\n`)
+		case c == '\r':
+			s.WriteString(`\r`)
+		case c >= 0x20 && c < 0x7f:
+			s.WriteByte(c)
+		default:
+			fmt.Fprintf(&s, "\\%02x", c)
+		}
+	}
+	s.WriteByte('"')
+	return s.String()
+}
+
+// Unquote decodes a text format string literal.
+func Unquote(s string) ([]byte, error) {
+	l := NewLexer([]byte(s))
+	t := l.nextToken()
+	if t.kind != tokenString {
+		return nil, fmt.Errorf("invalid string literal: %s", s)
+	}
+	if l.nextToken().kind != tokenEOF {
+		return nil, fmt.Errorf("invalid string literal: %s", s)
+	}
+	return t.val, nil
+}
+
+// Fields splits the Meta of a node into its atoms. Unlike strings.Fields it
+// keeps quoted strings, which may contain spaces, in one piece.
+func Fields(s string) []string {
+	var fields []string
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == ' ':
+			i++
+		case s[i] == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			fields = append(fields, s[i:min(j+1, len(s))])
+			i = j + 1
+		default:
+			j := strings.IndexByte(s[i:], ' ')
+			if j < 0 {
+				j = len(s) - i
+			}
+			fields = append(fields, s[i:i+j])
+			i += j
+		}
+	}
+	return fields
+}
@@ -0,0 +1,20 @@
+package text
+
+// Walk traverses the tree rooted at n in pre-order, calling fn for each node.
+// When fn returns false the children of the node are skipped.
+func Walk(n *Node, fn func(*Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for _, a := range n.Args {
+		Walk(a, fn)
+	}
+}
+
+// Inspect calls fn for every node of the tree rooted at n, in pre-order.
+func Inspect(n *Node, fn func(*Node)) {
+	Walk(n, func(n *Node) bool {
+		fn(n)
+		return true
+	})
+}
@@ -0,0 +1,45 @@
+package text_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/bluescreen10/war/text"
+)
+
+func TestWalk(t *testing.T) {
+	p := text.NewParser([]byte(`(module
+  (func $f (param i32) (result i32)
+    (i32.add (local.get 0) (i32.const 1)))
+  (global i32 (i32.const 2))
+  (func $g (result i32)
+    (block (result i32) (i32.const 3))))`))
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(skip text.Op) int {
+		n := 0
+		text.Walk(p.Root(), func(node *text.Node) bool {
+			if node.Op == text.OpI32Const {
+				n++
+			}
+			return node.Op != skip
+		})
+		return n
+	}
+	if got := count(text.OpUnkown); got != 3 {
+		t.Errorf("got %d constants, expected 3", got)
+	}
+	// pruning skips the constants of the functions
+	if got := count(text.OpFunc); got != 1 {
+		t.Errorf("got %d constants outside functions, expected 1", got)
+	}
+
+	var ops []text.Op
+	text.Inspect(p.Root().Args[0].Args[1], func(n *text.Node) { ops = append(ops, n.Op) })
+	want := []text.Op{text.OpGlobal, text.OpI32Const}
+	if !slices.Equal(ops, want) {
+		t.Errorf("got %v, expected %v", ops, want)
+	}
+}
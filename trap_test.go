@@ -0,0 +1,193 @@
+package main_test
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+	"github.com/bluescreen10/war/text"
+)
+
+func TestTrapFrames(t *testing.T) {
+	runtime := war.NewRuntime()
+	_, err := runtime.Instantiate([]byte(`(module
+  (func $div (param i32 i32) (result i32)
+    local.get 0
+    local.get 1
+    i32.div_u)
+  (func $main (export "main") (result i32)
+    i32.const 1
+    i32.const 0
+    call $div))`))
+	if err != nil {
+		t.Fatalf("instantiate: %v", err)
+	}
+
+	_, err = runtime.Invoke("main")
+	var trap *war.Trap
+	if !errors.As(err, &trap) {
+		t.Fatalf("expected trap, got %v", err)
+	}
+	if trap.Reason != "integer divide by zero" {
+		t.Errorf("reason: got %q", trap.Reason)
+	}
+	if got := trap.Pos.String(); got != "5:5" {
+		t.Errorf("pos: got %s expected 5:5", got)
+	}
+
+	expected := []war.Frame{
+		{Func: 0, Name: "div", Offset: 2},
+		{Func: 1, Name: "main", Offset: 2},
+	}
+	frames := trap.Frames()
+	if len(frames) != len(expected) {
+		t.Fatalf("frames: got %v expected %v", frames, expected)
+	}
+	for i := range expected {
+		if frames[i] != expected[i] {
+			t.Errorf("frame %d: got %v expected %v", i, frames[i], expected[i])
+		}
+	}
+}
+
+func TestTrapFramesAnonymous(t *testing.T) {
+	runtime := war.NewRuntime()
+	_, err := runtime.Instantiate([]byte(`(module
+  (func (export "main") (unreachable)))`))
+	if err != nil {
+		t.Fatalf("instantiate: %v", err)
+	}
+
+	_, err = runtime.Invoke("main")
+	var trap *war.Trap
+	if !errors.As(err, &trap) {
+		t.Fatalf("expected trap, got %v", err)
+	}
+	if got := trap.Frames()[0].String(); got != "func[0]+0" {
+		t.Errorf("frame: got %q", got)
+	}
+}
+
+func TestDivisionTraps(t *testing.T) {
+	runtime := war.NewRuntime()
+	_, err := runtime.Instantiate([]byte(`(module
+  (func (export "i32.div_s") (param i32 i32) (result i32)
+    (i32.div_s (local.get 0) (local.get 1)))
+  (func (export "i32.rem_s") (param i32 i32) (result i32)
+    (i32.rem_s (local.get 0) (local.get 1)))
+  (func (export "i64.div_s") (param i64 i64) (result i64)
+    (i64.div_s (local.get 0) (local.get 1))))`))
+	if err != nil {
+		t.Fatalf("instantiate: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []war.Value
+		reason string
+	}{
+		{"i32.div_s", []war.Value{war.I32(math.MinInt32), war.I32(-1)}, "integer overflow"},
+		{"i32.div_s", []war.Value{war.I32(1), war.I32(0)}, "integer divide by zero"},
+		{"i32.div_s", []war.Value{war.I32(math.MinInt32), war.I32(0)}, "integer divide by zero"},
+		{"i64.div_s", []war.Value{war.I64(math.MinInt64), war.I64(-1)}, "integer overflow"},
+		{"i64.div_s", []war.Value{war.I64(1), war.I64(0)}, "integer divide by zero"},
+		{"i32.rem_s", []war.Value{war.I32(1), war.I32(0)}, "integer divide by zero"},
+		// the remainder is defined even when the quotient overflows
+		{"i32.rem_s", []war.Value{war.I32(math.MinInt32), war.I32(-1)}, ""},
+	}
+	for _, tt := range tests {
+		_, err := runtime.Invoke(tt.name, tt.args...)
+		var trap *war.Trap
+		switch {
+		case tt.reason == "" && err != nil:
+			t.Errorf("%s%v: unexpected error %v", tt.name, tt.args, err)
+		case tt.reason == "":
+		case !errors.As(err, &trap):
+			t.Errorf("%s%v: expected trap, got %v", tt.name, tt.args, err)
+		case trap.Reason != tt.reason:
+			t.Errorf("%s%v: got %q, expected %q", tt.name, tt.args, trap.Reason, tt.reason)
+		}
+	}
+}
+
+func TestTrapReasons(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		reason string
+	}{
+		{"unreachable", `unreachable`, "unreachable"},
+		{"divide by zero", `(drop (i32.div_u (i32.const 1) (i32.const 0)))`, "integer divide by zero"},
+		{"division overflow", `(drop (i64.div_s (i64.const 0x8000000000000000) (i64.const -1)))`, "integer overflow"},
+		{"truncation of NaN", `(drop (i32.trunc_f32_s (f32.const nan)))`, "invalid conversion to integer"},
+		{"truncation overflow", `(drop (i64.trunc_f64_u (f64.const -1)))`, "integer overflow"},
+		{"load", `(drop (i32.load (i32.const 65534)))`, "out of bounds memory access"},
+		{"store", `(i64.store offset=1 (i32.const 65528) (i64.const 0))`, "out of bounds memory access"},
+		{"memory.fill", `(memory.fill (i32.const 65535) (i32.const 0) (i32.const 2))`, "out of bounds memory access"},
+		{"memory.init", `(memory.init $d (i32.const 0) (i32.const 1) (i32.const 2))`, "out of bounds memory access"},
+		{"dropped data", `(data.drop $d) (memory.init $d (i32.const 0) (i32.const 0) (i32.const 1))`, "out of bounds memory access"},
+		{"table.get", `(drop (table.get $t (i32.const 2)))`, "out of bounds table access"},
+		{"table.fill", `(table.fill $t (i32.const 1) (ref.null func) (i32.const 2))`, "out of bounds table access"},
+		{"table.init", `(table.init $t $e (i32.const 0) (i32.const 1) (i32.const 1))`, "out of bounds table access"},
+		{"dropped elem", `(elem.drop $e) (table.init $t $e (i32.const 0) (i32.const 0) (i32.const 1))`, "out of bounds table access"},
+		{"call stack", `(call $run)`, "call stack exhausted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := war.NewRuntime()
+			_, err := r.Instantiate([]byte(`(module
+  (memory 1)
+  (table $t 2 funcref)
+  (data $d "x")
+  (elem $e func $run)
+  (func $run (export "run") ` + tt.body + `))`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = r.Invoke("run")
+			var trap *war.Trap
+			if !errors.As(err, &trap) || trap.Reason != tt.reason {
+				t.Errorf("got %v, expected a trap with reason %q", err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestInternalError(t *testing.T) {
+	r := war.NewRuntime()
+	boom := war.HostFunc{Fn: func([]war.Value) ([]war.Value, error) {
+		var m map[string]int
+		m["boom"]++ // a bug of the host
+		return nil, nil
+	}}
+	r.RegisterHost("env", map[string]war.HostFunc{"boom": boom})
+	if _, err := r.Instantiate([]byte(`(module
+  (import "env" "boom" (func $boom))
+  (func (export "run") (result i32)
+    (call $boom)
+    (i32.const 1)))`)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := r.Invoke("run")
+	var ie *war.InternalError
+	if !errors.As(err, &ie) {
+		t.Fatalf("got %v, expected an internal error", err)
+	}
+	if ie.Op != text.OpCall || ie.Pos.Line != 4 || ie.Pos.Col != 5 {
+		t.Errorf("got %s at %s, expected call at 4:5", ie.Op, ie.Pos)
+	}
+	if !strings.HasPrefix(err.Error(), "internal error running call at 4:5: ") {
+		t.Errorf("got error %q", err)
+	}
+
+	// the runtime is still usable
+	if _, err := r.Instantiate([]byte(`(module (func (export "ok") (result i32) (i32.const 2)))`)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.Invoke("ok"); err != nil || got[0] != war.I32(2) {
+		t.Errorf("got %v, %v, expected [i32:2]", got, err)
+	}
+}
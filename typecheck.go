@@ -0,0 +1,612 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bluescreen10/war/text"
+)
+
+// https://webassembly.github.io/spec/core/appendix/algorithm.html
+
+// valueTypeUnknown is the type of the operands of an unreachable stack,
+// which match any type.
+const valueTypeUnknown ValueType = 0
+
+// ctrlFrame is a block being checked.
+type ctrlFrame struct {
+	op     text.Op
+	params []ValueType
+	// results of the block, or of the function for the outermost frame
+	results []ValueType
+	// height of the operand stack when the block was entered
+	height int
+	// set after an unconditional branch, the rest of the block can pop
+	// operands of any type
+	unreachable bool
+}
+
+// labelTypes returns the operands a branch to the frame takes.
+func (f *ctrlFrame) labelTypes() []ValueType {
+	if f.op == text.OpLoop {
+		return f.params
+	}
+	return f.results
+}
+
+// checker validates the bodies of functions against their types.
+type checker struct {
+	m       *Module
+	funcs   []funcType
+	globals []globalType
+	tables  []ValueType // element types
+	mems    int
+	locals  []ValueType
+
+	// the imported globals come first, they alone can be read by constant
+	// expressions
+	importedGlobals int
+
+	vals  []ValueType
+	ctrls []ctrlFrame
+}
+
+type checkError struct{ error }
+
+func (c *checker) errorf(format string, args ...any) {
+	panic(checkError{fmt.Errorf(format, args...)})
+}
+
+// newChecker returns a checker of the code of m, with its index spaces,
+// imports first.
+func newChecker(m *Module) *checker {
+	c := &checker{m: m, mems: len(m.mems)}
+	for _, imp := range m.imports {
+		switch imp.kind {
+		case ExternFunc:
+			c.funcs = append(c.funcs, imp.typ)
+		case ExternGlobal:
+			c.globals = append(c.globals, imp.global)
+			c.importedGlobals++
+		case ExternTable:
+			c.tables = append(c.tables, imp.elem)
+		case ExternMemory:
+			c.mems++
+		}
+	}
+	for _, f := range m.funcs {
+		c.funcs = append(c.funcs, f.typ)
+	}
+	for _, g := range m.globals {
+		c.globals = append(c.globals, g.typ)
+	}
+	for _, t := range m.tables {
+		c.tables = append(c.tables, t.typ)
+	}
+	return c
+}
+
+// checkModule type checks the functions and constant expressions of a
+// compiled module.
+func checkModule(m *Module) error {
+	c := newChecker(m)
+
+	if m.hasStart {
+		if int(m.start) >= len(c.funcs) {
+			return fmt.Errorf("unknown function %d", m.start)
+		}
+		if t := c.funcs[m.start]; len(t.params) > 0 || len(t.results) > 0 {
+			return fmt.Errorf("start function must have type [] -> []")
+		}
+	}
+
+	for i, g := range m.globals {
+		if err := c.checkConst(g.init, g.typ.typ); err != nil {
+			return fmt.Errorf("global %d: %w", c.importedGlobals+i, err)
+		}
+	}
+	for i, e := range m.elems {
+		// passive and declarative segments have no offset
+		if e.offset != nil {
+			if err := c.checkConst(e.offset, ValueTypeI32); err != nil {
+				return fmt.Errorf("elem %d: offset: %w", i, err)
+			}
+		}
+		for _, item := range e.init {
+			if err := c.checkConst(item, e.typ); err != nil {
+				return fmt.Errorf("elem %d: %w", i, err)
+			}
+		}
+	}
+	for i, d := range m.datas {
+		if d.offset != nil {
+			if err := c.checkConst(d.offset, ValueTypeI32); err != nil {
+				return fmt.Errorf("data %d: offset: %w", i, err)
+			}
+		}
+	}
+
+	for i, f := range m.funcs {
+		if err := c.checkFunc(f); err != nil {
+			if f.name != "" {
+				return fmt.Errorf("func $%s: %w", f.name, err)
+			}
+			return fmt.Errorf("func %d: %w", len(c.funcs)-len(m.funcs)+i, err)
+		}
+	}
+	return nil
+}
+
+// catch turns the error a check panicked with into *err.
+func catch(err *error) {
+	if e := recover(); e != nil {
+		ce, ok := e.(checkError)
+		if !ok {
+			panic(e)
+		}
+		*err = ce
+	}
+}
+
+// checkConst checks a constant expression producing a value of type want.
+// Besides constants, it can only take references and read imported
+// immutable globals, whose values are known before instantiation.
+func (c *checker) checkConst(code []*instr, want ValueType) (err error) {
+	defer catch(&err)
+
+	c.constInstrs(code)
+	c.locals = c.locals[:0]
+	c.vals = c.vals[:0]
+	c.ctrls = c.ctrls[:0]
+	c.pushCtrl(text.OpBlock, nil, []ValueType{want})
+	c.instrs(code)
+	c.popCtrl()
+	return nil
+}
+
+// constType type checks a constant expression of any type and returns the
+// type of its value.
+func (c *checker) constType(code []*instr) (t ValueType, err error) {
+	defer catch(&err)
+
+	c.constInstrs(code)
+	c.pushCtrl(text.OpBlock, nil, nil)
+	c.instrs(code)
+	if len(c.vals) != 1 {
+		c.errorf("type mismatch in constant expression")
+	}
+	return c.vals[0], nil
+}
+
+// constInstrs checks that the instructions of code, operands included,
+// are allowed in constant expressions.
+func (c *checker) constInstrs(code []*instr) {
+	for _, in := range code {
+		c.constInstrs(in.args)
+		switch op := in.op; {
+		case op >= text.OpI32Const && op <= text.OpV128Const, op == text.OpRefNull, op == text.OpRefFunc:
+		case op == text.OpGlobalGet:
+			if in.imm >= uint64(c.importedGlobals) {
+				c.errorf("unknown global %d: constant expressions only read imported globals", in.imm)
+			}
+			if c.globals[in.imm].mut {
+				c.errorf("constant expression required: global %d is mutable", in.imm)
+			}
+		default:
+			c.errorf("constant expression required: %s", op)
+		}
+	}
+}
+
+func (c *checker) checkFunc(f *function) (err error) {
+	defer catch(&err)
+
+	c.locals = append(append(c.locals[:0], f.typ.params...), f.locals...)
+	c.vals = c.vals[:0]
+	c.ctrls = c.ctrls[:0]
+	c.pushCtrl(text.OpBlock, nil, f.typ.results)
+	c.instrs(f.body)
+	c.popCtrl()
+	return nil
+}
+
+func (c *checker) push(t ValueType) {
+	c.vals = append(c.vals, t)
+}
+
+func (c *checker) pushAll(types []ValueType) {
+	c.vals = append(c.vals, types...)
+}
+
+// pop pops an operand, which must be of type want unless either is
+// unknown, and returns its type.
+func (c *checker) pop(want ValueType) ValueType {
+	f := &c.ctrls[len(c.ctrls)-1]
+	if len(c.vals) == f.height {
+		if f.unreachable {
+			return valueTypeUnknown
+		}
+		c.errorf("type mismatch")
+	}
+	got := c.vals[len(c.vals)-1]
+	c.vals = c.vals[:len(c.vals)-1]
+	if got != want && got != valueTypeUnknown && want != valueTypeUnknown {
+		c.errorf("type mismatch")
+	}
+	return got
+}
+
+// popAll pops operands of the given types and returns the types popped.
+func (c *checker) popAll(types []ValueType) []ValueType {
+	popped := make([]ValueType, len(types))
+	for i := len(types) - 1; i >= 0; i-- {
+		popped[i] = c.pop(types[i])
+	}
+	return popped
+}
+
+func (c *checker) pushCtrl(op text.Op, params, results []ValueType) {
+	c.ctrls = append(c.ctrls, ctrlFrame{op: op, params: params, results: results, height: len(c.vals)})
+	c.pushAll(params)
+}
+
+func (c *checker) popCtrl() ctrlFrame {
+	f := c.ctrls[len(c.ctrls)-1]
+	c.popAll(f.results)
+	if len(c.vals) != f.height {
+		c.errorf("type mismatch")
+	}
+	c.ctrls = c.ctrls[:len(c.ctrls)-1]
+	return f
+}
+
+// setUnreachable drops the operands of the current block, which can pop
+// anything from then on.
+func (c *checker) setUnreachable() {
+	f := &c.ctrls[len(c.ctrls)-1]
+	c.vals = c.vals[:f.height]
+	f.unreachable = true
+}
+
+func (c *checker) label(depth uint64) *ctrlFrame {
+	if depth >= uint64(len(c.ctrls)) {
+		c.errorf("unknown label %d", depth)
+	}
+	return &c.ctrls[len(c.ctrls)-1-int(depth)]
+}
+
+func (c *checker) instrs(code []*instr) {
+	for _, in := range code {
+		c.instr(in)
+	}
+}
+
+func (c *checker) instr(in *instr) {
+	// folded operands come first
+	c.instrs(in.args)
+
+	switch in.op {
+	case text.OpUnreachable:
+		c.setUnreachable()
+	case text.OpNop:
+	case text.OpBlock, text.OpLoop:
+		c.popAll(in.params)
+		c.pushCtrl(in.op, in.params, in.results)
+		c.instrs(in.body)
+		c.pushAll(c.popCtrl().results)
+	case text.OpIf:
+		c.pop(ValueTypeI32)
+		c.popAll(in.params)
+		c.pushCtrl(in.op, in.params, in.results)
+		c.instrs(in.body)
+		f := c.popCtrl()
+		if !hasElse(in.node) && !equalTypes(f.params, f.results) {
+			c.errorf("type mismatch")
+		}
+		c.pushCtrl(text.OpElse, f.params, f.results)
+		c.instrs(in.els)
+		c.pushAll(c.popCtrl().results)
+	case text.OpBr:
+		c.popAll(c.label(in.imm).labelTypes())
+		c.setUnreachable()
+	case text.OpBrIf:
+		c.pop(ValueTypeI32)
+		types := c.label(in.imm).labelTypes()
+		c.popAll(types)
+		c.pushAll(types)
+	case text.OpBrTable:
+		c.pop(ValueTypeI32)
+		def := c.label(uint64(in.labels[len(in.labels)-1])).labelTypes()
+		for _, depth := range in.labels {
+			types := c.label(uint64(depth)).labelTypes()
+			if len(types) != len(def) {
+				c.errorf("type mismatch")
+			}
+			// each target checks the operands without consuming them
+			c.pushAll(c.popAll(types))
+		}
+		c.popAll(def)
+		c.setUnreachable()
+	case text.OpReturn:
+		c.popAll(c.ctrls[0].results)
+		c.setUnreachable()
+	case text.OpSelect:
+		c.pop(ValueTypeI32)
+		if len(in.results) > 1 {
+			c.errorf("invalid result arity")
+		}
+		if len(in.results) == 1 {
+			t := in.results[0]
+			c.pop(t)
+			c.pop(t)
+			c.push(t)
+			break
+		}
+		t1 := c.pop(valueTypeUnknown)
+		t2 := c.pop(valueTypeUnknown)
+		if isRefType(t1) || isRefType(t2) {
+			c.errorf("type mismatch")
+		}
+		if t1 != t2 && t1 != valueTypeUnknown && t2 != valueTypeUnknown {
+			c.errorf("type mismatch")
+		}
+		if t1 == valueTypeUnknown {
+			t1 = t2
+		}
+		c.push(t1)
+	case text.OpLocalGet:
+		c.push(c.local(in))
+	case text.OpLocalSet:
+		c.pop(c.local(in))
+	case text.OpLocalTee:
+		t := c.local(in)
+		c.pop(t)
+		c.push(t)
+	case text.OpRefIsNull:
+		if t := c.pop(valueTypeUnknown); t != valueTypeUnknown && !isRefType(t) {
+			c.errorf("type mismatch")
+		}
+		c.push(ValueTypeI32)
+	default:
+		params, results, ok := c.effect(in)
+		if !ok {
+			c.errorf("%s: %w", in.op, ErrNotImplemented)
+		}
+		c.popAll(params)
+		c.pushAll(results)
+	}
+}
+
+// StackEffect returns the types of the operands an instruction of the
+// module pops, in order, and of the results it pushes, after checking its
+// immediates against the module. The operand of drop can be of any type,
+// which is the zero ValueType. The effect of the control instructions, of
+// select, ref.is_null and the instructions of locals depends on the code
+// around them, and isn't known.
+func (m *Module) StackEffect(n *text.Node) (params, results []ValueType, err error) {
+	fc := &funcCompiler{compiler: &compiler{m: m, names: m.ids}, labels: []string{""}}
+	in, err := fc.lower(&text.Node{Op: n.Op, Meta: n.Meta, Args: typeUses(n.Args)})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer catch(&err)
+	params, results, ok := newChecker(m).effect(in)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: stack effect depends on the code around it", n.Op)
+	}
+	return params, results, nil
+}
+
+// typeUses returns the type use and signature nodes of an instruction,
+// leaving out its folded operands.
+func typeUses(args []*text.Node) []*text.Node {
+	var nodes []*text.Node
+	for _, a := range args {
+		switch a.Op {
+		case text.OpTypeUse, text.OpParam, text.OpResult:
+			nodes = append(nodes, a)
+		}
+	}
+	return nodes
+}
+
+// effect checks the immediates of an instruction and returns the types it
+// pops and pushes, when they follow from the instruction and the index
+// spaces alone.
+func (c *checker) effect(in *instr) (params, results []ValueType, ok bool) {
+	i32 := ValueTypeI32
+	three := []ValueType{i32, i32, i32}
+	switch in.op {
+	case text.OpNop:
+		return nil, nil, true
+	case text.OpDrop:
+		return []ValueType{valueTypeUnknown}, nil, true
+	case text.OpCall:
+		if in.imm >= uint64(len(c.funcs)) {
+			c.errorf("unknown function %d", in.imm)
+		}
+		t := c.funcs[in.imm]
+		return t.params, t.results, true
+	case text.OpCallIndirect:
+		if c.table(in.imm2) != ValueTypeFuncRef {
+			c.errorf("type mismatch")
+		}
+		if in.imm >= uint64(len(c.m.types)) {
+			c.errorf("unknown type %d", in.imm)
+		}
+		// the index of the element comes last
+		return slices.Concat(in.params, []ValueType{i32}), in.results, true
+	case text.OpGlobalGet:
+		return nil, []ValueType{c.global(in.imm).typ}, true
+	case text.OpGlobalSet:
+		g := c.global(in.imm)
+		if !g.mut {
+			c.errorf("global is immutable")
+		}
+		return []ValueType{g.typ}, nil, true
+	case text.OpTableGet:
+		return []ValueType{i32}, []ValueType{c.table(in.imm)}, true
+	case text.OpTableSet:
+		return []ValueType{i32, c.table(in.imm)}, nil, true
+	case text.OpTableSize:
+		c.table(in.imm)
+		return nil, []ValueType{i32}, true
+	case text.OpTableGrow:
+		return []ValueType{c.table(in.imm), i32}, []ValueType{i32}, true
+	case text.OpTableFill:
+		return []ValueType{i32, c.table(in.imm), i32}, nil, true
+	case text.OpTableCopy, text.OpTableInit:
+		var src ValueType
+		if in.op == text.OpTableCopy {
+			src = c.table(in.imm2)
+		} else {
+			src = c.elem(in.imm2)
+		}
+		if c.table(in.imm) != src {
+			c.errorf("type mismatch")
+		}
+		return three, nil, true
+	case text.OpElemDrop:
+		c.elem(in.imm)
+		return nil, nil, true
+	case text.OpMemorySize:
+		c.memory(in.mem)
+		return nil, []ValueType{i32}, true
+	case text.OpMemoryGrow:
+		c.memory(in.mem)
+		return []ValueType{i32}, []ValueType{i32}, true
+	case text.OpMemoryFill, text.OpMemoryCopy, text.OpMemoryInit:
+		c.memory(in.mem)
+		if in.op == text.OpMemoryCopy {
+			c.memory(uint32(in.imm2))
+		}
+		if in.op == text.OpMemoryInit {
+			c.data(in.imm)
+		}
+		return three, nil, true
+	case text.OpDataDrop:
+		c.data(in.imm)
+		return nil, nil, true
+	case text.OpRefNull:
+		return nil, []ValueType{ValueType(in.imm)}, true
+	case text.OpRefFunc:
+		if in.imm >= uint64(len(c.funcs)) {
+			c.errorf("unknown function %d", in.imm)
+		}
+		return nil, []ValueType{ValueTypeFuncRef}, true
+	}
+
+	params, results, ok = opSignature(in.op)
+	if ok && isMemoryAccess(in.op) {
+		c.memory(in.mem)
+	}
+	return params, results, ok
+}
+
+func hasElse(n *text.Node) bool {
+	for _, a := range n.Args {
+		if a.Op == text.OpElse {
+			return true
+		}
+	}
+	return false
+}
+
+// local returns the type of the local accessed by in, which can be any of
+// the params and declared locals.
+func (c *checker) local(in *instr) ValueType {
+	if in.imm >= uint64(len(c.locals)) {
+		c.errorf("%s: unknown local %d", in.op, in.imm)
+	}
+	return c.locals[in.imm]
+}
+
+func (c *checker) global(idx uint64) globalType {
+	if idx >= uint64(len(c.globals)) {
+		c.errorf("unknown global %d", idx)
+	}
+	return c.globals[idx]
+}
+
+func (c *checker) table(idx uint64) ValueType {
+	if idx >= uint64(len(c.tables)) {
+		c.errorf("unknown table %d", idx)
+	}
+	return c.tables[idx]
+}
+
+func (c *checker) elem(idx uint64) ValueType {
+	if idx >= uint64(len(c.m.elems)) {
+		c.errorf("unknown elem segment %d", idx)
+	}
+	return c.m.elems[idx].typ
+}
+
+func (c *checker) data(idx uint64) {
+	if idx >= uint64(len(c.m.datas)) {
+		c.errorf("unknown data segment %d", idx)
+	}
+}
+
+func (c *checker) memory(idx uint32) {
+	if int(idx) >= c.mems {
+		c.errorf("unknown memory %d", idx)
+	}
+}
+
+func isRefType(t ValueType) bool {
+	return t == ValueTypeFuncRef || t == ValueTypeExternRef
+}
+
+// opSignature returns the operand and result types of a numeric or memory
+// instruction, which follow from its name: the type prefix, the operation
+// and, for conversions, the source type.
+func opSignature(op text.Op) (params, results []ValueType, ok bool) {
+	if isSIMD(op) {
+		return simdSignature(op)
+	}
+	prefix, name, _ := strings.Cut(op.String(), ".")
+	t, err := parseValueType(prefix)
+	if t == ValueTypeV128 {
+		// vector loads and stores
+		return nil, nil, false
+	}
+	if err != nil || isRefType(t) {
+		return nil, nil, false
+	}
+	one := []ValueType{t}
+	two := []ValueType{t, t}
+	i32 := []ValueType{ValueTypeI32}
+
+	switch {
+	case name == "const":
+		return nil, one, true
+	case isMemoryAccess(op) && strings.HasPrefix(name, "store"):
+		return []ValueType{ValueTypeI32, t}, nil, true
+	case isMemoryAccess(op):
+		return i32, one, true
+	case name == "eqz":
+		return one, i32, true
+	}
+
+	parts := strings.Split(name, "_")
+	for _, p := range parts[1:] {
+		if src, err := parseValueType(p); err == nil {
+			return []ValueType{src}, one, true
+		}
+	}
+
+	switch parts[0] {
+	case "eq", "ne", "lt", "gt", "le", "ge":
+		return two, i32, true
+	case "clz", "ctz", "popcnt", "abs", "neg", "sqrt", "ceil", "floor",
+		"trunc", "nearest", "extend8", "extend16", "extend32":
+		return one, one, true
+	case "add", "sub", "mul", "div", "rem", "and", "or", "xor", "shl", "shr",
+		"rotl", "rotr", "min", "max", "copysign":
+		return two, one, true
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,104 @@
+package main_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+	"github.com/bluescreen10/war/text"
+)
+
+func TestTypeCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"unreachable tail", `(module (func (result i32) unreachable i32.add))`, ""},
+		{"after br", `(module (func (result i32) (br 0 (i32.const 1)) (f32.const 0) drop))`, ""},
+		{"after return", `(module (func (result i32) (return (i32.const 1)) select))`, ""},
+		{"br_table", `(module (func (param i32) (result i64)
+  (block (result i64) (br_table 0 1 (i64.const 1) (local.get 0)))))`, ""},
+		{"unreachable still typed", `(module (func (result i32) unreachable (i64.const 0)))`, "type mismatch"},
+		{"wrong result", `(module (func (result i32) (i64.const 0)))`, "type mismatch"},
+		{"wrong operand", `(module (func (i32.add (i32.const 1) (i64.const 2)) drop))`, "type mismatch"},
+		{"leftover operand", `(module (func (result i32) (block (i32.const 1)) (i32.const 2)))`, "type mismatch"},
+		{"if without else", `(module (func (if (result i32) (i32.const 1) (then (i32.const 1))) drop))`, "type mismatch"},
+		{"if without else passing params", `(module (func (param i32) (result i32)
+  (if (param i32) (result i32) (local.get 0) (local.get 0) (then (i32.const 1) (i32.add)))))`, ""},
+		{"if without else changing types", `(module (func (param i32) (result i64)
+  (if (param i32) (result i64) (local.get 0) (local.get 0) (then (i64.extend_i32_s)))))`, "type mismatch"},
+		{"local index past params", `(module (func (param i32) (local.get 1) drop))`, "local.get: unknown local 1"},
+		{"local index past locals", `(module (func (param i32) (local i64) (local.set 2 (i64.const 0))))`, "local.set: unknown local 2"},
+		{"last local", `(module (func (param i32) (result i64) (local i64) (local.tee 1 (i64.const 0))))`, ""},
+		{"undefined local name", `(module (func (param $a i32) (result i32) (local.tee $b (i32.const 0))))`, "local.tee: unknown local $b"},
+		{"numeric select", `(module (func (result f64) (select (f64.const 1) (f64.const 2) (i32.const 0))))`, ""},
+		{"typed ref select", `(module (func (param externref) (result externref)
+  (select (result externref) (local.get 0) (ref.null extern) (i32.const 0))))`, ""},
+		{"untyped ref select", `(module (func (param externref) (result externref)
+  (select (local.get 0) (ref.null extern) (i32.const 0))))`, "type mismatch"},
+		{"untyped ref select after unreachable", `(module (func (result externref)
+  unreachable (ref.null extern) (i32.const 0) select))`, "type mismatch"},
+		{"memory.init", `(module (memory 1) (data "a")
+  (func (memory.init 0 (i32.const 0) (i32.const 0) (i32.const 1))))`, ""},
+		{"memory.init past data", `(module (memory 1) (data "a")
+  (func (memory.init 5 (i32.const 0) (i32.const 0) (i32.const 1))))`, "unknown data segment 5"},
+		{"data.drop without data", `(module (memory 1) (func (data.drop 0)))`, "unknown data segment 0"},
+		{"immutable global", `(module (global i32 (i32.const 0)) (func (global.set 0 (i32.const 1))))`, "global is immutable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := war.NewRuntime().Instantiate([]byte(tt.src))
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, expected %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStackEffect(t *testing.T) {
+	m, err := war.CompileModule([]byte(`(module
+  (global $g (mut f64) (f64.const 0))
+  (func $f (param i64 f32) (result i32) (i32.const 0)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := text.NewNode
+	tests := []struct {
+		name    string
+		instr   *text.Node
+		params  []war.ValueType
+		results []war.ValueType
+		err     string
+	}{
+		{"i32.add", node(text.OpI32Add, ""), []war.ValueType{war.ValueTypeI32, war.ValueTypeI32}, []war.ValueType{war.ValueTypeI32}, ""},
+		{"drop", node(text.OpDrop, ""), []war.ValueType{0}, nil, ""},
+		{"call", node(text.OpCall, "$f"), []war.ValueType{war.ValueTypeI64, war.ValueTypeF32}, []war.ValueType{war.ValueTypeI32}, ""},
+		{"global.set", node(text.OpGlobalSet, "$g"), []war.ValueType{war.ValueTypeF64}, nil, ""},
+		{"unknown function", node(text.OpCall, "1"), nil, nil, "unknown function 1"},
+		{"local", node(text.OpLocalGet, "0"), nil, nil, "depends on the code around it"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, results, err := m.StackEffect(tt.instr)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("got error %v, expected %q", err, tt.err)
+			case !slices.Equal(params, tt.params) || !slices.Equal(results, tt.results):
+				t.Errorf("got %v -> %v, expected %v -> %v", params, results, tt.params, tt.results)
+			}
+		})
+	}
+}
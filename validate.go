@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bluescreen10/war/text"
+)
+
+// validateFields checks the rules on the fields of a module: imports come
+// before any definition, so they take the first indices of their index
+// space, and export names are unique.
+func validateFields(n *text.Node) error {
+	var defined text.Op
+	exports := map[string]bool{}
+	for _, f := range n.Args {
+		switch f.Op {
+		case text.OpFunc, text.OpGlobal, text.OpTable, text.OpMemory:
+			if defined == 0 {
+				defined = f.Op
+			}
+		case text.OpImport:
+			if defined != 0 {
+				return fmt.Errorf("import after %s", defined)
+			}
+		case text.OpExport:
+			name, err := text.Unquote(f.Meta)
+			if err != nil {
+				return err
+			}
+			if exports[string(name)] {
+				return fmt.Errorf("duplicate export name %q", name)
+			}
+			exports[string(name)] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main_test
+
+import (
+	"strings"
+	"testing"
+
+	war "github.com/bluescreen10/war"
+	"github.com/bluescreen10/war/text"
+)
+
+func TestValidateFields(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"ordered imports", `(module
+  (import "lib" "add" (func (param i32 i32) (result i32)))
+  (import "lib" "g" (global i32))
+  (func (export "f") (result i32) (i32.const 1))
+  (export "g" (global 0)))`, ""},
+		{"duplicate export", `(module
+  (func (export "f"))
+  (func (export "f")))`, `duplicate export name "f"`},
+		{"duplicate export kinds", `(module
+  (func (export "x"))
+  (global (export "x") i32 (i32.const 0)))`, `duplicate export name "x"`},
+		{"import after function", `(module
+  (func)
+  (import "lib" "add" (func (param i32 i32) (result i32))))`, "import after func"},
+		{"import after global", `(module
+  (global i32 (i32.const 0))
+  (func (import "lib" "add") (param i32 i32) (result i32)))`, "import after global"},
+		{"import after memory", `(module
+  (memory 1)
+  (import "lib" "g" (global i32)))`, "import after memory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := war.NewRuntime()
+			if err := r.Exec([]byte(lib)); err != nil {
+				t.Fatal(err)
+			}
+			_, err := r.Instantiate([]byte(tt.src))
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("got error %v, expected %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestConstExpr(t *testing.T) {
+	const env = `(module $env
+  (global (export "g") i32 (i32.const 7))
+  (global (export "mg") (mut i32) (i32.const 1)))
+(register "env" $env)`
+
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"imported global", `(module
+  (import "env" "g" (global $g i32))
+  (global $h i32 (global.get $g))
+  (memory 1)
+  (data (global.get $g) "\2a")
+  (func (export "f") (result i32)
+    (i32.add (global.get $h) (i32.load8_u (i32.const 7)))))`, ""},
+		{"mutable imported global", `(module
+  (import "env" "mg" (global $g (mut i32)))
+  (memory 1)
+  (data (global.get $g) "\2a"))`, "data 0: offset: constant expression required: global 0 is mutable"},
+		{"defined global", `(module
+  (global $g i32 (i32.const 7))
+  (global i32 (global.get $g)))`, "global 1: unknown global 0"},
+		{"not constant", `(module
+  (table 1 funcref)
+  (elem (offset (i32.add (i32.const 0) (i32.const 0)))))`, "elem 0: offset: constant expression required: i32.add"},
+		{"wrong type", `(module (global i64 (i32.const 0)))`, "global 0: type mismatch"},
+		{"extra value", `(module (global i32 (i32.const 0) (i32.const 1)))`, "global 0: type mismatch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := war.NewRuntime()
+			if err := r.Exec([]byte(env)); err != nil {
+				t.Fatal(err)
+			}
+			_, err := r.Instantiate([]byte(tt.src))
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("got error %v, expected %q", err, tt.err)
+			}
+			if tt.err != "" {
+				return
+			}
+
+			// the global and the offset are the value of the import
+			got, err := r.Invoke("f")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got[0] != war.I32(7+42) {
+				t.Errorf("got %v, expected [i32:49]", got)
+			}
+		})
+	}
+}
+
+func TestEvalConstExpr(t *testing.T) {
+	node := text.NewNode
+	tests := []struct {
+		name    string
+		expr    *text.Node
+		globals []war.Value
+		want    war.Value
+		err     string
+	}{
+		{"const", node(text.OpF64Const, "1.5"), nil, war.F64(1.5), ""},
+		{"global", node(text.OpGlobalGet, "1"), []war.Value{war.I64(3), war.I32(7)}, war.I32(7), ""},
+		{"null", node(text.OpRefNull, "extern"), nil, war.ExternRef(nil), ""},
+		{"not constant", node(text.OpI32Add, "", node(text.OpI32Const, "1"), node(text.OpI32Const, "2")),
+			nil, war.Value{}, "constant expression required: i32.add"},
+		{"unknown global", node(text.OpGlobalGet, "0"), nil, war.Value{}, "unknown global 0"},
+		{"function", node(text.OpRefFunc, "0"), nil, war.Value{}, "unknown function 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := war.EvalConstExpr(tt.expr, tt.globals...)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("got error %v, expected %q", err, tt.err)
+			case got != tt.want:
+				t.Errorf("got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
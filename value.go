@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// ValueType is the type of a wasm value, using its binary encoding.
+type ValueType byte
+
+const (
+	ValueTypeI32 ValueType = 0x7f
+	ValueTypeI64 ValueType = 0x7e
+	ValueTypeF32 ValueType = 0x7d
+	ValueTypeF64 ValueType = 0x7c
+
+	ValueTypeV128 ValueType = 0x7b
+
+	ValueTypeFuncRef   ValueType = 0x70
+	ValueTypeExternRef ValueType = 0x6f
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case ValueTypeI32:
+		return "i32"
+	case ValueTypeI64:
+		return "i64"
+	case ValueTypeF32:
+		return "f32"
+	case ValueTypeF64:
+		return "f64"
+	case ValueTypeV128:
+		return "v128"
+	case ValueTypeFuncRef:
+		return "funcref"
+	case ValueTypeExternRef:
+		return "externref"
+	}
+	return fmt.Sprintf("valtype(0x%02x)", byte(t))
+}
+
+func parseValueType(s string) (ValueType, error) {
+	switch s {
+	case "i32":
+		return ValueTypeI32, nil
+	case "i64":
+		return ValueTypeI64, nil
+	case "f32":
+		return ValueTypeF32, nil
+	case "f64":
+		return ValueTypeF64, nil
+	case "v128":
+		return ValueTypeV128, nil
+	case "funcref":
+		return ValueTypeFuncRef, nil
+	case "externref":
+		return ValueTypeExternRef, nil
+	}
+	return 0, fmt.Errorf("unknown value type %q", s)
+}
+
+// Value is a typed wasm value. Numbers are kept as their raw bits so floats
+// round-trip exactly, NaN payloads included. References are kept in ref, a
+// nil ref being the null reference: a funcref holds the *funcInst it refers
+// to, shared by every instance importing the function, and an externref
+// holds a host value. References compare equal with == when they refer to
+// the same function or host value. A v128 keeps its low half in bits and
+// its high half in hi.
+type Value struct {
+	typ  ValueType
+	bits uint64
+	hi   uint64
+	ref  any
+}
+
+func I32(v int32) Value   { return Value{typ: ValueTypeI32, bits: uint64(uint32(v))} }
+func I64(v int64) Value   { return Value{typ: ValueTypeI64, bits: uint64(v)} }
+func F32(v float32) Value { return Value{typ: ValueTypeF32, bits: uint64(math.Float32bits(v))} }
+func F64(v float64) Value { return Value{typ: ValueTypeF64, bits: math.Float64bits(v)} }
+
+// V128 returns a v128 value from its low and high 64 bits, lane 0 being the
+// least significant.
+func V128(lo, hi uint64) Value { return Value{typ: ValueTypeV128, bits: lo, hi: hi} }
+
+// ExternRef returns an externref holding the host value v, a nil v being
+// the null reference. v must be comparable for the reference to be.
+func ExternRef(v any) Value { return Value{typ: ValueTypeExternRef, ref: v} }
+
+// zero returns the default value of type t.
+func zero(t ValueType) Value {
+	return Value{typ: t}
+}
+
+// IsNull reports whether v is a null reference.
+func (v Value) IsNull() bool {
+	return v.isRef() && v.ref == nil
+}
+
+func (v Value) isRef() bool {
+	return v.typ == ValueTypeFuncRef || v.typ == ValueTypeExternRef
+}
+
+func (v Value) Type() ValueType { return v.typ }
+func (v Value) I32() int32      { return int32(v.bits) }
+func (v Value) I64() int64      { return int64(v.bits) }
+func (v Value) F32() float32    { return math.Float32frombits(uint32(v.bits)) }
+func (v Value) F64() float64    { return math.Float64frombits(v.bits) }
+
+// Extern returns the host value held by an externref, nil for the null
+// reference.
+func (v Value) Extern() any {
+	if v.typ != ValueTypeExternRef {
+		return nil
+	}
+	return v.ref
+}
+
+// V128 returns the low and high 64 bits of a v128 value.
+func (v Value) V128() (lo, hi uint64) { return v.bits, v.hi }
+
+// Bits returns the raw bits of the value, the low half for a v128.
+func (v Value) Bits() uint64 { return v.bits }
+
+func (v Value) String() string {
+	switch v.typ {
+	case ValueTypeI32:
+		return fmt.Sprintf("i32:%d", v.I32())
+	case ValueTypeI64:
+		return fmt.Sprintf("i64:%d", v.I64())
+	case ValueTypeF32:
+		return fmt.Sprintf("f32:%v", v.F32())
+	case ValueTypeF64:
+		return fmt.Sprintf("f64:%v", v.F64())
+	case ValueTypeV128:
+		return fmt.Sprintf("v128:0x%016x%016x", v.hi, v.bits)
+	case ValueTypeFuncRef, ValueTypeExternRef:
+		if v.ref == nil {
+			return fmt.Sprintf("%s:null", v.typ)
+		}
+		return fmt.Sprintf("%s:%v", v.typ, v.ref)
+	}
+	return fmt.Sprintf("%s:%#x", v.typ, v.bits)
+}